@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+// AccessMarkerVariable passes the access marker of a cache to the cache
+// extractor
+const AccessMarkerVariable = "CACHE_ACCESS_MARKER"
+
+// accessKey is the key under which the access markers of the caches of
+// a project are stored
+const accessKey = ".access"
+
+// AccessMarker is an empty object uploaded by the cache extractor each time
+// it downloads the cache. Object stores don't record when an object was last
+// read, so the write time of the marker is the last access time of the
+// cache.
+type AccessMarker struct {
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers,omitempty"`
+}
+
+// generateAccessObjectName returns the object name of the access marker of
+// the cache
+func generateAccessObjectName(build *common.Build, config *common.CacheConfig, key string) (string, error) {
+	objectName, err := generateObjectName(build, config, key)
+	if err != nil || objectName == "" {
+		return "", err
+	}
+
+	return path.Join(generateBaseObjectName(build, config), accessKey, key), nil
+}
+
+// GetCacheAccessMarker returns the access marker of the cache, or nil when
+// the adapter can't list the markers or sign their upload
+func GetCacheAccessMarker(build *common.Build, key string) *AccessMarker {
+	config := getCacheConfig(build)
+	if config == nil {
+		return nil
+	}
+
+	objectName, err := generateAccessObjectName(build, config, key)
+	if err != nil || objectName == "" {
+		return nil
+	}
+
+	adapter, err := createAdapter(config, build.GetBuildTimeout(), objectName)
+	if err != nil {
+		logrus.WithError(err).Error("Could not create cache adapter")
+		return nil
+	}
+
+	if _, ok := adapter.(Lister); !ok {
+		return nil
+	}
+
+	u := adapter.GetUploadURL()
+	if u == nil {
+		return nil
+	}
+
+	return &AccessMarker{URL: u.String(), Headers: adapter.GetUploadHeaders()}
+}
+
+// GetCacheAccessMarkerEnv returns the environment passing the access marker
+// of the cache to the cache extractor
+func GetCacheAccessMarkerEnv(build *common.Build, key string) map[string]string {
+	marker := GetCacheAccessMarker(build, key)
+	if marker == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(marker)
+	if err != nil {
+		logrus.WithError(err).Error("Error encoding the cache access marker")
+		return nil
+	}
+
+	return map[string]string{AccessMarkerVariable: string(data)}
+}
+
+// accessMarkerOwner returns the name of the cache whose access the object
+// marks, and false when the object isn't an access marker. The name of the
+// marker can be relative to the prefix of the caches of the runner.
+func accessMarkerOwner(name string) (string, bool) {
+	i := strings.Index("/"+name, "/"+accessKey+"/")
+	if i < 0 || i+len(accessKey)+1 >= len(name) {
+		return "", false
+	}
+
+	return path.Join(name[:i], name[i+len(accessKey)+1:]), true
+}
+
+// SetAccessTimes sets the last access time of the listed caches from their
+// listed access markers. The markers are listed too, so they can be removed
+// together with their cache.
+func SetAccessTimes(objects []Object) []Object {
+	accessed := make(map[string]Object)
+	for _, object := range objects {
+		if owner, ok := accessMarkerOwner(object.Name); ok {
+			accessed[owner] = object
+		}
+	}
+
+	for i, object := range objects {
+		if marker, ok := accessed[object.Name]; ok && marker.Updated.After(object.Accessed) {
+			objects[i].Accessed = marker.Updated
+		}
+	}
+
+	return objects
+}
@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+func TestGetCacheAccessMarkerEnv(t *testing.T) {
+	tests := map[string]struct {
+		adapter       func(name string) Adapter
+		key           string
+		expectedURL   string
+		expectedEmpty bool
+	}{
+		"marker": {
+			adapter: func(name string) Adapter {
+				return &packsAdapter{name: name, uploadHdr: http.Header{"X-Ms-Blob-Type": []string{"BlockBlob"}}}
+			},
+			key:         "deps/linux",
+			expectedURL: "https://cache.example.com/project/10/.access/deps/linux?method=PUT",
+		},
+		"adapter without listing": {
+			adapter: func(_ string) Adapter {
+				return new(MockAdapter)
+			},
+			key:           "deps",
+			expectedEmpty: true,
+		},
+		"adapter without upload URL": {
+			adapter: func(name string) Adapter {
+				return &packsAdapter{name: name, noUpload: true}
+			},
+			key:           "deps",
+			expectedEmpty: true,
+		},
+		"empty cache key": {
+			adapter: func(name string) Adapter {
+				return &packsAdapter{name: name}
+			},
+			expectedEmpty: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			oldCreateAdapter := createAdapter
+			createAdapter = func(_ *common.CacheConfig, _ time.Duration, name string) (Adapter, error) {
+				return tt.adapter(name), nil
+			}
+			defer func() {
+				createAdapter = oldCreateAdapter
+			}()
+
+			build := &common.Build{
+				Runner: &common.RunnerConfig{
+					RunnerSettings: common.RunnerSettings{
+						Cache: &common.CacheConfig{Shared: true},
+					},
+				},
+				JobResponse: common.JobResponse{
+					JobInfo: common.JobInfo{ProjectID: 10},
+				},
+			}
+
+			env := GetCacheAccessMarkerEnv(build, tt.key)
+			if tt.expectedEmpty {
+				assert.Empty(t, env)
+				return
+			}
+
+			var marker AccessMarker
+			require.NoError(t, json.Unmarshal([]byte(env[AccessMarkerVariable]), &marker))
+			assert.Equal(t, tt.expectedURL, marker.URL)
+			assert.Equal(t, "BlockBlob", marker.Headers.Get("X-Ms-Blob-Type"))
+		})
+	}
+}
+
+func TestAccessMarkerOwner(t *testing.T) {
+	tests := map[string]struct {
+		name          string
+		expectedOwner string
+		expectedOK    bool
+	}{
+		"marker": {
+			name:          "runner/abcd1234/project/1/.access/deps",
+			expectedOwner: "runner/abcd1234/project/1/deps",
+			expectedOK:    true,
+		},
+		"marker relative to the runner prefix": {
+			name:          "1/.access/deps/linux",
+			expectedOwner: "1/deps/linux",
+			expectedOK:    true,
+		},
+		"marker at the root": {
+			name:          ".access/deps",
+			expectedOwner: "deps",
+			expectedOK:    true,
+		},
+		"cache": {
+			name: "project/1/deps",
+		},
+		"marker without key": {
+			name: "project/1/.access/",
+		},
+		"cache named like the markers": {
+			name: "project/1/deps.access/linux",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			owner, ok := accessMarkerOwner(tt.name)
+			assert.Equal(t, tt.expectedOK, ok)
+			assert.Equal(t, tt.expectedOwner, owner)
+		})
+	}
+}
+
+func TestSetAccessTimes(t *testing.T) {
+	now := time.Now()
+
+	objects := SetAccessTimes([]Object{
+		{Name: "project/1/read", Updated: now.Add(-time.Hour)},
+		{Name: "project/1/.access/read", Updated: now},
+		{Name: "project/1/unread", Updated: now.Add(-time.Hour)},
+	})
+
+	require.Len(t, objects, 3)
+	assert.Equal(t, now, objects[0].Accessed)
+	assert.Equal(t, now, objects[0].LastAccess())
+	assert.True(t, objects[2].Accessed.IsZero())
+	assert.Equal(t, now.Add(-time.Hour), objects[2].LastAccess(), "the write time without marker")
+}
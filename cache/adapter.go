@@ -1,6 +1,8 @@
 package cache
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -19,6 +21,67 @@ type Adapter interface {
 	GetUploadEnv() map[string]string
 }
 
+// The environment variables passed with the download URL to the cache
+// extractor by the adapters implementing DownloadEnvAdapter
+const (
+	// DownloadAuthorizationVariable passes the Authorization header sent
+	// with the requests downloading the cache, like a short-lived token
+	DownloadAuthorizationVariable = "CACHE_DOWNLOAD_AUTHORIZATION"
+	// DownloadLastModifiedVariable passes the modification date of the
+	// cache, for the servers not sending it with the cache
+	DownloadLastModifiedVariable = "CACHE_DOWNLOAD_LAST_MODIFIED"
+)
+
+// DownloadEnvAdapter is implemented by the adapters passing environment
+// variables to the cache extractor together with the download URL
+type DownloadEnvAdapter interface {
+	GetDownloadURLWithEnv() (*url.URL, map[string]string)
+}
+
+// DeleteURLAdapter is implemented by the adapters pre-signing the URLs
+// deleting the objects, with which the cache archiver removes the chunk packs
+// not used anymore
+type DeleteURLAdapter interface {
+	GetDeleteURL() *url.URL
+}
+
+// ErrListNotSupported is returned when the cache adapter can't list the
+// stored caches
+var ErrListNotSupported = errors.New("listing the caches isn't supported by the cache adapter")
+
+// Object is a cache archive stored by an adapter
+type Object struct {
+	Name    string
+	Size    int64
+	Updated time.Time
+	// Accessed is the last time the cache was downloaded, as recorded by
+	// its access marker. It's zero when the cache has no marker.
+	Accessed time.Time
+}
+
+// LastAccess returns the last time the cache was downloaded or written
+func (o Object) LastAccess() time.Time {
+	if o.Accessed.After(o.Updated) {
+		return o.Accessed
+	}
+
+	return o.Updated
+}
+
+// Lister is implemented by the adapters able to list the stored caches. The
+// objects whose name starts with the object name of the adapter are
+// returned, with the last access times of the caches whose access markers
+// are listed.
+type Lister interface {
+	List(ctx context.Context) ([]Object, error)
+}
+
+// Deleter is implemented by the adapters able to delete the stored caches.
+// The name is the full name of an object, as returned by List.
+type Deleter interface {
+	Delete(ctx context.Context, name string) error
+}
+
 type Factory func(config *common.CacheConfig, timeout time.Duration, objectName string) (Adapter, error)
 
 type FactoriesMap struct {
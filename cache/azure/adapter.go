@@ -1,6 +1,8 @@
 package azure
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -13,16 +15,25 @@ import (
 	"gitlab.com/gitlab-org/gitlab-runner/common"
 )
 
+// maxBlockSize is the maximum size of the blocks with the API version of the
+// SAS tokens
+const maxBlockSize = 100 * 1024 * 1024
+
 type signedURLGenerator func(name string, options *signedURLOptions) (*url.URL, error)
 type blobTokenGenerator func(name string, options *signedURLOptions) (string, error)
+type blobLister func(ctx context.Context, prefix string, options *signedURLOptions) ([]cache.Object, error)
+type blobDeleter func(ctx context.Context, name string, options *signedURLOptions) error
 
 type azureAdapter struct {
 	timeout    time.Duration
 	config     *common.CacheAzureConfig
 	objectName string
+	metadata   map[string]string
 
 	generateSignedURL   signedURLGenerator
 	blobTokenGenerator  blobTokenGenerator
+	listBlobs           blobLister
+	deleteBlob          blobDeleter
 	credentialsResolver credentialsResolver
 }
 
@@ -34,14 +45,48 @@ func (a *azureAdapter) GetUploadURL() *url.URL {
 	return a.presignURL(http.MethodPut)
 }
 
+// GetDeleteURL returns the SAS URL deleting the blob
+func (a *azureAdapter) GetDeleteURL() *url.URL {
+	return a.presignURL(http.MethodDelete)
+}
+
 func (a *azureAdapter) GetUploadHeaders() http.Header {
 	httpHeaders := http.Header{}
 	httpHeaders.Set("Content-Type", "application/octet-stream")
 	httpHeaders.Set("x-ms-blob-type", "BlockBlob")
+	for key, value := range a.metadata {
+		httpHeaders.Set("x-ms-meta-"+key, value)
+	}
 
 	return httpHeaders
 }
 
+// GetMultipartUpload returns the SAS URL of the block blob, to which the
+// blocks are uploaded and the block list is committed
+func (a *azureAdapter) GetMultipartUpload(partSize int64) *cache.MultipartUpload {
+	u := a.presignURL(http.MethodPut)
+	if u == nil {
+		return nil
+	}
+
+	if partSize > maxBlockSize {
+		partSize = maxBlockSize
+	}
+
+	httpHeaders := http.Header{}
+	httpHeaders.Set("x-ms-blob-content-type", "application/octet-stream")
+	for key, value := range a.metadata {
+		httpHeaders.Set("x-ms-meta-"+key, value)
+	}
+
+	return &cache.MultipartUpload{
+		Type:     cache.MultipartUploadAzure,
+		PartSize: partSize,
+		URL:      u.String(),
+		Headers:  httpHeaders,
+	}
+}
+
 func (a *azureAdapter) GetGoCloudURL() *url.URL {
 	if a.config.ContainerName == "" {
 		logrus.Error("ContainerName can't be empty")
@@ -75,6 +120,43 @@ func (a *azureAdapter) GetUploadEnv() map[string]string {
 	}
 }
 
+func (a *azureAdapter) List(ctx context.Context) ([]cache.Object, error) {
+	options, err := a.storageOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	objects, err := a.listBlobs(ctx, a.objectName, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return cache.SetAccessTimes(objects), nil
+}
+
+func (a *azureAdapter) Delete(ctx context.Context, name string) error {
+	options, err := a.storageOptions()
+	if err != nil {
+		return err
+	}
+
+	return a.deleteBlob(ctx, name, options)
+}
+
+func (a *azureAdapter) storageOptions() (*signedURLOptions, error) {
+	credentials := a.getCredentials()
+	if credentials == nil {
+		return nil, errors.New("missing Azure credentials")
+	}
+
+	return &signedURLOptions{
+		ContainerName: a.config.ContainerName,
+		StorageDomain: a.config.StorageDomain,
+		Credentials:   credentials,
+		Timeout:       a.timeout,
+	}, nil
+}
+
 func (a *azureAdapter) presignURL(method string) *url.URL {
 	credentials := a.getCredentials()
 	if credentials == nil {
@@ -147,9 +229,12 @@ func New(config *common.CacheConfig, timeout time.Duration, objectName string) (
 		config:              azure,
 		timeout:             timeout,
 		objectName:          strings.TrimLeft(objectName, "/"),
+		metadata:            cache.ObjectMetadata(config),
 		credentialsResolver: cr,
 		generateSignedURL:   presignedURL,
 		blobTokenGenerator:  getSASToken,
+		listBlobs:           listBlobs,
+		deleteBlob:          deleteBlob,
 	}
 
 	return a, nil
@@ -1,6 +1,7 @@
 package azure
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -13,6 +14,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
 	"gitlab.com/gitlab-org/gitlab-runner/common"
 )
 
@@ -375,3 +377,112 @@ func TestAdapterOperation(t *testing.T) {
 		})
 	}
 }
+
+func TestList(t *testing.T) {
+	now := time.Now()
+
+	tests := map[string]struct {
+		returnedObjects []cache.Object
+		returnedError   error
+		expectedError   string
+	}{
+		"objects listed": {
+			returnedObjects: []cache.Object{{Name: "key-1", Updated: now}},
+		},
+		"listing error": {
+			returnedError: errors.New("listing Azure blobs: test error"),
+			expectedError: "listing Azure blobs: test error",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			a, err := New(defaultAzureCache(), defaultTimeout, objectName)
+			require.NoError(t, err)
+
+			adapter, ok := a.(*azureAdapter)
+			require.True(t, ok, "Adapter should be properly casted to *adapter type")
+
+			cleanupCredentialsResolverMock := prepareMockedCredentialsResolver(adapter)
+			defer cleanupCredentialsResolverMock(t)
+
+			adapter.listBlobs = func(_ context.Context, prefix string, opts *signedURLOptions) ([]cache.Object, error) {
+				assert.Equal(t, objectName, prefix)
+				assert.Equal(t, containerName, opts.ContainerName)
+				assert.Equal(t, storageDomain, opts.StorageDomain)
+				assert.Equal(t, accountName, opts.Credentials.AccountName)
+
+				return tt.returnedObjects, tt.returnedError
+			}
+
+			objects, err := adapter.List(context.Background())
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.returnedObjects, objects)
+		})
+	}
+}
+
+func TestUploadHeadersWithMetadata(t *testing.T) {
+	config := defaultAzureCache()
+	config.Encryption = &common.CacheEncryptionConfig{KeyID: "v2"}
+
+	adapter, err := New(config, defaultTimeout, objectName)
+	require.NoError(t, err)
+
+	headers := adapter.GetUploadHeaders()
+	assert.Len(t, headers, 3)
+	assert.Equal(t, "v2", headers.Get("x-ms-meta-gitlab_runner_cache_key_id"))
+}
+
+func TestGetMultipartUpload(t *testing.T) {
+	config := defaultAzureCache()
+	config.Encryption = &common.CacheEncryptionConfig{KeyID: "v2"}
+
+	a, err := New(config, defaultTimeout, objectName)
+	require.NoError(t, err)
+
+	adapter, ok := a.(*azureAdapter)
+	require.True(t, ok, "Adapter should be properly casted to *adapter type")
+
+	cleanupCredentialsResolverMock := prepareMockedCredentialsResolver(adapter)
+	defer cleanupCredentialsResolverMock(t)
+
+	tc := adapterOperationTestCase{returnedURL: "https://azure.example.com/test/key?sig=XYZ"}
+	prepareMockedSignedURLGenerator(t, tc, http.MethodPut, adapter)
+
+	upload := adapter.GetMultipartUpload(2 * maxBlockSize)
+	require.NotNil(t, upload)
+	assert.Equal(t, cache.MultipartUploadAzure, upload.Type)
+	assert.Equal(t, int64(maxBlockSize), upload.PartSize)
+	assert.Equal(t, tc.returnedURL, upload.URL)
+	assert.Equal(t, "application/octet-stream", upload.Headers.Get("x-ms-blob-content-type"))
+	assert.Equal(t, "v2", upload.Headers.Get("x-ms-meta-gitlab_runner_cache_key_id"))
+}
+
+func TestDelete(t *testing.T) {
+	a, err := New(defaultAzureCache(), defaultTimeout, objectName)
+	require.NoError(t, err)
+
+	adapter, ok := a.(*azureAdapter)
+	require.True(t, ok, "Adapter should be properly casted to *adapter type")
+
+	cleanupCredentialsResolverMock := prepareMockedCredentialsResolver(adapter)
+	defer cleanupCredentialsResolverMock(t)
+
+	var deleted []string
+	adapter.deleteBlob = func(_ context.Context, name string, opts *signedURLOptions) error {
+		assert.Equal(t, containerName, opts.ContainerName)
+		assert.Equal(t, accountKey, opts.Credentials.AccountKey)
+
+		deleted = append(deleted, name)
+		return nil
+	}
+
+	require.NoError(t, adapter.Delete(context.Background(), "project/1/key"))
+	assert.Equal(t, []string{"project/1/key"}, deleted)
+}
@@ -1,6 +1,7 @@
 package azure
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -9,6 +10,7 @@ import (
 
 	"github.com/Azure/azure-storage-blob-go/azblob"
 
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
 	"gitlab.com/gitlab-org/gitlab-runner/common"
 )
 
@@ -70,8 +72,11 @@ func getSASQueryParameters(name string, o *signedURLOptions) (azblob.SASQueryPar
 	}
 
 	permissions := azblob.AccountSASPermissions{Read: true}
-	if o.Method == http.MethodPut {
+	switch o.Method {
+	case http.MethodPut:
 		permissions = azblob.AccountSASPermissions{Write: true}
+	case http.MethodDelete:
+		permissions = azblob.AccountSASPermissions{Delete: true}
 	}
 
 	// Set the desired SAS signature values and sign them with the
@@ -93,3 +98,65 @@ func getSASQueryParameters(name string, o *signedURLOptions) (azblob.SASQueryPar
 
 	return sas, nil
 }
+
+func containerURL(o *signedURLOptions) (azblob.ContainerURL, error) {
+	credential, err := azblob.NewSharedKeyCredential(o.Credentials.AccountName, o.Credentials.AccountKey)
+	if err != nil {
+		return azblob.ContainerURL{}, fmt.Errorf("creating Azure signature: %w", err)
+	}
+
+	domain := DefaultAzureServer
+	if o.StorageDomain != "" {
+		domain = o.StorageDomain
+	}
+
+	u := url.URL{
+		Scheme: "https",
+		Host:   fmt.Sprintf("%s.%s", o.Credentials.AccountName, domain),
+		Path:   "/" + o.ContainerName,
+	}
+
+	return azblob.NewContainerURL(u, azblob.NewPipeline(credential, azblob.PipelineOptions{})), nil
+}
+
+func listBlobs(ctx context.Context, prefix string, o *signedURLOptions) ([]cache.Object, error) {
+	container, err := containerURL(o)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []cache.Object
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		segment, err := container.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{Prefix: prefix})
+		if err != nil {
+			return nil, fmt.Errorf("listing Azure blobs: %w", err)
+		}
+
+		for _, blob := range segment.Segment.BlobItems {
+			object := cache.Object{Name: blob.Name, Updated: blob.Properties.LastModified}
+			if blob.Properties.ContentLength != nil {
+				object.Size = *blob.Properties.ContentLength
+			}
+
+			objects = append(objects, object)
+		}
+
+		marker = segment.NextMarker
+	}
+
+	return objects, nil
+}
+
+func deleteBlob(ctx context.Context, name string, o *signedURLOptions) error {
+	container, err := containerURL(o)
+	if err != nil {
+		return err
+	}
+
+	_, err = container.NewBlobURL(name).Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
+	if err != nil {
+		return fmt.Errorf("deleting Azure blob: %w", err)
+	}
+
+	return nil
+}
@@ -50,6 +50,11 @@ func TestAzureClientURLGeneration(t *testing.T) {
 			accountKey:  accountKey,
 			method:      http.MethodPut,
 		},
+		"DELETE request": {
+			accountName: accountName,
+			accountKey:  accountKey,
+			method:      http.MethodDelete,
+		},
 	}
 
 	for tn, tt := range tests {
@@ -99,10 +104,11 @@ func TestAzureClientURLGeneration(t *testing.T) {
 			assert.Equal(t, []string{"https"}, q["spr"]) // SignedProtocol
 
 			// SignedPermission
-			expectedPermissionValue := "w"
-			if tt.method == http.MethodGet {
-				expectedPermissionValue = "r"
-			}
+			expectedPermissionValue := map[string]string{
+				http.MethodGet:    "r",
+				http.MethodPut:    "w",
+				http.MethodDelete: "d",
+			}[tt.method]
 			assert.Equal(t, []string{expectedPermissionValue}, q["sp"])
 		})
 	}
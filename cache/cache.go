@@ -1,18 +1,25 @@
 package cache
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/url"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
 	"gitlab.com/gitlab-org/gitlab-runner/common"
 )
 
+// listTimeout limits the time spent listing the caches while the job
+// script is generated
+const listTimeout = time.Minute
+
 var createAdapter = CreateAdapter
 
 func getCacheConfig(build *common.Build) *common.CacheConfig {
@@ -23,13 +30,19 @@ func getCacheConfig(build *common.Build) *common.CacheConfig {
 	return build.Runner.Cache
 }
 
-func generateBaseObjectName(build *common.Build, config *common.CacheConfig) string {
+// generateRunnerObjectName returns the prefix of the object names of the
+// caches of all the projects using the runner
+func generateRunnerObjectName(runner *common.RunnerConfig, config *common.CacheConfig) string {
 	runnerSegment := ""
 	if !config.GetShared() {
-		runnerSegment = path.Join("runner", build.Runner.ShortDescription())
+		runnerSegment = path.Join("runner", runner.ShortDescription())
 	}
 
-	return path.Join(config.GetPath(), runnerSegment, "project", strconv.Itoa(build.JobInfo.ProjectID))
+	return path.Join(config.GetPath(), runnerSegment, "project")
+}
+
+func generateBaseObjectName(build *common.Build, config *common.CacheConfig) string {
+	return path.Join(generateRunnerObjectName(build.Runner, config), strconv.Itoa(build.JobInfo.ProjectID))
 }
 
 func generateObjectName(build *common.Build, config *common.CacheConfig, key string) (string, error) {
@@ -94,6 +107,25 @@ func GetCacheDownloadURL(build *common.Build, key string) *url.URL {
 	})
 }
 
+// GetCacheDownloadURLWithEnv returns the download URL of the cache with the
+// environment of the cache extractor downloading it
+func GetCacheDownloadURLWithEnv(build *common.Build, key string) (*url.URL, map[string]string) {
+	var u *url.URL
+	var env map[string]string
+
+	onAdapter(build, key, func(adapter Adapter) interface{} {
+		if a, ok := adapter.(DownloadEnvAdapter); ok {
+			u, env = a.GetDownloadURLWithEnv()
+			return nil
+		}
+
+		u = adapter.GetDownloadURL()
+		return nil
+	})
+
+	return u, env
+}
+
 func castToURL(handler func() interface{}) *url.URL {
 	result := handler()
 
@@ -146,3 +178,63 @@ func GetCacheUploadEnv(build *common.Build, key string) map[string]string {
 
 	return m
 }
+
+// ListCacheKeys returns the keys of the stored caches of the project
+// starting with prefix, the most recently updated first
+func ListCacheKeys(build *common.Build, prefix string) ([]string, error) {
+	config := getCacheConfig(build)
+	if config == nil {
+		return nil, fmt.Errorf("cache config not defined")
+	}
+
+	basePath := generateBaseObjectName(build, config)
+	if strings.Contains(prefix, "..") {
+		return nil, fmt.Errorf("cache key prefix %q can't contain `..`", prefix)
+	}
+
+	adapter, err := createAdapter(config, build.GetBuildTimeout(), basePath+"/"+prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	lister, ok := adapter.(Lister)
+	if !ok {
+		return nil, ErrListNotSupported
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), listTimeout)
+	defer cancel()
+
+	objects, err := lister.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(objects, func(i, j int) bool {
+		return objects[i].Updated.After(objects[j].Updated)
+	})
+
+	var keys []string
+	for _, object := range objects {
+		key := strings.TrimPrefix(object.Name, basePath+"/")
+		if !strings.HasPrefix(key, prefix) || isInternalKey(key) {
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// isInternalKey tells if the key is used internally by the cache helpers,
+// like the chunk packs of the chunked format
+func isInternalKey(key string) bool {
+	for _, element := range strings.Split(key, "/") {
+		if strings.HasPrefix(element, ".") {
+			return true
+		}
+	}
+
+	return false
+}
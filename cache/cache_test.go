@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"context"
 	"errors"
 	"net/url"
 	"testing"
@@ -379,3 +380,163 @@ func TestCacheUploadEnv(t *testing.T) {
 		})
 	}
 }
+
+type listerAdapter struct {
+	MockAdapter
+
+	objects []Object
+	err     error
+}
+
+func (a *listerAdapter) List(_ context.Context) ([]Object, error) {
+	return a.objects, a.err
+}
+
+func TestListCacheKeys(t *testing.T) {
+	now := time.Now()
+
+	tests := map[string]struct {
+		prefix              string
+		adapter             Adapter
+		expectedObjectName  string
+		expectedKeys        []string
+		expectedErr         error
+		expectedErrContains string
+	}{
+		"keys sorted by update time": {
+			prefix: "deps-",
+			adapter: &listerAdapter{
+				objects: []Object{
+					{Name: "project/10/deps-old", Updated: now.Add(-time.Hour)},
+					{Name: "project/10/deps-new", Updated: now},
+					{Name: "project/10/deps-/.chunks/ab/abcd", Updated: now},
+				},
+			},
+			expectedObjectName: "project/10/deps-",
+			expectedKeys:       []string{"deps-new", "deps-old"},
+		},
+		"adapter without listing": {
+			prefix:      "deps-",
+			adapter:     new(MockAdapter),
+			expectedErr: ErrListNotSupported,
+		},
+		"listing error": {
+			prefix:              "deps-",
+			adapter:             &listerAdapter{err: errors.New("access denied")},
+			expectedErrContains: "access denied",
+		},
+		"path traversal": {
+			prefix:              "../",
+			adapter:             &listerAdapter{},
+			expectedErrContains: "can't contain",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			var objectName string
+
+			oldCreateAdapter := createAdapter
+			createAdapter = func(_ *common.CacheConfig, _ time.Duration, name string) (Adapter, error) {
+				objectName = name
+				return tt.adapter, nil
+			}
+			defer func() {
+				createAdapter = oldCreateAdapter
+			}()
+
+			build := &common.Build{
+				Runner: &common.RunnerConfig{
+					RunnerSettings: common.RunnerSettings{
+						Cache: &common.CacheConfig{Shared: true},
+					},
+				},
+				JobResponse: common.JobResponse{
+					JobInfo: common.JobInfo{ProjectID: 10},
+				},
+			}
+
+			keys, err := ListCacheKeys(build, tt.prefix)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			if tt.expectedErrContains != "" {
+				assert.Contains(t, err.Error(), tt.expectedErrContains)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedObjectName, objectName)
+			assert.Equal(t, tt.expectedKeys, keys)
+		})
+	}
+}
+
+type downloadEnvAdapter struct {
+	MockAdapter
+
+	url *url.URL
+	env map[string]string
+}
+
+func (a *downloadEnvAdapter) GetDownloadURLWithEnv() (*url.URL, map[string]string) {
+	return a.url, a.env
+}
+
+func TestGetCacheDownloadURLWithEnv(t *testing.T) {
+	exampleURL, err := url.Parse("https://example.com/cache")
+	require.NoError(t, err)
+
+	env := map[string]string{DownloadAuthorizationVariable: "Bearer token"}
+
+	tests := map[string]struct {
+		adapter     func() Adapter
+		expectedURL *url.URL
+		expectedEnv map[string]string
+	}{
+		"adapter without environment": {
+			adapter: func() Adapter {
+				a := new(MockAdapter)
+				a.On("GetDownloadURL").Return(exampleURL).Once()
+				return a
+			},
+			expectedURL: exampleURL,
+		},
+		"adapter with environment": {
+			adapter: func() Adapter {
+				return &downloadEnvAdapter{url: exampleURL, env: env}
+			},
+			expectedURL: exampleURL,
+			expectedEnv: env,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			adapter := tt.adapter()
+
+			oldCreateAdapter := createAdapter
+			createAdapter = func(_ *common.CacheConfig, _ time.Duration, _ string) (Adapter, error) {
+				return adapter, nil
+			}
+			defer func() {
+				createAdapter = oldCreateAdapter
+			}()
+
+			build := &common.Build{
+				Runner: &common.RunnerConfig{
+					RunnerSettings: common.RunnerSettings{Cache: &common.CacheConfig{}},
+				},
+			}
+
+			u, env := GetCacheDownloadURLWithEnv(build, "key")
+			assert.Equal(t, tt.expectedURL, u)
+			assert.Equal(t, tt.expectedEnv, env)
+
+			if a, ok := adapter.(*MockAdapter); ok {
+				a.AssertExpectations(t)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+// ChunkPacksVariable passes the chunk packs of a cache in the chunked format
+// to the cache helpers
+const ChunkPacksVariable = "CACHE_CHUNK_PACKS"
+
+const (
+	// chunksKey is the key under which the chunk packs of the caches of
+	// a project are stored
+	chunksKey = ".chunks"
+	// packsSuffix is appended to the cache key to name the directory of its
+	// chunk packs, which can't collide with the packs of the keys nested
+	// in the cache key
+	packsSuffix = ".packs"
+)
+
+// maxChunkPacks limits the number of the chunk packs passed to the cache
+// helpers, the most recently uploaded first. The cache archiver repacks the
+// chunks long before a cache references that many packs.
+const maxChunkPacks = 16
+
+// ChunkPacks describes the packs storing the chunks of a cache in the chunked
+// format. Each job archiving the cache uploads the chunks missing from the
+// stored packs to a new pack, and the manifest of the cache locates the
+// chunks in the packs.
+type ChunkPacks struct {
+	// Packs are the stored packs of the cache, downloaded by the cache
+	// extractor and deleted by the cache archiver when not used anymore
+	Packs []ChunkPack `json:"packs,omitempty"`
+
+	// Name, UploadURL and UploadHeaders upload the new pack of the cache
+	// archiver
+	Name          string      `json:"name,omitempty"`
+	UploadURL     string      `json:"upload_url,omitempty"`
+	UploadHeaders http.Header `json:"upload_headers,omitempty"`
+}
+
+// ChunkPack is a stored pack of the chunks of a cache
+type ChunkPack struct {
+	Name        string `json:"name"`
+	Size        int64  `json:"size"`
+	DownloadURL string `json:"download_url,omitempty"`
+	DeleteURL   string `json:"delete_url,omitempty"`
+}
+
+// generatePacksObjectName returns the prefix of the object names of the chunk
+// packs of the cache
+func generatePacksObjectName(build *common.Build, config *common.CacheConfig, key string) (string, error) {
+	objectName, err := generateObjectName(build, config, key)
+	if err != nil || objectName == "" {
+		return "", err
+	}
+
+	return path.Join(generateBaseObjectName(build, config), chunksKey, key) + packsSuffix + "/", nil
+}
+
+// GetCacheChunkPacks returns the chunk packs of the cache, or nil when the
+// adapter can't store them. The packs are listed and signed for the cache
+// extractor, or for the cache archiver with upload.
+func GetCacheChunkPacks(build *common.Build, key string, upload bool) *ChunkPacks {
+	config := getCacheConfig(build)
+	if config == nil {
+		return nil
+	}
+
+	prefix, err := generatePacksObjectName(build, config, key)
+	if err != nil || prefix == "" {
+		return nil
+	}
+
+	adapter, err := createAdapter(config, build.GetBuildTimeout(), prefix)
+	if err != nil {
+		logrus.WithError(err).Error("Could not create cache adapter")
+		return nil
+	}
+
+	lister, ok := adapter.(Lister)
+	if !ok {
+		return nil
+	}
+
+	packs := &ChunkPacks{}
+	if upload {
+		packs.Name = newChunkPackName(build)
+
+		packAdapter, err := createAdapter(config, build.GetBuildTimeout(), prefix+packs.Name)
+		if err != nil {
+			logrus.WithError(err).Error("Could not create cache adapter")
+			return nil
+		}
+
+		u := packAdapter.GetUploadURL()
+		if u == nil {
+			return nil
+		}
+
+		packs.UploadURL = u.String()
+		packs.UploadHeaders = packAdapter.GetUploadHeaders()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), listTimeout)
+	defer cancel()
+
+	// Without the stored packs the cache can't be extracted, and the
+	// archiver uploads all its chunks again
+	objects, err := lister.List(ctx)
+	if err != nil {
+		logrus.WithError(err).Warning("Failed to list the chunk packs of the cache")
+		return packs
+	}
+
+	for _, object := range selectChunkPacks(objects, prefix) {
+		pack, ok := signChunkPack(build, config, object, prefix, upload)
+		if !ok {
+			return nil
+		}
+
+		packs.Packs = append(packs.Packs, pack)
+	}
+
+	return packs
+}
+
+// selectChunkPacks returns the packs listed under the prefix, the most
+// recently uploaded first
+func selectChunkPacks(objects []Object, prefix string) []Object {
+	var packs []Object
+	for _, object := range objects {
+		name := strings.TrimPrefix(object.Name, prefix)
+		if name == "" || name == object.Name || strings.Contains(name, "/") {
+			continue
+		}
+
+		packs = append(packs, object)
+	}
+
+	sort.SliceStable(packs, func(i, j int) bool {
+		return packs[i].Updated.After(packs[j].Updated)
+	})
+
+	if len(packs) > maxChunkPacks {
+		packs = packs[:maxChunkPacks]
+	}
+
+	return packs
+}
+
+func signChunkPack(
+	build *common.Build,
+	config *common.CacheConfig,
+	object Object,
+	prefix string,
+	upload bool,
+) (ChunkPack, bool) {
+	pack := ChunkPack{Name: strings.TrimPrefix(object.Name, prefix), Size: object.Size}
+
+	adapter, err := createAdapter(config, build.GetBuildTimeout(), object.Name)
+	if err != nil {
+		logrus.WithError(err).Error("Could not create cache adapter")
+		return pack, false
+	}
+
+	if upload {
+		// The packs are removed by the garbage collection when the
+		// adapter can't sign their deletion
+		if deleter, ok := adapter.(DeleteURLAdapter); ok {
+			if u := deleter.GetDeleteURL(); u != nil {
+				pack.DeleteURL = u.String()
+			}
+		}
+
+		return pack, true
+	}
+
+	u := adapter.GetDownloadURL()
+	if u == nil {
+		return pack, false
+	}
+	pack.DownloadURL = u.String()
+
+	return pack, true
+}
+
+// newChunkPackName returns a unique name for the pack uploaded by the job
+func newChunkPackName(build *common.Build) string {
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+
+	return strconv.Itoa(build.ID) + "-" + hex.EncodeToString(suffix)
+}
+
+// GetCacheChunkPacksEnv returns the environment passing the chunk packs of
+// the cache to the cache helpers, or nil when the adapter can't store them
+func GetCacheChunkPacksEnv(build *common.Build, key string, upload bool) map[string]string {
+	packs := GetCacheChunkPacks(build, key, upload)
+	if packs == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(packs)
+	if err != nil {
+		logrus.WithError(err).Error("Error encoding the cache chunk packs")
+		return nil
+	}
+
+	return map[string]string{ChunkPacksVariable: string(data)}
+}
+
+// chunkPackOwner returns the object name of the cache owning the chunk
+// pack, relative to the prefix of the caches of the runner. The other objects
+// stored under the chunks key, like the chunks of the previous layout of the
+// chunked format, have no owner. It returns false for the objects not stored
+// under the chunks key.
+func chunkPackOwner(name string) (string, bool) {
+	elements := strings.SplitN(name, "/", 3)
+	if len(elements) != 3 || elements[1] != chunksKey {
+		return "", false
+	}
+
+	dir := path.Dir(elements[2])
+	if !strings.HasSuffix(dir, packsSuffix) || dir == packsSuffix {
+		return "", true
+	}
+
+	return path.Join(elements[0], strings.TrimSuffix(dir, packsSuffix)), true
+}
@@ -0,0 +1,195 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+// packsAdapter signs URLs made of the method and the object name
+type packsAdapter struct {
+	listerAdapter
+
+	name      string
+	noUpload  bool
+	noDelete  bool
+	uploadHdr http.Header
+}
+
+func (a *packsAdapter) signedURL(method string) *url.URL {
+	return &url.URL{Scheme: "https", Host: "cache.example.com", Path: "/" + a.name, RawQuery: "method=" + method}
+}
+
+func (a *packsAdapter) GetDownloadURL() *url.URL {
+	return a.signedURL(http.MethodGet)
+}
+
+func (a *packsAdapter) GetUploadURL() *url.URL {
+	if a.noUpload {
+		return nil
+	}
+
+	return a.signedURL(http.MethodPut)
+}
+
+func (a *packsAdapter) GetUploadHeaders() http.Header {
+	return a.uploadHdr
+}
+
+func (a *packsAdapter) GetDeleteURL() *url.URL {
+	if a.noDelete {
+		return nil
+	}
+
+	return a.signedURL(http.MethodDelete)
+}
+
+func TestGetCacheChunkPacks(t *testing.T) {
+	now := time.Now()
+	prefix := "project/10/.chunks/deps.packs/"
+
+	objects := []Object{
+		{Name: prefix + "1-aaaa", Size: 10, Updated: now.Add(-time.Hour)},
+		{Name: prefix + "2-bbbb", Size: 20, Updated: now},
+		{Name: "project/10/.chunks/deps.packs/nested/3-cccc", Size: 30, Updated: now},
+	}
+
+	tests := map[string]struct {
+		upload        bool
+		noUpload      bool
+		noDelete      bool
+		listErr       error
+		adapter       func(name string) Adapter
+		expectedNil   bool
+		expectedPacks []ChunkPack
+	}{
+		"download": {
+			expectedPacks: []ChunkPack{
+				{Name: "2-bbbb", Size: 20, DownloadURL: "https://cache.example.com/" + prefix + "2-bbbb?method=GET"},
+				{Name: "1-aaaa", Size: 10, DownloadURL: "https://cache.example.com/" + prefix + "1-aaaa?method=GET"},
+			},
+		},
+		"upload": {
+			upload: true,
+			expectedPacks: []ChunkPack{
+				{Name: "2-bbbb", Size: 20, DeleteURL: "https://cache.example.com/" + prefix + "2-bbbb?method=DELETE"},
+				{Name: "1-aaaa", Size: 10, DeleteURL: "https://cache.example.com/" + prefix + "1-aaaa?method=DELETE"},
+			},
+		},
+		"upload without delete URLs": {
+			upload:   true,
+			noDelete: true,
+			expectedPacks: []ChunkPack{
+				{Name: "2-bbbb", Size: 20},
+				{Name: "1-aaaa", Size: 10},
+			},
+		},
+		"upload without upload URL": {
+			upload:      true,
+			noUpload:    true,
+			expectedNil: true,
+		},
+		"listing error": {
+			listErr: errors.New("access denied"),
+		},
+		"adapter without listing": {
+			adapter: func(_ string) Adapter {
+				return new(MockAdapter)
+			},
+			expectedNil: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			oldCreateAdapter := createAdapter
+			createAdapter = func(_ *common.CacheConfig, _ time.Duration, name string) (Adapter, error) {
+				if tt.adapter != nil {
+					return tt.adapter(name), nil
+				}
+
+				return &packsAdapter{
+					listerAdapter: listerAdapter{objects: objects, err: tt.listErr},
+					name:          name,
+					noUpload:      tt.noUpload,
+					noDelete:      tt.noDelete,
+				}, nil
+			}
+			defer func() {
+				createAdapter = oldCreateAdapter
+			}()
+
+			build := &common.Build{
+				Runner: &common.RunnerConfig{
+					RunnerSettings: common.RunnerSettings{
+						Cache: &common.CacheConfig{Shared: true},
+					},
+				},
+				JobResponse: common.JobResponse{
+					ID:      1234,
+					JobInfo: common.JobInfo{ProjectID: 10},
+				},
+			}
+
+			packs := GetCacheChunkPacks(build, "deps", tt.upload)
+			if tt.expectedNil {
+				assert.Nil(t, packs)
+				return
+			}
+
+			require.NotNil(t, packs)
+			assert.Equal(t, tt.expectedPacks, packs.Packs)
+
+			if !tt.upload {
+				assert.Empty(t, packs.Name)
+				assert.Empty(t, packs.UploadURL)
+				return
+			}
+
+			assert.True(t, strings.HasPrefix(packs.Name, "1234-"), packs.Name)
+			assert.Equal(t, "https://cache.example.com/"+prefix+packs.Name+"?method=PUT", packs.UploadURL)
+		})
+	}
+}
+
+func TestGetCacheChunkPacksEnv(t *testing.T) {
+	oldCreateAdapter := createAdapter
+	createAdapter = func(_ *common.CacheConfig, _ time.Duration, name string) (Adapter, error) {
+		return &packsAdapter{name: name}, nil
+	}
+	defer func() {
+		createAdapter = oldCreateAdapter
+	}()
+
+	build := defaultBuild(defaultCacheConfig())
+
+	env := GetCacheChunkPacksEnv(build, "deps", true)
+	require.Contains(t, env, ChunkPacksVariable)
+
+	var packs ChunkPacks
+	require.NoError(t, json.Unmarshal([]byte(env[ChunkPacksVariable]), &packs))
+	assert.NotEmpty(t, packs.Name)
+	assert.NotEmpty(t, packs.UploadURL)
+
+	assert.Nil(t, GetCacheChunkPacksEnv(build, "", true), "empty cache key")
+}
+
+func TestSelectChunkPacksLimit(t *testing.T) {
+	var objects []Object
+	for i := 0; i < maxChunkPacks+4; i++ {
+		objects = append(objects, Object{Name: "packs/" + string(rune('a'+i)), Updated: time.Unix(int64(i), 0)})
+	}
+
+	packs := selectChunkPacks(objects, "packs/")
+	require.Len(t, packs, maxChunkPacks)
+	assert.Equal(t, objects[len(objects)-1], packs[0], "the most recently uploaded first")
+}
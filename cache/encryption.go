@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/encryption"
+)
+
+// EncryptionKeysVariable passes the keys encrypting and decrypting the
+// caches to the cache helpers
+const EncryptionKeysVariable = "CACHE_ENCRYPTION_KEYS"
+
+func encryptionEnabled(config *common.CacheConfig) bool {
+	e := config.Encryption
+
+	return e != nil && (e.KeyID != "" || e.Key != "" || e.KeyVariable != "")
+}
+
+// ObjectMetadata returns the metadata the adapters record with the uploaded
+// caches. The ID of the key encrypting the cache is recorded, so keys can be
+// rotated.
+func ObjectMetadata(config *common.CacheConfig) map[string]string {
+	if config == nil || !encryptionEnabled(config) || config.Encryption.KeyID == "" {
+		return nil
+	}
+
+	return map[string]string{encryption.KeyIDMetadata: config.Encryption.KeyID}
+}
+
+// GetCacheEncryptionKeys returns the keyring encrypting the caches of the
+// build, or nil when the caches aren't encrypted. The key is read from the
+// configuration or from a variable of the runner's environment, never from
+// the variables of the job.
+func GetCacheEncryptionKeys(build *common.Build) (*encryption.Keyring, error) {
+	config := getCacheConfig(build)
+	if config == nil || !encryptionEnabled(config) {
+		return nil, nil
+	}
+
+	e := config.Encryption
+	if e.KeyID == "" {
+		return nil, errors.New("cache encryption key ID not defined")
+	}
+
+	encodedKey := e.GetKey(build.Runner.GetVariables())
+	switch {
+	case e.Key != "" && e.KeyVariable != "":
+		return nil, errors.New("cache encryption key and key variable can't be both defined")
+	case e.KeyVariable != "" && encodedKey == "":
+		return nil, fmt.Errorf("cache encryption key variable %s not defined in the runner's environment", e.KeyVariable)
+	case encodedKey == "":
+		return nil, errors.New("cache encryption key not defined")
+	}
+
+	key, err := encryption.DecodeKey(encodedKey)
+	if err != nil {
+		return nil, fmt.Errorf("cache encryption key %q: %w", e.KeyID, err)
+	}
+
+	keyring, err := encryption.NewKeyring(e.KeyID, key)
+	if err != nil {
+		return nil, err
+	}
+
+	for keyID, encodedKey := range e.PreviousKeys {
+		if keyID == e.KeyID {
+			continue
+		}
+
+		key, err := encryption.DecodeKey(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("cache encryption key %q: %w", keyID, err)
+		}
+
+		err = keyring.Add(keyID, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return keyring, nil
+}
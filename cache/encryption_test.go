@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/encryption"
+)
+
+func TestGetCacheEncryptionKeys(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, encryption.KeySize)
+	previousKey := bytes.Repeat([]byte{2}, encryption.KeySize)
+	encodedKey := base64.StdEncoding.EncodeToString(key)
+	encodedPreviousKey := base64.StdEncoding.EncodeToString(previousKey)
+
+	tests := map[string]struct {
+		config          *common.CacheEncryptionConfig
+		environment     []string
+		variables       common.JobVariables
+		expectedKeys    map[string][]byte
+		expectedErr     string
+		expectedNoCrypt bool
+	}{
+		"encryption not configured": {
+			expectedNoCrypt: true,
+		},
+		"empty encryption section": {
+			config:          &common.CacheEncryptionConfig{},
+			expectedNoCrypt: true,
+		},
+		"key from the configuration": {
+			config:       &common.CacheEncryptionConfig{KeyID: "v2", Key: encodedKey},
+			expectedKeys: map[string][]byte{"v2": key},
+		},
+		"key from a variable": {
+			config:       &common.CacheEncryptionConfig{KeyID: "v2", KeyVariable: "CACHE_KEY"},
+			environment:  []string{"CACHE_KEY=" + encodedKey + "\n"},
+			expectedKeys: map[string][]byte{"v2": key},
+		},
+		"key variable overridden by the job": {
+			config:       &common.CacheEncryptionConfig{KeyID: "v2", KeyVariable: "CACHE_KEY"},
+			environment:  []string{"CACHE_KEY=" + encodedKey},
+			variables:    common.JobVariables{{Key: "CACHE_KEY", Value: encodedPreviousKey}},
+			expectedKeys: map[string][]byte{"v2": key},
+		},
+		"key variable of the job": {
+			config:      &common.CacheEncryptionConfig{KeyID: "v2", KeyVariable: "CACHE_KEY"},
+			variables:   common.JobVariables{{Key: "CACHE_KEY", Value: encodedKey}},
+			expectedErr: "variable CACHE_KEY not defined in the runner's environment",
+		},
+		"previous keys": {
+			config: &common.CacheEncryptionConfig{
+				KeyID:        "v2",
+				Key:          encodedKey,
+				PreviousKeys: map[string]string{"v1": encodedPreviousKey, "v2": encodedPreviousKey},
+			},
+			expectedKeys: map[string][]byte{"v2": key, "v1": previousKey},
+		},
+		"missing key ID": {
+			config:      &common.CacheEncryptionConfig{Key: encodedKey},
+			expectedErr: "key ID not defined",
+		},
+		"missing key": {
+			config:      &common.CacheEncryptionConfig{KeyID: "v2"},
+			expectedErr: "key not defined",
+		},
+		"missing key variable": {
+			config:      &common.CacheEncryptionConfig{KeyID: "v2", KeyVariable: "CACHE_KEY"},
+			expectedErr: "variable CACHE_KEY not defined",
+		},
+		"key and key variable": {
+			config:      &common.CacheEncryptionConfig{KeyID: "v2", Key: encodedKey, KeyVariable: "CACHE_KEY"},
+			expectedErr: "can't be both defined",
+		},
+		"invalid key": {
+			config:      &common.CacheEncryptionConfig{KeyID: "v2", Key: "AQEB"},
+			expectedErr: "must be 32 bytes long",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			build := &common.Build{
+				Runner: &common.RunnerConfig{
+					RunnerSettings: common.RunnerSettings{
+						Environment: tt.environment,
+						Cache:       &common.CacheConfig{Encryption: tt.config},
+					},
+				},
+				JobResponse: common.JobResponse{Variables: tt.variables},
+			}
+
+			keyring, err := GetCacheEncryptionKeys(build)
+			if tt.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			if tt.expectedNoCrypt {
+				assert.Nil(t, keyring)
+				assert.Nil(t, ObjectMetadata(build.Runner.Cache))
+				return
+			}
+
+			assert.Equal(t, "v2", keyring.KeyID)
+			assert.Equal(t, tt.expectedKeys, keyring.Keys)
+			assert.Equal(t, map[string]string{encryption.KeyIDMetadata: "v2"}, ObjectMetadata(build.Runner.Cache))
+		})
+	}
+}
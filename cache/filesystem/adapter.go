@@ -0,0 +1,150 @@
+package filesystem
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+// filesystemAdapter stores the cache archives in a directory accessible to
+// the cache helpers, which read and write them through `file://` URLs
+type filesystemAdapter struct {
+	config     *common.CacheFilesystemConfig
+	objectName string
+}
+
+func (a *filesystemAdapter) GetDownloadURL() *url.URL {
+	return a.fileURL()
+}
+
+func (a *filesystemAdapter) GetUploadURL() *url.URL {
+	return a.fileURL()
+}
+
+// GetDeleteURL returns the URL of the file, deleted by the cache helpers
+// with a DELETE request
+func (a *filesystemAdapter) GetDeleteURL() *url.URL {
+	return a.fileURL()
+}
+
+func (a *filesystemAdapter) GetUploadHeaders() http.Header {
+	return nil
+}
+
+func (a *filesystemAdapter) GetGoCloudURL() *url.URL {
+	return nil
+}
+
+func (a *filesystemAdapter) GetUploadEnv() map[string]string {
+	return nil
+}
+
+// List walks the directory containing the object name, since the object
+// name can be a prefix of both files and directories
+func (a *filesystemAdapter) List(ctx context.Context) ([]cache.Object, error) {
+	root := filepath.Clean(a.config.Path)
+	dir := filepath.Join(root, filepath.Dir(filepath.FromSlash(a.objectName)))
+
+	var objects []cache.Object
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		name, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		name = filepath.ToSlash(name)
+		if !fi.Mode().IsRegular() || !strings.HasPrefix(name, a.objectName) {
+			return nil
+		}
+
+		objects = append(objects, cache.Object{Name: name, Size: fi.Size(), Updated: fi.ModTime()})
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing the cache directory: %w", err)
+	}
+
+	return cache.SetAccessTimes(objects), nil
+}
+
+// Delete removes the file of the object, and the directories left empty
+// by its removal
+func (a *filesystemAdapter) Delete(_ context.Context, name string) error {
+	root := filepath.Clean(a.config.Path)
+	path := filepath.Join(root, filepath.FromSlash(name))
+
+	if !strings.HasPrefix(path, root+string(filepath.Separator)) {
+		return fmt.Errorf("object %q outside of the cache directory", name)
+	}
+
+	err := os.Remove(path)
+	if err != nil {
+		return fmt.Errorf("removing cache file: %w", err)
+	}
+
+	for dir := filepath.Dir(path); dir != root; dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (a *filesystemAdapter) fileURL() *url.URL {
+	path := filepath.ToSlash(filepath.Join(a.config.Path, filepath.FromSlash(a.objectName)))
+
+	// Windows paths start with the drive letter
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	return &url.URL{
+		Scheme: "file",
+		Path:   path,
+	}
+}
+
+func New(config *common.CacheConfig, _ time.Duration, objectName string) (cache.Adapter, error) {
+	filesystem := config.Filesystem
+	if filesystem == nil || filesystem.Path == "" {
+		return nil, fmt.Errorf("missing filesystem configuration")
+	}
+
+	if !filepath.IsAbs(filesystem.Path) {
+		return nil, fmt.Errorf("filesystem cache path %q must be absolute", filesystem.Path)
+	}
+
+	a := &filesystemAdapter{
+		config:     filesystem,
+		objectName: objectName,
+	}
+
+	return a, nil
+}
+
+func init() {
+	err := cache.Factories().Register("filesystem", New)
+	if err != nil {
+		panic(err)
+	}
+}
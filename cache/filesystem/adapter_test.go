@@ -0,0 +1,206 @@
+package filesystem
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+func cacheRoot() string {
+	if runtime.GOOS == "windows" {
+		return `C:\cache`
+	}
+
+	return "/cache"
+}
+
+func TestNew(t *testing.T) {
+	tests := map[string]struct {
+		config        *common.CacheConfig
+		expectedError string
+	}{
+		"missing configuration": {
+			config:        &common.CacheConfig{Type: "filesystem"},
+			expectedError: "missing filesystem configuration",
+		},
+		"missing path": {
+			config: &common.CacheConfig{
+				Type:       "filesystem",
+				Filesystem: &common.CacheFilesystemConfig{},
+			},
+			expectedError: "missing filesystem configuration",
+		},
+		"relative path": {
+			config: &common.CacheConfig{
+				Type:       "filesystem",
+				Filesystem: &common.CacheFilesystemConfig{Path: "cache"},
+			},
+			expectedError: `filesystem cache path "cache" must be absolute`,
+		},
+		"valid configuration": {
+			config: &common.CacheConfig{
+				Type:       "filesystem",
+				Filesystem: &common.CacheFilesystemConfig{Path: cacheRoot()},
+			},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			adapter, err := New(tt.config, time.Hour, "key")
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.NotNil(t, adapter)
+		})
+	}
+}
+
+func TestAdapterURLs(t *testing.T) {
+	config := &common.CacheConfig{
+		Type:       "filesystem",
+		Filesystem: &common.CacheFilesystemConfig{Path: cacheRoot()},
+	}
+
+	adapter, err := cache.CreateAdapter(config, time.Hour, "project/1/key")
+	require.NoError(t, err)
+
+	expected := "file://" + filepath.ToSlash(filepath.Join(cacheRoot(), "project", "1", "key"))
+	if runtime.GOOS == "windows" {
+		expected = "file:///C:/cache/project/1/key"
+	}
+
+	assert.Equal(t, expected, adapter.GetDownloadURL().String())
+	assert.Equal(t, expected, adapter.GetUploadURL().String())
+	assert.Equal(t, expected, adapter.(cache.DeleteURLAdapter).GetDeleteURL().String())
+	assert.Nil(t, adapter.GetUploadHeaders())
+	assert.Nil(t, adapter.GetGoCloudURL())
+	assert.Nil(t, adapter.GetUploadEnv())
+}
+
+func TestList(t *testing.T) {
+	root, err := ioutil.TempDir("", "filesystem-cache")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(root) }()
+
+	for _, name := range []string{"deps-a", "deps-b/cache.zip", "other"} {
+		path := filepath.Join(root, "project", "1", filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(name), 0600))
+	}
+
+	config := &common.CacheConfig{
+		Type:       "filesystem",
+		Filesystem: &common.CacheFilesystemConfig{Path: root},
+	}
+
+	tests := map[string]struct {
+		objectName    string
+		expectedNames []string
+	}{
+		"prefix of files and directories": {
+			objectName:    "project/1/deps-",
+			expectedNames: []string{"project/1/deps-a", "project/1/deps-b/cache.zip"},
+		},
+		"missing directory": {
+			objectName: "project/2/deps-",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			adapter, err := cache.CreateAdapter(config, time.Hour, tt.objectName)
+			require.NoError(t, err)
+
+			objects, err := adapter.(cache.Lister).List(context.Background())
+			require.NoError(t, err)
+
+			var names []string
+			for _, object := range objects {
+				assert.False(t, object.Updated.IsZero())
+				names = append(names, object.Name)
+			}
+			assert.ElementsMatch(t, tt.expectedNames, names)
+		})
+	}
+}
+
+func TestListAccessTimes(t *testing.T) {
+	root, err := ioutil.TempDir("", "filesystem-cache")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(root) }()
+
+	written := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	accessed := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	for name, modTime := range map[string]time.Time{"deps": written, ".access/deps": accessed, "other": written} {
+		path := filepath.Join(root, "project", "1", filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, nil, 0600))
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+
+	config := &common.CacheConfig{
+		Type:       "filesystem",
+		Filesystem: &common.CacheFilesystemConfig{Path: root},
+	}
+
+	adapter, err := cache.CreateAdapter(config, time.Hour, "project/")
+	require.NoError(t, err)
+
+	objects, err := adapter.(cache.Lister).List(context.Background())
+	require.NoError(t, err)
+
+	lastAccess := make(map[string]time.Time)
+	for _, object := range objects {
+		lastAccess[object.Name] = object.LastAccess()
+	}
+
+	assert.Equal(t, map[string]time.Time{
+		"project/1/deps":         accessed,
+		"project/1/.access/deps": accessed,
+		"project/1/other":        written,
+	}, lastAccess)
+}
+
+func TestDelete(t *testing.T) {
+	root, err := ioutil.TempDir("", "filesystem-cache")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(root) }()
+
+	for _, name := range []string{"project/1/nested/key", "project/1/other"} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(name), 0600))
+	}
+
+	config := &common.CacheConfig{
+		Type:       "filesystem",
+		Filesystem: &common.CacheFilesystemConfig{Path: root},
+	}
+
+	adapter, err := cache.CreateAdapter(config, time.Hour, "project/1/")
+	require.NoError(t, err)
+
+	deleter := adapter.(cache.Deleter)
+
+	require.NoError(t, deleter.Delete(context.Background(), "project/1/nested/key"))
+	assert.NoDirExists(t, filepath.Join(root, "project", "1", "nested"))
+	assert.FileExists(t, filepath.Join(root, "project", "1", "other"))
+
+	assert.Error(t, deleter.Delete(context.Background(), "project/1/nested/key"))
+	assert.Error(t, deleter.Delete(context.Background(), "../outside"))
+}
@@ -0,0 +1,257 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+// orphanPackAge is the age after which the chunk packs and the access
+// markers of a missing cache are removed. The packs are uploaded before the
+// manifest of their cache.
+const orphanPackAge = 24 * time.Hour
+
+// staleUploadAge is the age after which the incomplete multipart uploads of
+// the caches are aborted. The runner aborts the uploads of a job when the job
+// ends, so only the uploads of a runner stopped during a job are left.
+const staleUploadAge = 24 * time.Hour
+
+// GCPolicy selects the caches removed by the garbage collection. The size
+// budget removes the least recently used caches first: the caches downloaded
+// by the cache extractor are marked with their access time, and the caches
+// without marker are used when they're written. The chunk packs of a cache
+// count in its size.
+type GCPolicy struct {
+	// MaxAge selects the caches written more than MaxAge ago
+	MaxAge time.Duration
+	// MaxSize selects the least recently used caches until the total size
+	// of the remaining ones is at most MaxSize
+	MaxSize int64
+}
+
+func (p GCPolicy) expired(object Object, now time.Time) bool {
+	return p.MaxAge > 0 && now.Sub(object.Updated) > p.MaxAge
+}
+
+// selectObjects returns the objects to remove: the expired ones, and the
+// least recently used ones exceeding the size budget, in the order of their
+// last access. keptSize is the size of the stored objects which aren't
+// removed anyway, counted in MaxSize.
+func (p GCPolicy) selectObjects(objects []Object, keptSize int64, now time.Time) []Object {
+	sorted := make([]Object, len(objects))
+	copy(sorted, objects)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].LastAccess().Before(sorted[j].LastAccess())
+	})
+
+	size := keptSize
+	for _, object := range sorted {
+		if !p.expired(object, now) {
+			size += object.Size
+		}
+	}
+
+	var selected []Object
+	for _, object := range sorted {
+		if p.expired(object, now) {
+			selected = append(selected, object)
+			continue
+		}
+
+		if p.MaxSize > 0 && size > p.MaxSize {
+			selected = append(selected, object)
+			size -= object.Size
+		}
+	}
+
+	return selected
+}
+
+// CollectGarbage removes the caches of the runner selected by the policy and
+// returns them. With dryRun, the selected caches are returned without being
+// removed. The chunk packs of the chunked format and the access markers are
+// removed together with their cache, and when their cache is missing for
+// longer than orphanPackAge. The packs of the kept caches are never removed.
+func CollectGarbage(ctx context.Context, runner *common.RunnerConfig, policy GCPolicy, dryRun bool) ([]Object, error) {
+	adapter, prefix, err := runnerAdapter(runner)
+	if err != nil {
+		return nil, err
+	}
+
+	lister, ok := adapter.(Lister)
+	if !ok {
+		return nil, ErrListNotSupported
+	}
+
+	deleter, ok := adapter.(Deleter)
+	if !ok {
+		return nil, fmt.Errorf("cache type %q doesn't support removing caches", runner.Cache.Type)
+	}
+
+	objects, err := lister.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The chunk packs and the access markers belong to their cache
+	var caches []Object
+	owned := make(map[string][]Object)
+	for _, object := range objects {
+		name := strings.TrimPrefix(object.Name, prefix)
+		if owner, ok := chunkPackOwner(name); ok {
+			owned[owner] = append(owned[owner], object)
+			continue
+		}
+
+		if owner, ok := accessMarkerOwner(name); ok {
+			owned[owner] = append(owned[owner], object)
+			continue
+		}
+
+		if isInternalKey(name) {
+			continue
+		}
+
+		caches = append(caches, object)
+	}
+
+	now := time.Now()
+
+	// The caches are selected with the size of their chunk packs. The
+	// access markers are empty.
+	entries := make([]Object, 0, len(caches))
+	byName := make(map[string]Object, len(caches))
+	for _, object := range caches {
+		byName[object.Name] = object
+
+		entry := object
+		for _, pack := range owned[strings.TrimPrefix(object.Name, prefix)] {
+			entry.Size += pack.Size
+		}
+
+		entries = append(entries, entry)
+	}
+
+	orphans, keptSize := orphanPacks(caches, owned, prefix, now)
+
+	// Each cache is removed before its packs, so the remaining caches
+	// never reference removed packs
+	var selected []Object
+	for _, entry := range policy.selectObjects(entries, keptSize, now) {
+		owner := strings.TrimPrefix(entry.Name, prefix)
+		selected = append(selected, byName[entry.Name])
+		selected = append(selected, owned[owner]...)
+	}
+	selected = append(selected, orphans...)
+
+	if dryRun {
+		return selected, nil
+	}
+
+	for i, object := range selected {
+		err := deleter.Delete(ctx, object.Name)
+		if err != nil {
+			return selected[:i], fmt.Errorf("removing cache %s: %w", object.Name, err)
+		}
+	}
+
+	return selected, nil
+}
+
+// runnerAdapter returns the adapter of the prefix of the caches of the runner
+func runnerAdapter(runner *common.RunnerConfig) (Adapter, string, error) {
+	config := runner.Cache
+	if config == nil {
+		return nil, "", errors.New("cache config not defined")
+	}
+
+	prefix := generateRunnerObjectName(runner, config) + "/"
+
+	adapter, err := createAdapter(config, listTimeout, prefix)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return adapter, prefix, nil
+}
+
+// AbortStaleUploads aborts the multipart uploads of the caches of the runner
+// started more than staleUploadAge ago, and returns them. With dryRun, the
+// uploads are returned without being aborted. The adapters not storing the
+// incomplete uploads have none.
+func AbortStaleUploads(ctx context.Context, runner *common.RunnerConfig, dryRun bool) ([]IncompleteUpload, error) {
+	adapter, _, err := runnerAdapter(runner)
+	if err != nil {
+		return nil, err
+	}
+
+	lister, ok := adapter.(IncompleteUploadLister)
+	if !ok {
+		return nil, nil
+	}
+
+	uploads, err := lister.ListIncompleteUploads(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	var stale []IncompleteUpload
+	for _, upload := range uploads {
+		if now.Sub(upload.Initiated) > staleUploadAge {
+			stale = append(stale, upload)
+		}
+	}
+
+	if dryRun {
+		return stale, nil
+	}
+
+	for i, upload := range stale {
+		err := lister.AbortIncompleteUpload(ctx, upload)
+		if err != nil {
+			return stale[:i], fmt.Errorf("aborting the upload of cache %s: %w", upload.Name, err)
+		}
+	}
+
+	return stale, nil
+}
+
+// orphanPacks returns the chunk packs and the access markers whose cache is
+// missing for longer than orphanPackAge, and the total size of the other
+// packs without cache
+func orphanPacks(caches []Object, packs map[string][]Object, prefix string, now time.Time) ([]Object, int64) {
+	owned := make(map[string]bool, len(caches))
+	for _, object := range caches {
+		owned[strings.TrimPrefix(object.Name, prefix)] = true
+	}
+
+	owners := make([]string, 0, len(packs))
+	for owner := range packs {
+		if !owned[owner] {
+			owners = append(owners, owner)
+		}
+	}
+	sort.Strings(owners)
+
+	var orphans []Object
+	var keptSize int64
+	for _, owner := range owners {
+		for _, pack := range packs[owner] {
+			if now.Sub(pack.Updated) > orphanPackAge {
+				orphans = append(orphans, pack)
+				continue
+			}
+
+			keptSize += pack.Size
+		}
+	}
+
+	return orphans, keptSize
+}
@@ -0,0 +1,430 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+func TestGCPolicySelectObjects(t *testing.T) {
+	now := time.Now()
+
+	objects := []Object{
+		{Name: "new", Size: 10, Updated: now.Add(-time.Hour)},
+		{Name: "old", Size: 20, Updated: now.Add(-48 * time.Hour)},
+		{Name: "recent", Size: 30, Updated: now.Add(-24 * time.Hour)},
+	}
+
+	tests := map[string]struct {
+		policy        GCPolicy
+		keptSize      int64
+		expectedNames []string
+	}{
+		"no limits": {},
+		"max age": {
+			policy:        GCPolicy{MaxAge: 36 * time.Hour},
+			expectedNames: []string{"old"},
+		},
+		"max size": {
+			policy:        GCPolicy{MaxSize: 39},
+			expectedNames: []string{"old", "recent"},
+		},
+		"max size already satisfied": {
+			policy: GCPolicy{MaxSize: 60},
+		},
+		"max size with kept objects": {
+			policy:        GCPolicy{MaxSize: 60},
+			keptSize:      25,
+			expectedNames: []string{"old", "recent"},
+		},
+		"max age and max size": {
+			policy:        GCPolicy{MaxAge: 36 * time.Hour, MaxSize: 10},
+			expectedNames: []string{"old", "recent"},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			var names []string
+			for _, object := range tt.policy.selectObjects(objects, tt.keptSize, now) {
+				names = append(names, object.Name)
+			}
+
+			assert.Equal(t, tt.expectedNames, names)
+		})
+	}
+}
+
+func TestGCPolicySelectObjectsByLastAccess(t *testing.T) {
+	now := time.Now()
+
+	objects := []Object{
+		{Name: "old-but-read", Size: 10, Updated: now.Add(-48 * time.Hour), Accessed: now.Add(-time.Hour)},
+		{Name: "newer-unread", Size: 10, Updated: now.Add(-24 * time.Hour)},
+		{Name: "newest", Size: 10, Updated: now.Add(-2 * time.Hour), Accessed: now.Add(-3 * time.Hour)},
+	}
+
+	tests := map[string]struct {
+		policy        GCPolicy
+		expectedNames []string
+	}{
+		"max size": {
+			policy:        GCPolicy{MaxSize: 20},
+			expectedNames: []string{"newer-unread"},
+		},
+		"max size keeping the most recently read cache": {
+			policy:        GCPolicy{MaxSize: 10},
+			expectedNames: []string{"newer-unread", "newest"},
+		},
+		"max age by the write time": {
+			policy:        GCPolicy{MaxAge: 36 * time.Hour},
+			expectedNames: []string{"old-but-read"},
+		},
+		"max age freeing the size budget": {
+			policy:        GCPolicy{MaxAge: 36 * time.Hour, MaxSize: 20},
+			expectedNames: []string{"old-but-read"},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			var names []string
+			for _, object := range tt.policy.selectObjects(objects, 0, now) {
+				names = append(names, object.Name)
+			}
+
+			assert.Equal(t, tt.expectedNames, names)
+		})
+	}
+}
+
+type deleterAdapter struct {
+	listerAdapter
+
+	deleted   []string
+	deleteErr error
+}
+
+func (a *deleterAdapter) Delete(_ context.Context, name string) error {
+	if a.deleteErr != nil {
+		return a.deleteErr
+	}
+
+	a.deleted = append(a.deleted, name)
+	return nil
+}
+
+func TestCollectGarbage(t *testing.T) {
+	now := time.Now()
+
+	objects := []Object{
+		{Name: "runner/abcd1234/project/1/new", Size: 10, Updated: now},
+		{Name: "runner/abcd1234/project/1/old", Size: 10, Updated: now.Add(-48 * time.Hour)},
+		{Name: "runner/abcd1234/project/1/.chunks/ab/abcd", Size: 10, Updated: now.Add(-48 * time.Hour)},
+		{Name: "runner/abcd1234/project/1/.chunks/old.packs/1-abcd", Size: 10, Updated: now.Add(-48 * time.Hour)},
+		{Name: "runner/abcd1234/project/1/.chunks/new.packs/2-abcd", Size: 10, Updated: now.Add(-48 * time.Hour)},
+		{Name: "runner/abcd1234/project/1/.chunks/gone.packs/3-abcd", Size: 10, Updated: now.Add(-48 * time.Hour)},
+		{Name: "runner/abcd1234/project/1/.chunks/gone.packs/4-abcd", Size: 10, Updated: now},
+	}
+
+	expectedRemoved := []string{
+		"runner/abcd1234/project/1/old",
+		"runner/abcd1234/project/1/.chunks/old.packs/1-abcd",
+		"runner/abcd1234/project/1/.chunks/ab/abcd",
+		"runner/abcd1234/project/1/.chunks/gone.packs/3-abcd",
+	}
+
+	tests := map[string]struct {
+		policy              GCPolicy
+		dryRun              bool
+		deleteErr           error
+		expectedRemoved     []string
+		expectedDeleted     []string
+		expectedErrContains string
+	}{
+		"removes the selected caches with their chunk packs": {
+			policy:          GCPolicy{MaxAge: 24 * time.Hour},
+			expectedRemoved: expectedRemoved,
+			expectedDeleted: expectedRemoved,
+		},
+		"max size counting the chunk packs": {
+			// the caches take 20 bytes each with their pack, and the
+			// recent orphan pack 10 bytes
+			policy: GCPolicy{MaxSize: 25},
+			expectedRemoved: []string{
+				"runner/abcd1234/project/1/old",
+				"runner/abcd1234/project/1/.chunks/old.packs/1-abcd",
+				"runner/abcd1234/project/1/new",
+				"runner/abcd1234/project/1/.chunks/new.packs/2-abcd",
+				"runner/abcd1234/project/1/.chunks/ab/abcd",
+				"runner/abcd1234/project/1/.chunks/gone.packs/3-abcd",
+			},
+			dryRun: true,
+		},
+		"dry run": {
+			policy:          GCPolicy{MaxAge: 24 * time.Hour},
+			dryRun:          true,
+			expectedRemoved: expectedRemoved,
+		},
+		"delete error": {
+			policy:              GCPolicy{MaxAge: 24 * time.Hour},
+			deleteErr:           errors.New("access denied"),
+			expectedErrContains: "access denied",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			adapter := &deleterAdapter{
+				listerAdapter: listerAdapter{objects: objects},
+				deleteErr:     tt.deleteErr,
+			}
+
+			var objectName string
+
+			oldCreateAdapter := createAdapter
+			createAdapter = func(_ *common.CacheConfig, _ time.Duration, name string) (Adapter, error) {
+				objectName = name
+				return adapter, nil
+			}
+			defer func() {
+				createAdapter = oldCreateAdapter
+			}()
+
+			runner := &common.RunnerConfig{
+				RunnerCredentials: common.RunnerCredentials{Token: "abcd1234"},
+				RunnerSettings: common.RunnerSettings{
+					Cache: &common.CacheConfig{Type: "test"},
+				},
+			}
+
+			removed, err := CollectGarbage(context.Background(), runner, tt.policy, tt.dryRun)
+			assert.Equal(t, "runner/abcd1234/project/", objectName)
+
+			if tt.expectedErrContains != "" {
+				assert.Contains(t, err.Error(), tt.expectedErrContains)
+				assert.Empty(t, removed)
+				return
+			}
+
+			require.NoError(t, err)
+
+			var names []string
+			for _, object := range removed {
+				names = append(names, object.Name)
+			}
+
+			assert.Equal(t, tt.expectedRemoved, names)
+			assert.Equal(t, tt.expectedDeleted, adapter.deleted)
+		})
+	}
+}
+
+func TestCollectGarbageByLastAccess(t *testing.T) {
+	now := time.Now()
+
+	adapter := &deleterAdapter{
+		listerAdapter: listerAdapter{
+			objects: SetAccessTimes([]Object{
+				{Name: "project/1/old-but-read", Size: 10, Updated: now.Add(-48 * time.Hour)},
+				{Name: "project/1/.access/old-but-read", Updated: now.Add(-time.Hour)},
+				{Name: "project/1/newer-unread", Size: 10, Updated: now.Add(-24 * time.Hour)},
+				{Name: "project/1/.access/gone", Updated: now.Add(-48 * time.Hour)},
+			}),
+		},
+	}
+
+	oldCreateAdapter := createAdapter
+	createAdapter = func(_ *common.CacheConfig, _ time.Duration, _ string) (Adapter, error) {
+		return adapter, nil
+	}
+	defer func() {
+		createAdapter = oldCreateAdapter
+	}()
+
+	runner := &common.RunnerConfig{
+		RunnerSettings: common.RunnerSettings{
+			Cache: &common.CacheConfig{Type: "test", Shared: true},
+		},
+	}
+
+	removed, err := CollectGarbage(context.Background(), runner, GCPolicy{MaxSize: 10}, false)
+	require.NoError(t, err)
+
+	var names []string
+	for _, object := range removed {
+		names = append(names, object.Name)
+	}
+
+	assert.Equal(t, []string{"project/1/newer-unread", "project/1/.access/gone"}, names)
+	assert.Equal(t, names, adapter.deleted)
+}
+
+type uploadsAdapter struct {
+	MockAdapter
+
+	uploads  []IncompleteUpload
+	listErr  error
+	abortErr error
+	aborted  []string
+}
+
+func (a *uploadsAdapter) ListIncompleteUploads(_ context.Context) ([]IncompleteUpload, error) {
+	return a.uploads, a.listErr
+}
+
+func (a *uploadsAdapter) AbortIncompleteUpload(_ context.Context, upload IncompleteUpload) error {
+	if a.abortErr != nil {
+		return a.abortErr
+	}
+
+	a.aborted = append(a.aborted, upload.ID)
+	return nil
+}
+
+func TestAbortStaleUploads(t *testing.T) {
+	now := time.Now()
+
+	uploads := []IncompleteUpload{
+		{Name: "project/1/new", ID: "new", Initiated: now.Add(-time.Hour)},
+		{Name: "project/1/stale", ID: "stale", Initiated: now.Add(-48 * time.Hour)},
+	}
+
+	tests := map[string]struct {
+		adapter             Adapter
+		dryRun              bool
+		expectedStale       []string
+		expectedAborted     []string
+		expectedErrContains string
+	}{
+		"stale uploads aborted": {
+			adapter:         &uploadsAdapter{uploads: uploads},
+			expectedStale:   []string{"stale"},
+			expectedAborted: []string{"stale"},
+		},
+		"dry run": {
+			adapter:       &uploadsAdapter{uploads: uploads},
+			dryRun:        true,
+			expectedStale: []string{"stale"},
+		},
+		"adapter without incomplete uploads": {
+			adapter: new(MockAdapter),
+		},
+		"listing error": {
+			adapter:             &uploadsAdapter{listErr: errors.New("access denied")},
+			expectedErrContains: "access denied",
+		},
+		"abort error": {
+			adapter:             &uploadsAdapter{uploads: uploads, abortErr: errors.New("access denied")},
+			expectedErrContains: "aborting the upload of cache project/1/stale: access denied",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			var objectName string
+
+			oldCreateAdapter := createAdapter
+			createAdapter = func(_ *common.CacheConfig, _ time.Duration, name string) (Adapter, error) {
+				objectName = name
+				return tt.adapter, nil
+			}
+			defer func() {
+				createAdapter = oldCreateAdapter
+			}()
+
+			runner := &common.RunnerConfig{
+				RunnerSettings: common.RunnerSettings{
+					Cache: &common.CacheConfig{Type: "test", Shared: true},
+				},
+			}
+
+			stale, err := AbortStaleUploads(context.Background(), runner, tt.dryRun)
+			assert.Equal(t, "project/", objectName)
+
+			if tt.expectedErrContains != "" {
+				assert.Contains(t, err.Error(), tt.expectedErrContains)
+				assert.Empty(t, stale)
+				return
+			}
+
+			require.NoError(t, err)
+
+			var ids []string
+			for _, upload := range stale {
+				ids = append(ids, upload.ID)
+			}
+			assert.Equal(t, tt.expectedStale, ids)
+
+			if adapter, ok := tt.adapter.(*uploadsAdapter); ok {
+				assert.Equal(t, tt.expectedAborted, adapter.aborted)
+			}
+		})
+	}
+}
+
+func TestChunkPackOwner(t *testing.T) {
+	tests := map[string]struct {
+		name          string
+		expectedOwner string
+		expectedOK    bool
+	}{
+		"pack": {
+			name:          "1/.chunks/deps.packs/10-abcd",
+			expectedOwner: "1/deps",
+			expectedOK:    true,
+		},
+		"pack of a nested key": {
+			name:          "1/.chunks/deps/linux.packs/10-abcd",
+			expectedOwner: "1/deps/linux",
+			expectedOK:    true,
+		},
+		"cache": {
+			name: "1/deps",
+		},
+		"other object under the chunks key": {
+			name:       "1/.chunks/ab/abcd",
+			expectedOK: true,
+		},
+		"packs directory without key": {
+			name:       "1/.chunks/.packs/10-abcd",
+			expectedOK: true,
+		},
+		"other internal object": {
+			name: "1/.cache/abcd",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			owner, ok := chunkPackOwner(tt.name)
+			assert.Equal(t, tt.expectedOK, ok)
+			assert.Equal(t, tt.expectedOwner, owner)
+		})
+	}
+}
+
+func TestCollectGarbageWithoutListing(t *testing.T) {
+	oldCreateAdapter := createAdapter
+	createAdapter = func(_ *common.CacheConfig, _ time.Duration, _ string) (Adapter, error) {
+		return new(MockAdapter), nil
+	}
+	defer func() {
+		createAdapter = oldCreateAdapter
+	}()
+
+	runner := &common.RunnerConfig{
+		RunnerSettings: common.RunnerSettings{
+			Cache: &common.CacheConfig{Type: "test", Shared: true},
+		},
+	}
+
+	_, err := CollectGarbage(context.Background(), runner, GCPolicy{MaxAge: time.Hour}, false)
+	assert.ErrorIs(t, err, ErrListNotSupported)
+}
@@ -1,6 +1,8 @@
 package gcs
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -8,19 +10,27 @@ import (
 
 	"cloud.google.com/go/storage"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/api/iterator"
 
 	"gitlab.com/gitlab-org/gitlab-runner/cache"
 	"gitlab.com/gitlab-org/gitlab-runner/common"
 )
 
+const (
+	resumableHeader    = "x-goog-resumable"
+	resumableChunkSize = 256 * 1024
+)
+
 type signedURLGenerator func(bucket string, name string, opts *storage.SignedURLOptions) (string, error)
 
 type gcsAdapter struct {
 	timeout    time.Duration
 	config     *common.CacheGCSConfig
 	objectName string
+	metadata   map[string]string
 
 	generateSignedURL   signedURLGenerator
+	newStorageClient    storageClientFactory
 	credentialsResolver credentialsResolver
 }
 
@@ -29,11 +39,61 @@ func (a *gcsAdapter) GetDownloadURL() *url.URL {
 }
 
 func (a *gcsAdapter) GetUploadURL() *url.URL {
-	return a.presignURL(http.MethodPut, "application/octet-stream")
+	return a.presignURL(http.MethodPut, "application/octet-stream", a.metadataHeaders()...)
+}
+
+// GetDeleteURL returns the signed URL deleting the object
+func (a *gcsAdapter) GetDeleteURL() *url.URL {
+	return a.presignURL(http.MethodDelete, "")
 }
 
+// GetUploadHeaders returns the metadata headers signed in the upload URL
 func (a *gcsAdapter) GetUploadHeaders() http.Header {
-	return nil
+	if len(a.metadata) == 0 {
+		return nil
+	}
+
+	httpHeaders := http.Header{}
+	httpHeaders.Set("Content-Type", "application/octet-stream")
+	for key, value := range a.metadata {
+		httpHeaders.Set("x-goog-meta-"+key, value)
+	}
+
+	return httpHeaders
+}
+
+func (a *gcsAdapter) metadataHeaders() []string {
+	var headers []string
+	for key, value := range a.metadata {
+		headers = append(headers, "x-goog-meta-"+key+":"+value)
+	}
+
+	return headers
+}
+
+// GetMultipartUpload signs the URL starting a resumable upload session. The
+// parts must be multiples of 256KiB.
+func (a *gcsAdapter) GetMultipartUpload(partSize int64) *cache.MultipartUpload {
+	headers := append([]string{resumableHeader + ":start"}, a.metadataHeaders()...)
+
+	u := a.presignURL(http.MethodPost, "application/octet-stream", headers...)
+	if u == nil {
+		return nil
+	}
+
+	httpHeaders := a.GetUploadHeaders()
+	if httpHeaders == nil {
+		httpHeaders = http.Header{}
+	}
+	httpHeaders.Set("Content-Type", "application/octet-stream")
+	httpHeaders.Set(resumableHeader, "start")
+
+	return &cache.MultipartUpload{
+		Type:     cache.MultipartUploadGCS,
+		PartSize: (partSize + resumableChunkSize - 1) / resumableChunkSize * resumableChunkSize,
+		URL:      u.String(),
+		Headers:  httpHeaders,
+	}
 }
 
 func (a *gcsAdapter) GetGoCloudURL() *url.URL {
@@ -44,7 +104,60 @@ func (a *gcsAdapter) GetUploadEnv() map[string]string {
 	return nil
 }
 
-func (a *gcsAdapter) presignURL(method string, contentType string) *url.URL {
+func (a *gcsAdapter) List(ctx context.Context) ([]cache.Object, error) {
+	client, err := a.storageClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = client.Close() }()
+
+	var objects []cache.Object
+
+	it := client.Bucket(a.config.BucketName).Objects(ctx, &storage.Query{Prefix: a.objectName})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("listing GCS objects: %w", err)
+		}
+
+		objects = append(objects, cache.Object{Name: attrs.Name, Size: attrs.Size, Updated: attrs.Updated})
+	}
+
+	return cache.SetAccessTimes(objects), nil
+}
+
+func (a *gcsAdapter) Delete(ctx context.Context, name string) error {
+	client, err := a.storageClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = client.Close() }()
+
+	err = client.Bucket(a.config.BucketName).Object(name).Delete(ctx)
+	if err != nil {
+		return fmt.Errorf("deleting GCS object: %w", err)
+	}
+
+	return nil
+}
+
+func (a *gcsAdapter) storageClient(ctx context.Context) (*storage.Client, error) {
+	if a.config.BucketName == "" {
+		return nil, errors.New("BucketName can't be empty")
+	}
+
+	err := a.credentialsResolver.Resolve()
+	if err != nil {
+		return nil, fmt.Errorf("resolving GCS credentials: %w", err)
+	}
+
+	return a.newStorageClient(ctx, a.credentialsResolver.Credentials())
+}
+
+func (a *gcsAdapter) presignURL(method string, contentType string, headers ...string) *url.URL {
 	err := a.credentialsResolver.Resolve()
 	if err != nil {
 		logrus.Errorf("error while resolving GCS credentials: %v", err)
@@ -69,6 +182,7 @@ func (a *gcsAdapter) presignURL(method string, contentType string) *url.URL {
 		Method:         method,
 		Expires:        time.Now().Add(a.timeout),
 		ContentType:    contentType,
+		Headers:        headers,
 	})
 	if err != nil {
 		logrus.Errorf("error while generating GCS pre-signed URL: %v", err)
@@ -99,7 +213,9 @@ func New(config *common.CacheConfig, timeout time.Duration, objectName string) (
 		config:              gcs,
 		timeout:             timeout,
 		objectName:          objectName,
+		metadata:            cache.ObjectMetadata(config),
 		generateSignedURL:   storage.SignedURL,
+		newStorageClient:    newStorageClient,
 		credentialsResolver: cr,
 	}
 
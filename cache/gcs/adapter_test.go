@@ -1,10 +1,15 @@
 package gcs
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,7 +17,9 @@ import (
 	"github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/api/option"
 
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
 	"gitlab.com/gitlab-org/gitlab-runner/common"
 )
 
@@ -323,3 +330,158 @@ func TestAdapterOperation(t *testing.T) {
 		})
 	}
 }
+
+func TestUploadURLWithMetadata(t *testing.T) {
+	config := defaultGCSCache()
+	config.Encryption = &common.CacheEncryptionConfig{KeyID: "v2"}
+
+	a, err := New(config, defaultTimeout, objectName)
+	require.NoError(t, err)
+
+	adapter, ok := a.(*gcsAdapter)
+	require.True(t, ok, "Adapter should be properly casted to *adapter type")
+
+	cleanupCredentialsResolverMock := prepareMockedCredentialsResolver(adapter)
+	defer cleanupCredentialsResolverMock(t)
+
+	adapter.generateSignedURL = func(bucket string, name string, opts *storage.SignedURLOptions) (string, error) {
+		assert.Equal(t, []string{"x-goog-meta-gitlab_runner_cache_key_id:v2"}, opts.Headers)
+
+		return "https://storage.googleapis.com/test/key", nil
+	}
+
+	assert.Equal(t, "https://storage.googleapis.com/test/key", adapter.GetUploadURL().String())
+	assert.Equal(t, http.Header{
+		"Content-Type":                           []string{"application/octet-stream"},
+		"X-Goog-Meta-Gitlab_runner_cache_key_id": []string{"v2"},
+	}, adapter.GetUploadHeaders())
+}
+
+func TestGetMultipartUpload(t *testing.T) {
+	config := defaultGCSCache()
+	config.Encryption = &common.CacheEncryptionConfig{KeyID: "v2"}
+
+	a, err := New(config, defaultTimeout, objectName)
+	require.NoError(t, err)
+
+	adapter, ok := a.(*gcsAdapter)
+	require.True(t, ok, "Adapter should be properly casted to *adapter type")
+
+	cleanupCredentialsResolverMock := prepareMockedCredentialsResolver(adapter)
+	defer cleanupCredentialsResolverMock(t)
+
+	adapter.generateSignedURL = func(bucket string, name string, opts *storage.SignedURLOptions) (string, error) {
+		assert.Equal(t, http.MethodPost, opts.Method)
+		assert.Equal(t, []string{"x-goog-resumable:start", "x-goog-meta-gitlab_runner_cache_key_id:v2"}, opts.Headers)
+
+		return "https://storage.googleapis.com/test/key", nil
+	}
+
+	upload := adapter.GetMultipartUpload(300 * 1024)
+	require.NotNil(t, upload)
+	assert.Equal(t, &cache.MultipartUpload{
+		Type:     cache.MultipartUploadGCS,
+		PartSize: 512 * 1024,
+		URL:      "https://storage.googleapis.com/test/key",
+		Headers: http.Header{
+			"Content-Type":                           []string{"application/octet-stream"},
+			"X-Goog-Resumable":                       []string{"start"},
+			"X-Goog-Meta-Gitlab_runner_cache_key_id": []string{"v2"},
+		},
+	}, upload)
+}
+
+// fakeGCSServer implements the parts of the JSON API used to list and
+// delete objects
+type fakeGCSServer struct {
+	objects map[string]cache.Object
+}
+
+func (f *fakeGCSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucketPath := "/storage/v1/b/" + bucketName + "/o"
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == bucketPath:
+		var items []map[string]string
+		for _, object := range f.objects {
+			if !strings.HasPrefix(object.Name, r.URL.Query().Get("prefix")) {
+				continue
+			}
+
+			items = append(items, map[string]string{
+				"name":    object.Name,
+				"size":    strconv.FormatInt(object.Size, 10),
+				"updated": object.Updated.Format(time.RFC3339Nano),
+			})
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"kind": "storage#objects", "items": items})
+
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, bucketPath+"/"):
+		name := strings.TrimPrefix(r.URL.Path, bucketPath+"/")
+		if _, ok := f.objects[name]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		delete(f.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func TestListAndDelete(t *testing.T) {
+	updated := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	server := &fakeGCSServer{
+		objects: map[string]cache.Object{
+			"key-1":   {Name: "key-1", Size: 10, Updated: updated},
+			"other-1": {Name: "other-1", Size: 20, Updated: updated},
+		},
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	a, err := New(defaultGCSCache(), defaultTimeout, objectName)
+	require.NoError(t, err)
+
+	adapter, ok := a.(*gcsAdapter)
+	require.True(t, ok, "Adapter should be properly casted to *adapter type")
+
+	cleanupCredentialsResolverMock := prepareMockedCredentialsResolver(adapter)
+	defer cleanupCredentialsResolverMock(t)
+
+	adapter.newStorageClient = func(ctx context.Context, credentials *common.CacheGCSCredentials) (*storage.Client, error) {
+		assert.Equal(t, accessID, credentials.AccessID)
+
+		return storage.NewClient(
+			ctx,
+			option.WithEndpoint(ts.URL+"/storage/v1/"),
+			option.WithoutAuthentication(),
+		)
+	}
+
+	objects, err := adapter.List(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []cache.Object{{Name: "key-1", Size: 10, Updated: updated}}, objects)
+
+	require.NoError(t, adapter.Delete(context.Background(), "key-1"))
+	assert.NotContains(t, server.objects, "key-1")
+	assert.Contains(t, server.objects, "other-1")
+
+	err = adapter.Delete(context.Background(), "key-1")
+	assert.Error(t, err)
+}
+
+func TestListWithoutBucket(t *testing.T) {
+	config := defaultGCSCache()
+	config.GCS.BucketName = ""
+
+	a, err := New(config, defaultTimeout, objectName)
+	require.NoError(t, err)
+
+	_, err = a.(cache.Lister).List(context.Background())
+	assert.EqualError(t, err, "BucketName can't be empty")
+}
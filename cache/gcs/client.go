@@ -0,0 +1,44 @@
+package gcs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+const tokenURI = "https://oauth2.googleapis.com/token"
+
+type storageClientFactory func(ctx context.Context, credentials *common.CacheGCSCredentials) (*storage.Client, error)
+
+// newStorageClient creates a client of the JSON API, used to list and delete
+// the caches. When a private key is configured, it's used to authenticate as
+// the service account, otherwise the default credentials of the environment
+// are used.
+func newStorageClient(ctx context.Context, credentials *common.CacheGCSCredentials) (*storage.Client, error) {
+	var opts []option.ClientOption
+	if credentials.PrivateKey != "" {
+		credentialsJSON, err := json.Marshal(map[string]string{
+			"type":         "service_account",
+			"client_email": credentials.AccessID,
+			"private_key":  credentials.PrivateKey,
+			"token_uri":    tokenURI,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("encoding GCS credentials: %w", err)
+		}
+
+		opts = append(opts, option.WithCredentialsJSON(credentialsJSON))
+	}
+
+	client, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("creating GCS client: %w", err)
+	}
+
+	return client, nil
+}
@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+// MultipartUploadVariable passes the description of the multipart upload of
+// a cache to the cache archiver
+const MultipartUploadVariable = "CACHE_MULTIPART_UPLOAD"
+
+// The types of the multipart uploads
+const (
+	// MultipartUploadS3 uploads the parts to pre-signed S3 URLs, and
+	// completes the upload with the list of the parts
+	MultipartUploadS3 = "s3"
+	// MultipartUploadGCS starts a resumable upload session with a signed
+	// URL, and uploads the parts to the session
+	MultipartUploadGCS = "gcs"
+	// MultipartUploadAzure uploads the parts as the blocks of a block blob
+	// with a SAS URL, and commits the list of the blocks
+	MultipartUploadAzure = "azure"
+)
+
+// MultipartUpload describes how the cache archiver uploads a cache in parts.
+// When the upload is retried, it's resumed from the last uploaded part.
+type MultipartUpload struct {
+	Type string `json:"type"`
+	// PartSize is the minimum size of the parts. The archiver makes the
+	// parts bigger when the provider limits their number.
+	PartSize int64 `json:"part_size"`
+
+	// URL is the signed URL starting the GCS upload session, or the SAS
+	// URL of the Azure block blob
+	URL string `json:"url,omitempty"`
+	// Headers are sent when the GCS upload session is started, and when
+	// the Azure block list is committed
+	Headers http.Header `json:"headers,omitempty"`
+
+	// PartURLs are the pre-signed URLs of the S3 parts, in order
+	PartURLs []string `json:"part_urls,omitempty"`
+	// CompleteURL and AbortURL complete and abort the S3 upload
+	CompleteURL string `json:"complete_url,omitempty"`
+	AbortURL    string `json:"abort_url,omitempty"`
+
+	// Abort aborts the upload from the runner when the job ends, in case
+	// the cache archiver neither completed nor aborted it. Aborting
+	// a completed upload has no effect.
+	Abort func() error `json:"-"`
+}
+
+// MultipartUploader is implemented by the adapters able to upload the caches
+// in parts. The parts are at least partSize bytes long.
+type MultipartUploader interface {
+	GetMultipartUpload(partSize int64) *MultipartUpload
+}
+
+// IncompleteUpload is a multipart upload of a cache neither completed nor
+// aborted. Its parts stay stored until it's completed or aborted.
+type IncompleteUpload struct {
+	Name      string
+	ID        string
+	Size      int64
+	Initiated time.Time
+}
+
+// IncompleteUploadLister is implemented by the adapters storing the parts of
+// the multipart uploads until they're completed or aborted. The uploads of
+// the objects whose name starts with the object name of the adapter are
+// listed.
+type IncompleteUploadLister interface {
+	ListIncompleteUploads(ctx context.Context) ([]IncompleteUpload, error)
+	AbortIncompleteUpload(ctx context.Context, upload IncompleteUpload) error
+}
+
+// GetCacheMultipartUpload returns the multipart upload of the cache, or nil
+// when it isn't enabled or supported by the adapter
+func GetCacheMultipartUpload(build *common.Build, key string) *MultipartUpload {
+	config := getCacheConfig(build)
+	if config == nil {
+		return nil
+	}
+
+	partSize, err := config.GetUploadPartSize()
+	if err != nil {
+		logrus.WithError(err).Error("Multipart cache uploads disabled")
+		return nil
+	}
+
+	if partSize == 0 {
+		return nil
+	}
+
+	result := onAdapter(build, key, func(adapter Adapter) interface{} {
+		uploader, ok := adapter.(MultipartUploader)
+		if !ok {
+			return nil
+		}
+
+		return uploader.GetMultipartUpload(partSize)
+	})
+
+	upload, ok := result.(*MultipartUpload)
+	if !ok || upload == nil {
+		return nil
+	}
+
+	if upload.Abort != nil {
+		build.OnJobEnd(func() {
+			err := upload.Abort()
+			if err != nil {
+				logrus.WithError(err).Warning("Failed to abort the multipart cache upload")
+			}
+		})
+	}
+
+	return upload
+}
+
+// GetCacheMultipartUploadEnv returns the environment passing the multipart
+// upload of the cache to the cache archiver
+func GetCacheMultipartUploadEnv(build *common.Build, key string) map[string]string {
+	upload := GetCacheMultipartUpload(build, key)
+	if upload == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(upload)
+	if err != nil {
+		logrus.WithError(err).Error("Error encoding the multipart cache upload")
+		return nil
+	}
+
+	return map[string]string{MultipartUploadVariable: string(data)}
+}
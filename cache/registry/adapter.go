@@ -0,0 +1,305 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/docker/auth"
+)
+
+// AuthorizationVariable passes the Authorization header pushing the caches
+// to the cache archiver. It's only a short-lived bearer token scoped to the
+// repository: the credentials of the runner are never passed to the job.
+const AuthorizationVariable = "CACHE_REGISTRY_AUTHORIZATION"
+
+const requestTimeout = time.Minute
+
+var tagRegexp = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+var resolveDockerCredentials = auth.ResolveConfigForImage
+
+// registryAdapter stores the caches as OCI artifacts in a repository of a
+// container registry. The archiver pushes them through the GoCloud driver of
+// this package. The extractor downloads the layers from the registry, or
+// from the object storage the registry redirects to.
+type registryAdapter struct {
+	config     *common.CacheRegistryConfig
+	objectName string
+	baseURL    *url.URL
+	repository string
+}
+
+// GetDownloadURL returns the URL of the cache when it can be downloaded
+// without credentials, like the URL of the object storage the registry
+// redirects to
+func (a *registryAdapter) GetDownloadURL() *url.URL {
+	u, env := a.GetDownloadURLWithEnv()
+	if env[cache.DownloadAuthorizationVariable] != "" {
+		return nil
+	}
+
+	return u
+}
+
+// GetDownloadURLWithEnv returns the URL of the cache blob, with the creation
+// date recorded in the manifest since the registries don't send it with the
+// blobs. The blobs served by registries with token authentication are
+// downloaded with a pull token scoped to the repository. The registries with
+// basic authentication can only serve the caches through redirects, their
+// password not being passed to the job.
+func (a *registryAdapter) GetDownloadURLWithEnv() (*url.URL, map[string]string) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	c := a.newClient("pull")
+
+	m, _, err := c.getManifest(ctx, tagFor(a.objectName))
+	if errors.Is(err, errNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		logrus.WithError(err).Errorf("error getting the cache manifest from the registry")
+		return nil, nil
+	}
+
+	object, ok := m.object()
+	if !ok {
+		logrus.Errorf("the manifest of %q doesn't describe a cache", a.objectName)
+		return nil, nil
+	}
+
+	layer, _ := m.layer()
+	u, redirected, err := c.resolveBlobURL(ctx, layer.Digest)
+	if err != nil {
+		logrus.WithError(err).Errorf("error resolving the cache blob URL")
+		return nil, nil
+	}
+
+	env := map[string]string{}
+	if !object.Updated.IsZero() {
+		env[cache.DownloadLastModifiedVariable] = object.Updated.UTC().Format(http.TimeFormat)
+	}
+
+	switch {
+	case redirected, c.authorization == "":
+		return u, env
+	case strings.HasPrefix(c.authorization, "Bearer "):
+		env[cache.DownloadAuthorizationVariable] = c.authorization
+		return u, env
+	}
+
+	logrus.Errorf("the registry serves the cache with basic authentication, whose credentials aren't passed to the job")
+	return nil, nil
+}
+
+func (a *registryAdapter) GetUploadURL() *url.URL {
+	return nil
+}
+
+func (a *registryAdapter) GetUploadHeaders() http.Header {
+	return nil
+}
+
+func (a *registryAdapter) GetGoCloudURL() *url.URL {
+	query := url.Values{"repository": []string{a.repository}}
+	if a.config.Insecure {
+		query.Set("insecure", "true")
+	}
+
+	// The object name is attached to the URL, like the other GoCloud URLs
+	return &url.URL{
+		Scheme:   Scheme,
+		Host:     a.baseURL.Host,
+		Path:     "/" + a.objectName,
+		RawQuery: query.Encode(),
+	}
+}
+
+func (a *registryAdapter) GetUploadEnv() map[string]string {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	c := a.newClient("pull,push")
+
+	err := c.ping(ctx)
+	if err != nil {
+		logrus.WithError(err).Errorf("error authenticating to the registry")
+		return map[string]string{}
+	}
+
+	switch {
+	case c.authorization == "":
+		return map[string]string{}
+	case strings.HasPrefix(c.authorization, "Bearer "):
+		return map[string]string{AuthorizationVariable: c.authorization}
+	}
+
+	logrus.Errorf("the registry requires basic authentication, whose credentials aren't passed to the job: " +
+		"use a registry with token authentication to push the caches")
+	return map[string]string{}
+}
+
+// List returns the caches whose name starts with the object name. The
+// repository can't be listed by name, so the manifests of all its tags are
+// read.
+func (a *registryAdapter) List(ctx context.Context) ([]cache.Object, error) {
+	c := a.newClient("pull")
+
+	tags, err := c.listTags(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing the registry tags: %w", err)
+	}
+
+	var objects []cache.Object
+	for _, tag := range tags {
+		if !tagRegexp.MatchString(tag) {
+			continue
+		}
+
+		m, _, err := c.getManifest(ctx, tag)
+		if errors.Is(err, errNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		object, ok := m.object()
+		if ok && strings.HasPrefix(object.Name, a.objectName) {
+			objects = append(objects, object)
+		}
+	}
+
+	return objects, nil
+}
+
+// Delete removes the manifest of the cache. The layers are removed by the
+// garbage collection of the registry.
+func (a *registryAdapter) Delete(ctx context.Context, name string) error {
+	return deleteCache(ctx, a.newClient("pull,delete"), name)
+}
+
+func deleteCache(ctx context.Context, c *client, name string) error {
+	_, d, err := c.getManifest(ctx, tagFor(name))
+	if err != nil {
+		return fmt.Errorf("getting the cache manifest: %w", err)
+	}
+
+	err = c.deleteManifest(ctx, d)
+	if err != nil {
+		return fmt.Errorf("deleting the cache manifest: %w", err)
+	}
+
+	return nil
+}
+
+func (a *registryAdapter) newClient(actions string) *client {
+	username, password := a.credentials()
+
+	return &client{
+		baseURL:    a.baseURL,
+		repository: a.repository,
+		username:   username,
+		password:   password,
+		actions:    actions,
+		httpClient: newHTTPClient(),
+	}
+}
+
+// credentials returns the configured credentials, or the Docker credentials
+// of the runner for the registry
+func (a *registryAdapter) credentials() (string, string) {
+	if a.config.Username != "" {
+		return a.config.Username, a.config.Password
+	}
+
+	info, err := resolveDockerCredentials(a.config.Repository, "", "", nil)
+	if err != nil {
+		logrus.WithError(err).Warningln("error resolving the Docker credentials of the registry")
+		return "", ""
+	}
+
+	if info == nil {
+		return "", ""
+	}
+
+	return info.AuthConfig.Username, info.AuthConfig.Password
+}
+
+// newHTTPClient returns a client not following redirects, so the URLs of the
+// object storages the registries redirect to are resolved
+func newHTTPClient() *http.Client {
+	return &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// parseRepository returns the URL of the registry API and the path of the
+// repository in the registry
+func parseRepository(repository string, insecure bool) (*url.URL, string, error) {
+	if repository == "" {
+		return nil, "", errors.New("missing registry repository")
+	}
+
+	named, err := reference.ParseNormalizedNamed(repository)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid registry repository %q: %w", repository, err)
+	}
+
+	if !reference.IsNameOnly(named) {
+		return nil, "", fmt.Errorf("registry repository %q can't have a tag or a digest", repository)
+	}
+
+	host := reference.Domain(named)
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+
+	scheme := "https"
+	if insecure {
+		scheme = "http"
+	}
+
+	return &url.URL{Scheme: scheme, Host: host}, reference.Path(named), nil
+}
+
+func New(config *common.CacheConfig, _ time.Duration, objectName string) (cache.Adapter, error) {
+	registry := config.Registry
+	if registry == nil {
+		return nil, fmt.Errorf("missing registry configuration")
+	}
+
+	baseURL, repository, err := parseRepository(registry.Repository, registry.Insecure)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &registryAdapter{
+		config:     registry,
+		objectName: strings.TrimLeft(objectName, "/"),
+		baseURL:    baseURL,
+		repository: repository,
+	}
+
+	return a, nil
+}
+
+func init() {
+	err := cache.Factories().Register("registry", New)
+	if err != nil {
+		panic(err)
+	}
+}
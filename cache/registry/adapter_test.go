@@ -0,0 +1,604 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/cli/cli/config/types"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gocloud.dev/blob"
+
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/docker/auth"
+)
+
+const (
+	testRepository = "group/cache"
+	testUsername   = "user"
+	testPassword   = "secret"
+	testToken      = "token"
+)
+
+// fakeRegistry implements the parts of the distribution API used by the
+// adapter, like a registry:2 container storing the blobs in memory
+type fakeRegistry struct {
+	t *testing.T
+
+	// basicAuth and tokenAuth select the authentication of the registry
+	basicAuth bool
+	tokenAuth bool
+	// redirect serves the blobs with redirects to a storage
+	redirect bool
+
+	lock      sync.Mutex
+	uploads   map[string][]byte
+	blobs     map[digest.Digest][]byte
+	manifests map[digest.Digest][]byte
+	tags      map[string]digest.Digest
+
+	server *httptest.Server
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	r := &fakeRegistry{
+		t:         t,
+		uploads:   map[string][]byte{},
+		blobs:     map[digest.Digest][]byte{},
+		manifests: map[digest.Digest][]byte{},
+		tags:      map[string]digest.Digest{},
+	}
+	r.server = httptest.NewServer(r)
+
+	return r
+}
+
+func (r *fakeRegistry) repository() string {
+	return strings.TrimPrefix(r.server.URL, "http://") + "/" + testRepository
+}
+
+func (r *fakeRegistry) authorized(w http.ResponseWriter, req *http.Request) bool {
+	switch {
+	case r.tokenAuth:
+		if req.Header.Get("Authorization") == "Bearer "+testToken {
+			return true
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry"`, r.server.URL))
+	case r.basicAuth:
+		username, password, ok := req.BasicAuth()
+		if ok && username == testUsername && password == testPassword {
+			return true
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+	default:
+		return true
+	}
+
+	w.WriteHeader(http.StatusUnauthorized)
+	return false
+}
+
+func (r *fakeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	switch {
+	case req.URL.Path == "/token":
+		r.serveToken(w, req)
+		return
+	case strings.HasPrefix(req.URL.Path, "/storage/"):
+		r.serveBlob(w, digest.Digest(strings.TrimPrefix(req.URL.Path, "/storage/")))
+		return
+	case !r.authorized(w, req):
+		return
+	case req.URL.Path == "/v2/":
+		return
+	}
+
+	prefix := "/v2/" + testRepository + "/"
+	if !strings.HasPrefix(req.URL.Path, prefix) {
+		http.NotFound(w, req)
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(req.URL.Path, prefix), "/", 3)
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+
+	switch {
+	case parts[0] == "blobs" && parts[1] == "uploads":
+		r.serveUpload(w, req, parts[2])
+	case parts[0] == "blobs" && req.Method == http.MethodGet:
+		if r.redirect {
+			http.Redirect(w, req, "/storage/"+parts[1], http.StatusTemporaryRedirect)
+			return
+		}
+		r.serveBlob(w, digest.Digest(parts[1]))
+	case parts[0] == "manifests":
+		r.serveManifest(w, req, parts[1])
+	case parts[0] == "tags" && parts[1] == "list":
+		r.serveTags(w, req)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (r *fakeRegistry) serveToken(w http.ResponseWriter, req *http.Request) {
+	assert.Equal(r.t, "registry", req.URL.Query().Get("service"))
+	assert.Contains(r.t, req.URL.Query().Get("scope"), "repository:"+testRepository+":")
+
+	username, password, ok := req.BasicAuth()
+	if !ok || username != testUsername || password != testPassword {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]string{"token": testToken})
+}
+
+func (r *fakeRegistry) serveUpload(w http.ResponseWriter, req *http.Request, id string) {
+	body, err := ioutil.ReadAll(req.Body)
+	require.NoError(r.t, err)
+
+	switch req.Method {
+	case http.MethodPost:
+		id = strconv.Itoa(len(r.uploads) + 1)
+		r.uploads[id] = nil
+	case http.MethodPatch:
+		r.uploads[id] = append(r.uploads[id], body...)
+	case http.MethodPut:
+		data := append(r.uploads[id], body...)
+		d := digest.Digest(req.URL.Query().Get("digest"))
+		if d != digest.FromBytes(data) {
+			http.Error(w, "digest mismatch", http.StatusBadRequest)
+			return
+		}
+
+		delete(r.uploads, id)
+		r.blobs[d] = data
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s?_state=%s", testRepository, id, id))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (r *fakeRegistry) serveBlob(w http.ResponseWriter, d digest.Digest) {
+	data, ok := r.blobs[d]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	_, _ = w.Write(data)
+}
+
+func (r *fakeRegistry) serveManifest(w http.ResponseWriter, req *http.Request, reference string) {
+	switch req.Method {
+	case http.MethodPut:
+		assert.Equal(r.t, v1.MediaTypeImageManifest, req.Header.Get("Content-Type"))
+
+		data, err := ioutil.ReadAll(req.Body)
+		require.NoError(r.t, err)
+
+		var m manifest
+		require.NoError(r.t, json.Unmarshal(data, &m))
+		for _, descriptor := range append(m.Layers, m.Config) {
+			assert.Contains(r.t, r.blobs, descriptor.Digest)
+		}
+
+		d := digest.FromBytes(data)
+		r.manifests[d] = data
+		r.tags[reference] = d
+		w.WriteHeader(http.StatusCreated)
+
+	case http.MethodGet:
+		d := digest.Digest(reference)
+		if tagged, ok := r.tags[reference]; ok {
+			d = tagged
+		}
+
+		data, ok := r.manifests[d]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", v1.MediaTypeImageManifest)
+		w.Header().Set("Docker-Content-Digest", d.String())
+		_, _ = w.Write(data)
+
+	case http.MethodDelete:
+		d := digest.Digest(reference)
+		if _, ok := r.manifests[d]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		delete(r.manifests, d)
+		for tag, tagged := range r.tags {
+			if tagged == d {
+				delete(r.tags, tag)
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// serveTags paginates the tags two by two
+func (r *fakeRegistry) serveTags(w http.ResponseWriter, req *http.Request) {
+	if len(r.tags) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var tags []string
+	for tag := range r.tags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	last := req.URL.Query().Get("last")
+	start := sort.SearchStrings(tags, last)
+	if last != "" && start < len(tags) && tags[start] == last {
+		start++
+	}
+
+	end := start + 2
+	if end < len(tags) {
+		w.Header().Set("Link", fmt.Sprintf(`</v2/%s/tags/list?n=2&last=%s>; rel="next"`, testRepository, tags[end-1]))
+	} else {
+		end = len(tags)
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"name": testRepository, "tags": tags[start:end]})
+}
+
+func (r *fakeRegistry) newAdapter(t *testing.T, config *common.CacheRegistryConfig, objectName string) *registryAdapter {
+	config.Repository = r.repository()
+	config.Insecure = true
+
+	adapter, err := New(&common.CacheConfig{Registry: config}, 0, objectName)
+	require.NoError(t, err)
+
+	return adapter.(*registryAdapter)
+}
+
+func (r *fakeRegistry) push(t *testing.T, name string, data string) {
+	baseURL, err := url.Parse(r.server.URL)
+	require.NoError(t, err)
+
+	c := &client{
+		baseURL:    baseURL,
+		repository: testRepository,
+		httpClient: newHTTPClient(),
+	}
+	require.NoError(t, pushCache(context.Background(), c, name, strings.NewReader(data), nil))
+}
+
+func mockDockerCredentials(t *testing.T, username string, password string) func() {
+	old := resolveDockerCredentials
+	resolveDockerCredentials = func(
+		imageName string,
+		_ string,
+		_ string,
+		_ []common.Credentials,
+	) (*auth.RegistryInfo, error) {
+		assert.True(t, strings.HasSuffix(imageName, "/"+testRepository))
+		if username == "" {
+			return nil, nil
+		}
+
+		return &auth.RegistryInfo{AuthConfig: types.AuthConfig{Username: username, Password: password}}, nil
+	}
+
+	return func() {
+		resolveDockerCredentials = old
+	}
+}
+
+func upload(t *testing.T, adapter *registryAdapter, data string) {
+	for key, value := range adapter.GetUploadEnv() {
+		require.NoError(t, os.Setenv(key, value))
+		defer os.Unsetenv(key)
+	}
+
+	goCloudURL := adapter.GetGoCloudURL()
+	require.NotNil(t, goCloudURL)
+
+	ctx := context.Background()
+	b, err := blob.OpenBucket(ctx, goCloudURL.String())
+	require.NoError(t, err)
+	defer b.Close()
+
+	writer, err := b.NewWriter(ctx, strings.TrimLeft(goCloudURL.Path, "/"), &blob.WriterOptions{
+		Metadata: map[string]string{"gitlab_runner_cache_key_id": "v1"},
+	})
+	require.NoError(t, err)
+
+	_, err = writer.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+}
+
+func download(t *testing.T, u *url.URL, env map[string]string) string {
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	require.NoError(t, err)
+
+	if authorization := env[cache.DownloadAuthorizationVariable]; authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	data, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return string(data)
+}
+
+func TestUploadAndDownload(t *testing.T) {
+	tests := map[string]struct {
+		basicAuth             bool
+		tokenAuth             bool
+		redirect              bool
+		config                common.CacheRegistryConfig
+		dockerUsername        string
+		expectedNoUpload      bool
+		expectedNoDownload    bool
+		expectedAuthorization bool
+	}{
+		"anonymous": {},
+		"basic authentication": {
+			basicAuth:          true,
+			config:             common.CacheRegistryConfig{Username: testUsername, Password: testPassword},
+			expectedNoUpload:   true,
+			expectedNoDownload: true,
+		},
+		"basic authentication with the Docker credentials": {
+			basicAuth:          true,
+			dockerUsername:     testUsername,
+			expectedNoUpload:   true,
+			expectedNoDownload: true,
+		},
+		"basic authentication with redirected blobs": {
+			basicAuth:        true,
+			redirect:         true,
+			config:           common.CacheRegistryConfig{Username: testUsername, Password: testPassword},
+			expectedNoUpload: true,
+		},
+		"token authentication with redirected blobs": {
+			tokenAuth: true,
+			redirect:  true,
+			config:    common.CacheRegistryConfig{Username: testUsername, Password: testPassword},
+		},
+		"token authentication with blobs served by the registry": {
+			tokenAuth:             true,
+			config:                common.CacheRegistryConfig{Username: testUsername, Password: testPassword},
+			expectedAuthorization: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			defer mockDockerCredentials(t, tt.dockerUsername, testPassword)()
+
+			registry := newFakeRegistry(t)
+			defer registry.server.Close()
+
+			registry.basicAuth = tt.basicAuth
+			registry.tokenAuth = tt.tokenAuth
+			registry.redirect = tt.redirect
+
+			adapter := registry.newAdapter(t, &tt.config, "runner/project/1/key")
+			assert.Nil(t, adapter.GetDownloadURL(), "the cache doesn't exist yet")
+			assert.Nil(t, adapter.GetUploadURL())
+
+			for _, value := range adapter.GetUploadEnv() {
+				assert.NotContains(t, value, basicAuth(testUsername, testPassword))
+			}
+
+			if tt.expectedNoUpload {
+				assert.Empty(t, adapter.GetUploadEnv())
+
+				// the cache is pushed with the credentials of the runner
+				err := pushCache(
+					context.Background(),
+					adapter.newClient("pull,push"),
+					"runner/project/1/key",
+					strings.NewReader("archive content"),
+					map[string]string{"gitlab_runner_cache_key_id": "v1"},
+				)
+				require.NoError(t, err)
+			} else {
+				upload(t, adapter, "archive content")
+			}
+			require.Len(t, registry.tags, 1)
+
+			m, _, err := adapter.newClient("pull").getManifest(context.Background(), tagFor("runner/project/1/key"))
+			require.NoError(t, err)
+			assert.Equal(t, configMediaType, m.Config.MediaType)
+			assert.Equal(t, "runner/project/1/key", m.Annotations[nameAnnotation])
+			assert.Equal(t, "v1", m.Annotations["gitlab_runner_cache_key_id"])
+
+			u, env := adapter.GetDownloadURLWithEnv()
+			if tt.expectedNoDownload {
+				assert.Nil(t, u)
+				assert.Nil(t, adapter.GetDownloadURL())
+				return
+			}
+
+			require.NotNil(t, u)
+			assert.Empty(t, u.User, "no credentials are passed in the URL")
+			assert.Equal(t, "archive content", download(t, u, env))
+
+			created, err := time.Parse(time.RFC3339, m.Annotations[v1.AnnotationCreated])
+			require.NoError(t, err)
+			assert.Equal(t, created.UTC().Format(http.TimeFormat), env[cache.DownloadLastModifiedVariable])
+
+			if tt.expectedAuthorization {
+				assert.Equal(t, "Bearer "+testToken, env[cache.DownloadAuthorizationVariable])
+				assert.Nil(t, adapter.GetDownloadURL(), "the blob can't be downloaded without the token")
+				return
+			}
+
+			assert.NotContains(t, env, cache.DownloadAuthorizationVariable)
+			assert.Equal(t, u, adapter.GetDownloadURL())
+		})
+	}
+}
+
+func TestUploadEnv(t *testing.T) {
+	defer mockDockerCredentials(t, "", "")()
+
+	registry := newFakeRegistry(t)
+	defer registry.server.Close()
+
+	adapter := registry.newAdapter(t, &common.CacheRegistryConfig{}, "key")
+	assert.Empty(t, adapter.GetUploadEnv())
+
+	registry.tokenAuth = true
+	assert.Empty(t, adapter.GetUploadEnv(), "the token can't be fetched without credentials")
+
+	adapter.config.Username = testUsername
+	adapter.config.Password = testPassword
+	assert.Equal(t, map[string]string{AuthorizationVariable: "Bearer " + testToken}, adapter.GetUploadEnv())
+
+	registry.tokenAuth = false
+	registry.basicAuth = true
+	assert.Empty(t, adapter.GetUploadEnv(), "the password isn't passed to the job")
+}
+
+func TestGetGoCloudURL(t *testing.T) {
+	adapter, err := New(&common.CacheConfig{
+		Registry: &common.CacheRegistryConfig{Repository: "registry.example.com:5000/group/cache", Insecure: true},
+	}, 0, "/runner/project/1/key")
+	require.NoError(t, err)
+
+	assert.Equal(
+		t,
+		"registry://registry.example.com:5000/runner/project/1/key?insecure=true&repository=group%2Fcache",
+		adapter.GetGoCloudURL().String(),
+	)
+}
+
+func TestListAndDelete(t *testing.T) {
+	defer mockDockerCredentials(t, "", "")()
+
+	registry := newFakeRegistry(t)
+	defer registry.server.Close()
+
+	registry.push(t, "runner/project/1/key-1", "1")
+	registry.push(t, "runner/project/1/key-2", "22")
+	registry.push(t, "runner/project/2/key-1", "333")
+	registry.tags["latest"] = registry.tags[tagFor("runner/project/2/key-1")]
+
+	adapter := registry.newAdapter(t, &common.CacheRegistryConfig{}, "runner/project/1/")
+	var lister cache.Lister = adapter
+	var deleter cache.Deleter = adapter
+
+	objects, err := lister.List(context.Background())
+	require.NoError(t, err)
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+
+	require.Len(t, objects, 2)
+	assert.Equal(t, "runner/project/1/key-1", objects[0].Name)
+	assert.Equal(t, int64(1), objects[0].Size)
+	assert.False(t, objects[0].Updated.IsZero())
+	assert.Equal(t, "runner/project/1/key-2", objects[1].Name)
+	assert.Equal(t, int64(2), objects[1].Size)
+
+	require.NoError(t, deleter.Delete(context.Background(), "runner/project/1/key-1"))
+	assert.Error(t, deleter.Delete(context.Background(), "runner/project/1/key-1"))
+
+	objects, err = lister.List(context.Background())
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+	assert.Equal(t, "runner/project/1/key-2", objects[0].Name)
+}
+
+func TestParseRepository(t *testing.T) {
+	tests := map[string]struct {
+		repository         string
+		insecure           bool
+		expectedURL        string
+		expectedRepository string
+		expectedErr        bool
+	}{
+		"registry with port": {
+			repository:         "registry.example.com:5000/group/cache",
+			expectedURL:        "https://registry.example.com:5000",
+			expectedRepository: "group/cache",
+		},
+		"insecure registry": {
+			repository:         "localhost:5000/cache",
+			insecure:           true,
+			expectedURL:        "http://localhost:5000",
+			expectedRepository: "cache",
+		},
+		"Docker Hub": {
+			repository:         "cache",
+			expectedURL:        "https://registry-1.docker.io",
+			expectedRepository: "library/cache",
+		},
+		"empty": {
+			expectedErr: true,
+		},
+		"tagged": {
+			repository:  "registry.example.com/cache:latest",
+			expectedErr: true,
+		},
+		"invalid": {
+			repository:  "registry.example.com/Cache",
+			expectedErr: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			u, repository, err := parseRepository(tt.repository, tt.insecure)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedURL, u.String())
+			assert.Equal(t, tt.expectedRepository, repository)
+		})
+	}
+}
+
+func TestUploadToMissingRegistry(t *testing.T) {
+	registry := newFakeRegistry(t)
+	registry.server.Close()
+
+	c := &client{baseURL: &url.URL{Scheme: "http", Host: registry.server.Listener.Addr().String()}, httpClient: newHTTPClient()}
+	w := newWriter(context.Background(), c, "key", nil)
+
+	_, err := w.Write(bytes.Repeat([]byte("x"), 1024))
+	assert.Error(t, err)
+	assert.Error(t, w.Close())
+}
@@ -0,0 +1,93 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"time"
+
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
+)
+
+// A cache is stored as an OCI artifact: a manifest with an empty config and
+// a single layer holding the archive. The manifest is tagged with the hash of
+// the object name, which can't be used as a tag, and records the name in its
+// annotations.
+const (
+	configMediaType = "application/vnd.gitlab.runner.cache.config.v1+json"
+	layerMediaType  = "application/vnd.gitlab.runner.cache.layer.v1"
+
+	nameAnnotation = "com.gitlab.runner.cache.name"
+)
+
+var emptyConfig = []byte("{}")
+
+type manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	Config        v1.Descriptor     `json:"config"`
+	Layers        []v1.Descriptor   `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+func tagFor(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:])
+}
+
+// layer returns the layer holding the archive of the cache, or false when the
+// manifest doesn't describe a cache
+func (m *manifest) layer() (v1.Descriptor, bool) {
+	if m.Config.MediaType != configMediaType || len(m.Layers) != 1 {
+		return v1.Descriptor{}, false
+	}
+
+	return m.Layers[0], true
+}
+
+func (m *manifest) object() (cache.Object, bool) {
+	layer, ok := m.layer()
+	if !ok || m.Annotations[nameAnnotation] == "" {
+		return cache.Object{}, false
+	}
+
+	updated, _ := time.Parse(time.RFC3339, m.Annotations[v1.AnnotationCreated])
+
+	return cache.Object{
+		Name:    m.Annotations[nameAnnotation],
+		Size:    layer.Size,
+		Updated: updated,
+	}, true
+}
+
+// pushCache uploads the archive read from r and tags the artifact
+// describing it
+func pushCache(ctx context.Context, c *client, name string, r io.Reader, metadata map[string]string) error {
+	layer, err := c.uploadBlob(ctx, r, layerMediaType)
+	if err != nil {
+		return err
+	}
+
+	config, err := c.pushBlob(ctx, emptyConfig, configMediaType)
+	if err != nil {
+		return err
+	}
+
+	annotations := map[string]string{}
+	for key, value := range metadata {
+		annotations[key] = value
+	}
+	annotations[nameAnnotation] = name
+	annotations[v1.AnnotationCreated] = time.Now().UTC().Format(time.RFC3339)
+
+	return c.putManifest(ctx, tagFor(name), &manifest{
+		SchemaVersion: 2,
+		MediaType:     v1.MediaTypeImageManifest,
+		Config:        config,
+		Layers:        []v1.Descriptor{layer},
+		Annotations:   annotations,
+	})
+}
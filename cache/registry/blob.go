@@ -0,0 +1,163 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/gcerrors"
+)
+
+// Scheme is the scheme of the GoCloud URLs pushing the caches to a
+// registry, e.g. registry://registry.example.com/runner/project/1/key?repository=group/cache
+const Scheme = "registry"
+
+var errNotImplemented = errors.New("not implemented by the registry cache driver")
+
+func init() {
+	blob.DefaultURLMux().RegisterBucket(Scheme, new(urlOpener))
+}
+
+// urlOpener opens the buckets of the cache archiver, which authenticates with
+// the Authorization header passed by the runner
+type urlOpener struct{}
+
+func (o *urlOpener) OpenBucketURL(_ context.Context, u *url.URL) (*blob.Bucket, error) {
+	repository := u.Query().Get("repository")
+	if repository == "" {
+		return nil, fmt.Errorf("open bucket %v: missing repository", u)
+	}
+
+	scheme := "https"
+	if u.Query().Get("insecure") == "true" {
+		scheme = "http"
+	}
+
+	c := &client{
+		baseURL:       &url.URL{Scheme: scheme, Host: u.Host},
+		repository:    repository,
+		actions:       "pull,push",
+		authorization: os.Getenv(AuthorizationVariable),
+		httpClient:    newHTTPClient(),
+	}
+
+	return blob.NewBucket(&bucket{client: c}), nil
+}
+
+// bucket implements the writes of the GoCloud driver, the only operation
+// the cache archiver needs
+type bucket struct {
+	client *client
+}
+
+func (b *bucket) ErrorCode(err error) gcerrors.ErrorCode {
+	switch {
+	case errors.Is(err, errNotFound):
+		return gcerrors.NotFound
+	case errors.Is(err, errNotImplemented):
+		return gcerrors.Unimplemented
+	}
+
+	return gcerrors.Unknown
+}
+
+func (b *bucket) As(interface{}) bool {
+	return false
+}
+
+func (b *bucket) ErrorAs(error, interface{}) bool {
+	return false
+}
+
+func (b *bucket) Attributes(context.Context, string) (*driver.Attributes, error) {
+	return nil, errNotImplemented
+}
+
+func (b *bucket) ListPaged(context.Context, *driver.ListOptions) (*driver.ListPage, error) {
+	return nil, errNotImplemented
+}
+
+func (b *bucket) NewRangeReader(context.Context, string, int64, int64, *driver.ReaderOptions) (driver.Reader, error) {
+	return nil, errNotImplemented
+}
+
+func (b *bucket) NewTypedWriter(
+	ctx context.Context,
+	key string,
+	_ string,
+	opts *driver.WriterOptions,
+) (driver.Writer, error) {
+	if opts.BeforeWrite != nil {
+		err := opts.BeforeWrite(b.As)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return newWriter(ctx, b.client, key, opts.Metadata), nil
+}
+
+func (b *bucket) Copy(context.Context, string, string, *driver.CopyOptions) error {
+	return errNotImplemented
+}
+
+func (b *bucket) Delete(ctx context.Context, key string) error {
+	return deleteCache(ctx, b.client, key)
+}
+
+func (b *bucket) SignedURL(context.Context, string, *driver.SignedURLOptions) (string, error) {
+	return "", errNotImplemented
+}
+
+func (b *bucket) Close() error {
+	return nil
+}
+
+// writer streams the written archive to the registry, and tags the cache
+// once it's closed
+type writer struct {
+	ctx  context.Context
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func newWriter(ctx context.Context, c *client, key string, metadata map[string]string) *writer {
+	pr, pw := io.Pipe()
+
+	w := &writer{
+		ctx:  ctx,
+		pw:   pw,
+		done: make(chan error, 1),
+	}
+
+	go func() {
+		err := pushCache(ctx, c, key, pr, metadata)
+		_ = pr.CloseWithError(err)
+		w.done <- err
+	}()
+
+	return w
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close completes the upload. The upload is aborted when the context is
+// canceled.
+func (w *writer) Close() error {
+	if err := w.ctx.Err(); err != nil {
+		_ = w.pw.CloseWithError(err)
+		<-w.done
+		return err
+	}
+
+	_ = w.pw.Close()
+
+	return <-w.done
+}
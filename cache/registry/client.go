@@ -0,0 +1,484 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var errNotFound = errors.New("not found")
+
+var (
+	challengeParamRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+	nextLinkRegexp       = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+)
+
+// client implements the parts of the OCI distribution API used to store the
+// caches in a repository of a registry
+type client struct {
+	baseURL    *url.URL
+	repository string
+	username   string
+	password   string
+	// actions are the actions requested with bearer tokens, e.g. pull,push
+	actions string
+
+	// authorization is the Authorization header sent with the requests,
+	// set once the registry challenged the client
+	authorization string
+
+	httpClient *http.Client
+}
+
+func (c *client) url(format string, args ...interface{}) *url.URL {
+	return c.baseURL.ResolveReference(&url.URL{
+		Path: fmt.Sprintf("/v2/%s/"+format, append([]interface{}{c.repository}, args...)...),
+	})
+}
+
+func (c *client) newRequest(ctx context.Context, method string, u *url.URL, body []byte) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	return http.NewRequestWithContext(ctx, method, u.String(), reader)
+}
+
+// do sends the request, authenticating to the registry when it's challenged.
+// Requests streaming their body can't be replayed, so they must be sent
+// once the client is authenticated.
+func (c *client) do(req *http.Request) (*http.Response, error) {
+	resp, err := c.send(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	_ = resp.Body.Close()
+
+	if c.authorization != "" && !strings.Contains(challenge, "insufficient_scope") {
+		return nil, fmt.Errorf("%s %s: unauthorized", req.Method, req.URL.Path)
+	}
+
+	err = c.authenticate(req.Context(), challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Body != nil && req.GetBody == nil {
+		return nil, fmt.Errorf("%s %s: unauthorized", req.Method, req.URL.Path)
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c.send(retry)
+}
+
+func (c *client) send(req *http.Request) (*http.Response, error) {
+	if c.authorization != "" {
+		req.Header.Set("Authorization", c.authorization)
+	}
+
+	return c.httpClient.Do(req)
+}
+
+// authenticate answers a Basic or Bearer WWW-Authenticate challenge
+func (c *client) authenticate(ctx context.Context, challenge string) error {
+	scheme := strings.ToLower(strings.SplitN(challenge, " ", 2)[0])
+
+	switch scheme {
+	case "basic":
+		if c.username == "" {
+			return errors.New("registry requires credentials")
+		}
+
+		c.authorization = "Basic " + basicAuth(c.username, c.password)
+		return nil
+
+	case "bearer":
+		params := map[string]string{}
+		for _, match := range challengeParamRegexp.FindAllStringSubmatch(challenge, -1) {
+			params[strings.ToLower(match[1])] = match[2]
+		}
+
+		token, err := c.fetchToken(ctx, params["realm"], params["service"])
+		if err != nil {
+			return fmt.Errorf("fetching registry token: %w", err)
+		}
+
+		c.authorization = "Bearer " + token
+		return nil
+	}
+
+	return fmt.Errorf("unsupported registry authentication challenge %q", challenge)
+}
+
+func (c *client) fetchToken(ctx context.Context, realm string, service string) (string, error) {
+	u, err := url.Parse(realm)
+	if err != nil || realm == "" {
+		return "", fmt.Errorf("invalid realm %q", realm)
+	}
+
+	query := u.Query()
+	if service != "" {
+		query.Set("service", service)
+	}
+	query.Set("scope", fmt.Sprintf("repository:%s:%s", c.repository, c.actions))
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var response struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return "", err
+	}
+
+	if response.Token != "" {
+		return response.Token, nil
+	}
+	if response.AccessToken != "" {
+		return response.AccessToken, nil
+	}
+
+	return "", errors.New("no token received")
+}
+
+// ping checks the API is available, authenticating the client
+func (c *client) ping(ctx context.Context) error {
+	req, err := c.newRequest(ctx, http.MethodGet, c.baseURL.ResolveReference(&url.URL{Path: "/v2/"}), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	return checkStatus(resp, http.StatusOK)
+}
+
+func (c *client) startUpload(ctx context.Context) (*url.URL, error) {
+	req, err := c.newRequest(ctx, http.MethodPost, c.url("blobs/uploads/"), []byte{})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	err = checkStatus(resp, http.StatusAccepted)
+	if err != nil {
+		return nil, err
+	}
+
+	return location(resp)
+}
+
+// uploadBlob streams the blob to the registry, computing its digest while
+// it's sent
+func (c *client) uploadBlob(ctx context.Context, r io.Reader, mediaType string) (v1.Descriptor, error) {
+	uploadURL, err := c.startUpload(ctx)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+
+	digester := digest.Canonical.Digester()
+	body := &countingReader{r: io.TeeReader(r, digester.Hash())}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, uploadURL.String(), ioutil.NopCloser(body))
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	err = checkStatus(resp, http.StatusAccepted)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+
+	uploadURL, err = location(resp)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+
+	descriptor := v1.Descriptor{MediaType: mediaType, Digest: digester.Digest(), Size: body.n}
+
+	return descriptor, c.finishUpload(ctx, uploadURL, descriptor.Digest, nil)
+}
+
+// pushBlob uploads a small blob in a single request
+func (c *client) pushBlob(ctx context.Context, data []byte, mediaType string) (v1.Descriptor, error) {
+	uploadURL, err := c.startUpload(ctx)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+
+	descriptor := v1.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(data), Size: int64(len(data))}
+
+	return descriptor, c.finishUpload(ctx, uploadURL, descriptor.Digest, data)
+}
+
+func (c *client) finishUpload(ctx context.Context, uploadURL *url.URL, d digest.Digest, data []byte) error {
+	query := uploadURL.Query()
+	query.Set("digest", d.String())
+	uploadURL.RawQuery = query.Encode()
+
+	if data == nil {
+		data = []byte{}
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPut, uploadURL, data)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	return checkStatus(resp, http.StatusCreated)
+}
+
+// getManifest returns the manifest with its digest, or errNotFound
+func (c *client) getManifest(ctx context.Context, reference string) (*manifest, digest.Digest, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, c.url("manifests/%s", reference), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", v1.MediaTypeImageManifest)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", errNotFound
+	}
+
+	err = checkStatus(resp, http.StatusOK)
+	if err != nil {
+		return nil, "", err
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var m manifest
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		return nil, "", fmt.Errorf("decoding manifest %s: %w", reference, err)
+	}
+
+	d, err := digest.Parse(resp.Header.Get("Docker-Content-Digest"))
+	if err != nil {
+		d = digest.FromBytes(data)
+	}
+
+	return &m, d, nil
+}
+
+func (c *client) putManifest(ctx context.Context, tag string, m *manifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPut, c.url("manifests/%s", tag), data)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", v1.MediaTypeImageManifest)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	return checkStatus(resp, http.StatusCreated)
+}
+
+func (c *client) deleteManifest(ctx context.Context, d digest.Digest) error {
+	req, err := c.newRequest(ctx, http.MethodDelete, c.url("manifests/%s", d), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+
+	return checkStatus(resp, http.StatusAccepted)
+}
+
+// listTags returns all the tags of the repository, following the pagination
+func (c *client) listTags(ctx context.Context) ([]string, error) {
+	var tags []string
+
+	u := c.url("tags/list")
+	for u != nil {
+		req, err := c.newRequest(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+
+		err = checkStatus(resp, http.StatusOK)
+		if err == nil {
+			err = json.NewDecoder(resp.Body).Decode(&page)
+		}
+		_ = resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			return tags, nil
+		case err != nil:
+			return nil, err
+		}
+
+		tags = append(tags, page.Tags...)
+
+		u = nil
+		if match := nextLinkRegexp.FindStringSubmatch(resp.Header.Get("Link")); match != nil {
+			u, err = resp.Request.URL.Parse(match[1])
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return tags, nil
+}
+
+// resolveBlobURL returns the URL from which the blob is downloaded. Registries
+// storing the blobs in an object storage redirect to a pre-signed URL of the
+// storage, which is returned. Otherwise the blob is served by the registry.
+func (c *client) resolveBlobURL(ctx context.Context, d digest.Digest) (u *url.URL, redirected bool, err error) {
+	blobURL := c.url("blobs/%s", d)
+
+	req, err := c.newRequest(ctx, http.MethodGet, blobURL, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch {
+	case resp.StatusCode >= 300 && resp.StatusCode < 400:
+		u, err = location(resp)
+		return u, true, err
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, false, errNotFound
+	}
+
+	return blobURL, false, checkStatus(resp, http.StatusOK)
+}
+
+func location(resp *http.Response) (*url.URL, error) {
+	loc := resp.Header.Get("Location")
+	if loc == "" {
+		return nil, fmt.Errorf("%s %s: missing Location header", resp.Request.Method, resp.Request.URL.Path)
+	}
+
+	return resp.Request.URL.Parse(loc)
+}
+
+func checkStatus(resp *http.Response, expected int) error {
+	if resp.StatusCode == expected {
+		return nil
+	}
+
+	return fmt.Errorf("%s %s: unexpected status %s", resp.Request.Method, resp.Request.URL.Path, resp.Status)
+}
+
+func basicAuth(username string, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
@@ -1,21 +1,33 @@
 package s3
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 
+	"github.com/minio/minio-go/v6"
 	"github.com/sirupsen/logrus"
 
 	"gitlab.com/gitlab-org/gitlab-runner/cache"
 	"gitlab.com/gitlab-org/gitlab-runner/common"
 )
 
+const (
+	// multipartUploadParts is the number of pre-signed URLs of the parts of
+	// multipart uploads. With parts of at most 5GiB, caches up to 80GiB can
+	// be uploaded.
+	multipartUploadParts = 16
+	minPartSize          = 5 * 1024 * 1024
+)
+
 type s3Adapter struct {
 	timeout    time.Duration
 	config     *common.CacheS3Config
 	objectName string
+	metadata   map[string]string
 	client     minioClient
 }
 
@@ -31,7 +43,19 @@ func (a *s3Adapter) GetDownloadURL() *url.URL {
 }
 
 func (a *s3Adapter) GetUploadURL() *url.URL {
-	URL, err := a.client.PresignedPutObject(a.config.BucketName, a.objectName, a.timeout)
+	URL, err := a.presignUploadURL()
+	if err != nil {
+		logrus.WithError(err).Error("error while generating S3 pre-signed URL")
+
+		return nil
+	}
+
+	return URL
+}
+
+// GetDeleteURL returns the pre-signed URL deleting the object
+func (a *s3Adapter) GetDeleteURL() *url.URL {
+	URL, err := a.client.Presign(http.MethodDelete, a.config.BucketName, a.objectName, a.timeout, nil)
 	if err != nil {
 		logrus.WithError(err).Error("error while generating S3 pre-signed URL")
 
@@ -41,6 +65,21 @@ func (a *s3Adapter) GetUploadURL() *url.URL {
 	return URL
 }
 
+// presignUploadURL signs the metadata as query parameters, S3 rejects the
+// unsigned metadata headers of pre-signed requests
+func (a *s3Adapter) presignUploadURL() (*url.URL, error) {
+	if len(a.metadata) == 0 {
+		return a.client.PresignedPutObject(a.config.BucketName, a.objectName, a.timeout)
+	}
+
+	params := url.Values{}
+	for key, value := range a.metadata {
+		params.Set("x-amz-meta-"+key, value)
+	}
+
+	return a.client.Presign(http.MethodPut, a.config.BucketName, a.objectName, a.timeout, params)
+}
+
 func (a *s3Adapter) GetUploadHeaders() http.Header {
 	return nil
 }
@@ -53,6 +92,159 @@ func (a *s3Adapter) GetUploadEnv() map[string]string {
 	return nil
 }
 
+// GetMultipartUpload starts a multipart upload, and pre-signs the URLs of
+// its parts and the URLs completing and aborting it. S3 can't pre-sign the
+// parts of an upload not started yet, so the upload is started with the
+// script of the job, and aborted by the runner when the job ends. The
+// uploads left by a runner stopped during the job are aborted by the garbage
+// collection.
+func (a *s3Adapter) GetMultipartUpload(partSize int64) *cache.MultipartUpload {
+	uploadID, err := a.client.NewMultipartUpload(
+		a.config.BucketName,
+		a.objectName,
+		minio.PutObjectOptions{UserMetadata: a.metadata},
+	)
+	if err != nil {
+		logrus.WithError(err).Error("error while starting S3 multipart upload")
+		return nil
+	}
+
+	upload, err := a.presignMultipartUpload(uploadID, partSize)
+	if err != nil {
+		logrus.WithError(err).Error("error while generating S3 pre-signed URL")
+
+		err = a.abortMultipartUpload(a.objectName, uploadID)
+		if err != nil {
+			logrus.WithError(err).Error("error while aborting S3 multipart upload")
+		}
+
+		return nil
+	}
+
+	upload.Abort = func() error {
+		return a.abortMultipartUpload(a.objectName, uploadID)
+	}
+
+	return upload
+}
+
+// abortMultipartUpload aborts the upload, unless it was already completed or
+// aborted
+func (a *s3Adapter) abortMultipartUpload(objectName string, uploadID string) error {
+	err := a.client.AbortMultipartUpload(a.config.BucketName, objectName, uploadID)
+	if minio.ToErrorResponse(err).Code == "NoSuchUpload" {
+		return nil
+	}
+
+	return err
+}
+
+func (a *s3Adapter) presignMultipartUpload(uploadID string, partSize int64) (*cache.MultipartUpload, error) {
+	if partSize < minPartSize {
+		partSize = minPartSize
+	}
+
+	upload := &cache.MultipartUpload{
+		Type:     cache.MultipartUploadS3,
+		PartSize: partSize,
+	}
+
+	presign := func(method string, params url.Values) (string, error) {
+		params.Set("uploadId", uploadID)
+
+		u, err := a.client.Presign(method, a.config.BucketName, a.objectName, a.timeout, params)
+		if err != nil {
+			return "", err
+		}
+
+		return u.String(), nil
+	}
+
+	for part := 1; part <= multipartUploadParts; part++ {
+		u, err := presign(http.MethodPut, url.Values{"partNumber": []string{strconv.Itoa(part)}})
+		if err != nil {
+			return nil, err
+		}
+
+		upload.PartURLs = append(upload.PartURLs, u)
+	}
+
+	var err error
+
+	upload.CompleteURL, err = presign(http.MethodPost, url.Values{})
+	if err != nil {
+		return nil, err
+	}
+
+	upload.AbortURL, err = presign(http.MethodDelete, url.Values{})
+	if err != nil {
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+func (a *s3Adapter) List(ctx context.Context) ([]cache.Object, error) {
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	var objects []cache.Object
+	for info := range a.client.ListObjectsV2(a.config.BucketName, a.objectName, true, doneCh) {
+		if info.Err != nil {
+			return nil, fmt.Errorf("listing S3 objects: %w", info.Err)
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		objects = append(objects, cache.Object{Name: info.Key, Size: info.Size, Updated: info.LastModified})
+	}
+
+	return cache.SetAccessTimes(objects), nil
+}
+
+func (a *s3Adapter) ListIncompleteUploads(ctx context.Context) ([]cache.IncompleteUpload, error) {
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	var uploads []cache.IncompleteUpload
+	for info := range a.client.ListIncompleteUploads(a.config.BucketName, a.objectName, true, doneCh) {
+		if info.Err != nil {
+			return nil, fmt.Errorf("listing S3 multipart uploads: %w", info.Err)
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		uploads = append(uploads, cache.IncompleteUpload{
+			Name:      info.Key,
+			ID:        info.UploadID,
+			Size:      info.Size,
+			Initiated: info.Initiated,
+		})
+	}
+
+	return uploads, nil
+}
+
+func (a *s3Adapter) AbortIncompleteUpload(_ context.Context, upload cache.IncompleteUpload) error {
+	err := a.abortMultipartUpload(upload.Name, upload.ID)
+	if err != nil {
+		return fmt.Errorf("aborting S3 multipart upload: %w", err)
+	}
+
+	return nil
+}
+
+func (a *s3Adapter) Delete(_ context.Context, name string) error {
+	err := a.client.RemoveObject(a.config.BucketName, name)
+	if err != nil {
+		return fmt.Errorf("removing S3 object: %w", err)
+	}
+
+	return nil
+}
+
 func New(config *common.CacheConfig, timeout time.Duration, objectName string) (cache.Adapter, error) {
 	s3 := config.S3
 	if s3 == nil {
@@ -68,6 +260,7 @@ func New(config *common.CacheConfig, timeout time.Duration, objectName string) (
 		config:     s3,
 		timeout:    timeout,
 		objectName: objectName,
+		metadata:   cache.ObjectMetadata(config),
 		client:     client,
 	}
 
@@ -1,11 +1,17 @@
 package s3
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/minio/minio-go/v6"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -147,3 +153,416 @@ func TestNoConfiguration(t *testing.T) {
 
 	assert.EqualError(t, err, "missing S3 configuration")
 }
+
+func TestUploadURLWithMetadata(t *testing.T) {
+	URL, err := url.Parse("https://s3.example.com")
+	require.NoError(t, err)
+
+	client := new(mockMinioClient)
+	defer client.AssertExpectations(t)
+
+	client.
+		On(
+			"Presign",
+			http.MethodPut, bucketName, objectName, defaultTimeout,
+			url.Values{"x-amz-meta-gitlab_runner_cache_key_id": []string{"v2"}},
+		).
+		Return(URL, nil).
+		Once()
+
+	oldNewMinioClient := newMinioClient
+	newMinioClient = func(s3 *common.CacheS3Config) (minioClient, error) {
+		return client, nil
+	}
+	defer func() {
+		newMinioClient = oldNewMinioClient
+	}()
+
+	cacheConfig := defaultCacheFactory()
+	cacheConfig.Encryption = &common.CacheEncryptionConfig{KeyID: "v2"}
+
+	adapter, err := New(cacheConfig, defaultTimeout, objectName)
+	require.NoError(t, err)
+
+	assert.Equal(t, URL, adapter.GetUploadURL())
+	assert.Nil(t, adapter.GetUploadHeaders())
+}
+
+func TestDeleteURL(t *testing.T) {
+	URL, err := url.Parse("https://s3.example.com")
+	require.NoError(t, err)
+
+	client := new(mockMinioClient)
+	defer client.AssertExpectations(t)
+
+	client.
+		On("Presign", http.MethodDelete, bucketName, objectName, defaultTimeout, url.Values(nil)).
+		Return(URL, nil).
+		Once()
+
+	oldNewMinioClient := newMinioClient
+	newMinioClient = func(s3 *common.CacheS3Config) (minioClient, error) {
+		return client, nil
+	}
+	defer func() {
+		newMinioClient = oldNewMinioClient
+	}()
+
+	adapter, err := New(defaultCacheFactory(), defaultTimeout, objectName)
+	require.NoError(t, err)
+
+	assert.Equal(t, URL, adapter.(cache.DeleteURLAdapter).GetDeleteURL())
+}
+
+func TestGetMultipartUpload(t *testing.T) {
+	URL, err := url.Parse("https://s3.example.com/part")
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		presignErr     error
+		expectedUpload bool
+	}{
+		"upload pre-signed": {
+			expectedUpload: true,
+		},
+		"pre-signing error": {
+			presignErr: errors.New("test error"),
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			client := new(mockMinioClient)
+			defer client.AssertExpectations(t)
+
+			client.
+				On("NewMultipartUpload", bucketName, objectName, mock.Anything).
+				Return("upload-id", nil).
+				Once()
+			client.
+				On("Presign", mock.Anything, bucketName, objectName, defaultTimeout, mock.Anything).
+				Return(URL, tt.presignErr)
+			if tt.presignErr != nil {
+				client.
+					On("AbortMultipartUpload", bucketName, objectName, "upload-id").
+					Return(nil).
+					Once()
+			}
+
+			oldNewMinioClient := newMinioClient
+			newMinioClient = func(s3 *common.CacheS3Config) (minioClient, error) {
+				return client, nil
+			}
+			defer func() {
+				newMinioClient = oldNewMinioClient
+			}()
+
+			adapter, err := New(defaultCacheFactory(), defaultTimeout, objectName)
+			require.NoError(t, err)
+
+			upload := adapter.(cache.MultipartUploader).GetMultipartUpload(1024)
+			if !tt.expectedUpload {
+				assert.Nil(t, upload)
+				return
+			}
+
+			require.NotNil(t, upload)
+			assert.Equal(t, cache.MultipartUploadS3, upload.Type)
+			assert.Equal(t, int64(minPartSize), upload.PartSize)
+			assert.Len(t, upload.PartURLs, multipartUploadParts)
+			assert.Equal(t, URL.String(), upload.CompleteURL)
+			assert.Equal(t, URL.String(), upload.AbortURL)
+
+			client.AssertCalled(
+				t, "Presign", http.MethodPut, bucketName, objectName, defaultTimeout,
+				url.Values{"partNumber": []string{"16"}, "uploadId": []string{"upload-id"}},
+			)
+		})
+	}
+}
+
+func TestMultipartUploadAbort(t *testing.T) {
+	tests := map[string]struct {
+		abortErr      error
+		expectedError bool
+	}{
+		"upload aborted": {},
+		"upload already completed": {
+			abortErr: minio.ErrorResponse{Code: "NoSuchUpload", StatusCode: http.StatusNotFound},
+		},
+		"abort error": {
+			abortErr:      errors.New("test error"),
+			expectedError: true,
+		},
+	}
+
+	URL, err := url.Parse("https://s3.example.com/part")
+	require.NoError(t, err)
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			client := new(mockMinioClient)
+			defer client.AssertExpectations(t)
+
+			client.
+				On("NewMultipartUpload", bucketName, objectName, mock.Anything).
+				Return("upload-id", nil).
+				Once()
+			client.
+				On("Presign", mock.Anything, bucketName, objectName, defaultTimeout, mock.Anything).
+				Return(URL, nil)
+			client.
+				On("AbortMultipartUpload", bucketName, objectName, "upload-id").
+				Return(tt.abortErr).
+				Once()
+
+			oldNewMinioClient := newMinioClient
+			newMinioClient = func(s3 *common.CacheS3Config) (minioClient, error) {
+				return client, nil
+			}
+			defer func() {
+				newMinioClient = oldNewMinioClient
+			}()
+
+			adapter, err := New(defaultCacheFactory(), defaultTimeout, objectName)
+			require.NoError(t, err)
+
+			upload := adapter.(cache.MultipartUploader).GetMultipartUpload(1024)
+			require.NotNil(t, upload)
+			require.NotNil(t, upload.Abort)
+
+			err = upload.Abort()
+			if tt.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	now := time.Now()
+
+	tests := map[string]struct {
+		objects         []minio.ObjectInfo
+		expectedObjects []cache.Object
+		expectedErr     string
+	}{
+		"objects listed": {
+			objects: []minio.ObjectInfo{
+				{Key: "key-1", LastModified: now},
+				{Key: "key-2", LastModified: now.Add(-time.Hour)},
+			},
+			expectedObjects: []cache.Object{
+				{Name: "key-1", Updated: now},
+				{Name: "key-2", Updated: now.Add(-time.Hour)},
+			},
+		},
+		"listing error": {
+			objects:     []minio.ObjectInfo{{Err: errors.New("access denied")}},
+			expectedErr: "listing S3 objects: access denied",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			objectsCh := make(chan minio.ObjectInfo, len(tt.objects))
+			for _, object := range tt.objects {
+				objectsCh <- object
+			}
+			close(objectsCh)
+
+			client := new(mockMinioClient)
+			defer client.AssertExpectations(t)
+			client.
+				On("ListObjectsV2", bucketName, objectName, true, mock.Anything).
+				Return((<-chan minio.ObjectInfo)(objectsCh)).
+				Once()
+
+			oldNewMinioClient := newMinioClient
+			newMinioClient = func(s3 *common.CacheS3Config) (minioClient, error) {
+				return client, nil
+			}
+			defer func() {
+				newMinioClient = oldNewMinioClient
+			}()
+
+			adapter, err := New(defaultCacheFactory(), defaultTimeout, objectName)
+			require.NoError(t, err)
+
+			objects, err := adapter.(cache.Lister).List(context.Background())
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedObjects, objects)
+		})
+	}
+}
+
+func TestIncompleteUploads(t *testing.T) {
+	now := time.Now()
+
+	tests := map[string]struct {
+		uploads         []minio.ObjectMultipartInfo
+		abortErr        error
+		expectedUploads []cache.IncompleteUpload
+		expectedErr     string
+		expectedAbort   string
+	}{
+		"uploads listed and aborted": {
+			uploads: []minio.ObjectMultipartInfo{
+				{Key: "key-1", UploadID: "upload-1", Size: 10, Initiated: now},
+			},
+			expectedUploads: []cache.IncompleteUpload{
+				{Name: "key-1", ID: "upload-1", Size: 10, Initiated: now},
+			},
+		},
+		"upload already aborted": {
+			uploads: []minio.ObjectMultipartInfo{
+				{Key: "key-1", UploadID: "upload-1", Initiated: now},
+			},
+			abortErr: minio.ErrorResponse{Code: "NoSuchUpload"},
+			expectedUploads: []cache.IncompleteUpload{
+				{Name: "key-1", ID: "upload-1", Initiated: now},
+			},
+		},
+		"abort error": {
+			uploads: []minio.ObjectMultipartInfo{
+				{Key: "key-1", UploadID: "upload-1", Initiated: now},
+			},
+			abortErr: minio.ErrorResponse{Code: "AccessDenied", Message: "access denied"},
+			expectedUploads: []cache.IncompleteUpload{
+				{Name: "key-1", ID: "upload-1", Initiated: now},
+			},
+			expectedAbort: "aborting S3 multipart upload: access denied",
+		},
+		"listing error": {
+			uploads:     []minio.ObjectMultipartInfo{{Err: errors.New("access denied")}},
+			expectedErr: "listing S3 multipart uploads: access denied",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			uploadsCh := make(chan minio.ObjectMultipartInfo, len(tt.uploads))
+			for _, upload := range tt.uploads {
+				uploadsCh <- upload
+			}
+			close(uploadsCh)
+
+			client := new(mockMinioClient)
+			defer client.AssertExpectations(t)
+			client.
+				On("ListIncompleteUploads", bucketName, objectName, true, mock.Anything).
+				Return((<-chan minio.ObjectMultipartInfo)(uploadsCh)).
+				Once()
+
+			oldNewMinioClient := newMinioClient
+			newMinioClient = func(s3 *common.CacheS3Config) (minioClient, error) {
+				return client, nil
+			}
+			defer func() {
+				newMinioClient = oldNewMinioClient
+			}()
+
+			adapter, err := New(defaultCacheFactory(), defaultTimeout, objectName)
+			require.NoError(t, err)
+
+			lister := adapter.(cache.IncompleteUploadLister)
+
+			uploads, err := lister.ListIncompleteUploads(context.Background())
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedUploads, uploads)
+
+			client.On("AbortMultipartUpload", bucketName, "key-1", "upload-1").Return(tt.abortErr).Once()
+
+			err = lister.AbortIncompleteUpload(context.Background(), uploads[0])
+			if tt.expectedAbort != "" {
+				assert.EqualError(t, err, tt.expectedAbort)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+// fakeS3Server implements the parts of the S3 API used to list and delete
+// objects, standing in for MinIO
+type fakeS3Server struct {
+	objects map[string]cache.Object
+}
+
+func (f *fakeS3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucketPath := "/" + bucketName
+
+	switch {
+	case r.Method == http.MethodGet && strings.TrimSuffix(r.URL.Path, "/") == bucketPath:
+		var contents string
+		for _, object := range f.objects {
+			if !strings.HasPrefix(object.Name, r.URL.Query().Get("prefix")) {
+				continue
+			}
+
+			contents += fmt.Sprintf(
+				"<Contents><Key>%s</Key><LastModified>%s</LastModified><Size>%d</Size></Contents>",
+				object.Name,
+				object.Updated.Format("2006-01-02T15:04:05.000Z"),
+				object.Size,
+			)
+		}
+
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = fmt.Fprintf(
+			w,
+			`<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`+
+				`<Name>%s</Name><IsTruncated>false</IsTruncated>%s</ListBucketResult>`,
+			bucketName,
+			contents,
+		)
+
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, bucketPath+"/"):
+		delete(f.objects, strings.TrimPrefix(r.URL.Path, bucketPath+"/"))
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func TestListAndDeleteWithS3Server(t *testing.T) {
+	updated := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	server := &fakeS3Server{
+		objects: map[string]cache.Object{
+			"key-1":   {Name: "key-1", Size: 10, Updated: updated},
+			"other-1": {Name: "other-1", Size: 20, Updated: updated},
+		},
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := defaultCacheFactory()
+	config.S3.ServerAddress = strings.TrimPrefix(ts.URL, "http://")
+	config.S3.Insecure = true
+
+	adapter, err := New(config, defaultTimeout, objectName)
+	require.NoError(t, err)
+
+	objects, err := adapter.(cache.Lister).List(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []cache.Object{{Name: "key-1", Size: 10, Updated: updated}}, objects)
+
+	require.NoError(t, adapter.(cache.Deleter).Delete(context.Background(), "key-1"))
+	assert.NotContains(t, server.objects, "key-1")
+	assert.Contains(t, server.objects, "other-1")
+}
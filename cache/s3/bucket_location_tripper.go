@@ -9,15 +9,24 @@ import (
 
 type bucketLocationTripper struct {
 	bucketLocation string
+	transport      http.RoundTripper
 }
 
 // The Minio Golang library always attempts to query the bucket location and
 // currently has no way of statically setting that value.  To avoid that
-// lookup, the Runner cache uses the library only to generate the URLs,
-// forgoing the library's API for uploading and downloading files. The custom
-// Roundtripper stubs out any network requests that would normally be made via
-// the library.
+// lookup, the custom Roundtripper stubs out the bucket location requests.
+// The Runner cache uses the library mostly to generate the URLs, the other
+// requests, used to list and delete the caches, are sent to the server.
 func (b *bucketLocationTripper) RoundTrip(req *http.Request) (res *http.Response, err error) {
+	if _, ok := req.URL.Query()["location"]; !ok {
+		transport := b.transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+
+		return transport.RoundTrip(req)
+	}
+
 	var buffer bytes.Buffer
 	err = xml.NewEncoder(&buffer).Encode(b.bucketLocation)
 	if err != nil {
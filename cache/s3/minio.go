@@ -13,6 +13,13 @@ import (
 const DefaultAWSS3Server = "s3.amazonaws.com"
 
 type minioClient interface {
+	Presign(
+		method string,
+		bucketName string,
+		objectName string,
+		expires time.Duration,
+		reqParams url.Values,
+	) (*url.URL, error)
 	PresignedGetObject(
 		bucketName string,
 		objectName string,
@@ -20,6 +27,38 @@ type minioClient interface {
 		reqParams url.Values,
 	) (*url.URL, error)
 	PresignedPutObject(bucketName string, objectName string, expires time.Duration) (*url.URL, error)
+	ListObjectsV2(
+		bucketName string,
+		objectPrefix string,
+		recursive bool,
+		doneCh <-chan struct{},
+	) <-chan minio.ObjectInfo
+	RemoveObject(bucketName string, objectName string) error
+	ListIncompleteUploads(
+		bucketName string,
+		objectPrefix string,
+		recursive bool,
+		doneCh <-chan struct{},
+	) <-chan minio.ObjectMultipartInfo
+	NewMultipartUpload(bucketName string, objectName string, opts minio.PutObjectOptions) (string, error)
+	AbortMultipartUpload(bucketName string, objectName string, uploadID string) error
+}
+
+// minioCore adds the multipart upload operations of minio.Core to the client
+type minioCore struct {
+	*minio.Client
+}
+
+func (c *minioCore) NewMultipartUpload(
+	bucketName string,
+	objectName string,
+	opts minio.PutObjectOptions,
+) (string, error) {
+	return minio.Core{Client: c.Client}.NewMultipartUpload(bucketName, objectName, opts)
+}
+
+func (c *minioCore) AbortMultipartUpload(bucketName string, objectName string, uploadID string) error {
+	return minio.Core{Client: c.Client}.AbortMultipartUpload(bucketName, objectName, uploadID)
 }
 
 var newMinio = minio.New
@@ -44,5 +83,5 @@ var newMinioClient = func(s3 *common.CacheS3Config) (minioClient, error) {
 		bucketLocation: s3.BucketLocation,
 	})
 
-	return client, nil
+	return &minioCore{Client: client}, nil
 }
@@ -5,6 +5,8 @@ package s3
 import (
 	time "time"
 
+	minio "github.com/minio/minio-go/v6"
+
 	mock "github.com/stretchr/testify/mock"
 
 	url "net/url"
@@ -15,6 +17,96 @@ type mockMinioClient struct {
 	mock.Mock
 }
 
+// AbortMultipartUpload provides a mock function with given fields: bucketName, objectName, uploadID
+func (_m *mockMinioClient) AbortMultipartUpload(bucketName string, objectName string, uploadID string) error {
+	ret := _m.Called(bucketName, objectName, uploadID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, string) error); ok {
+		r0 = rf(bucketName, objectName, uploadID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ListIncompleteUploads provides a mock function with given fields: bucketName, objectPrefix, recursive, doneCh
+func (_m *mockMinioClient) ListIncompleteUploads(bucketName string, objectPrefix string, recursive bool, doneCh <-chan struct{}) <-chan minio.ObjectMultipartInfo {
+	ret := _m.Called(bucketName, objectPrefix, recursive, doneCh)
+
+	var r0 <-chan minio.ObjectMultipartInfo
+	if rf, ok := ret.Get(0).(func(string, string, bool, <-chan struct{}) <-chan minio.ObjectMultipartInfo); ok {
+		r0 = rf(bucketName, objectPrefix, recursive, doneCh)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(<-chan minio.ObjectMultipartInfo)
+		}
+	}
+
+	return r0
+}
+
+// ListObjectsV2 provides a mock function with given fields: bucketName, objectPrefix, recursive, doneCh
+func (_m *mockMinioClient) ListObjectsV2(bucketName string, objectPrefix string, recursive bool, doneCh <-chan struct{}) <-chan minio.ObjectInfo {
+	ret := _m.Called(bucketName, objectPrefix, recursive, doneCh)
+
+	var r0 <-chan minio.ObjectInfo
+	if rf, ok := ret.Get(0).(func(string, string, bool, <-chan struct{}) <-chan minio.ObjectInfo); ok {
+		r0 = rf(bucketName, objectPrefix, recursive, doneCh)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(<-chan minio.ObjectInfo)
+		}
+	}
+
+	return r0
+}
+
+// NewMultipartUpload provides a mock function with given fields: bucketName, objectName, opts
+func (_m *mockMinioClient) NewMultipartUpload(bucketName string, objectName string, opts minio.PutObjectOptions) (string, error) {
+	ret := _m.Called(bucketName, objectName, opts)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string, string, minio.PutObjectOptions) string); ok {
+		r0 = rf(bucketName, objectName, opts)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string, minio.PutObjectOptions) error); ok {
+		r1 = rf(bucketName, objectName, opts)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Presign provides a mock function with given fields: method, bucketName, objectName, expires, reqParams
+func (_m *mockMinioClient) Presign(method string, bucketName string, objectName string, expires time.Duration, reqParams url.Values) (*url.URL, error) {
+	ret := _m.Called(method, bucketName, objectName, expires, reqParams)
+
+	var r0 *url.URL
+	if rf, ok := ret.Get(0).(func(string, string, string, time.Duration, url.Values) *url.URL); ok {
+		r0 = rf(method, bucketName, objectName, expires, reqParams)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*url.URL)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string, string, time.Duration, url.Values) error); ok {
+		r1 = rf(method, bucketName, objectName, expires, reqParams)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // PresignedGetObject provides a mock function with given fields: bucketName, objectName, expires, reqParams
 func (_m *mockMinioClient) PresignedGetObject(bucketName string, objectName string, expires time.Duration, reqParams url.Values) (*url.URL, error) {
 	ret := _m.Called(bucketName, objectName, expires, reqParams)
@@ -60,3 +152,17 @@ func (_m *mockMinioClient) PresignedPutObject(bucketName string, objectName stri
 
 	return r0, r1
 }
+
+// RemoveObject provides a mock function with given fields: bucketName, objectName
+func (_m *mockMinioClient) RemoveObject(bucketName string, objectName string) error {
+	ret := _m.Called(bucketName, objectName)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string) error); ok {
+		r0 = rf(bucketName, objectName)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
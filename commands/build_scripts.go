@@ -0,0 +1,136 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+const maskedValue = "[MASKED]"
+
+// buildScriptsWriter generates the scripts of all the stages of a build,
+// without executing any of them
+type buildScriptsWriter struct {
+	// OutputDir is the directory in which a file is created for every
+	// stage. When empty, the scripts are written to Output.
+	OutputDir string
+	Output    io.Writer
+}
+
+// getShellScriptInfo returns the shell configuration the executor would use
+// for the build. The executor is only created, not prepared, so nothing
+// is started.
+func getShellScriptInfo(build *common.Build) (common.ShellScriptInfo, error) {
+	config := build.Runner
+
+	provider := common.GetExecutorProvider(config.Executor)
+	if provider == nil || !provider.CanCreate() {
+		return common.ShellScriptInfo{}, fmt.Errorf("unknown executor: %s", config.Executor)
+	}
+
+	info := *provider.Create().Shell()
+	info.Build = build
+	info.PreCloneScript = config.PreCloneScript
+	info.PreBuildScript = config.PreBuildScript
+	info.PostBuildScript = config.PostBuildScript
+	if config.Shell != "" {
+		info.Shell = config.Shell
+	}
+
+	return info, nil
+}
+
+// prepareBuildDirs sets the build directories the same way the executor
+// would, falling back to directories in the working directory
+func prepareBuildDirs(build *common.Build, wd string) error {
+	config := build.Runner
+
+	rootDir := config.BuildsDir
+	if rootDir == "" {
+		rootDir = filepath.Join(wd, "builds")
+	}
+
+	cacheDir := config.CacheDir
+	if cacheDir == "" {
+		cacheDir = filepath.Join(wd, "cache")
+	}
+
+	customBuildDirEnabled := config.CustomBuildDir != nil && config.CustomBuildDir.Enabled
+
+	return build.StartBuild(rootDir, cacheDir, customBuildDirEnabled, false)
+}
+
+// Write generates the script of every stage of the build. Stages without
+// anything to execute are skipped. The values of masked variables are
+// redacted from the scripts.
+func (w *buildScriptsWriter) Write(build *common.Build, info common.ShellScriptInfo) error {
+	redact := newMaskReplacer(build.GetAllVariables().Masked())
+
+	if w.OutputDir != "" {
+		err := os.MkdirAll(w.OutputDir, 0755)
+		if err != nil {
+			return fmt.Errorf("creating scripts directory: %w", err)
+		}
+	}
+
+	for index, stage := range build.BuildStages() {
+		script, err := common.GenerateShellScript(stage, info)
+		if errors.Is(err, common.ErrSkipBuildStage) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("generating script for %s: %w", stage, err)
+		}
+
+		err = w.writeScript(index, stage, info.Shell, redact.Replace(script))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *buildScriptsWriter) writeScript(index int, stage common.BuildStage, shell string, script string) error {
+	if w.OutputDir == "" {
+		_, err := fmt.Fprintf(w.Output, "### %s\n%s\n", stage, script)
+		return err
+	}
+
+	name := fmt.Sprintf("%02d-%s.%s", index, stage, scriptExtension(shell))
+	err := ioutil.WriteFile(filepath.Join(w.OutputDir, name), []byte(script), 0644)
+	if err != nil {
+		return fmt.Errorf("writing script for %s: %w", stage, err)
+	}
+
+	return nil
+}
+
+func scriptExtension(shell string) string {
+	switch shell {
+	case "pwsh", "powershell":
+		return "ps1"
+	case "cmd":
+		return "cmd"
+	default:
+		return "sh"
+	}
+}
+
+func newMaskReplacer(values []string) *strings.Replacer {
+	var oldnew []string
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		oldnew = append(oldnew, value, maskedValue)
+	}
+
+	return strings.NewReplacer(oldnew...)
+}
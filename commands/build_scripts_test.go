@@ -0,0 +1,89 @@
+package commands
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	_ "gitlab.com/gitlab-org/gitlab-runner/shells"
+)
+
+func newScriptsTestBuild(t *testing.T) (*common.Build, common.ShellScriptInfo) {
+	build, err := common.NewBuild(common.JobResponse{
+		GitInfo: common.GitInfo{
+			RepoURL: "https://gitlab.example.com/group/project.git",
+			Sha:     "1234567890abcdef1234567890abcdef12345678",
+			Ref:     "main",
+			RefType: common.RefTypeBranch,
+		},
+		Variables: common.JobVariables{
+			{Key: "SECRET", Value: "hidden-value", Masked: true},
+		},
+		Steps: common.Steps{
+			{
+				Name:   common.StepNameScript,
+				Script: common.StepScript{"echo $SECRET", "echo hidden-value"},
+				When:   common.StepWhenOnSuccess,
+			},
+		},
+	}, &common.RunnerConfig{}, nil, nil)
+	require.NoError(t, err)
+
+	build.BuildDir = "/builds/project"
+
+	return build, common.ShellScriptInfo{Shell: "bash", Build: build}
+}
+
+func TestBuildScriptsWriterOutput(t *testing.T) {
+	build, info := newScriptsTestBuild(t)
+
+	buf := new(bytes.Buffer)
+	writer := &buildScriptsWriter{Output: buf}
+	require.NoError(t, writer.Write(build, info))
+
+	output := buf.String()
+	assert.Contains(t, output, "### "+string(common.BuildStagePrepare)+"\n")
+	assert.Contains(t, output, "### step_script\n")
+	assert.Contains(t, output, maskedValue)
+	assert.NotContains(t, output, "hidden-value")
+	assert.NotContains(t, output, "### "+string(common.BuildStageRestoreCache)+"\n")
+}
+
+func TestBuildScriptsWriterOutputDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build-scripts")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	build, info := newScriptsTestBuild(t)
+
+	writer := &buildScriptsWriter{OutputDir: filepath.Join(dir, "scripts")}
+	require.NoError(t, writer.Write(build, info))
+
+	files, err := filepath.Glob(filepath.Join(dir, "scripts", "*"))
+	require.NoError(t, err)
+
+	var names []string
+	for _, file := range files {
+		names = append(names, filepath.Base(file))
+	}
+	assert.Contains(t, names, "00-prepare_script.sh")
+	assert.Contains(t, names, "10-step_script.sh")
+
+	script, err := ioutil.ReadFile(filepath.Join(dir, "scripts", "10-step_script.sh"))
+	require.NoError(t, err)
+	assert.Contains(t, string(script), maskedValue)
+	assert.NotContains(t, string(script), "hidden-value")
+}
+
+func TestScriptExtension(t *testing.T) {
+	assert.Equal(t, "sh", scriptExtension("bash"))
+	assert.Equal(t, "ps1", scriptExtension("pwsh"))
+	assert.Equal(t, "ps1", scriptExtension("powershell"))
+	assert.Equal(t, "cmd", scriptExtension("cmd"))
+}
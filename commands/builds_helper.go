@@ -78,6 +78,11 @@ type buildsHelper struct {
 
 	jobsTotal            *prometheus.CounterVec
 	jobDurationHistogram *prometheus.HistogramVec
+
+	cacheHitsTotal         *prometheus.CounterVec
+	cacheMissesTotal       *prometheus.CounterVec
+	cacheTransferredBytes  *prometheus.CounterVec
+	cacheDurationHistogram *prometheus.HistogramVec
 }
 
 func (b *buildsHelper) getRunnerCounter(runner *common.RunnerConfig) *runnerCounter {
@@ -221,6 +226,33 @@ func (b *buildsHelper) removeBuild(deleteBuild *common.Build) bool {
 	return false
 }
 
+// observeCacheResult updates the cache metrics with a result reported by the
+// cache helpers of the build
+func (b *buildsHelper) observeCacheResult(build *common.Build, result common.CacheResult) {
+	runner := build.Runner.ShortDescription()
+
+	cacheType := "local"
+	if build.Runner.Cache != nil && build.Runner.Cache.Type != "" {
+		cacheType = build.Runner.Cache.Type
+	}
+
+	switch result.Operation {
+	case common.CacheOperationExtract:
+		if result.Hit {
+			b.cacheHitsTotal.WithLabelValues(runner, cacheType).Inc()
+		} else {
+			b.cacheMissesTotal.WithLabelValues(runner, cacheType).Inc()
+		}
+	case common.CacheOperationArchive:
+	default:
+		// only the known operations are used as label values
+		return
+	}
+
+	b.cacheTransferredBytes.WithLabelValues(runner, cacheType, result.Operation).Add(float64(result.Bytes))
+	b.cacheDurationHistogram.WithLabelValues(runner, cacheType, result.Operation).Observe(result.Duration.Seconds())
+}
+
 func (b *buildsHelper) buildsCount() int {
 	b.lock.Lock()
 	defer b.lock.Unlock()
@@ -260,6 +292,10 @@ func (b *buildsHelper) Describe(ch chan<- *prometheus.Desc) {
 
 	b.jobsTotal.Describe(ch)
 	b.jobDurationHistogram.Describe(ch)
+	b.cacheHitsTotal.Describe(ch)
+	b.cacheMissesTotal.Describe(ch)
+	b.cacheTransferredBytes.Describe(ch)
+	b.cacheDurationHistogram.Describe(ch)
 }
 
 // Collect implements prometheus.Collector.
@@ -296,6 +332,10 @@ func (b *buildsHelper) Collect(ch chan<- prometheus.Metric) {
 
 	b.jobsTotal.Collect(ch)
 	b.jobDurationHistogram.Collect(ch)
+	b.cacheHitsTotal.Collect(ch)
+	b.cacheMissesTotal.Collect(ch)
+	b.cacheTransferredBytes.Collect(ch)
+	b.cacheDurationHistogram.Collect(ch)
 }
 
 func (b *buildsHelper) ListJobsHandler(w http.ResponseWriter, r *http.Request) {
@@ -333,5 +373,34 @@ func newBuildsHelper() buildsHelper {
 			},
 			[]string{"runner"},
 		),
+		cacheHitsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gitlab_runner_cache_hits_total",
+				Help: "Total number of caches found and extracted",
+			},
+			[]string{"runner", "cache_type"},
+		),
+		cacheMissesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gitlab_runner_cache_misses_total",
+				Help: "Total number of caches not found",
+			},
+			[]string{"runner", "cache_type"},
+		),
+		cacheTransferredBytes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gitlab_runner_cache_transferred_bytes_total",
+				Help: "Total number of bytes downloaded and uploaded by the cache operations",
+			},
+			[]string{"runner", "cache_type", "operation"},
+		),
+		cacheDurationHistogram: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "gitlab_runner_cache_operation_duration_seconds",
+				Help:    "Histogram of the durations of the cache operations",
+				Buckets: []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 600, 1800},
+			},
+			[]string{"runner", "cache_type", "operation"},
+		),
 	}
 }
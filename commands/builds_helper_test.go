@@ -5,7 +5,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -126,6 +129,83 @@ func TestBuildsHelperFindSessionByURL(t *testing.T) {
 	assert.Nil(t, foundSession)
 }
 
+func metricValue(t *testing.T, metric prometheus.Metric) *dto.Metric {
+	m := new(dto.Metric)
+	require.NoError(t, metric.Write(m))
+
+	return m
+}
+
+func TestBuildsHelperObserveCacheResult(t *testing.T) {
+	tests := map[string]struct {
+		cache        *common.CacheConfig
+		expectedType string
+	}{
+		"without cache config": {
+			expectedType: "local",
+		},
+		"with cache config": {
+			cache:        &common.CacheConfig{Type: "s3"},
+			expectedType: "s3",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			build := &common.Build{
+				Runner: &common.RunnerConfig{
+					RunnerCredentials: common.RunnerCredentials{Token: "abcd1234"},
+					RunnerSettings:    common.RunnerSettings{Cache: tt.cache},
+				},
+			}
+			runner := build.Runner.ShortDescription()
+
+			h := newBuildsHelper()
+			h.observeCacheResult(build, common.CacheResult{
+				Operation: common.CacheOperationExtract,
+				Hit:       true,
+				Bytes:     100,
+				Duration:  time.Second,
+			})
+			h.observeCacheResult(build, common.CacheResult{
+				Operation: common.CacheOperationExtract,
+				Duration:  time.Second,
+			})
+			h.observeCacheResult(build, common.CacheResult{
+				Operation: common.CacheOperationArchive,
+				Bytes:     50,
+				Duration:  2 * time.Second,
+			})
+
+			hits := metricValue(t, h.cacheHitsTotal.WithLabelValues(runner, tt.expectedType))
+			assert.Equal(t, float64(1), hits.GetCounter().GetValue())
+
+			misses := metricValue(t, h.cacheMissesTotal.WithLabelValues(runner, tt.expectedType))
+			assert.Equal(t, float64(1), misses.GetCounter().GetValue())
+
+			downloaded := metricValue(
+				t,
+				h.cacheTransferredBytes.WithLabelValues(runner, tt.expectedType, common.CacheOperationExtract),
+			)
+			assert.Equal(t, float64(100), downloaded.GetCounter().GetValue())
+
+			uploaded := metricValue(
+				t,
+				h.cacheTransferredBytes.WithLabelValues(runner, tt.expectedType, common.CacheOperationArchive),
+			)
+			assert.Equal(t, float64(50), uploaded.GetCounter().GetValue())
+
+			archiveDuration := metricValue(
+				t,
+				h.cacheDurationHistogram.
+					WithLabelValues(runner, tt.expectedType, common.CacheOperationArchive).(prometheus.Metric),
+			)
+			assert.Equal(t, uint64(1), archiveDuration.GetHistogram().GetSampleCount())
+			assert.Equal(t, float64(2), archiveDuration.GetHistogram().GetSampleSum())
+		})
+	}
+}
+
 func TestBuildsHelper_ListJobsHandler(t *testing.T) {
 	tests := map[string]struct {
 		build          *common.Build
@@ -0,0 +1,165 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+//nolint:lll
+type CacheGCCommand struct {
+	configOptions
+
+	Name    string        `short:"n" long:"name" description:"Name of the runner whose caches are removed, all the runners with a cache configured by default"`
+	MaxAge  time.Duration `long:"max-age" description:"Remove the caches written longer ago than this duration, e.g. 168h"`
+	MaxSize string        `long:"max-size" description:"Remove the least recently used caches until the remaining ones fit in this size, e.g. 10GB"`
+	DryRun  bool          `long:"dry-run" description:"List the caches that would be removed without removing them"`
+}
+
+func (c *CacheGCCommand) policy() (cache.GCPolicy, error) {
+	policy := cache.GCPolicy{MaxAge: c.MaxAge}
+
+	if c.MaxSize != "" {
+		size, err := units.RAMInBytes(c.MaxSize)
+		if err != nil {
+			return policy, fmt.Errorf("parsing --max-size: %w", err)
+		}
+		policy.MaxSize = size
+	}
+
+	if policy.MaxAge <= 0 && policy.MaxSize <= 0 {
+		return policy, errors.New("--max-age or --max-size is required")
+	}
+
+	return policy, nil
+}
+
+func (c *CacheGCCommand) selectRunners() ([]*common.RunnerConfig, error) {
+	if c.Name != "" {
+		runner, err := c.RunnerByName(c.Name)
+		if err != nil {
+			return nil, err
+		}
+		if runner.Cache == nil || runner.Cache.Type == "" {
+			return nil, fmt.Errorf("runner %q has no cache configured", c.Name)
+		}
+
+		return []*common.RunnerConfig{runner}, nil
+	}
+
+	var runners []*common.RunnerConfig
+	for _, runner := range c.config.Runners {
+		if runner.Cache != nil && runner.Cache.Type != "" {
+			runners = append(runners, runner)
+		}
+	}
+
+	return runners, nil
+}
+
+func (c *CacheGCCommand) Execute(_ *cli.Context) {
+	policy, err := c.policy()
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+
+	err = c.loadConfig()
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+
+	runners, err := c.selectRunners()
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+
+	failed := false
+	for _, runner := range runners {
+		if !c.collectGarbage(runner, policy) {
+			failed = true
+		}
+
+		if !c.abortStaleUploads(runner) {
+			failed = true
+		}
+	}
+
+	if failed {
+		logrus.Fatalln("Failed to remove the caches of some runners")
+	}
+}
+
+func (c *CacheGCCommand) collectGarbage(runner *common.RunnerConfig, policy cache.GCPolicy) bool {
+	logger := logrus.WithFields(logrus.Fields{
+		"runner":     runner.ShortDescription(),
+		"cache_type": runner.Cache.Type,
+	})
+
+	message := "Removed cache"
+	if c.DryRun {
+		message = "Would remove cache"
+	}
+
+	removed, err := cache.CollectGarbage(context.Background(), runner, policy, c.DryRun)
+
+	var size int64
+	for _, object := range removed {
+		size += object.Size
+		logger.WithFields(logrus.Fields{
+			"name":    object.Name,
+			"size":    units.HumanSize(float64(object.Size)),
+			"updated": object.Updated,
+		}).Println(message)
+	}
+
+	logger.WithField("size", units.HumanSize(float64(size))).Println(message+"s:", len(removed))
+
+	if err != nil {
+		logger.WithError(err).Errorln("Failed to remove caches")
+		return false
+	}
+
+	return true
+}
+
+// abortStaleUploads aborts the multipart uploads of the caches left by
+// a runner stopped during a job
+func (c *CacheGCCommand) abortStaleUploads(runner *common.RunnerConfig) bool {
+	logger := logrus.WithFields(logrus.Fields{
+		"runner":     runner.ShortDescription(),
+		"cache_type": runner.Cache.Type,
+	})
+
+	message := "Aborted incomplete upload"
+	if c.DryRun {
+		message = "Would abort incomplete upload"
+	}
+
+	aborted, err := cache.AbortStaleUploads(context.Background(), runner, c.DryRun)
+	for _, upload := range aborted {
+		logger.WithFields(logrus.Fields{
+			"name":      upload.Name,
+			"size":      units.HumanSize(float64(upload.Size)),
+			"initiated": upload.Initiated,
+		}).Println(message)
+	}
+
+	if err != nil {
+		logger.WithError(err).Errorln("Failed to abort incomplete uploads")
+		return false
+	}
+
+	return true
+}
+
+func init() {
+	common.RegisterCommand2("cache-gc", "remove old caches of the runners", &CacheGCCommand{})
+}
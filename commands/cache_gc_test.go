@@ -0,0 +1,71 @@
+package commands
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+func TestCacheGCCommandPolicy(t *testing.T) {
+	tests := map[string]struct {
+		command        CacheGCCommand
+		expectedPolicy cache.GCPolicy
+		expectedErr    bool
+	}{
+		"no limits": {
+			expectedErr: true,
+		},
+		"max age": {
+			command:        CacheGCCommand{MaxAge: 24 * time.Hour},
+			expectedPolicy: cache.GCPolicy{MaxAge: 24 * time.Hour},
+		},
+		"max size": {
+			command:        CacheGCCommand{MaxSize: "10MB"},
+			expectedPolicy: cache.GCPolicy{MaxSize: 10 * 1024 * 1024},
+		},
+		"invalid max size": {
+			command:     CacheGCCommand{MaxSize: "ten"},
+			expectedErr: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			policy, err := tt.command.policy()
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedPolicy, policy)
+		})
+	}
+}
+
+func TestCacheGCCommandSelectRunners(t *testing.T) {
+	withCache := &common.RunnerConfig{
+		Name:           "with-cache",
+		RunnerSettings: common.RunnerSettings{Cache: &common.CacheConfig{Type: "s3"}},
+	}
+	withoutCache := &common.RunnerConfig{Name: "without-cache"}
+
+	command := CacheGCCommand{
+		configOptions: configOptions{
+			config: &common.Config{Runners: []*common.RunnerConfig{withCache, withoutCache}},
+		},
+	}
+
+	runners, err := command.selectRunners()
+	require.NoError(t, err)
+	assert.Equal(t, []*common.RunnerConfig{withCache}, runners)
+
+	command.Name = "without-cache"
+	_, err = command.selectRunners()
+	assert.Error(t, err)
+}
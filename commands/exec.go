@@ -1,9 +1,14 @@
 package commands
 
 import (
+	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
@@ -11,6 +16,10 @@ import (
 
 	"gitlab.com/gitlab-org/gitlab-runner/common"
 	"gitlab.com/gitlab-org/gitlab-runner/helpers/gitlab_ci_yaml_parser"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/trace"
+
+	// Force to load the local cache adapter, executes init() on it
+	_ "gitlab.com/gitlab-org/gitlab-runner/cache/filesystem"
 
 	// Force to load all executors, executes init() on them
 	_ "gitlab.com/gitlab-org/gitlab-runner/executors/custom"
@@ -21,10 +30,30 @@ import (
 	_ "gitlab.com/gitlab-org/gitlab-runner/executors/virtualbox"
 )
 
+// execDataDir is the directory, relative to the project directory, in which
+// `exec` keeps the data shared between jobs
+const execDataDir = ".gitlab-exec"
+
+// emptySha is used by GitLab as the before SHA of the first commit
+const emptySha = "0000000000000000000000000000000000000000"
+
 type ExecCommand struct {
 	common.RunnerSettings
-	Job     string
-	Timeout int `long:"timeout" description:"Job execution timeout (in seconds)"`
+	Timeout      int      `long:"timeout" description:"Job execution timeout (in seconds)"`
+	Concurrent   int      `long:"concurrent" description:"Maximum number of independent jobs run at the same time when executing 'all' jobs"`
+	ArtifactsDir string   `long:"artifacts-dir" description:"Directory in which the artifacts of the jobs are stored and passed to the dependent jobs (defaults to .gitlab-exec/artifacts in the project directory)"`
+	Manual       []string `long:"manual" description:"Name of a manual job to run when executing 'all' jobs (can be used multiple times)"`
+	ReportJSON   string   `long:"report-json" description:"Write a JSON summary of the pipeline to this file when executing 'all' jobs"`
+	ReportJUnit  string   `long:"report-junit" description:"Write a JUnit XML report of the pipeline to this file when executing 'all' jobs"`
+	DryRun       bool     `long:"dry-run" description:"Print the scripts generated for every stage of the jobs instead of executing them"`
+	ScriptsDir   string   `long:"scripts-dir" description:"Directory in which the scripts generated by --dry-run are written, one file per stage (defaults to stdout)"`
+	Variables    []string `long:"var" description:"Variable standing in for a project CI/CD variable, as KEY=VALUE or with options as masked,file:KEY=VALUE (can be used multiple times)"`
+	EnvFiles     []string `long:"env-file" description:"File with the variables standing in for project CI/CD variables, one KEY=VALUE entry per line (can be used multiple times)"`
+
+	artifacts     *localArtifactsStore
+	gitInfo       common.GitInfo
+	defaultBranch string
+	selector      *gitlab_ci_yaml_parser.JobSelector
 }
 
 // nolint:unparam
@@ -36,7 +65,8 @@ func (c *ExecCommand) runCommand(name string, arg ...string) (string, error) {
 	return string(result), err
 }
 
-func (c *ExecCommand) createBuild(repoURL string, abortSignal chan os.Signal) (*common.Build, error) {
+// getGitInfo describes the commit checked out in the project directory
+func (c *ExecCommand) getGitInfo(repoURL string) (common.GitInfo, error) {
 	// Check if we have uncommitted changes
 	_, err := c.runCommand("git", "diff", "--quiet", "HEAD")
 	if err != nil {
@@ -47,19 +77,89 @@ func (c *ExecCommand) createBuild(repoURL string, abortSignal chan os.Signal) (*
 	// Parse Git settings
 	sha, err := c.runCommand("git", "rev-parse", "HEAD")
 	if err != nil {
-		return nil, err
+		return common.GitInfo{}, err
 	}
 
 	beforeSha, err := c.runCommand("git", "rev-parse", "HEAD~1")
 	if err != nil {
-		beforeSha = "0000000000000000000000000000000000000000"
+		beforeSha = emptySha
 	}
 
 	refName, err := c.runCommand("git", "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return common.GitInfo{}, err
+	}
+
+	gitInfo := common.GitInfo{
+		RepoURL:   repoURL,
+		Ref:       strings.TrimSpace(refName),
+		RefType:   common.RefTypeBranch,
+		Sha:       strings.TrimSpace(sha),
+		BeforeSha: strings.TrimSpace(beforeSha),
+	}
+
+	// A detached HEAD pointing to a tag is treated as a tag pipeline
+	if gitInfo.Ref == "HEAD" {
+		tags, err := c.runCommand("git", "tag", "--points-at", "HEAD")
+		if fields := strings.Fields(tags); err == nil && len(fields) > 0 {
+			gitInfo.Ref = fields[0]
+			gitInfo.RefType = common.RefTypeTag
+		}
+	}
+
+	return gitInfo, nil
+}
+
+// getDefaultBranch returns the default branch of the origin remote, if known
+func (c *ExecCommand) getDefaultBranch() string {
+	ref, err := c.runCommand("git", "for-each-ref", "--format=%(symref:short)", "refs/remotes/origin/HEAD")
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimPrefix(strings.TrimSpace(ref), "origin/")
+}
+
+// getChangedFiles returns the files changed by the checked out commit, or nil
+// when they can't be determined
+func (c *ExecCommand) getChangedFiles(gitInfo common.GitInfo) []string {
+	if gitInfo.BeforeSha == emptySha {
+		return nil
+	}
+
+	files, err := c.runCommand("git", "diff", "--name-only", gitInfo.BeforeSha, gitInfo.Sha)
+	if err != nil {
+		return nil
+	}
+
+	changedFiles := []string{}
+	for _, file := range strings.Split(files, "\n") {
+		if file != "" {
+			changedFiles = append(changedFiles, file)
+		}
+	}
+
+	return changedFiles
+}
+
+func (c *ExecCommand) newJobSelector(wd string) (*gitlab_ci_yaml_parser.JobSelector, error) {
+	projectVariables, err := c.getProjectVariables()
 	if err != nil {
 		return nil, err
 	}
 
+	selector := gitlab_ci_yaml_parser.NewJobSelector(c.gitInfo, wd)
+	selector.ChangedFiles = c.getChangedFiles(c.gitInfo)
+	selector.SelectedJobs = c.Manual
+	selector.ProjectVariables = projectVariables
+	if c.defaultBranch != "" {
+		selector.Variables["CI_DEFAULT_BRANCH"] = c.defaultBranch
+	}
+
+	return selector, nil
+}
+
+func (c *ExecCommand) createBuild(abortSignal chan os.Signal) (*common.Build, error) {
 	jobResponse := common.JobResponse{
 		ID:            1,
 		Token:         "",
@@ -70,12 +170,7 @@ func (c *ExecCommand) createBuild(repoURL string, abortSignal chan os.Signal) (*
 			ProjectID:   1,
 			ProjectName: "",
 		},
-		GitInfo: common.GitInfo{
-			RepoURL:   repoURL,
-			Ref:       strings.TrimSpace(refName),
-			Sha:       strings.TrimSpace(sha),
-			BeforeSha: strings.TrimSpace(beforeSha),
-		},
+		GitInfo: c.gitInfo,
 		RunnerInfo: common.RunnerInfo{
 			Timeout: c.getTimeout(),
 		},
@@ -85,6 +180,15 @@ func (c *ExecCommand) createBuild(repoURL string, abortSignal chan os.Signal) (*
 		RunnerSettings: c.RunnerSettings,
 	}
 
+	// Add self-volume to docker
+	repoURL := c.gitInfo.RepoURL
+	docker := common.DockerConfig{}
+	if c.RunnerSettings.Docker != nil {
+		docker = *c.RunnerSettings.Docker
+	}
+	docker.Volumes = append(append([]string{}, docker.Volumes...), repoURL+":"+repoURL+":ro")
+	runner.Docker = &docker
+
 	return common.NewBuild(jobResponse, runner, abortSignal, nil)
 }
 
@@ -108,66 +212,282 @@ func (c *ExecCommand) Execute(context *cli.Context) {
 		return
 	}
 
-	if job == "all" {
-		parser := gitlab_ci_yaml_parser.NewGitLabCiYamlParser(c.Job)
-		var m, _ = parser.ParseFile()
-
-		// sorted by stage.
-		var jobs, ok = m.GetAllJobsSorted()
-		// panic(fmt.Sprintf("%v", jobs))
-		if ok {
-			for i := range jobs {
-				c.ExecuteOrig(context, jobs[i])
-			}
-		} else {
-			// TODO it goes here, why?
-			for i := range jobs {
-				c.ExecuteOrig(context, jobs[i])
-			}
-		}
-	} else {
-		c.ExecuteOrig(context, job)
-	}
-}
-
-func (c *ExecCommand) ExecuteOrig(context *cli.Context, job string) {
 	wd, err := os.Getwd()
 	if err != nil {
 		logrus.Fatalln(err)
 	}
 
-	c.Job = job
-
 	c.Executor = context.Command.Name
+	c.artifacts = newLocalArtifactsStore(c.getArtifactsDir(wd))
+	c.configureCache(wd)
+
+	c.gitInfo, err = c.getGitInfo(wd)
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+	c.defaultBranch = c.getDefaultBranch()
+
+	c.selector, err = c.newJobSelector(wd)
+	if err != nil {
+		logrus.Fatalln(err)
+	}
 
 	abortSignal := make(chan os.Signal)
 	doneSignal := make(chan int, 1)
 
 	go waitForInterrupts(nil, abortSignal, doneSignal, nil)
 
-	// Add self-volume to docker
-	if c.RunnerSettings.Docker == nil {
-		c.RunnerSettings.Docker = &common.DockerConfig{}
+	if job == "all" {
+		c.executePipeline(wd, abortSignal)
+		return
+	}
+
+	if c.DryRun {
+		err = c.writeJobScripts(wd, c.getStandaloneJob(job), c.ScriptsDir, abortSignal)
+		if err != nil {
+			logrus.Fatalln(err)
+		}
+		return
 	}
-	c.RunnerSettings.Docker.Volumes = append(c.RunnerSettings.Docker.Volumes, wd+":"+wd+":ro")
 
-	// Create build
-	build, err := c.createBuild(wd, abortSignal)
+	err = c.runJob(wd, c.getStandaloneJob(job), 0, os.Stdout, abortSignal)
 	if err != nil {
 		logrus.Fatalln(err)
 	}
+}
 
-	parser := gitlab_ci_yaml_parser.NewGitLabCiYamlParser(c.Job)
-	err = parser.ParseYaml(&build.JobResponse)
+func (c *ExecCommand) getArtifactsDir(wd string) string {
+	if c.ArtifactsDir == "" {
+		return filepath.Join(wd, execDataDir, "artifacts")
+	}
+
+	if filepath.IsAbs(c.ArtifactsDir) {
+		return c.ArtifactsDir
+	}
+
+	return filepath.Join(wd, c.ArtifactsDir)
+}
+
+// configureCache makes the jobs use a cache stored in the project directory,
+// unless a different cache is configured, so it's kept between executions
+func (c *ExecCommand) configureCache(wd string) {
+	if c.Cache == nil {
+		c.Cache = &common.CacheConfig{}
+	}
+
+	if c.Cache.Type == "" {
+		c.Cache.Type = "filesystem"
+		c.Cache.Shared = true
+	}
+
+	if c.Cache.Type != "filesystem" {
+		return
+	}
+
+	if c.Cache.Filesystem == nil {
+		c.Cache.Filesystem = &common.CacheFilesystemConfig{}
+	}
+
+	switch {
+	case c.Cache.Filesystem.Path == "":
+		c.Cache.Filesystem.Path = filepath.Join(wd, execDataDir, "cache")
+	case !filepath.IsAbs(c.Cache.Filesystem.Path):
+		c.Cache.Filesystem.Path = filepath.Join(wd, c.Cache.Filesystem.Path)
+	}
+}
+
+// getStandaloneJob returns the pipeline definition of the job executed on
+// its own, so it can use the artifacts stored by previous executions of the
+// jobs it depends on
+func (c *ExecCommand) getStandaloneJob(name string) gitlab_ci_yaml_parser.PipelineJob {
+	job := gitlab_ci_yaml_parser.PipelineJob{Name: name}
+
+	config, err := gitlab_ci_yaml_parser.NewGitLabCiYamlParser(name).ParseFile()
+	if err != nil {
+		return job
+	}
+
+	jobs, err := config.GetPipelineJobs(nil)
+	if err != nil {
+		logrus.WithError(err).Debugln("Can't resolve the job dependencies")
+		return job
+	}
+
+	for _, pipelineJob := range jobs {
+		if pipelineJob.Name == name {
+			return pipelineJob
+		}
+	}
+
+	return job
+}
+
+func (c *ExecCommand) executePipeline(wd string, abortSignal chan os.Signal) {
+	config, err := gitlab_ci_yaml_parser.NewGitLabCiYamlParser("").ParseFile()
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+
+	jobs, err := config.GetPipelineJobs(c.selector)
 	if err != nil {
 		logrus.Fatalln(err)
 	}
 
-	err = build.Run(&common.Config{}, &common.Trace{Writer: os.Stdout})
+	if c.DryRun {
+		c.writePipelineScripts(wd, jobs, abortSignal)
+		return
+	}
 
+	err = c.artifacts.Reset()
 	if err != nil {
 		logrus.Fatalln(err)
 	}
+
+	var outputLock sync.Mutex
+	runner := newPipelineRunner(jobs, c.Concurrent, func(job gitlab_ci_yaml_parser.PipelineJob, slot int) error {
+		var output io.Writer = os.Stdout
+		if c.Concurrent > 1 {
+			output = newPrefixWriter(os.Stdout, &outputLock, "["+job.Name+"] ")
+		}
+
+		return c.runJob(wd, job, slot, output, abortSignal)
+	})
+
+	startedAt := time.Now()
+	results := runner.Run()
+	printPipelineSummary(os.Stdout, results)
+
+	err = c.writeReports(newPipelineReport(results, time.Since(startedAt)))
+	if err != nil {
+		logrus.Errorln(err)
+	}
+
+	if pipelineFailed(results) {
+		logrus.Fatalln("Pipeline failed")
+	}
+}
+
+func (c *ExecCommand) writeReports(report pipelineReport) error {
+	if c.ReportJSON != "" {
+		err := writePipelineReportFile(c.ReportJSON, report, writePipelineJSONReport)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.ReportJUnit != "" {
+		return writePipelineReportFile(c.ReportJUnit, report, writePipelineJUnitReport)
+	}
+
+	return nil
+}
+
+// writePipelineScripts writes the scripts of all the jobs of the pipeline,
+// in a separate directory for every job when a scripts directory is set
+func (c *ExecCommand) writePipelineScripts(
+	wd string,
+	jobs []gitlab_ci_yaml_parser.PipelineJob,
+	abortSignal chan os.Signal,
+) {
+	for _, job := range jobs {
+		scriptsDir := ""
+		if c.ScriptsDir != "" {
+			scriptsDir = filepath.Join(c.ScriptsDir, common.LocalJobDirName(job.Name))
+		} else {
+			_, _ = fmt.Fprintf(os.Stdout, "## %s\n", job.Name)
+		}
+
+		err := c.writeJobScripts(wd, job, scriptsDir, abortSignal)
+		if err != nil {
+			logrus.Fatalln(err)
+		}
+	}
+}
+
+func (c *ExecCommand) writeJobScripts(
+	wd string,
+	job gitlab_ci_yaml_parser.PipelineJob,
+	scriptsDir string,
+	abortSignal chan os.Signal,
+) error {
+	build, err := c.prepareBuild(job, 0, abortSignal)
+	if err != nil {
+		return err
+	}
+
+	err = prepareBuildDirs(build, wd)
+	if err != nil {
+		return err
+	}
+
+	info, err := getShellScriptInfo(build)
+	if err != nil {
+		return err
+	}
+
+	writer := &buildScriptsWriter{OutputDir: scriptsDir, Output: os.Stdout}
+	return writer.Write(build, info)
+}
+
+func (c *ExecCommand) runJob(
+	wd string,
+	job gitlab_ci_yaml_parser.PipelineJob,
+	slot int,
+	output io.Writer,
+	abortSignal chan os.Signal,
+) error {
+	build, err := c.prepareBuild(job, slot, abortSignal)
+	if err != nil {
+		return err
+	}
+
+	err = c.artifacts.Prepare(build)
+	if err != nil {
+		return err
+	}
+
+	// The artifacts are written by the helper running next to the job
+	build.Runner.Docker.Volumes = append(build.Runner.Docker.Volumes, build.LocalArtifactsDir+":"+build.LocalArtifactsDir)
+
+	// The same applies to the archives of the local cache
+	if c.Cache.Type == "filesystem" {
+		cachePath := c.Cache.Filesystem.Path
+		build.Runner.Docker.Volumes = append(build.Runner.Docker.Volumes, cachePath+":"+cachePath)
+	}
+
+	// The masked variables are set by the build once it's started
+	maskedOutput := trace.NewMaskWriter(output)
+	defer func() { _ = maskedOutput.Close() }()
+
+	return build.Run(&common.Config{}, &common.Trace{Writer: maskedOutput})
+}
+
+// prepareBuild creates the build of the job, with the dependencies whose
+// artifacts are stored locally
+func (c *ExecCommand) prepareBuild(
+	job gitlab_ci_yaml_parser.PipelineJob,
+	slot int,
+	abortSignal chan os.Signal,
+) (*common.Build, error) {
+	build, err := c.createBuild(abortSignal)
+	if err != nil {
+		return nil, err
+	}
+
+	// Jobs running at the same time must not share their build directories
+	build.ProjectRunnerID = slot
+
+	parser := gitlab_ci_yaml_parser.NewGitLabCiYamlParser(job.Name)
+	parser.SetJobSelector(c.selector)
+	err = parser.ParseYaml(&build.JobResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	build.LocalArtifactsDir = c.artifacts.dir
+	build.Dependencies = c.artifacts.Dependencies(job.Dependencies)
+
+	return build, nil
 }
 
 func init() {
@@ -0,0 +1,63 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+// localArtifactsStore keeps the artifacts of the jobs executed by `exec` on
+// the local filesystem, so they can be passed to the jobs that depend on them
+// without a GitLab instance. The archives are written and read by the
+// artifacts-uploader and artifacts-downloader helpers, see
+// common.Build.LocalArtifactsDir.
+type localArtifactsStore struct {
+	dir string
+}
+
+func newLocalArtifactsStore(dir string) *localArtifactsStore {
+	return &localArtifactsStore{dir: dir}
+}
+
+// Reset removes the artifacts stored by a previous execution
+func (s *localArtifactsStore) Reset() error {
+	return os.RemoveAll(s.dir)
+}
+
+// Prepare makes sure that the store exists and configures the build to use it
+func (s *localArtifactsStore) Prepare(build *common.Build) error {
+	err := os.MkdirAll(s.dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	build.LocalArtifactsDir = s.dir
+
+	return nil
+}
+
+// Dependencies returns the dependencies of a job for the listed jobs which
+// have stored their artifacts. Jobs that didn't produce any artifacts are
+// skipped, the same way GitLab skips them.
+func (s *localArtifactsStore) Dependencies(jobs []string) common.Dependencies {
+	var dependencies common.Dependencies
+
+	for i, job := range jobs {
+		fi, err := os.Stat(common.LocalArtifactsPath(s.dir, job))
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+
+		dependencies = append(dependencies, common.Dependency{
+			ID:   i + 1,
+			Name: job,
+			ArtifactsFile: common.DependencyArtifactsFile{
+				Filename: filepath.Base(common.LocalArtifactsPath(s.dir, job)),
+				Size:     fi.Size(),
+			},
+		})
+	}
+
+	return dependencies
+}
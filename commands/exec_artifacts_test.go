@@ -0,0 +1,44 @@
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+func TestLocalArtifactsStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exec-artifacts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store := newLocalArtifactsStore(filepath.Join(dir, "artifacts"))
+
+	build := &common.Build{}
+	require.NoError(t, store.Prepare(build))
+	assert.Equal(t, filepath.Join(dir, "artifacts"), build.LocalArtifactsDir)
+
+	archive := build.LocalArtifactsPath("build: [linux]")
+	require.NoError(t, os.MkdirAll(filepath.Dir(archive), 0755))
+	require.NoError(t, ioutil.WriteFile(archive, []byte("content"), 0644))
+
+	dependencies := store.Dependencies([]string{"build: [linux]", "no artifacts"})
+	assert.Equal(t, common.Dependencies{
+		{
+			ID:   1,
+			Name: "build: [linux]",
+			ArtifactsFile: common.DependencyArtifactsFile{
+				Filename: "artifacts.zip",
+				Size:     7,
+			},
+		},
+	}, dependencies)
+
+	require.NoError(t, store.Reset())
+	assert.Empty(t, store.Dependencies([]string{"build: [linux]"}))
+}
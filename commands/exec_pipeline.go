@@ -0,0 +1,346 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/gitlab_ci_yaml_parser"
+)
+
+type pipelineJobStatus string
+
+const (
+	pipelineJobStatusSuccess       pipelineJobStatus = "success"
+	pipelineJobStatusFailed        pipelineJobStatus = "failed"
+	pipelineJobStatusAllowedFailed pipelineJobStatus = "failed (allowed)"
+	pipelineJobStatusSkipped       pipelineJobStatus = "skipped"
+	pipelineJobStatusManual        pipelineJobStatus = "manual"
+)
+
+type pipelineJobResult struct {
+	Name     string
+	Stage    string
+	Status   pipelineJobStatus
+	Duration time.Duration
+	Err      error
+
+	ExitCode      int
+	FailureReason common.JobFailureReason
+
+	// upstreamFailed is set when the job was skipped because a job it needs
+	// has failed, so that the failure propagates to the whole subgraph
+	upstreamFailed bool
+	// upstreamBlocked is set when the job was skipped because a job it needs
+	// is a blocking manual job, which isn't run locally unless selected
+	upstreamBlocked bool
+}
+
+// pipelineJobRunFunc runs a single job. The slot is unique among the jobs that
+// run at the same time, so it can be used to separate their build directories.
+type pipelineJobRunFunc func(job gitlab_ci_yaml_parser.PipelineJob, slot int) error
+
+// pipelineRunner executes the jobs of a local pipeline following the job
+// graph, running up to concurrency independent jobs at the same time
+type pipelineRunner struct {
+	jobs        []gitlab_ci_yaml_parser.PipelineJob
+	jobIndex    map[string]int
+	concurrency int
+	runJob      pipelineJobRunFunc
+}
+
+type pipelineJobFinished struct {
+	index  int
+	slot   int
+	result pipelineJobResult
+}
+
+func newPipelineRunner(
+	jobs []gitlab_ci_yaml_parser.PipelineJob,
+	concurrency int,
+	runJob pipelineJobRunFunc,
+) *pipelineRunner {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	jobIndex := make(map[string]int, len(jobs))
+	for index, job := range jobs {
+		jobIndex[job.Name] = index
+	}
+
+	return &pipelineRunner{
+		jobs:        jobs,
+		jobIndex:    jobIndex,
+		concurrency: concurrency,
+		runJob:      runJob,
+	}
+}
+
+// Run executes the whole pipeline and returns the results in the order in
+// which the jobs are defined in the pipeline
+func (p *pipelineRunner) Run() []pipelineJobResult {
+	results := make([]*pipelineJobResult, len(p.jobs))
+	started := make([]bool, len(p.jobs))
+
+	freeSlots := make([]int, 0, p.concurrency)
+	for slot := p.concurrency - 1; slot >= 0; slot-- {
+		freeSlots = append(freeSlots, slot)
+	}
+
+	finished := make(chan pipelineJobFinished)
+	running := 0
+
+	for {
+		p.skipUnreachableJobs(results, started)
+
+		for index := range p.jobs {
+			if len(freeSlots) == 0 {
+				break
+			}
+
+			if started[index] || !p.isReady(index, results) {
+				continue
+			}
+
+			slot := freeSlots[len(freeSlots)-1]
+			freeSlots = freeSlots[:len(freeSlots)-1]
+			started[index] = true
+			running++
+
+			go func(index, slot int) {
+				finished <- pipelineJobFinished{
+					index:  index,
+					slot:   slot,
+					result: p.execute(p.jobs[index], slot),
+				}
+			}(index, slot)
+		}
+
+		if running == 0 {
+			break
+		}
+
+		done := <-finished
+		running--
+		freeSlots = append(freeSlots, done.slot)
+		results[done.index] = &done.result
+	}
+
+	list := make([]pipelineJobResult, 0, len(results))
+	for index, result := range results {
+		if result == nil {
+			// This can happen only if the graph is broken, which is validated
+			// by the parser, but make sure that all jobs are reported
+			result = p.skippedResult(p.jobs[index])
+		}
+		list = append(list, *result)
+	}
+
+	return list
+}
+
+func (p *pipelineRunner) execute(job gitlab_ci_yaml_parser.PipelineJob, slot int) pipelineJobResult {
+	result := pipelineJobResult{
+		Name:   job.Name,
+		Stage:  job.Stage,
+		Status: pipelineJobStatusSuccess,
+	}
+
+	startedAt := time.Now()
+	result.Err = p.runJob(job, slot)
+	result.Duration = time.Since(startedAt)
+
+	if result.Err != nil {
+		result.Status = pipelineJobStatusFailed
+		result.ExitCode, result.FailureReason = getFailureData(result.Err)
+
+		if job.IsFailureAllowed(result.ExitCode) {
+			result.Status = pipelineJobStatusAllowedFailed
+		}
+	}
+
+	return result
+}
+
+// getFailureData returns the exit code and the failure reason of the job
+// error, the same way they're reported to GitLab
+func getFailureData(err error) (int, common.JobFailureReason) {
+	var buildErr *common.BuildError
+	if !errors.As(err, &buildErr) {
+		return 0, common.RunnerSystemFailure
+	}
+
+	if buildErr.FailureReason == "" {
+		return buildErr.ExitCode, common.ScriptFailure
+	}
+
+	return buildErr.ExitCode, buildErr.FailureReason
+}
+
+// isReady reports whether all the jobs needed by the job have finished. Jobs
+// that shouldn't run at all are marked earlier by skipUnreachableJobs.
+func (p *pipelineRunner) isReady(index int, results []*pipelineJobResult) bool {
+	needsFinished, _, _ := p.needsState(index, results)
+	return needsFinished
+}
+
+// skipUnreachableJobs marks as skipped all jobs whose needs have finished,
+// but which shouldn't run because of their `when:` setting or because they
+// wait for a manual job
+func (p *pipelineRunner) skipUnreachableJobs(results []*pipelineJobResult, started []bool) {
+	for changed := true; changed; {
+		changed = false
+
+		for index, job := range p.jobs {
+			if started[index] {
+				continue
+			}
+
+			needsFinished, needsFailed, needsBlocked := p.needsState(index, results)
+			if !needsFinished {
+				continue
+			}
+
+			skip := needsBlocked
+			switch job.When {
+			case gitlab_ci_yaml_parser.JobWhenAlways:
+			case gitlab_ci_yaml_parser.JobWhenOnFailure:
+				skip = skip || !needsFailed
+			default:
+				skip = skip || needsFailed
+			}
+
+			switch {
+			case skip:
+				results[index] = p.skippedResult(job)
+				results[index].upstreamFailed = needsFailed
+				results[index].upstreamBlocked = needsBlocked
+			case job.When == gitlab_ci_yaml_parser.JobWhenManual:
+				results[index] = p.skippedResult(job)
+				results[index].Status = pipelineJobStatusManual
+			default:
+				continue
+			}
+
+			started[index] = true
+			changed = true
+		}
+	}
+}
+
+// needsState returns whether all the needed jobs have finished, whether any
+// of them failed without being allowed to and whether any of them is
+// a blocking manual job
+func (p *pipelineRunner) needsState(
+	index int,
+	results []*pipelineJobResult,
+) (finished bool, failed bool, blocked bool) {
+	for _, need := range p.jobs[index].Needs {
+		needIndex := p.jobIndex[need]
+		result := results[needIndex]
+		if result == nil {
+			return false, false, false
+		}
+
+		if result.Status == pipelineJobStatusFailed || result.upstreamFailed {
+			failed = true
+		}
+
+		if result.Status == pipelineJobStatusManual && !p.jobs[needIndex].AllowFailure || result.upstreamBlocked {
+			blocked = true
+		}
+	}
+
+	return true, failed, blocked
+}
+
+func (p *pipelineRunner) skippedResult(job gitlab_ci_yaml_parser.PipelineJob) *pipelineJobResult {
+	return &pipelineJobResult{
+		Name:   job.Name,
+		Stage:  job.Stage,
+		Status: pipelineJobStatusSkipped,
+	}
+}
+
+func pipelineFailed(results []pipelineJobResult) bool {
+	for _, result := range results {
+		if result.Status == pipelineJobStatusFailed {
+			return true
+		}
+	}
+
+	return false
+}
+
+func printPipelineSummary(w io.Writer, results []pipelineJobResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "JOB\tSTAGE\tSTATUS\tDURATION\tERROR")
+
+	for _, result := range results {
+		errText := ""
+		if result.Err != nil {
+			errText = result.Err.Error()
+		}
+
+		_, _ = fmt.Fprintf(
+			tw,
+			"%s\t%s\t%s\t%s\t%s\n",
+			result.Name,
+			result.Stage,
+			result.Status,
+			result.Duration.Round(time.Millisecond),
+			errText,
+		)
+	}
+
+	_ = tw.Flush()
+}
+
+// prefixWriter prefixes every line written to it, so the output of jobs
+// running in parallel can be told apart
+type prefixWriter struct {
+	lock        *sync.Mutex
+	w           io.Writer
+	prefix      []byte
+	atLineStart bool
+}
+
+func newPrefixWriter(w io.Writer, lock *sync.Mutex, prefix string) *prefixWriter {
+	return &prefixWriter{
+		lock:        lock,
+		w:           w,
+		prefix:      []byte(prefix),
+		atLineStart: true,
+	}
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(p, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+
+		if w.atLineStart {
+			buf.Write(w.prefix)
+		}
+		buf.Write(line)
+		w.atLineStart = line[len(line)-1] == '\n'
+	}
+
+	_, err := w.w.Write(buf.Bytes())
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,222 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/gitlab_ci_yaml_parser"
+)
+
+func resultStatuses(results []pipelineJobResult) map[string]pipelineJobStatus {
+	statuses := make(map[string]pipelineJobStatus, len(results))
+	for _, result := range results {
+		statuses[result.Name] = result.Status
+	}
+
+	return statuses
+}
+
+func TestPipelineRunnerFollowsNeeds(t *testing.T) {
+	jobs := []gitlab_ci_yaml_parser.PipelineJob{
+		{Name: "build", Stage: "build"},
+		{Name: "test", Stage: "test", Needs: []string{"build"}},
+		{Name: "lint", Stage: "test", Needs: []string{}},
+		{Name: "deploy", Stage: "deploy", Needs: []string{"test", "lint"}},
+	}
+
+	var lock sync.Mutex
+	var order []string
+
+	runner := newPipelineRunner(jobs, 1, func(job gitlab_ci_yaml_parser.PipelineJob, slot int) error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		assert.Equal(t, 0, slot)
+		order = append(order, job.Name)
+		return nil
+	})
+
+	results := runner.Run()
+	require.Len(t, results, 4)
+	assert.False(t, pipelineFailed(results))
+	assert.Equal(t, []string{"build", "test", "lint", "deploy"}, order)
+	for _, result := range results {
+		assert.Equal(t, pipelineJobStatusSuccess, result.Status)
+	}
+}
+
+func TestPipelineRunnerRunsIndependentJobsInParallel(t *testing.T) {
+	jobs := []gitlab_ci_yaml_parser.PipelineJob{
+		{Name: "a", Needs: []string{}},
+		{Name: "b", Needs: []string{}},
+		{Name: "c", Needs: []string{}},
+		{Name: "d", Needs: []string{"a", "b", "c"}},
+	}
+
+	var running, maxRunning int32
+	slots := make(chan int, len(jobs))
+
+	runner := newPipelineRunner(jobs, 2, func(job gitlab_ci_yaml_parser.PipelineJob, slot int) error {
+		current := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if current <= max || atomic.CompareAndSwapInt32(&maxRunning, max, current) {
+				break
+			}
+		}
+
+		slots <- slot
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+
+	results := runner.Run()
+	close(slots)
+
+	assert.False(t, pipelineFailed(results))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&maxRunning))
+	for slot := range slots {
+		assert.Contains(t, []int{0, 1}, slot)
+	}
+}
+
+func TestPipelineRunnerFailures(t *testing.T) {
+	tests := map[string]struct {
+		jobs             []gitlab_ci_yaml_parser.PipelineJob
+		failing          map[string]error
+		expectedStatuses map[string]pipelineJobStatus
+		expectedFailed   bool
+	}{
+		"dependents are skipped": {
+			jobs: []gitlab_ci_yaml_parser.PipelineJob{
+				{Name: "build"},
+				{Name: "test", Needs: []string{"build"}},
+				{Name: "deploy", Needs: []string{"test"}},
+				{Name: "lint", Needs: []string{}},
+			},
+			failing: map[string]error{"build": errors.New("failed")},
+			expectedStatuses: map[string]pipelineJobStatus{
+				"build":  pipelineJobStatusFailed,
+				"test":   pipelineJobStatusSkipped,
+				"deploy": pipelineJobStatusSkipped,
+				"lint":   pipelineJobStatusSuccess,
+			},
+			expectedFailed: true,
+		},
+		"allowed failure": {
+			jobs: []gitlab_ci_yaml_parser.PipelineJob{
+				{Name: "build", AllowFailure: true},
+				{Name: "test", Needs: []string{"build"}},
+			},
+			failing: map[string]error{"build": errors.New("failed")},
+			expectedStatuses: map[string]pipelineJobStatus{
+				"build": pipelineJobStatusAllowedFailed,
+				"test":  pipelineJobStatusSuccess,
+			},
+		},
+		"manual jobs": {
+			jobs: []gitlab_ci_yaml_parser.PipelineJob{
+				{Name: "build"},
+				{Name: "optional", When: gitlab_ci_yaml_parser.JobWhenManual, AllowFailure: true},
+				{Name: "approve", When: gitlab_ci_yaml_parser.JobWhenManual},
+				{Name: "test", Needs: []string{"build", "optional"}},
+				{Name: "deploy", Needs: []string{"test", "approve"}},
+				{Name: "cleanup", When: gitlab_ci_yaml_parser.JobWhenAlways, Needs: []string{"deploy"}},
+			},
+			expectedStatuses: map[string]pipelineJobStatus{
+				"build":    pipelineJobStatusSuccess,
+				"optional": pipelineJobStatusManual,
+				"approve":  pipelineJobStatusManual,
+				"test":     pipelineJobStatusSuccess,
+				"deploy":   pipelineJobStatusSkipped,
+				"cleanup":  pipelineJobStatusSkipped,
+			},
+		},
+		"allowed failure with matching exit code": {
+			jobs: []gitlab_ci_yaml_parser.PipelineJob{
+				{Name: "build", AllowFailure: true, AllowFailureExitCodes: []int{137}},
+				{Name: "test", Needs: []string{"build"}},
+			},
+			failing: map[string]error{"build": &common.BuildError{ExitCode: 137}},
+			expectedStatuses: map[string]pipelineJobStatus{
+				"build": pipelineJobStatusAllowedFailed,
+				"test":  pipelineJobStatusSuccess,
+			},
+		},
+		"allowed failure with other exit code": {
+			jobs: []gitlab_ci_yaml_parser.PipelineJob{
+				{Name: "build", AllowFailure: true, AllowFailureExitCodes: []int{137}},
+				{Name: "test", Needs: []string{"build"}},
+			},
+			failing: map[string]error{"build": &common.BuildError{ExitCode: 1}},
+			expectedStatuses: map[string]pipelineJobStatus{
+				"build": pipelineJobStatusFailed,
+				"test":  pipelineJobStatusSkipped,
+			},
+			expectedFailed: true,
+		},
+		"when always and on_failure": {
+			jobs: []gitlab_ci_yaml_parser.PipelineJob{
+				{Name: "build"},
+				{Name: "test", Needs: []string{"build"}},
+				{Name: "cleanup", Needs: []string{"test"}, When: gitlab_ci_yaml_parser.JobWhenAlways},
+				{Name: "notify", Needs: []string{"test"}, When: gitlab_ci_yaml_parser.JobWhenOnFailure},
+				{Name: "report", Needs: []string{"build"}, When: gitlab_ci_yaml_parser.JobWhenOnFailure},
+			},
+			failing: map[string]error{"test": errors.New("failed")},
+			expectedStatuses: map[string]pipelineJobStatus{
+				"build":   pipelineJobStatusSuccess,
+				"test":    pipelineJobStatusFailed,
+				"cleanup": pipelineJobStatusSuccess,
+				"notify":  pipelineJobStatusSuccess,
+				"report":  pipelineJobStatusSkipped,
+			},
+			expectedFailed: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			runner := newPipelineRunner(tt.jobs, 2, func(job gitlab_ci_yaml_parser.PipelineJob, slot int) error {
+				return tt.failing[job.Name]
+			})
+
+			results := runner.Run()
+			assert.Equal(t, tt.expectedStatuses, resultStatuses(results))
+			assert.Equal(t, tt.expectedFailed, pipelineFailed(results))
+		})
+	}
+}
+
+func TestPrintPipelineSummary(t *testing.T) {
+	buf := new(bytes.Buffer)
+	printPipelineSummary(buf, []pipelineJobResult{
+		{Name: "build", Stage: "build", Status: pipelineJobStatusSuccess, Duration: 1500 * time.Millisecond},
+		{Name: "test", Stage: "test", Status: pipelineJobStatusFailed, Err: errors.New("exit code 1")},
+	})
+
+	assert.Equal(t, ""+
+		"JOB    STAGE  STATUS   DURATION  ERROR\n"+
+		"build  build  success  1.5s      \n"+
+		"test   test   failed   0s        exit code 1\n", buf.String())
+}
+
+func TestPrefixWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := newPrefixWriter(buf, new(sync.Mutex), "[job] ")
+
+	_, _ = w.Write([]byte("first line\nsecond "))
+	_, _ = w.Write([]byte("line\n"))
+
+	assert.Equal(t, "[job] first line\n[job] second line\n", buf.String())
+}
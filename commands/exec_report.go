@@ -0,0 +1,176 @@
+package commands
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+// pipelineReport is the JSON summary of a pipeline executed with `exec all`
+type pipelineReport struct {
+	Status   string              `json:"status"`
+	Duration float64             `json:"duration"`
+	Jobs     []pipelineReportJob `json:"jobs"`
+}
+
+type pipelineReportJob struct {
+	Name          string                  `json:"name"`
+	Stage         string                  `json:"stage"`
+	Status        string                  `json:"status"`
+	AllowFailure  bool                    `json:"allow_failure"`
+	Duration      float64                 `json:"duration"`
+	ExitCode      int                     `json:"exit_code"`
+	FailureReason common.JobFailureReason `json:"failure_reason,omitempty"`
+	Error         string                  `json:"error,omitempty"`
+}
+
+func newPipelineReport(results []pipelineJobResult, duration time.Duration) pipelineReport {
+	report := pipelineReport{
+		Status:   string(pipelineJobStatusSuccess),
+		Duration: duration.Seconds(),
+		Jobs:     make([]pipelineReportJob, 0, len(results)),
+	}
+
+	if pipelineFailed(results) {
+		report.Status = string(pipelineJobStatusFailed)
+	}
+
+	for _, result := range results {
+		job := pipelineReportJob{
+			Name:          result.Name,
+			Stage:         result.Stage,
+			Status:        string(result.Status),
+			Duration:      result.Duration.Seconds(),
+			ExitCode:      result.ExitCode,
+			FailureReason: result.FailureReason,
+		}
+
+		if result.Status == pipelineJobStatusAllowedFailed {
+			job.Status = string(pipelineJobStatusFailed)
+			job.AllowFailure = true
+		}
+
+		if result.Err != nil {
+			job.Error = result.Err.Error()
+		}
+
+		report.Jobs = append(report.Jobs, job)
+	}
+
+	return report
+}
+
+func writePipelineJSONReport(w io.Writer, report pipelineReport) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(report)
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// writePipelineJUnitReport writes the report as a single test suite with
+// a test case per job, using the stage as the class name. Jobs that are
+// allowed to fail are reported as failures too, the JSON report can be used
+// to tell them apart.
+func writePipelineJUnitReport(w io.Writer, report pipelineReport) error {
+	suite := junitTestSuite{
+		Name:  "pipeline",
+		Tests: len(report.Jobs),
+		Time:  formatReportSeconds(report.Duration),
+	}
+
+	for _, job := range report.Jobs {
+		testCase := junitTestCase{
+			Name:      job.Name,
+			ClassName: job.Stage,
+			Time:      formatReportSeconds(job.Duration),
+		}
+
+		switch pipelineJobStatus(job.Status) {
+		case pipelineJobStatusFailed:
+			suite.Failures++
+			testCase.Failure = &junitMessage{
+				Message: fmt.Sprintf("job failed with exit code %d", job.ExitCode),
+				Type:    string(job.FailureReason),
+				Text:    job.Error,
+			}
+			if job.AllowFailure {
+				testCase.Failure.Message += " (allowed to fail)"
+			}
+		case pipelineJobStatusSkipped, pipelineJobStatusManual:
+			suite.Skipped++
+			testCase.Skipped = &junitMessage{Message: job.Status}
+		}
+
+		suite.Cases = append(suite.Cases, testCase)
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+
+	err = encoder.Encode(junitTestSuites{Suites: []junitTestSuite{suite}})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+func formatReportSeconds(seconds float64) string {
+	return fmt.Sprintf("%.3f", seconds)
+}
+
+func writePipelineReportFile(path string, report pipelineReport, write func(io.Writer, pipelineReport) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating report file: %w", err)
+	}
+
+	err = write(file, report)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("writing report file %s: %w", path, err)
+	}
+
+	return nil
+}
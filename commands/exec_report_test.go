@@ -0,0 +1,127 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/gitlab_ci_yaml_parser"
+)
+
+func testPipelineResults() []pipelineJobResult {
+	return []pipelineJobResult{
+		{Name: "build", Stage: "build", Status: pipelineJobStatusSuccess, Duration: 1500 * time.Millisecond},
+		{
+			Name:          "lint",
+			Stage:         "test",
+			Status:        pipelineJobStatusAllowedFailed,
+			Duration:      time.Second,
+			Err:           errors.New("exit status 2"),
+			ExitCode:      2,
+			FailureReason: common.ScriptFailure,
+		},
+		{
+			Name:          "test",
+			Stage:         "test",
+			Status:        pipelineJobStatusFailed,
+			Duration:      2 * time.Second,
+			Err:           errors.New("exit status 1"),
+			ExitCode:      1,
+			FailureReason: common.ScriptFailure,
+		},
+		{Name: "deploy", Stage: "deploy", Status: pipelineJobStatusSkipped},
+	}
+}
+
+func TestWritePipelineJSONReport(t *testing.T) {
+	buf := new(bytes.Buffer)
+	report := newPipelineReport(testPipelineResults(), 5*time.Second)
+	require.NoError(t, writePipelineJSONReport(buf, report))
+
+	assert.JSONEq(t, `{
+  "status": "failed",
+  "duration": 5,
+  "jobs": [
+    {"name": "build", "stage": "build", "status": "success", "allow_failure": false, "duration": 1.5, "exit_code": 0},
+    {"name": "lint", "stage": "test", "status": "failed", "allow_failure": true, "duration": 1, "exit_code": 2,
+     "failure_reason": "script_failure", "error": "exit status 2"},
+    {"name": "test", "stage": "test", "status": "failed", "allow_failure": false, "duration": 2, "exit_code": 1,
+     "failure_reason": "script_failure", "error": "exit status 1"},
+    {"name": "deploy", "stage": "deploy", "status": "skipped", "allow_failure": false, "duration": 0, "exit_code": 0}
+  ]
+}`, buf.String())
+}
+
+func TestWritePipelineJUnitReport(t *testing.T) {
+	buf := new(bytes.Buffer)
+	report := newPipelineReport(testPipelineResults(), 5*time.Second)
+	require.NoError(t, writePipelineJUnitReport(buf, report))
+
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="pipeline" tests="4" failures="2" skipped="1" time="5.000">
+    <testcase name="build" classname="build" time="1.500"></testcase>
+    <testcase name="lint" classname="test" time="1.000">
+      <failure message="job failed with exit code 2 (allowed to fail)" type="script_failure">exit status 2</failure>
+    </testcase>
+    <testcase name="test" classname="test" time="2.000">
+      <failure message="job failed with exit code 1" type="script_failure">exit status 1</failure>
+    </testcase>
+    <testcase name="deploy" classname="deploy" time="0.000">
+      <skipped message="skipped"></skipped>
+    </testcase>
+  </testsuite>
+</testsuites>
+`, buf.String())
+}
+
+func TestPipelineRunnerRecordsFailureData(t *testing.T) {
+	jobs := []gitlab_ci_yaml_parser.PipelineJob{
+		{Name: "script"},
+		{Name: "timeout"},
+		{Name: "system"},
+	}
+
+	failures := map[string]error{
+		"script":  &common.BuildError{ExitCode: 3},
+		"timeout": &common.BuildError{FailureReason: common.JobExecutionTimeout},
+		"system":  errors.New("can't create executor"),
+	}
+
+	runner := newPipelineRunner(jobs, 1, func(job gitlab_ci_yaml_parser.PipelineJob, slot int) error {
+		return failures[job.Name]
+	})
+
+	results := runner.Run()
+	require.Len(t, results, 3)
+
+	assert.Equal(t, 3, results[0].ExitCode)
+	assert.Equal(t, common.ScriptFailure, results[0].FailureReason)
+	assert.Equal(t, common.JobExecutionTimeout, results[1].FailureReason)
+	assert.Equal(t, common.RunnerSystemFailure, results[2].FailureReason)
+}
+
+func TestWritePipelineReportFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exec-report")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "report.json")
+	report := newPipelineReport(testPipelineResults(), time.Second)
+	require.NoError(t, writePipelineReportFile(path, report, writePipelineJSONReport))
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"status": "failed"`)
+
+	err = writePipelineReportFile(filepath.Join(dir, "missing", "report.json"), report, writePipelineJSONReport)
+	assert.Error(t, err)
+}
@@ -0,0 +1,63 @@
+package commands
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+func TestExecConfigureCache(t *testing.T) {
+	wd := filepath.Join(string(filepath.Separator), "project")
+
+	tests := map[string]struct {
+		cache         *common.CacheConfig
+		expectedCache *common.CacheConfig
+	}{
+		"no cache configured": {
+			expectedCache: &common.CacheConfig{
+				Type:   "filesystem",
+				Shared: true,
+				Filesystem: &common.CacheFilesystemConfig{
+					Path: filepath.Join(wd, execDataDir, "cache"),
+				},
+			},
+		},
+		"relative filesystem path": {
+			cache: &common.CacheConfig{
+				Type:       "filesystem",
+				Filesystem: &common.CacheFilesystemConfig{Path: "tmp/cache"},
+			},
+			expectedCache: &common.CacheConfig{
+				Type:       "filesystem",
+				Filesystem: &common.CacheFilesystemConfig{Path: filepath.Join(wd, "tmp", "cache")},
+			},
+		},
+		"absolute filesystem path": {
+			cache: &common.CacheConfig{
+				Filesystem: &common.CacheFilesystemConfig{Path: filepath.Join(wd, "cache")},
+			},
+			expectedCache: &common.CacheConfig{
+				Type:       "filesystem",
+				Shared:     true,
+				Filesystem: &common.CacheFilesystemConfig{Path: filepath.Join(wd, "cache")},
+			},
+		},
+		"other cache type": {
+			cache:         &common.CacheConfig{Type: "s3", S3: &common.CacheS3Config{BucketName: "cache"}},
+			expectedCache: &common.CacheConfig{Type: "s3", S3: &common.CacheS3Config{BucketName: "cache"}},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			c := &ExecCommand{}
+			c.Cache = tt.cache
+
+			c.configureCache(wd)
+			assert.Equal(t, tt.expectedCache, c.Cache)
+		})
+	}
+}
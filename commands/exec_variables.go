@@ -0,0 +1,138 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+const (
+	variableOptionMasked = "masked"
+	variableOptionFile   = "file"
+
+	// minMaskedValueLength is the minimum length of masked values accepted
+	// by GitLab
+	minMaskedValueLength = 8
+)
+
+var variableNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
+// parseProjectVariable parses a variable given as `KEY=VALUE`. The key can be
+// prefixed with comma separated options, e.g. `masked,file:KEY=VALUE`, to
+// mark the variable as masked or as a file variable.
+func parseProjectVariable(text string) (common.JobVariable, error) {
+	keyValue := strings.SplitN(text, "=", 2)
+	if len(keyValue) != 2 {
+		return common.JobVariable{}, fmt.Errorf("invalid variable %q: missing =", text)
+	}
+
+	variable := common.JobVariable{Key: keyValue[0], Value: keyValue[1]}
+
+	if index := strings.Index(variable.Key, ":"); index >= 0 {
+		for _, option := range strings.Split(variable.Key[:index], ",") {
+			switch strings.TrimSpace(option) {
+			case variableOptionMasked:
+				variable.Masked = true
+			case variableOptionFile:
+				variable.File = true
+			default:
+				return common.JobVariable{}, fmt.Errorf("invalid variable %q: unknown option %q", text, option)
+			}
+		}
+
+		variable.Key = variable.Key[index+1:]
+	}
+
+	if !variableNameRegexp.MatchString(variable.Key) {
+		return common.JobVariable{}, fmt.Errorf("invalid variable name %q", variable.Key)
+	}
+
+	if variable.Masked && (len(variable.Value) < minMaskedValueLength || strings.Contains(variable.Value, "\n")) {
+		return common.JobVariable{}, fmt.Errorf(
+			"variable %s can't be masked: the value must be a single line of at least %d characters",
+			variable.Key,
+			minMaskedValueLength,
+		)
+	}
+
+	return variable, nil
+}
+
+// loadEnvFile reads the variables of a file with a `KEY=VALUE` entry on every
+// line. Empty lines and lines starting with `#` are ignored, values can be
+// quoted and entries can use the same options as parseProjectVariable.
+func loadEnvFile(path string) (common.JobVariables, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening env file: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	var variables common.JobVariables
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		variable, err := parseProjectVariable(unquoteEnvValue(strings.TrimPrefix(line, "export ")))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+
+		variables = append(variables, variable)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading env file: %w", err)
+	}
+
+	return variables, nil
+}
+
+func unquoteEnvValue(entry string) string {
+	keyValue := strings.SplitN(entry, "=", 2)
+	if len(keyValue) != 2 {
+		return entry
+	}
+
+	value := keyValue[1]
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		value = value[1 : len(value)-1]
+	}
+
+	return keyValue[0] + "=" + value
+}
+
+// getProjectVariables returns the variables standing in for the CI/CD
+// variables of the project settings. The variables given on the command line
+// take precedence over the ones read from the env files.
+func (c *ExecCommand) getProjectVariables() (common.JobVariables, error) {
+	var variables common.JobVariables
+
+	for _, path := range c.EnvFiles {
+		fileVariables, err := loadEnvFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		variables = append(variables, fileVariables...)
+	}
+
+	for _, text := range c.Variables {
+		variable, err := parseProjectVariable(text)
+		if err != nil {
+			return nil, err
+		}
+
+		variables = append(variables, variable)
+	}
+
+	return variables, nil
+}
@@ -0,0 +1,114 @@
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+func TestParseProjectVariable(t *testing.T) {
+	tests := map[string]struct {
+		text             string
+		expectedVariable common.JobVariable
+		expectedError    string
+	}{
+		"plain variable": {
+			text:             "KEY=value=with=equals",
+			expectedVariable: common.JobVariable{Key: "KEY", Value: "value=with=equals"},
+		},
+		"masked variable": {
+			text:             "masked:TOKEN=secret-token",
+			expectedVariable: common.JobVariable{Key: "TOKEN", Value: "secret-token", Masked: true},
+		},
+		"masked file variable": {
+			text:             "masked,file:CERT=certificate",
+			expectedVariable: common.JobVariable{Key: "CERT", Value: "certificate", Masked: true, File: true},
+		},
+		"empty value": {
+			text:             "EMPTY=",
+			expectedVariable: common.JobVariable{Key: "EMPTY"},
+		},
+		"missing value": {
+			text:          "KEY",
+			expectedError: `invalid variable "KEY": missing =`,
+		},
+		"unknown option": {
+			text:          "protected:KEY=value",
+			expectedError: `invalid variable "protected:KEY=value": unknown option "protected"`,
+		},
+		"invalid name": {
+			text:          "MY-KEY=value",
+			expectedError: `invalid variable name "MY-KEY"`,
+		},
+		"short masked value": {
+			text:          "masked:KEY=short",
+			expectedError: "variable KEY can't be masked: the value must be a single line of at least 8 characters",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			variable, err := parseProjectVariable(tt.text)
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedVariable, variable)
+		})
+	}
+}
+
+func TestExecProjectVariables(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exec-env-file")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	envFile := filepath.Join(dir, "variables.env")
+	err = ioutil.WriteFile(envFile, []byte(`
+# Project settings
+export PLAIN=plain
+QUOTED="quoted value"
+masked:TOKEN='secret-token'
+OVERRIDDEN=file
+`), 0600)
+	require.NoError(t, err)
+
+	c := &ExecCommand{
+		EnvFiles:  []string{envFile},
+		Variables: []string{"OVERRIDDEN=command line"},
+	}
+
+	variables, err := c.getProjectVariables()
+	require.NoError(t, err)
+
+	assert.Equal(t, common.JobVariables{
+		{Key: "PLAIN", Value: "plain"},
+		{Key: "QUOTED", Value: "quoted value"},
+		{Key: "TOKEN", Value: "secret-token", Masked: true},
+		{Key: "OVERRIDDEN", Value: "file"},
+		{Key: "OVERRIDDEN", Value: "command line"},
+	}, variables)
+	assert.Equal(t, "command line", variables.Get("OVERRIDDEN"))
+}
+
+func TestExecProjectVariablesInvalidEnvFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exec-env-file")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	envFile := filepath.Join(dir, "variables.env")
+	require.NoError(t, ioutil.WriteFile(envFile, []byte("VALID=value\ninvalid\n"), 0600))
+
+	c := &ExecCommand{EnvFiles: []string{envFile}}
+
+	_, err = c.getProjectVariables()
+	assert.EqualError(t, err, envFile+`:2: invalid variable "invalid": missing =`)
+}
@@ -32,14 +32,16 @@ type Format string
 
 // Formats supported by GitLab.
 const (
-	Raw  Format = "raw"
-	Gzip Format = "gzip"
-	Zip  Format = "zip"
+	Raw     Format = "raw"
+	Gzip    Format = "gzip"
+	Zip     Format = "zip"
+	TarZstd Format = "tarzstd"
 )
 
 var (
-	archivers  = make(map[Format]NewArchiverFunc)
-	extractors = make(map[Format]NewExtractorFunc)
+	archivers        = make(map[Format]NewArchiverFunc)
+	extractors       = make(map[Format]NewExtractorFunc)
+	streamExtractors = make(map[Format]NewStreamExtractorFunc)
 )
 
 // Archiver is an interface for the Archive method.
@@ -60,6 +62,11 @@ type NewArchiverFunc func(w io.Writer, dir string, level CompressionLevel) (Arch
 // used to instantiate a new extractor (with NewExtractor()).
 type NewExtractorFunc func(r io.ReaderAt, size int64, dir string) (Extractor, error)
 
+// NewStreamExtractorFunc is a function that can be registered (with
+// RegisterStreamExtractor()) and used to instantiate a new extractor reading
+// the archive sequentially (with NewStreamExtractor()).
+type NewStreamExtractorFunc func(r io.Reader, dir string) (Extractor, error)
+
 // Register registers a new archiver, overriding the archiver and/or extractor
 // for the format provided.
 func Register(format Format, archiver NewArchiverFunc, extractor NewExtractorFunc) {
@@ -95,3 +102,23 @@ func NewExtractor(format Format, r io.ReaderAt, size int64, dir string) (Extract
 
 	return fn(r, size, dir)
 }
+
+// RegisterStreamExtractor registers a new stream extractor, overriding the
+// stream extractor for the format provided.
+func RegisterStreamExtractor(format Format, extractor NewStreamExtractorFunc) {
+	streamExtractors[format] = extractor
+}
+
+// NewStreamExtractor returns a new Extractor of the specified format, reading
+// the archive sequentially. Only the formats not needing random access, like
+// tar based ones, can be extracted as a stream.
+//
+// The extractor will extract files to the directory provided.
+func NewStreamExtractor(format Format, r io.Reader, dir string) (Extractor, error) {
+	fn := streamExtractors[format]
+	if fn == nil {
+		return nil, fmt.Errorf("%q format: %w", format, ErrUnsupportedArchiveFormat)
+	}
+
+	return fn(r, dir)
+}
@@ -39,6 +39,9 @@ func TestDefaultRegistration(t *testing.T) {
 			} else {
 				assert.ErrorIs(t, err, archive.ErrUnsupportedArchiveFormat)
 			}
+
+			_, err = archive.NewStreamExtractor(tn, nil, "")
+			assert.ErrorIs(t, err, archive.ErrUnsupportedArchiveFormat)
 		})
 	}
 }
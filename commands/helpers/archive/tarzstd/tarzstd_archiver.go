@@ -0,0 +1,64 @@
+package tarzstd
+
+import (
+	"context"
+	"io"
+	"os"
+	"runtime"
+
+	"github.com/klauspost/compress/zstd"
+
+	"gitlab.com/gitlab-org/gitlab-runner/commands/helpers/archive"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/archives"
+)
+
+func init() {
+	archive.Register(archive.TarZstd, NewArchiver, NewExtractor)
+	archive.RegisterStreamExtractor(archive.TarZstd, NewStreamExtractor)
+}
+
+// archiver is a tar archiver compressed with zstd.
+type archiver struct {
+	w     io.Writer
+	dir   string
+	level archive.CompressionLevel
+}
+
+// NewArchiver returns a new tar.zst Archiver.
+func NewArchiver(w io.Writer, dir string, level archive.CompressionLevel) (archive.Archiver, error) {
+	return &archiver{w: w, dir: dir, level: level}, nil
+}
+
+// Archive archives all files provided. The compression uses all the
+// available CPUs.
+func (a *archiver) Archive(ctx context.Context, files map[string]os.FileInfo) error {
+	zw, err := zstd.NewWriter(
+		a.w,
+		zstd.WithEncoderLevel(encoderLevel(a.level)),
+		zstd.WithEncoderConcurrency(runtime.GOMAXPROCS(0)),
+	)
+	if err != nil {
+		return err
+	}
+
+	err = archives.CreateTarArchive(ctx, zw, a.dir, files)
+	if err != nil {
+		_ = zw.Close()
+		return err
+	}
+
+	return zw.Close()
+}
+
+func encoderLevel(level archive.CompressionLevel) zstd.EncoderLevel {
+	switch level {
+	case archive.FastestCompression:
+		return zstd.SpeedFastest
+	case archive.SlowCompression:
+		return zstd.SpeedBetterCompression
+	case archive.SlowestCompression:
+		return zstd.SpeedBestCompression
+	default:
+		return zstd.SpeedDefault
+	}
+}
@@ -0,0 +1,72 @@
+package tarzstd
+
+import (
+	"context"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+
+	"gitlab.com/gitlab-org/gitlab-runner/commands/helpers/archive"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/archives"
+)
+
+// extractor is a tar.zst stream extractor.
+type extractor struct {
+	r    io.ReaderAt
+	size int64
+	dir  string
+}
+
+// NewExtractor returns a new tar.zst Extractor.
+func NewExtractor(r io.ReaderAt, size int64, dir string) (archive.Extractor, error) {
+	return &extractor{r: r, size: size, dir: dir}, nil
+}
+
+// Extract extracts files from the reader to the directory passed to
+// NewExtractor.
+func (e *extractor) Extract(ctx context.Context) error {
+	return extract(ctx, io.NewSectionReader(e.r, 0, e.size), e.dir)
+}
+
+// streamExtractor is a tar.zst extractor reading the archive while it's
+// downloaded.
+type streamExtractor struct {
+	r   io.Reader
+	dir string
+}
+
+// NewStreamExtractor returns a new tar.zst Extractor reading the archive
+// sequentially.
+func NewStreamExtractor(r io.Reader, dir string) (archive.Extractor, error) {
+	return &streamExtractor{r: r, dir: dir}, nil
+}
+
+// Extract extracts files from the reader to the directory passed to
+// NewStreamExtractor.
+func (e *streamExtractor) Extract(ctx context.Context) error {
+	return extract(ctx, e.r, e.dir)
+}
+
+func extract(ctx context.Context, r io.Reader, dir string) error {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	return archives.ExtractTarArchive(&contextReader{ctx: ctx, r: zr}, dir)
+}
+
+// contextReader stops the extraction once the context is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return r.r.Read(p)
+}
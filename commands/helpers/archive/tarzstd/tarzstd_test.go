@@ -0,0 +1,126 @@
+package tarzstd
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/commands/helpers/archive"
+)
+
+func TestArchiveAndExtract(t *testing.T) {
+	src, err := ioutil.TempDir("", "tarzstd-src")
+	require.NoError(t, err)
+	defer os.RemoveAll(src)
+
+	content := bytes.Repeat([]byte("compressible content "), 10000)
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "dir"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "dir", "file"), content, 0640))
+	require.NoError(t, os.Symlink("dir/file", filepath.Join(src, "link")))
+
+	files := make(map[string]os.FileInfo)
+	for _, name := range []string{"dir", filepath.Join("dir", "file"), "link"} {
+		fi, err := os.Lstat(filepath.Join(src, name))
+		require.NoError(t, err)
+		files[name] = fi
+	}
+
+	levels := map[string]archive.CompressionLevel{
+		"fastest": archive.FastestCompression,
+		"fast":    archive.FastCompression,
+		"default": archive.DefaultCompression,
+		"slow":    archive.SlowCompression,
+		"slowest": archive.SlowestCompression,
+	}
+
+	for tn, level := range levels {
+		t.Run(tn, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+
+			archiver, err := archive.NewArchiver(archive.TarZstd, buf, src, level)
+			require.NoError(t, err)
+			require.NoError(t, archiver.Archive(context.Background(), files))
+			assert.Less(t, buf.Len(), len(content)/10)
+
+			dst, err := ioutil.TempDir("", "tarzstd-dst")
+			require.NoError(t, err)
+			defer os.RemoveAll(dst)
+
+			r := bytes.NewReader(buf.Bytes())
+			extractor, err := archive.NewExtractor(archive.TarZstd, r, r.Size(), dst)
+			require.NoError(t, err)
+			require.NoError(t, extractor.Extract(context.Background()))
+
+			extracted, err := ioutil.ReadFile(filepath.Join(dst, "dir", "file"))
+			require.NoError(t, err)
+			assert.Equal(t, content, extracted)
+
+			fi, err := os.Stat(filepath.Join(dst, "dir", "file"))
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0640), fi.Mode().Perm())
+
+			link, err := os.Readlink(filepath.Join(dst, "link"))
+			require.NoError(t, err)
+			assert.Equal(t, "dir/file", link)
+		})
+	}
+}
+
+func TestStreamExtract(t *testing.T) {
+	src, err := ioutil.TempDir("", "tarzstd-src")
+	require.NoError(t, err)
+	defer os.RemoveAll(src)
+
+	content := bytes.Repeat([]byte("compressible content "), 10000)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "file"), content, 0600))
+
+	fi, err := os.Lstat(filepath.Join(src, "file"))
+	require.NoError(t, err)
+
+	buf := new(bytes.Buffer)
+	archiver, err := archive.NewArchiver(archive.TarZstd, buf, src, archive.DefaultCompression)
+	require.NoError(t, err)
+	require.NoError(t, archiver.Archive(context.Background(), map[string]os.FileInfo{"file": fi}))
+
+	dst, err := ioutil.TempDir("", "tarzstd-dst")
+	require.NoError(t, err)
+	defer os.RemoveAll(dst)
+
+	// the pipe hides the io.ReaderAt implementation of the buffer
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := io.Copy(pw, buf)
+		_ = pw.CloseWithError(err)
+	}()
+
+	extractor, err := archive.NewStreamExtractor(archive.TarZstd, pr, dst)
+	require.NoError(t, err)
+	require.NoError(t, extractor.Extract(context.Background()))
+
+	extracted, err := ioutil.ReadFile(filepath.Join(dst, "file"))
+	require.NoError(t, err)
+	assert.Equal(t, content, extracted)
+}
+
+func TestExtractCanceled(t *testing.T) {
+	buf := new(bytes.Buffer)
+
+	archiver, err := NewArchiver(buf, "", archive.DefaultCompression)
+	require.NoError(t, err)
+	require.NoError(t, archiver.Archive(context.Background(), nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	r := bytes.NewReader(buf.Bytes())
+	extractor, err := NewExtractor(r, r.Size(), "")
+	require.NoError(t, err)
+	assert.ErrorIs(t, extractor.Extract(ctx), context.Canceled)
+}
@@ -1,6 +1,8 @@
 package helpers
 
 import (
+	"bytes"
+	"io"
 	"os"
 
 	"gitlab.com/gitlab-org/gitlab-runner/commands/helpers/archive"
@@ -10,6 +12,7 @@ import (
 	// auto-register default archivers/extractors
 	_ "gitlab.com/gitlab-org/gitlab-runner/commands/helpers/archive/gziplegacy"
 	_ "gitlab.com/gitlab-org/gitlab-runner/commands/helpers/archive/raw"
+	_ "gitlab.com/gitlab-org/gitlab-runner/commands/helpers/archive/tarzstd"
 	_ "gitlab.com/gitlab-org/gitlab-runner/commands/helpers/archive/ziplegacy"
 
 	"github.com/sirupsen/logrus"
@@ -43,3 +46,18 @@ func GetCompressionLevel(name string) archive.CompressionLevel {
 
 	return archive.DefaultCompression
 }
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// detectArchiveFormat returns the format of an archive to extract, so
+// archives created with a different format than the configured one can
+// still be extracted
+func detectArchiveFormat(r io.ReaderAt) archive.Format {
+	magic := make([]byte, len(zstdMagic))
+	_, err := r.ReadAt(magic, 0)
+	if err == nil && bytes.Equal(magic, zstdMagic) {
+		return archive.TarZstd
+	}
+
+	return archive.Zip
+}
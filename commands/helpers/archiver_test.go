@@ -1,6 +1,7 @@
 package helpers
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -25,3 +26,28 @@ func TestCompressionLevel(t *testing.T) {
 		})
 	}
 }
+
+func TestDetectArchiveFormat(t *testing.T) {
+	tests := map[string]struct {
+		content        []byte
+		expectedFormat archive.Format
+	}{
+		"zip": {
+			content:        []byte("PK\x03\x04"),
+			expectedFormat: archive.Zip,
+		},
+		"zstd": {
+			content:        []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00},
+			expectedFormat: archive.TarZstd,
+		},
+		"empty": {
+			expectedFormat: archive.Zip,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			assert.Equal(t, tt.expectedFormat, detectArchiveFormat(bytes.NewReader(tt.content)))
+		})
+	}
+}
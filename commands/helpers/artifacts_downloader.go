@@ -24,7 +24,8 @@ type ArtifactsDownloaderCommand struct {
 	network common.Network
 	meter.TransferMeterCommand
 
-	DirectDownload bool `long:"direct-download" env:"FF_USE_DIRECT_DOWNLOAD" description:"Support direct download for data stored externally to GitLab"`
+	DirectDownload bool   `long:"direct-download" env:"FF_USE_DIRECT_DOWNLOAD" description:"Support direct download for data stored externally to GitLab"`
+	LocalPath      string `long:"local-path" description:"Extract the artifacts archive stored at this path instead of downloading it from GitLab"`
 }
 
 func (c *ArtifactsDownloaderCommand) directDownloadFlag(retry int) *bool {
@@ -78,6 +79,31 @@ func (c *ArtifactsDownloaderCommand) Execute(cliContext *cli.Context) {
 		logrus.Fatalln("Unable to get working directory")
 	}
 
+	filename := c.LocalPath
+	if filename == "" {
+		filename = c.downloadToTempFile()
+		defer func() { _ = os.Remove(filename) }()
+	}
+
+	f, size, err := openZip(filename)
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+	defer f.Close()
+
+	extractor, err := archive.NewExtractor(detectArchiveFormat(f), f, size, wd)
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+
+	// Extract artifacts file
+	err = extractor.Extract(context.Background())
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+}
+
+func (c *ArtifactsDownloaderCommand) downloadToTempFile() string {
 	if c.URL == "" || c.Token == "" {
 		logrus.Fatalln("Missing runner credentials")
 	}
@@ -91,32 +117,17 @@ func (c *ArtifactsDownloaderCommand) Execute(cliContext *cli.Context) {
 		logrus.Fatalln(err)
 	}
 	_ = file.Close()
-	defer func() { _ = os.Remove(file.Name()) }()
 
 	// Download artifacts file
 	err = c.doRetry(func(retry int) error {
 		return c.download(file.Name(), retry)
 	})
 	if err != nil {
+		_ = os.Remove(file.Name())
 		logrus.Fatalln(err)
 	}
 
-	f, size, err := openZip(file.Name())
-	if err != nil {
-		logrus.Fatalln(err)
-	}
-	defer f.Close()
-
-	extractor, err := archive.NewExtractor(archive.Zip, f, size, wd)
-	if err != nil {
-		logrus.Fatalln(err)
-	}
-
-	// Extract artifacts file
-	err = extractor.Extract(context.Background())
-	if err != nil {
-		logrus.Fatalln(err)
-	}
+	return file.Name()
 }
 
 func openZip(filename string) (*os.File, int64, error) {
@@ -3,7 +3,9 @@ package helpers
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
@@ -42,6 +44,8 @@ type ArtifactsUploaderCommand struct {
 	Format           common.ArtifactFormat `long:"artifact-format" description:"Format of generated artifacts"`
 	Type             string                `long:"artifact-type" description:"Type of generated artifacts"`
 	CompressionLevel string                `long:"compression-level" env:"ARTIFACT_COMPRESSION_LEVEL" description:"Compression level (fastest, fast, default, slow, slowest)"`
+	LocalPath        string                `long:"local-path" description:"Store the artifacts archive at this path instead of uploading it to GitLab"`
+	ArchiveFormat    common.ArtifactFormat `long:"archive-format" env:"ARTIFACT_ARCHIVE_FORMAT" description:"Format of the archive stored with --local-path (zip, tarzstd)"`
 }
 
 func (c *ArtifactsUploaderCommand) artifactFilename(name string, format common.ArtifactFormat) string {
@@ -56,20 +60,40 @@ func (c *ArtifactsUploaderCommand) artifactFilename(name string, format common.A
 
 	case common.ArtifactFormatGzip:
 		return name + ".gz"
+
+	case common.ArtifactFormatTarZstd:
+		return name + ".tar.zst"
 	}
 	return name
 }
 
-func (c *ArtifactsUploaderCommand) createReadStream() (string, io.ReadCloser, error) {
-	if len(c.files) == 0 {
-		return "", nil, nil
-	}
-
+// archiveFormat returns the format of the created archive. GitLab accepts
+// only zip archives, so a different archive format can only be used for the
+// archives stored locally.
+func (c *ArtifactsUploaderCommand) archiveFormat() common.ArtifactFormat {
 	format := c.Format
 	if format == common.ArtifactFormatDefault {
 		format = common.ArtifactFormatZip
 	}
 
+	if c.ArchiveFormat == "" || format != common.ArtifactFormatZip {
+		return format
+	}
+
+	if c.LocalPath == "" {
+		logrus.Warningln("The archive format", c.ArchiveFormat, "is only supported for artifacts stored locally, using zip")
+		return format
+	}
+
+	return c.ArchiveFormat
+}
+
+func (c *ArtifactsUploaderCommand) createReadStream() (string, io.ReadCloser, error) {
+	if len(c.files) == 0 {
+		return "", nil, nil
+	}
+
+	format := c.archiveFormat()
 	filename := c.artifactFilename(c.Name, format)
 	pr, pw := io.Pipe()
 
@@ -113,6 +137,10 @@ func (c *ArtifactsUploaderCommand) Run() error {
 		meter.LabelledRateFormat(os.Stdout, "Uploading artifacts", meter.UnknownTotalSize),
 	)
 
+	if c.LocalPath != "" {
+		return c.storeLocally(stream)
+	}
+
 	// Upload the data
 	switch c.network.UploadRawArtifacts(c.JobCredentials, stream, options) {
 	case common.UploadSucceeded:
@@ -130,6 +158,33 @@ func (c *ArtifactsUploaderCommand) Run() error {
 	}
 }
 
+// storeLocally writes the archive to LocalPath. The archive is written to
+// a temporary file first, so an interrupted job never leaves a partial
+// archive behind for the jobs that depend on it.
+func (c *ArtifactsUploaderCommand) storeLocally(stream io.Reader) error {
+	dir := filepath.Dir(c.LocalPath)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("creating local artifacts directory: %w", err)
+	}
+
+	file, err := ioutil.TempFile(dir, "artifacts")
+	if err != nil {
+		return fmt.Errorf("creating local artifacts file: %w", err)
+	}
+	defer func() { _ = os.Remove(file.Name()) }()
+
+	_, err = io.Copy(file, stream)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("writing local artifacts file: %w", err)
+	}
+
+	return os.Rename(file.Name(), c.LocalPath)
+}
+
 func (c *ArtifactsUploaderCommand) ShouldRetry(tries int, err error) bool {
 	var errAs retryableErr
 	if !errors.As(err, &errAs) {
@@ -151,11 +206,13 @@ func (c *ArtifactsUploaderCommand) ShouldRetry(tries int, err error) bool {
 func (c *ArtifactsUploaderCommand) Execute(*cli.Context) {
 	log.SetRunnerFormatter()
 
-	if c.URL == "" || c.Token == "" {
-		logrus.Fatalln("Missing runner credentials")
-	}
-	if c.ID <= 0 {
-		logrus.Fatalln("Missing build ID")
+	if c.LocalPath == "" {
+		if c.URL == "" || c.Token == "" {
+			logrus.Fatalln("Missing runner credentials")
+		}
+		if c.ID <= 0 {
+			logrus.Fatalln("Missing build ID")
+		}
 	}
 
 	// Enumerate files
@@ -5,6 +5,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -418,3 +419,117 @@ func TestArtifactUploaderCommandShouldRetry(t *testing.T) {
 		})
 	}
 }
+
+func TestArtifactsLocalPathRoundTrip(t *testing.T) {
+	OnEachZipArchiver(t, func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "local-artifacts")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		localPath := filepath.Join(dir, "job", "artifacts.zip")
+
+		writeTestFile(t, artifactsTestArchivedFile)
+		defer os.Remove(artifactsTestArchivedFile)
+
+		removeHook := helpers.MakeFatalToPanic()
+		defer removeHook()
+
+		uploader := ArtifactsUploaderCommand{
+			network:   &testNetwork{},
+			LocalPath: localPath,
+			fileArchiver: fileArchiver{
+				Paths: []string{artifactsTestArchivedFile},
+			},
+		}
+		uploader.Execute(nil)
+		assert.FileExists(t, localPath)
+
+		require.NoError(t, os.Remove(artifactsTestArchivedFile))
+
+		downloader := ArtifactsDownloaderCommand{
+			network:   &testNetwork{},
+			LocalPath: localPath,
+		}
+		downloader.Execute(nil)
+		assert.FileExists(t, artifactsTestArchivedFile)
+	})
+}
+
+func TestArtifactsLocalPathTarZstdRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local-artifacts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	localPath := filepath.Join(dir, "job", "artifacts.zip")
+
+	writeTestFile(t, artifactsTestArchivedFile)
+	defer os.Remove(artifactsTestArchivedFile)
+
+	removeHook := helpers.MakeFatalToPanic()
+	defer removeHook()
+
+	uploader := ArtifactsUploaderCommand{
+		network:       &testNetwork{},
+		LocalPath:     localPath,
+		ArchiveFormat: common.ArtifactFormatTarZstd,
+		fileArchiver: fileArchiver{
+			Paths: []string{artifactsTestArchivedFile},
+		},
+	}
+	uploader.Execute(nil)
+
+	f, err := os.Open(localPath)
+	require.NoError(t, err)
+	assert.Equal(t, archive.TarZstd, detectArchiveFormat(f))
+	_ = f.Close()
+
+	require.NoError(t, os.Remove(artifactsTestArchivedFile))
+
+	downloader := ArtifactsDownloaderCommand{
+		network:   &testNetwork{},
+		LocalPath: localPath,
+	}
+	downloader.Execute(nil)
+	assert.FileExists(t, artifactsTestArchivedFile)
+}
+
+func TestArtifactsUploaderArchiveFormat(t *testing.T) {
+	tests := map[string]struct {
+		format         common.ArtifactFormat
+		archiveFormat  common.ArtifactFormat
+		localPath      string
+		expectedFormat common.ArtifactFormat
+	}{
+		"default": {
+			expectedFormat: common.ArtifactFormatZip,
+		},
+		"report format": {
+			format:         common.ArtifactFormatGzip,
+			archiveFormat:  common.ArtifactFormatTarZstd,
+			localPath:      "artifacts",
+			expectedFormat: common.ArtifactFormatGzip,
+		},
+		"archive stored locally": {
+			format:         common.ArtifactFormatZip,
+			archiveFormat:  common.ArtifactFormatTarZstd,
+			localPath:      "artifacts",
+			expectedFormat: common.ArtifactFormatTarZstd,
+		},
+		"archive uploaded to GitLab": {
+			archiveFormat:  common.ArtifactFormatTarZstd,
+			expectedFormat: common.ArtifactFormatZip,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			uploader := ArtifactsUploaderCommand{
+				Format:        tt.format,
+				ArchiveFormat: tt.archiveFormat,
+				LocalPath:     tt.localPath,
+			}
+
+			assert.Equal(t, tt.expectedFormat, uploader.archiveFormat())
+		})
+	}
+}
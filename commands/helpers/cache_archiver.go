@@ -15,9 +15,12 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
+	_ "gitlab.com/gitlab-org/gitlab-runner/cache/registry" // Needed to register the registry driver
 	"gitlab.com/gitlab-org/gitlab-runner/commands/helpers/archive"
 	"gitlab.com/gitlab-org/gitlab-runner/commands/helpers/meter"
 	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/encryption"
 	url_helpers "gitlab.com/gitlab-org/gitlab-runner/helpers/url"
 	"gitlab.com/gitlab-org/gitlab-runner/log"
 
@@ -37,9 +40,19 @@ type CacheArchiverCommand struct {
 	Timeout          int      `long:"timeout" description:"Overall timeout for cache uploading request (in minutes)"`
 	Headers          []string `long:"header" description:"HTTP headers to send with PUT request (in form of 'key:value')"`
 	CompressionLevel string   `long:"compression-level" env:"CACHE_COMPRESSION_LEVEL" description:"Compression level (fastest, fast, default, slow, slowest)"`
-
-	client *CacheClient
-	mux    *blob.URLMux
+	Format           string   `long:"format" env:"CACHE_ARCHIVE_FORMAT" description:"Cache format (zip, tarzstd, chunked)"`
+	ChunkPacks       string   `long:"chunk-packs" env:"CACHE_CHUNK_PACKS" description:"Chunk packs of the cache (JSON), storing the chunks of the chunked format"`
+	EncryptionKeys   string   `long:"encryption-keys" env:"CACHE_ENCRYPTION_KEYS" description:"Keys encrypting the uploaded cache (in form of comma separated 'id=base64 key', the first one is used)"`
+	MultipartUpload  string   `long:"multipart-upload" env:"CACHE_MULTIPART_UPLOAD" description:"Multipart upload of the cache (JSON), resumed from the last uploaded part when retried"`
+
+	client           *CacheClient
+	mux              *blob.URLMux
+	keyring          *encryption.Keyring
+	multipart        *multipartUpload
+	packs            *cache.ChunkPacks
+	stalePacks       []cache.ChunkPack
+	encryptedArchive string
+	uploaded         int64
 }
 
 func (c *CacheArchiverCommand) getClient() *CacheClient {
@@ -50,8 +63,22 @@ func (c *CacheArchiverCommand) getClient() *CacheClient {
 	return c.client
 }
 
-func (c *CacheArchiverCommand) upload(_ int) error {
-	file, err := os.Open(c.File)
+// archivePath returns the path of the file uploaded to the cache URL: the
+// archive, or the manifest in the chunked format
+func (c *CacheArchiverCommand) archivePath() string {
+	if c.Format == common.CacheFormatChunked {
+		return chunkedManifestPath(c.File)
+	}
+
+	return c.File
+}
+
+func (c *CacheArchiverCommand) upload(retry int) error {
+	if c.multipart != nil && c.GoCloudURL == "" {
+		return c.uploadInParts(retry)
+	}
+
+	file, err := os.Open(c.archivePath())
 	if err != nil {
 		return err
 	}
@@ -69,15 +96,32 @@ func (c *CacheArchiverCommand) upload(_ int) error {
 	)
 	defer rc.Close()
 
+	var reader io.Reader = rc
+	size := fi.Size()
+
+	if c.keyring != nil {
+		reader, err = encryption.NewEncrypter(rc, c.keyring)
+		if err != nil {
+			return err
+		}
+		size = encryption.EncryptedSize(size, c.keyring.KeyID)
+	}
+
 	if c.GoCloudURL != "" {
-		return c.handleGoCloudURL(rc)
+		err = c.handleGoCloudURL(reader)
+	} else {
+		err = c.handlePresignedURL(fi, reader, size)
 	}
 
-	return c.handlePresignedURL(fi, rc)
+	if err == nil {
+		c.uploaded += size
+	}
+
+	return err
 }
 
-func (c *CacheArchiverCommand) handlePresignedURL(fi os.FileInfo, file io.Reader) error {
-	logrus.Infoln("Uploading", filepath.Base(c.File), "to", url_helpers.CleanURL(c.URL))
+func (c *CacheArchiverCommand) handlePresignedURL(fi os.FileInfo, file io.Reader, size int64) error {
+	logrus.Infoln("Uploading", filepath.Base(c.archivePath()), "to", url_helpers.CleanURL(c.URL))
 
 	req, err := http.NewRequest(http.MethodPut, c.URL, file)
 	if err != nil {
@@ -85,7 +129,7 @@ func (c *CacheArchiverCommand) handlePresignedURL(fi os.FileInfo, file io.Reader
 	}
 
 	c.setHeaders(req, fi)
-	req.ContentLength = fi.Size()
+	req.ContentLength = size
 
 	resp, err := c.getClient().Do(req)
 	if err != nil {
@@ -97,7 +141,7 @@ func (c *CacheArchiverCommand) handlePresignedURL(fi os.FileInfo, file io.Reader
 }
 
 func (c *CacheArchiverCommand) handleGoCloudURL(file io.Reader) error {
-	logrus.Infoln("Uploading", filepath.Base(c.File), "to", url_helpers.CleanURL(c.GoCloudURL))
+	logrus.Infoln("Uploading", filepath.Base(c.archivePath()), "to", url_helpers.CleanURL(c.GoCloudURL))
 
 	if c.mux == nil {
 		c.mux = blob.DefaultURLMux()
@@ -122,7 +166,7 @@ func (c *CacheArchiverCommand) handleGoCloudURL(file io.Reader) error {
 	}
 	defer b.Close()
 
-	writer, err := b.NewWriter(ctx, objectName, nil)
+	writer, err := b.NewWriter(ctx, objectName, c.writerOptions())
 	if err != nil {
 		return err
 	}
@@ -142,7 +186,19 @@ func (c *CacheArchiverCommand) handleGoCloudURL(file io.Reader) error {
 	return nil
 }
 
-func (c *CacheArchiverCommand) createZipFile(filename string) error {
+// writerOptions records the ID of the key encrypting the cache in the
+// metadata of the object
+func (c *CacheArchiverCommand) writerOptions() *blob.WriterOptions {
+	if c.keyring == nil {
+		return nil
+	}
+
+	return &blob.WriterOptions{
+		Metadata: map[string]string{encryption.KeyIDMetadata: c.keyring.KeyID},
+	}
+}
+
+func (c *CacheArchiverCommand) createArchiveFile(filename string, format archive.Format) error {
 	err := os.MkdirAll(filepath.Dir(filename), 0700)
 	if err != nil {
 		return err
@@ -157,7 +213,7 @@ func (c *CacheArchiverCommand) createZipFile(filename string) error {
 
 	logrus.Debugln("Temporary file:", f.Name())
 
-	archiver, err := archive.NewArchiver(archive.Zip, f, c.wd, GetCompressionLevel(c.CompressionLevel))
+	archiver, err := archive.NewArchiver(format, f, c.wd, GetCompressionLevel(c.CompressionLevel))
 	if err != nil {
 		return err
 	}
@@ -176,6 +232,17 @@ func (c *CacheArchiverCommand) createZipFile(filename string) error {
 	return os.Rename(f.Name(), filename)
 }
 
+func (c *CacheArchiverCommand) createArchive() error {
+	switch c.Format {
+	case common.CacheFormatChunked:
+		return c.createChunkedCache()
+	case common.CacheFormatTarZstd:
+		return c.createArchiveFile(c.File, archive.TarZstd)
+	default:
+		return c.createArchiveFile(c.File, archive.Zip)
+	}
+}
+
 func (c *CacheArchiverCommand) Execute(*cli.Context) {
 	log.SetRunnerFormatter()
 
@@ -183,36 +250,62 @@ func (c *CacheArchiverCommand) Execute(*cli.Context) {
 		logrus.Fatalln("Missing --file")
 	}
 
+	if err := checkCacheFormat(c.Format); err != nil {
+		logrus.Fatalln(err)
+	}
+
+	keyring, err := parseEncryptionKeys(c.EncryptionKeys)
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+	c.keyring = keyring
+
+	started := time.Now()
+
+	c.multipart, err = newMultipartUpload(c.MultipartUpload, c.getClient())
+	if err != nil {
+		logrus.WithError(err).Warningln("Uploading the cache in a single request")
+	}
+
 	// Enumerate files
-	err := c.enumerate()
+	err = c.enumerate()
 	if err != nil {
+		c.abortUploadInParts()
 		logrus.Fatalln(err)
 	}
 
 	// Check if list of files changed
-	if !c.isFileChanged(c.File) {
+	if !c.isFileChanged(c.archivePath()) {
 		logrus.Infoln("Archive is up to date!")
+		c.abortUploadInParts()
+		reportCacheResult(common.CacheOperationArchive, false, 0, started)
 
 		return
 	}
 
 	// Create archive
-	err = c.createZipFile(c.File)
+	err = c.createArchive()
 	if err != nil {
+		c.abortUploadInParts()
 		logrus.Fatalln(err)
 	}
 
 	// Upload archive if needed
 	if c.URL != "" || c.GoCloudURL != "" {
 		err := c.doRetry(c.upload)
+		c.finishUploadInParts(err)
 		if err != nil {
 			logrus.Fatalln(err)
 		}
+
+		c.deleteStalePacks()
 	} else {
 		logrus.Infoln(
 			"No URL provided, cache will be not uploaded to shared cache server. " +
 				"Cache will be stored only locally.")
 	}
+
+	reportCacheResult(common.CacheOperationArchive, false, c.uploaded, started)
 }
 
 func (c *CacheArchiverCommand) setHeaders(req *http.Request, fi os.FileInfo) {
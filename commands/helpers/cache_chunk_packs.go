@@ -0,0 +1,312 @@
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
+	"gitlab.com/gitlab-org/gitlab-runner/commands/helpers/chunked"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/encryption"
+	url_helpers "gitlab.com/gitlab-org/gitlab-runner/helpers/url"
+)
+
+// maxReferencedPacks limits the number of the chunk packs referenced by
+// a cache. All the chunks are uploaded again to the new pack when the cache
+// would reference more packs, or when most of the data of the referenced
+// packs isn't used by the cache anymore.
+const maxReferencedPacks = 4
+
+var errChunkPacksReadOnly = errors.New("the chunk packs can't be written by the cache extractor")
+
+func parseChunkPacks(description string) (*cache.ChunkPacks, error) {
+	if description == "" {
+		return nil, nil
+	}
+
+	var packs cache.ChunkPacks
+	err := json.Unmarshal([]byte(description), &packs)
+	if err != nil {
+		return nil, fmt.Errorf("decoding the chunk packs: %w", err)
+	}
+
+	return &packs, nil
+}
+
+// reusableLocations returns the locations of the chunks of the manifest
+// stored in the packs, as known from the previous manifest of the cache
+func reusableLocations(
+	previous *chunked.Manifest,
+	manifest *chunked.Manifest,
+	stored map[string]cache.ChunkPack,
+) map[string]chunked.Location {
+	locations := make(map[string]chunked.Location)
+	if previous == nil {
+		return locations
+	}
+
+	previousLocations := previous.Locations()
+	used := make(map[string]int64)
+
+	for hash := range manifest.Hashes() {
+		location, ok := previousLocations[hash]
+		if !ok {
+			continue
+		}
+
+		if _, ok := stored[location.Pack]; !ok {
+			continue
+		}
+
+		locations[hash] = location
+		used[location.Pack] += location.Length
+	}
+
+	var usedSize, packsSize int64
+	for pack, size := range used {
+		usedSize += size
+		packsSize += stored[pack].Size
+	}
+
+	if len(used) >= maxReferencedPacks || usedSize*2 < packsSize {
+		logrus.Infoln("Repacking the chunks of the cache")
+		return make(map[string]chunked.Location)
+	}
+
+	return locations
+}
+
+// uploadChunkPack uploads the chunks of the manifest which aren't stored in
+// the packs of the cache to the new pack of the job, and locates all the
+// chunks in the manifest. The packs not used anymore are deleted once the
+// manifest is uploaded.
+func (c *CacheArchiverCommand) uploadChunkPack(
+	local chunked.Store,
+	previous *chunked.Manifest,
+	manifest *chunked.Manifest,
+) error {
+	stored := make(map[string]cache.ChunkPack, len(c.packs.Packs))
+	for _, pack := range c.packs.Packs {
+		stored[pack.Name] = pack
+	}
+
+	locations := reusableLocations(previous, manifest, stored)
+
+	file, err := ioutil.TempFile(filepath.Dir(c.File), "pack_")
+	if err != nil {
+		return fmt.Errorf("creating chunk pack: %w", err)
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}()
+
+	var size int64
+	count := 0
+	for _, chunk := range manifest.Chunks {
+		if _, ok := locations[chunk.Hash]; ok {
+			continue
+		}
+
+		length, err := c.writeChunk(file, local, chunk.Hash)
+		if err != nil {
+			return fmt.Errorf("writing chunk pack: %w", err)
+		}
+
+		locations[chunk.Hash] = chunked.Location{Pack: c.packs.Name, Offset: size, Length: length}
+		size += length
+		count++
+	}
+
+	if size > 0 {
+		err = c.doRetry(func(int) error {
+			return c.uploadPackFile(file, size)
+		})
+		if err != nil {
+			return fmt.Errorf("uploading chunk pack: %w", err)
+		}
+
+		c.uploaded += size
+	}
+
+	logrus.Infof("Uploaded %d of %d chunks (%d bytes)", count, len(manifest.Hashes()), size)
+
+	manifest.Locate(locations)
+
+	referenced := manifest.Packs()
+	c.stalePacks = nil
+	for _, pack := range c.packs.Packs {
+		if !referenced[pack.Name] {
+			c.stalePacks = append(c.stalePacks, pack)
+		}
+	}
+
+	return nil
+}
+
+// writeChunk appends the chunk to the pack, encrypted on its own when the
+// caches are encrypted, and returns its stored length
+func (c *CacheArchiverCommand) writeChunk(w io.Writer, local chunked.Store, hash string) (int64, error) {
+	r, err := local.Open(hash)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = r.Close() }()
+
+	var reader io.Reader = r
+	if c.keyring != nil {
+		reader, err = encryption.NewEncrypter(r, c.keyring)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return io.Copy(w, reader)
+}
+
+func (c *CacheArchiverCommand) uploadPackFile(file *os.File, size int64) error {
+	logrus.Infoln("Uploading chunk pack", c.packs.Name, "to", url_helpers.CleanURL(c.packs.UploadURL))
+
+	req, err := http.NewRequest(http.MethodPut, c.packs.UploadURL, io.NewSectionReader(file, 0, size))
+	if err != nil {
+		return retryableErr{err: err}
+	}
+
+	req.ContentLength = size
+	req.Header = c.packs.UploadHeaders.Clone()
+	if len(req.Header) == 0 {
+		req.Header = http.Header{}
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+
+	resp, err := c.getClient().Do(req)
+	if err != nil {
+		return retryableErr{err: err}
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	return retryOnServerError(resp)
+}
+
+// deleteStalePacks deletes the chunk packs not used anymore by the uploaded
+// cache. The packs failing to be deleted are left to the next cache archiver
+// or to the garbage collection.
+func (c *CacheArchiverCommand) deleteStalePacks() {
+	for _, pack := range c.stalePacks {
+		if pack.DeleteURL == "" {
+			continue
+		}
+
+		err := deletePack(c.getClient(), pack.DeleteURL)
+		if err != nil {
+			logrus.WithError(err).Warningln("Deleting the unused chunk pack", pack.Name)
+			continue
+		}
+
+		logrus.Debugln("Deleted the unused chunk pack", pack.Name)
+	}
+}
+
+func deletePack(client *CacheClient, url string) error {
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("received: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// packReader reads the chunks from the chunk packs of the cache with range
+// requests, decrypting them when the caches are encrypted
+type packReader struct {
+	ctx       context.Context
+	client    *CacheClient
+	keyring   *encryption.Keyring
+	urls      map[string]string
+	locations map[string]chunked.Location
+
+	lock sync.Mutex
+	size int64
+}
+
+func newPackReader(
+	ctx context.Context,
+	client *CacheClient,
+	keyring *encryption.Keyring,
+	packs *cache.ChunkPacks,
+	manifest *chunked.Manifest,
+) *packReader {
+	urls := make(map[string]string, len(packs.Packs))
+	for _, pack := range packs.Packs {
+		urls[pack.Name] = pack.DownloadURL
+	}
+
+	return &packReader{
+		ctx:       ctx,
+		client:    client,
+		keyring:   keyring,
+		urls:      urls,
+		locations: manifest.Locations(),
+	}
+}
+
+func (r *packReader) Has(hash string) (bool, error) {
+	_, ok := r.locations[hash]
+
+	return ok, nil
+}
+
+func (r *packReader) Open(hash string) (io.ReadCloser, error) {
+	location, ok := r.locations[hash]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", chunked.ErrChunkNotFound, hash)
+	}
+
+	url, ok := r.urls[location.Pack]
+	if !ok || url == "" {
+		return nil, fmt.Errorf("%w: %s (pack %s not found)", chunked.ErrChunkNotFound, hash, location.Pack)
+	}
+
+	data, err := fetchRange(r.ctx, r.client, url, http.Header{}, "", location.Offset, location.Length)
+	if err != nil {
+		return nil, retryableErr{err: fmt.Errorf("downloading chunk %s: %w", hash, err)}
+	}
+
+	r.lock.Lock()
+	r.size += int64(len(data))
+	r.lock.Unlock()
+
+	var reader io.Reader = bytes.NewReader(data)
+	if r.keyring != nil {
+		reader, err = encryption.NewDecrypter(reader, r.keyring)
+		if err != nil {
+			return nil, fmt.Errorf("decrypting chunk %s: %w", hash, err)
+		}
+	}
+
+	return ioutil.NopCloser(reader), nil
+}
+
+func (r *packReader) Put(string, io.Reader) error {
+	return errChunkPacksReadOnly
+}
@@ -0,0 +1,154 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
+	"gitlab.com/gitlab-org/gitlab-runner/commands/helpers/chunked"
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+const chunkTransferConcurrency = 8
+
+func checkCacheFormat(format string) error {
+	switch format {
+	case "", common.CacheFormatZip, common.CacheFormatTarZstd, common.CacheFormatChunked:
+		return nil
+	}
+
+	return fmt.Errorf("unknown cache format %q", format)
+}
+
+// chunkedManifestPath returns the path of the manifest replacing the archive
+// file in the chunked format. The manifest is what's uploaded to and
+// downloaded from the cache URL.
+func chunkedManifestPath(file string) string {
+	return filepath.Join(filepath.Dir(file), "manifest.json")
+}
+
+// chunkedLocalStore returns the local copy of the chunks of the cache
+func chunkedLocalStore(file string) *chunked.DirStore {
+	return chunked.NewDirStore(filepath.Join(filepath.Dir(file), "chunks"))
+}
+
+func (c *CacheArchiverCommand) createChunkedCache() error {
+	packs, err := parseChunkPacks(c.ChunkPacks)
+	if err != nil {
+		return err
+	}
+	c.packs = packs
+
+	if c.packs == nil && (c.URL != "" || c.GoCloudURL != "") {
+		return errors.New("the chunked format requires the chunk packs to upload the cache")
+	}
+
+	local := chunkedLocalStore(c.File)
+
+	// The previous manifest locates the chunks already uploaded
+	previous, err := chunked.ReadManifest(chunkedManifestPath(c.File))
+	if err != nil && !os.IsNotExist(err) {
+		logrus.WithError(err).Warningln("Reading the previous manifest")
+	}
+
+	manifest, err := chunked.Archive(context.Background(), local, c.wd, c.files)
+	if err != nil {
+		return err
+	}
+
+	if c.packs != nil {
+		err = c.uploadChunkPack(local, previous, manifest)
+		if err != nil {
+			return err
+		}
+	}
+
+	pruneChunks(local, manifest)
+
+	// the manifest is written last, so it only references available chunks
+	return manifest.WriteFile(chunkedManifestPath(c.File))
+}
+
+// extractChunkedCache returns whether a cache was found and extracted
+func (c *CacheExtractorCommand) extractChunkedCache(wd string) (bool, error) {
+	manifestPath := chunkedManifestPath(c.File)
+
+	manifest, err := chunked.ReadManifest(manifestPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	packs, err := parseChunkPacks(c.ChunkPacks)
+	if err != nil {
+		return false, err
+	}
+
+	ctx := context.Background()
+	local := chunkedLocalStore(c.File)
+
+	if c.URL != "" && packs != nil {
+		err = c.downloadChunks(ctx, local, packs, manifest)
+		if err != nil {
+			// the cache is created again instead of being reported
+			// as up to date
+			_ = os.Remove(manifestPath)
+			return false, err
+		}
+	}
+
+	err = chunked.Extract(ctx, local, manifest, wd)
+	if err != nil {
+		return false, err
+	}
+
+	pruneChunks(local, manifest)
+
+	return true, nil
+}
+
+// downloadChunks downloads the chunks of the manifest missing locally from
+// the chunk packs. The downloaded chunks are kept when it's retried.
+func (c *CacheExtractorCommand) downloadChunks(
+	ctx context.Context,
+	local chunked.Store,
+	packs *cache.ChunkPacks,
+	manifest *chunked.Manifest,
+) error {
+	reader := newPackReader(ctx, c.getClient(), c.keyring, packs, manifest)
+
+	var downloaded int
+	err := c.doRetry(func(int) error {
+		count, _, err := chunked.Transfer(ctx, reader, local, manifest, chunkTransferConcurrency)
+		downloaded += count
+
+		return err
+	})
+
+	c.downloaded += reader.size
+	if err != nil {
+		return fmt.Errorf("downloading chunks: %w", err)
+	}
+
+	logrus.Infof("Downloaded %d of %d chunks (%d bytes)", downloaded, len(manifest.Hashes()), reader.size)
+
+	return nil
+}
+
+// pruneChunks removes the local chunks not used anymore by the cache
+func pruneChunks(local *chunked.DirStore, manifest *chunked.Manifest) {
+	removed, err := local.Prune(manifest.Hashes())
+	if err != nil {
+		logrus.WithError(err).Warningln("Removing unused chunks")
+		return
+	}
+
+	logrus.Debugln("Removed", removed, "unused chunks")
+}
@@ -0,0 +1,252 @@
+package helpers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
+	"gitlab.com/gitlab-org/gitlab-runner/commands/helpers/chunked"
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+func chunkPacksJSON(t *testing.T, packs cache.ChunkPacks) string {
+	data, err := json.Marshal(packs)
+	require.NoError(t, err)
+
+	return string(data)
+}
+
+func TestChunkedCacheRoundTrip(t *testing.T) {
+	const cachedDir = "chunked_cache_test"
+
+	tests := map[string]struct {
+		encryptionKeys string
+	}{
+		"plain":     {},
+		"encrypted": {encryptionKeys: testEncryptionKeys},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "chunked-cache")
+			require.NoError(t, err)
+			defer func() { _ = os.RemoveAll(dir) }()
+
+			require.NoError(t, os.MkdirAll(filepath.Join(cachedDir, "nested"), 0755))
+			defer func() { _ = os.RemoveAll(cachedDir) }()
+
+			writeTestFile(t, filepath.Join(cachedDir, "file.txt"))
+			require.NoError(t, ioutil.WriteFile(filepath.Join(cachedDir, "nested", "data"), []byte("data"), 0600))
+
+			manifestURL := fileURL(filepath.Join(dir, "remote", "key"))
+			packPath := func(name string) string {
+				return filepath.Join(dir, "remote", ".chunks", "key.packs", name)
+			}
+
+			archiver := NewCacheArchiverCommandForTest(filepath.Join(dir, "archiver", "cache.zip"), []string{cachedDir})
+			archiver.URL = manifestURL
+			archiver.Format = common.CacheFormatChunked
+			archiver.EncryptionKeys = tt.encryptionKeys
+			archiver.ChunkPacks = chunkPacksJSON(t, cache.ChunkPacks{
+				Name:      "1-aaaa",
+				UploadURL: fileURL(packPath("1-aaaa")),
+			})
+			archiver.Execute(nil)
+
+			assert.FileExists(t, filepath.Join(dir, "archiver", "manifest.json"))
+			assert.FileExists(t, filepath.Join(dir, "remote", "key"))
+			assert.NoFileExists(t, filepath.Join(dir, "archiver", "cache.zip"))
+
+			fi, err := os.Stat(packPath("1-aaaa"))
+			require.NoError(t, err)
+
+			require.NoError(t, os.RemoveAll(cachedDir))
+
+			extractor := CacheExtractorCommand{
+				File:           filepath.Join(dir, "extractor", "cache.zip"),
+				URL:            manifestURL,
+				Format:         common.CacheFormatChunked,
+				EncryptionKeys: tt.encryptionKeys,
+				ChunkPacks: chunkPacksJSON(t, cache.ChunkPacks{
+					Packs: []cache.ChunkPack{
+						{Name: "1-aaaa", Size: fi.Size(), DownloadURL: fileURL(packPath("1-aaaa"))},
+					},
+				}),
+			}
+			extractor.Execute(nil)
+
+			content, err := ioutil.ReadFile(filepath.Join(cachedDir, "nested", "data"))
+			require.NoError(t, err)
+			assert.Equal(t, "data", string(content))
+			assert.FileExists(t, filepath.Join(cachedDir, "file.txt"))
+
+			chunks, err := filepath.Glob(filepath.Join(dir, "extractor", "chunks", "*", "*"))
+			require.NoError(t, err)
+			assert.NotEmpty(t, chunks)
+
+			// The changed cache is archived from the extracted one, whose
+			// only chunk changed, so the first pack isn't used anymore
+			require.NoError(t, ioutil.WriteFile(filepath.Join(cachedDir, "nested", "data"), []byte("changed"), 0600))
+
+			archiver = NewCacheArchiverCommandForTest(extractor.File, []string{cachedDir})
+			archiver.URL = manifestURL
+			archiver.Format = common.CacheFormatChunked
+			archiver.EncryptionKeys = tt.encryptionKeys
+			archiver.ChunkPacks = chunkPacksJSON(t, cache.ChunkPacks{
+				Packs: []cache.ChunkPack{
+					{Name: "1-aaaa", Size: fi.Size(), DeleteURL: fileURL(packPath("1-aaaa"))},
+				},
+				Name:      "2-bbbb",
+				UploadURL: fileURL(packPath("2-bbbb")),
+			})
+			archiver.Execute(nil)
+
+			assert.NoFileExists(t, packPath("1-aaaa"))
+			assert.FileExists(t, packPath("2-bbbb"))
+
+			manifest, err := chunked.ReadManifest(filepath.Join(dir, "extractor", "manifest.json"))
+			require.NoError(t, err)
+			assert.Equal(t, map[string]bool{"2-bbbb": true}, manifest.Packs())
+		})
+	}
+}
+
+func TestChunkedCacheExtractionWithMissingPack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chunked-cache")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	manifest := &chunked.Manifest{
+		Version: 2,
+		Chunks: []chunked.Chunk{{
+			Hash:     "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+			Size:     5,
+			Location: chunked.Location{Pack: "1-aaaa", Length: 5},
+		}},
+	}
+
+	data, err := json.Marshal(manifest)
+	require.NoError(t, err)
+
+	remote := filepath.Join(dir, "remote", "key")
+	require.NoError(t, os.MkdirAll(filepath.Dir(remote), 0755))
+	require.NoError(t, ioutil.WriteFile(remote, data, 0600))
+
+	extractor := CacheExtractorCommand{
+		File:       filepath.Join(dir, "extractor", "cache.zip"),
+		URL:        fileURL(remote),
+		Format:     common.CacheFormatChunked,
+		ChunkPacks: chunkPacksJSON(t, cache.ChunkPacks{}),
+	}
+
+	found, err := extractor.extract(dir)
+	assert.False(t, found)
+	assert.ErrorIs(t, err, chunked.ErrChunkNotFound)
+	assert.NoFileExists(t, filepath.Join(dir, "extractor", "manifest.json"), "the cache is created again")
+}
+
+func TestReusableLocations(t *testing.T) {
+	const (
+		first  = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+		second = "486ea46224d1bb4fb680f34f7c9ad96a8f24ec88be73ea8e5a6c65260e9cb8a7"
+	)
+
+	previous := &chunked.Manifest{
+		Chunks: []chunked.Chunk{
+			{Hash: first, Size: 100, Location: chunked.Location{Pack: "1-aaaa", Length: 100}},
+			{Hash: second, Size: 100, Location: chunked.Location{Pack: "2-bbbb", Length: 100}},
+		},
+	}
+
+	manifest := &chunked.Manifest{
+		Chunks: []chunked.Chunk{{Hash: first, Size: 100}, {Hash: second, Size: 100}},
+	}
+
+	tests := map[string]struct {
+		previous          *chunked.Manifest
+		stored            []cache.ChunkPack
+		expectedLocations []string
+	}{
+		"no previous manifest": {
+			stored: []cache.ChunkPack{{Name: "1-aaaa", Size: 100}},
+		},
+		"chunks of the stored packs": {
+			previous:          previous,
+			stored:            []cache.ChunkPack{{Name: "1-aaaa", Size: 100}, {Name: "2-bbbb", Size: 100}},
+			expectedLocations: []string{first, second},
+		},
+		"chunks of a missing pack": {
+			previous:          previous,
+			stored:            []cache.ChunkPack{{Name: "1-aaaa", Size: 100}},
+			expectedLocations: []string{first},
+		},
+		"packs mostly unused": {
+			previous: previous,
+			stored:   []cache.ChunkPack{{Name: "1-aaaa", Size: 1000}, {Name: "2-bbbb", Size: 100}},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			stored := make(map[string]cache.ChunkPack)
+			for _, pack := range tt.stored {
+				stored[pack.Name] = pack
+			}
+
+			locations := reusableLocations(tt.previous, manifest, stored)
+
+			var hashes []string
+			for _, hash := range []string{first, second} {
+				if _, ok := locations[hash]; ok {
+					hashes = append(hashes, hash)
+				}
+			}
+
+			assert.Equal(t, tt.expectedLocations, hashes)
+		})
+	}
+}
+
+func TestReusableLocationsRepacksTooManyPacks(t *testing.T) {
+	previous := &chunked.Manifest{}
+	manifest := &chunked.Manifest{}
+	stored := make(map[string]cache.ChunkPack)
+
+	for i := 0; i < maxReferencedPacks; i++ {
+		hash := chunkedTestHash(i)
+		pack := cache.ChunkPack{Name: hash[:8], Size: 10}
+		stored[pack.Name] = pack
+
+		manifest.Chunks = append(manifest.Chunks, chunked.Chunk{Hash: hash, Size: 10})
+		previous.Chunks = append(previous.Chunks, chunked.Chunk{
+			Hash:     hash,
+			Size:     10,
+			Location: chunked.Location{Pack: pack.Name, Length: 10},
+		})
+	}
+
+	assert.Empty(t, reusableLocations(previous, manifest, stored))
+
+	delete(stored, manifest.Chunks[0].Hash[:8])
+	assert.Len(t, reusableLocations(previous, manifest, stored), maxReferencedPacks-1)
+}
+
+func chunkedTestHash(i int) string {
+	hash := []byte("0000000000000000000000000000000000000000000000000000000000000000")
+	hash[0] = "0123456789abcdef"[i%16]
+
+	return string(hash)
+}
+
+func TestCheckCacheFormat(t *testing.T) {
+	assert.NoError(t, checkCacheFormat(""))
+	assert.NoError(t, checkCacheFormat(common.CacheFormatZip))
+	assert.NoError(t, checkCacheFormat(common.CacheFormatChunked))
+	assert.Error(t, checkCacheFormat("rar"))
+}
@@ -21,7 +21,7 @@ func (c *CacheClient) prepareClient(timeout int) {
 }
 
 func (c *CacheClient) prepareTransport() {
-	c.Transport = &http.Transport{
+	transport := &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
 		DialContext: (&net.Dialer{
 			Timeout:   30 * time.Second,
@@ -33,6 +33,11 @@ func (c *CacheClient) prepareTransport() {
 		ResponseHeaderTimeout: 30 * time.Second,
 		DisableCompression:    true,
 	}
+
+	// Used by the filesystem cache adapter
+	transport.RegisterProtocol("file", &fileTransport{})
+
+	c.Transport = transport
 }
 
 func NewCacheClient(timeout int) *CacheClient {
@@ -0,0 +1,15 @@
+package helpers
+
+import (
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/encryption"
+)
+
+// parseEncryptionKeys returns the keyring encrypting the caches, or nil
+// when the caches aren't encrypted
+func parseEncryptionKeys(keys string) (*encryption.Keyring, error) {
+	if keys == "" {
+		return nil, nil
+	}
+
+	return encryption.ParseKeyring(keys)
+}
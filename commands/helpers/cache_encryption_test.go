@@ -0,0 +1,145 @@
+package helpers
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/encryption"
+)
+
+const (
+	testEncryptionKeys = "v2=AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=,v1=AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI="
+	testOtherKeys      = "v3=AwMDAwMDAwMDAwMDAwMDAwMDAwMDAwMDAwMDAwMDAwM="
+)
+
+func TestCacheArchiverUploadsEncryptedCache(t *testing.T) {
+	var uploaded []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+
+		var err error
+		uploaded, err = ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(uploaded)), r.ContentLength)
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "cache-encryption")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "cache.zip")
+	require.NoError(t, ioutil.WriteFile(file, []byte("archive content"), 0600))
+
+	keyring, err := encryption.ParseKeyring(testEncryptionKeys)
+	require.NoError(t, err)
+
+	cmd := CacheArchiverCommand{
+		File:    file,
+		URL:     ts.URL + "/cache.zip",
+		keyring: keyring,
+	}
+	require.NoError(t, cmd.upload(0))
+	assert.Equal(t, int64(len(uploaded)), cmd.uploaded)
+
+	decrypter, err := encryption.NewDecrypter(bytes.NewReader(uploaded), keyring)
+	require.NoError(t, err)
+
+	decrypted, err := ioutil.ReadAll(decrypter)
+	require.NoError(t, err)
+	assert.Equal(t, "archive content", string(decrypted))
+}
+
+func serveEncryptedCache(t *testing.T, keys string) http.HandlerFunc {
+	archive := new(bytes.Buffer)
+	writer := zip.NewWriter(archive)
+	_, err := writer.Create(cacheExtractorTestArchivedFile)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	content := archive.Bytes()
+	if keys != "" {
+		keyring, err := encryption.ParseKeyring(keys)
+		require.NoError(t, err)
+
+		encrypter, err := encryption.NewEncrypter(archive, keyring)
+		require.NoError(t, err)
+
+		content, err = ioutil.ReadAll(encrypter)
+		require.NoError(t, err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", time.Now().Format(http.TimeFormat))
+		_, _ = w.Write(content)
+	}
+}
+
+func TestCacheExtractorDecryptsCache(t *testing.T) {
+	tests := map[string]struct {
+		uploadKeys    string
+		downloadKeys  string
+		expectedPanic bool
+	}{
+		"encrypted cache": {
+			uploadKeys:   testEncryptionKeys,
+			downloadKeys: testEncryptionKeys,
+		},
+		"cache encrypted with a previous key": {
+			uploadKeys:   "v1=AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI=",
+			downloadKeys: testEncryptionKeys,
+		},
+		"cache encrypted with an unknown key": {
+			uploadKeys:    testOtherKeys,
+			downloadKeys:  testEncryptionKeys,
+			expectedPanic: true,
+		},
+		"unencrypted cache": {
+			downloadKeys:  testEncryptionKeys,
+			expectedPanic: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			ts := httptest.NewServer(serveEncryptedCache(t, tt.uploadKeys))
+			defer ts.Close()
+
+			defer os.Remove(cacheExtractorArchive)
+			defer os.Remove(cacheExtractorTestArchivedFile)
+			os.Remove(cacheExtractorArchive)
+			os.Remove(cacheExtractorTestArchivedFile)
+
+			removeHook := helpers.MakeFatalToPanic()
+			defer removeHook()
+
+			cmd := CacheExtractorCommand{
+				File:           cacheExtractorArchive,
+				URL:            ts.URL + "/cache.zip",
+				EncryptionKeys: tt.downloadKeys,
+			}
+
+			if tt.expectedPanic {
+				assert.Panics(t, func() { cmd.Execute(nil) })
+				_, err := os.Stat(cacheExtractorArchive)
+				assert.True(t, os.IsNotExist(err), "the cache must not be stored")
+				return
+			}
+
+			assert.NotPanics(t, func() { cmd.Execute(nil) })
+			_, err := os.Stat(cacheExtractorTestArchivedFile)
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -2,6 +2,9 @@ package helpers
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -13,13 +16,17 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
 	"gitlab.com/gitlab-org/gitlab-runner/commands/helpers/archive"
 	"gitlab.com/gitlab-org/gitlab-runner/commands/helpers/meter"
 	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/encryption"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/featureflags"
 	url_helpers "gitlab.com/gitlab-org/gitlab-runner/helpers/url"
 	"gitlab.com/gitlab-org/gitlab-runner/log"
 )
 
+//nolint:lll
 type CacheExtractorCommand struct {
 	retryHelper
 	meter.TransferMeterCommand
@@ -28,7 +35,26 @@ type CacheExtractorCommand struct {
 	URL     string `long:"url" description:"URL of remote cache resource"`
 	Timeout int    `long:"timeout" description:"Overall timeout for cache downloading request (in minutes)"`
 
-	client *CacheClient
+	Format     string `long:"format" description:"Cache format (zip, tarzstd, chunked)"`
+	ChunkPacks string `long:"chunk-packs" env:"CACHE_CHUNK_PACKS" description:"Chunk packs of the cache (JSON), storing the chunks of the chunked format"`
+
+	AccessMarker string `long:"access-marker" env:"CACHE_ACCESS_MARKER" description:"Access marker of the cache (JSON), uploaded when the cache is downloaded"`
+
+	EncryptionKeys string `long:"encryption-keys" env:"CACHE_ENCRYPTION_KEYS" description:"Keys decrypting the downloaded cache (in form of comma separated 'id=base64 key')"`
+
+	Authorization string `long:"authorization" env:"CACHE_DOWNLOAD_AUTHORIZATION" description:"Authorization header sent with the requests downloading the cache, e.g. a short-lived registry token"`
+	LastModified  string `long:"last-modified" env:"CACHE_DOWNLOAD_LAST_MODIFIED" description:"Modification date of the remote cache (in HTTP date format), used when the server doesn't send it"`
+
+	DownloadConcurrency int `long:"download-concurrency" env:"CACHE_DOWNLOAD_CONCURRENCY" description:"Number of parallel range requests downloading large caches (1 disables them)"`
+
+	client     *CacheClient
+	keyring    *encryption.Keyring
+	downloaded int64
+
+	// streamDir is the directory in which the cache is extracted while
+	// it's downloaded, empty when the streaming extraction is disabled
+	streamDir string
+	extracted bool
 }
 
 func (c *CacheExtractorCommand) getClient() *CacheClient {
@@ -39,13 +65,28 @@ func (c *CacheExtractorCommand) getClient() *CacheClient {
 	return c.client
 }
 
-func checkIfUpToDate(path string, resp *http.Response) (bool, time.Time) {
+// checkIfUpToDate compares the local cache with the modification date of the
+// remote one, sent by the server or else passed by the runner
+func checkIfUpToDate(path string, resp *http.Response, lastModified string) (bool, time.Time) {
+	if header := resp.Header.Get("Last-Modified"); header != "" {
+		lastModified = header
+	}
+
 	fi, _ := os.Lstat(path)
-	date, _ := time.Parse(http.TimeFormat, resp.Header.Get("Last-Modified"))
+	date, _ := time.Parse(http.TimeFormat, lastModified)
 	return fi != nil && !date.After(fi.ModTime()), date
 }
 
 func getRemoteCacheSize(resp *http.Response) int64 {
+	if resp.StatusCode == http.StatusPartialContent {
+		_, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return meter.UnknownTotalSize
+		}
+
+		return size
+	}
+
 	length, _ := strconv.Atoi(resp.Header.Get("Content-Length"))
 	if length <= 0 {
 		return meter.UnknownTotalSize
@@ -54,8 +95,21 @@ func getRemoteCacheSize(resp *http.Response) int64 {
 	return int64(length)
 }
 
+// archivePath returns the path of the file downloaded from the cache URL:
+// the archive, or the manifest in the chunked format
+func (c *CacheExtractorCommand) archivePath() string {
+	if c.Format == common.CacheFormatChunked {
+		return chunkedManifestPath(c.File)
+	}
+
+	return c.File
+}
+
 func (c *CacheExtractorCommand) download(_ int) error {
-	err := os.MkdirAll(filepath.Dir(c.File), 0700)
+	path := c.archivePath()
+	c.extracted = false
+
+	err := os.MkdirAll(filepath.Dir(path), 0700)
 	if err != nil {
 		return err
 	}
@@ -67,13 +121,13 @@ func (c *CacheExtractorCommand) download(_ int) error {
 
 	defer func() { _ = resp.Body.Close() }()
 
-	upToDate, date := checkIfUpToDate(c.File, resp)
+	upToDate, date := checkIfUpToDate(path, resp, c.LastModified)
 	if upToDate {
-		logrus.Infoln(filepath.Base(c.File), "is up to date")
+		logrus.Infoln(filepath.Base(path), "is up to date")
 		return nil
 	}
 
-	file, err := ioutil.TempFile(filepath.Dir(c.File), "cache")
+	file, err := ioutil.TempFile(filepath.Dir(path), "cache")
 	if err != nil {
 		return err
 	}
@@ -83,7 +137,7 @@ func (c *CacheExtractorCommand) download(_ int) error {
 		_ = os.Remove(file.Name())
 	}()
 
-	logrus.Infoln("Downloading", filepath.Base(c.File), "from", url_helpers.CleanURL(c.URL))
+	logrus.Infoln("Downloading", filepath.Base(path), "from", url_helpers.CleanURL(c.URL))
 
 	writer := meter.NewWriter(
 		file,
@@ -94,9 +148,28 @@ func (c *CacheExtractorCommand) download(_ int) error {
 	// Close() is checked properly bellow, where the file handling is being finalized
 	defer func() { _ = writer.Close() }()
 
-	_, err = io.Copy(writer, resp.Body)
+	src, err := c.getCacheReader(resp)
 	if err != nil {
-		return retryableErr{err: err}
+		return err
+	}
+	defer func() { _ = src.Close() }()
+
+	body := &countingReader{r: src}
+	defer func() { c.downloaded += body.n }()
+
+	var dst io.Writer = writer
+	var stream *streamExtractor
+	if c.streamDir != "" && c.Format != common.CacheFormatChunked {
+		stream = newStreamExtractor(c.streamDir)
+		dst = io.MultiWriter(writer, stream)
+	}
+
+	err = c.copyCache(dst, body)
+	if stream != nil {
+		err = stream.finish(err)
+	}
+	if err != nil {
+		return err
 	}
 
 	err = os.Chtimes(file.Name(), time.Now(), date)
@@ -109,16 +182,82 @@ func (c *CacheExtractorCommand) download(_ int) error {
 		return err
 	}
 
-	err = os.Rename(file.Name(), c.File)
+	err = os.Rename(file.Name(), path)
 	if err != nil {
 		return err
 	}
 
+	c.extracted = stream != nil && stream.extracted()
+
+	return nil
+}
+
+// getCacheReader returns the reader of the downloaded cache. The rest of a
+// cache whose first range was downloaded is downloaded with parallel range
+// requests.
+func (c *CacheExtractorCommand) getCacheReader(resp *http.Response) (io.ReadCloser, error) {
+	if resp.StatusCode != http.StatusPartialContent {
+		return ioutil.NopCloser(resp.Body), nil
+	}
+
+	r, err := newRangeReader(c.getClient(), c.URL, c.requestHeader(), resp, c.DownloadConcurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// copyCache copies the downloaded cache, decrypting it when the caches are
+// encrypted. Decryption errors other than truncated downloads aren't retried.
+func (c *CacheExtractorCommand) copyCache(dst io.Writer, src io.Reader) error {
+	if c.keyring != nil {
+		decrypter, err := encryption.NewDecrypter(src, c.keyring)
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return retryableErr{err: err}
+		}
+		if err != nil {
+			return fmt.Errorf("decrypting cache: %w", err)
+		}
+
+		src = decrypter
+	}
+
+	_, err := io.Copy(dst, src)
+	if errors.Is(err, encryption.ErrCorrupted) {
+		return fmt.Errorf("decrypting cache: %w", err)
+	}
+	if err != nil {
+		return retryableErr{err: err}
+	}
+
 	return nil
 }
 
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+
+	return n, err
+}
+
+// getCache requests the cache. With parallel downloads, only its first range
+// is requested, the servers not supporting the ranges returning the whole
+// cache.
 func (c *CacheExtractorCommand) getCache() (*http.Response, error) {
-	resp, err := c.getClient().Get(c.URL)
+	ranged := c.DownloadConcurrency > 1 && c.Format != common.CacheFormatChunked
+
+	resp, err := c.requestCache(ranged)
+	if err == nil && ranged && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		// The range of an empty cache can't be satisfied
+		_ = resp.Body.Close()
+		resp, err = c.requestCache(false)
+	}
 	if err != nil {
 		return nil, retryableErr{err: err}
 	}
@@ -131,6 +270,68 @@ func (c *CacheExtractorCommand) getCache() (*http.Response, error) {
 	return resp, retryOnServerError(resp)
 }
 
+func (c *CacheExtractorCommand) requestCache(ranged bool) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, c.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header = c.requestHeader()
+	if ranged {
+		req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", downloadRangeSize-1))
+	}
+
+	return c.getClient().Do(req)
+}
+
+// requestHeader returns the headers of the requests downloading the cache
+func (c *CacheExtractorCommand) requestHeader() http.Header {
+	header := http.Header{}
+	if c.Authorization != "" {
+		header.Set("Authorization", c.Authorization)
+	}
+
+	return header
+}
+
+// markAccess uploads the access marker of the downloaded cache, whose write
+// time tells the garbage collection when the cache was last used. The cache
+// is extracted even when the marker can't be uploaded.
+func (c *CacheExtractorCommand) markAccess() {
+	if c.AccessMarker == "" {
+		return
+	}
+
+	var marker cache.AccessMarker
+	err := json.Unmarshal([]byte(c.AccessMarker), &marker)
+	if err != nil {
+		logrus.WithError(err).Warningln("Decoding the cache access marker")
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPut, marker.URL, http.NoBody)
+	if err != nil {
+		logrus.WithError(err).Warningln("Uploading the cache access marker")
+		return
+	}
+
+	req.Header = marker.Headers.Clone()
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+
+	resp, err := c.getClient().Do(req)
+	if err != nil {
+		logrus.WithError(err).Warningln("Uploading the cache access marker")
+		return
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode/100 != 2 {
+		logrus.Warningln("Uploading the cache access marker, received:", resp.Status)
+	}
+}
+
 func (c *CacheExtractorCommand) Execute(cliContext *cli.Context) {
 	log.SetRunnerFormatter()
 
@@ -143,35 +344,75 @@ func (c *CacheExtractorCommand) Execute(cliContext *cli.Context) {
 		logrus.Fatalln("Missing cache file")
 	}
 
+	if err := checkCacheFormat(c.Format); err != nil {
+		logrus.Fatalln(err)
+	}
+
+	c.keyring, err = parseEncryptionKeys(c.EncryptionKeys)
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+
+	logger := logrus.WithField("name", featureflags.UseStreamingCacheExtraction)
+	if featureflags.IsOn(logger, os.Getenv(featureflags.UseStreamingCacheExtraction)) {
+		c.streamDir = wd
+	}
+
+	started := time.Now()
+
+	found, err := c.extract(wd)
+	if err == nil || errors.Is(err, os.ErrNotExist) {
+		reportCacheResult(common.CacheOperationExtract, found, c.downloaded, started)
+	}
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+}
+
+// extract downloads and extracts the cache. It returns whether a cache was
+// found and extracted.
+func (c *CacheExtractorCommand) extract(wd string) (bool, error) {
 	if c.URL != "" {
 		err := c.doRetry(c.download)
 		if err != nil {
-			logrus.Fatalln(err)
+			return false, err
 		}
+
+		c.markAccess()
 	} else {
 		logrus.Infoln(
 			"No URL provided, cache will not be downloaded from shared cache server. " +
 				"Instead a local version of cache will be extracted.")
 	}
 
+	if c.Format == common.CacheFormatChunked {
+		return c.extractChunkedCache(wd)
+	}
+
+	if c.extracted {
+		return true, nil
+	}
+
 	f, size, err := openZip(c.File)
 	if os.IsNotExist(err) {
-		return
+		return false, nil
 	}
 	if err != nil {
-		logrus.Fatalln(err)
+		return false, err
 	}
 	defer f.Close()
 
-	extractor, err := archive.NewExtractor(archive.Zip, f, size, wd)
+	extractor, err := archive.NewExtractor(detectArchiveFormat(f), f, size, wd)
 	if err != nil {
-		logrus.Fatalln(err)
+		return false, err
 	}
 
 	err = extractor.Extract(context.Background())
 	if err != nil {
-		logrus.Fatalln(err)
+		return false, err
 	}
+
+	return true, nil
 }
 
 func init() {
@@ -183,6 +424,7 @@ func init() {
 				Retry:     2,
 				RetryTime: time.Second,
 			},
+			DownloadConcurrency: 4,
 		},
 	)
 }
@@ -3,15 +3,19 @@ package helpers
 import (
 	"archive/zip"
 	"bytes"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	"gitlab.com/gitlab-org/gitlab-runner/common"
 	"gitlab.com/gitlab-org/gitlab-runner/helpers"
 )
 
@@ -120,6 +124,36 @@ func TestCacheExtractorRemoteServerNotFound(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestCacheExtractorAuthorization(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		testServeCache(w, r)
+	}))
+	defer ts.Close()
+
+	defer os.Remove(cacheExtractorArchive)
+	defer os.Remove(cacheExtractorTestArchivedFile)
+
+	removeHook := helpers.MakeFatalToPanic()
+	defer removeHook()
+
+	cmd := CacheExtractorCommand{
+		File:          cacheExtractorArchive,
+		URL:           ts.URL + "/cache.zip",
+		Authorization: "Bearer token",
+	}
+	assert.NotPanics(t, func() {
+		cmd.Execute(nil)
+	})
+
+	_, err := os.Stat(cacheExtractorTestArchivedFile)
+	assert.NoError(t, err)
+}
+
 func TestCacheExtractorRemoteServerTimedOut(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(testServeCache))
 	defer ts.Close()
@@ -175,6 +209,119 @@ func TestCacheExtractorRemoteServer(t *testing.T) {
 	assert.NotPanics(t, func() { cmd.Execute(nil) }, "archive is up to date")
 }
 
+func TestCacheExtractorAccessMarker(t *testing.T) {
+	tests := map[string]struct {
+		url             string
+		expectedMarkers int
+	}{
+		"downloaded cache": {
+			url:             "/cache.zip",
+			expectedMarkers: 1,
+		},
+		"missing cache": {
+			url: "/invalid-file.zip",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			markers := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/.access/cache.zip" {
+					assert.Equal(t, http.MethodPut, r.Method)
+					assert.Equal(t, "BlockBlob", r.Header.Get("X-Ms-Blob-Type"))
+					markers++
+					return
+				}
+
+				testServeCache(w, r)
+			}))
+			defer ts.Close()
+
+			defer os.Remove(cacheExtractorArchive)
+			defer os.Remove(cacheExtractorTestArchivedFile)
+
+			removeHook := helpers.MakeFatalToPanic()
+			defer removeHook()
+
+			cmd := CacheExtractorCommand{
+				File:         cacheExtractorArchive,
+				URL:          ts.URL + tt.url,
+				AccessMarker: `{"url":"` + ts.URL + `/.access/cache.zip","headers":{"X-Ms-Blob-Type":["BlockBlob"]}}`,
+			}
+
+			_, _ = cmd.extract(".")
+			assert.Equal(t, tt.expectedMarkers, markers)
+		})
+	}
+}
+
+func TestCheckIfUpToDate(t *testing.T) {
+	lastModified := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+
+	tests := map[string]struct {
+		localModTime       time.Time
+		lastModified       string
+		runnerLastModified string
+		expectedUpToDate   bool
+	}{
+		"missing local file": {
+			lastModified: lastModified.Format(http.TimeFormat),
+		},
+		"newer local file": {
+			localModTime:     lastModified.Add(time.Hour),
+			lastModified:     lastModified.Format(http.TimeFormat),
+			expectedUpToDate: true,
+		},
+		"older local file": {
+			localModTime: lastModified.Add(-time.Hour),
+			lastModified: lastModified.Format(http.TimeFormat),
+		},
+		"missing Last-Modified": {
+			localModTime:     lastModified.Add(time.Hour),
+			expectedUpToDate: true,
+		},
+		"missing Last-Modified and local file": {},
+		"date passed by the runner": {
+			localModTime:       lastModified.Add(-time.Hour),
+			runnerLastModified: lastModified.Format(http.TimeFormat),
+		},
+		"date passed by the runner of an up to date cache": {
+			localModTime:       lastModified.Add(time.Hour),
+			runnerLastModified: lastModified.Format(http.TimeFormat),
+			expectedUpToDate:   true,
+		},
+		"date sent by the server preferred": {
+			localModTime:       lastModified.Add(-time.Hour),
+			lastModified:       lastModified.Format(http.TimeFormat),
+			runnerLastModified: lastModified.Add(-2 * time.Hour).Format(http.TimeFormat),
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			file, err := ioutil.TempFile("", "cache")
+			require.NoError(t, err)
+			require.NoError(t, file.Close())
+			defer os.Remove(file.Name())
+
+			if tt.localModTime.IsZero() {
+				require.NoError(t, os.Remove(file.Name()))
+			} else {
+				require.NoError(t, os.Chtimes(file.Name(), tt.localModTime, tt.localModTime))
+			}
+
+			resp := &http.Response{Header: http.Header{}}
+			if tt.lastModified != "" {
+				resp.Header.Set("Last-Modified", tt.lastModified)
+			}
+
+			upToDate, _ := checkIfUpToDate(file.Name(), resp, tt.runnerLastModified)
+			assert.Equal(t, tt.expectedUpToDate, upToDate)
+		})
+	}
+}
+
 func TestCacheExtractorRemoteServerFailOnInvalidServer(t *testing.T) {
 	removeHook := helpers.MakeFatalToPanic()
 	defer removeHook()
@@ -191,3 +338,78 @@ func TestCacheExtractorRemoteServerFailOnInvalidServer(t *testing.T) {
 	_, err := os.Stat(cacheExtractorTestArchivedFile)
 	assert.Error(t, err)
 }
+
+func TestCacheExtractorReportsResult(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(testServeCache))
+	defer ts.Close()
+
+	defer os.Remove(cacheExtractorArchive)
+	defer os.Remove(cacheExtractorTestArchivedFile)
+
+	tests := map[string]struct {
+		url            string
+		key            string
+		expectedPanic  bool
+		expectedResult string
+	}{
+		"hit": {
+			url:            ts.URL + "/cache.zip",
+			key:            "key",
+			expectedResult: `:{"operation":"extract","hit":true,"bytes":`,
+		},
+		"miss": {
+			url:            ts.URL + "/missing.zip",
+			key:            "key",
+			expectedPanic:  true,
+			expectedResult: `:{"operation":"extract","bytes":0,`,
+		},
+		"results not collected": {
+			url: ts.URL + "/cache.zip",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			os.Remove(cacheExtractorArchive)
+
+			oldKey, oldKeySet := os.LookupEnv(common.CacheResultKeyVariable)
+			defer func() {
+				if oldKeySet {
+					_ = os.Setenv(common.CacheResultKeyVariable, oldKey)
+					return
+				}
+				_ = os.Unsetenv(common.CacheResultKeyVariable)
+			}()
+			require.NoError(t, os.Setenv(common.CacheResultKeyVariable, tt.key))
+
+			output := new(bytes.Buffer)
+			oldCacheResultOutput := cacheResultOutput
+			cacheResultOutput = output
+			defer func() {
+				cacheResultOutput = oldCacheResultOutput
+			}()
+
+			removeHook := helpers.MakeFatalToPanic()
+			defer removeHook()
+
+			cmd := CacheExtractorCommand{
+				File: cacheExtractorArchive,
+				URL:  tt.url,
+			}
+
+			if tt.expectedPanic {
+				assert.Panics(t, func() { cmd.Execute(nil) })
+			} else {
+				assert.NotPanics(t, func() { cmd.Execute(nil) })
+			}
+
+			if tt.expectedResult == "" {
+				assert.Empty(t, output.String())
+				return
+			}
+
+			assert.True(t, strings.HasPrefix(output.String(), "cache_result:"))
+			assert.Contains(t, output.String(), tt.expectedResult)
+		})
+	}
+}
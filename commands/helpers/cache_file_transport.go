@@ -0,0 +1,169 @@
+package helpers
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// fileTransport handles the `file://` URLs generated by the filesystem cache
+// adapter, so the cache helpers can use a local directory the same way as
+// a remote cache server. Single byte ranges are supported, as used to
+// download the chunks from the chunk packs.
+type fileTransport struct{}
+
+func (t *fileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := fileURLPath(req.URL)
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		return t.get(req, path)
+	case http.MethodPut:
+		return t.put(req, path)
+	case http.MethodDelete:
+		return t.delete(req, path)
+	}
+
+	return newFileResponse(req, http.StatusMethodNotAllowed, nil), nil
+}
+
+func (t *fileTransport) get(req *http.Request, path string) (*http.Response, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return newFileResponse(req, http.StatusNotFound, nil), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := file.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		_ = file.Close()
+		return newFileResponse(req, http.StatusNotFound, nil), nil
+	}
+
+	status := http.StatusOK
+	offset, length := int64(0), fi.Size()
+
+	if rangeHeader := req.Header.Get("Range"); rangeHeader != "" && req.Method == http.MethodGet {
+		var end int64
+		_, err := fmt.Sscanf(rangeHeader, "bytes=%d-%d", &offset, &end)
+		if err != nil || offset < 0 || end < offset || offset >= fi.Size() {
+			_ = file.Close()
+			return newFileResponse(req, http.StatusRequestedRangeNotSatisfiable, nil), nil
+		}
+
+		if end >= fi.Size() {
+			end = fi.Size() - 1
+		}
+
+		status = http.StatusPartialContent
+		length = end - offset + 1
+	}
+
+	var body io.ReadCloser = file
+	if req.Method == http.MethodHead {
+		_ = file.Close()
+		body = nil
+	} else if status == http.StatusPartialContent {
+		body = &fileRange{Reader: io.NewSectionReader(file, offset, length), Closer: file}
+	}
+
+	resp := newFileResponse(req, status, body)
+	resp.ContentLength = length
+	resp.Header.Set("Content-Length", strconv.FormatInt(length, 10))
+	resp.Header.Set("Last-Modified", fi.ModTime().UTC().Format(http.TimeFormat))
+	if status == http.StatusPartialContent {
+		resp.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, fi.Size()))
+	}
+
+	return resp, nil
+}
+
+// fileRange reads a range of a file
+type fileRange struct {
+	io.Reader
+	io.Closer
+}
+
+// put stores the request body at the path. The body is written to
+// a temporary file first, so concurrent jobs never read a partial archive.
+func (t *fileTransport) put(req *http.Request, path string) (*http.Response, error) {
+	if req.Body != nil {
+		defer func() { _ = req.Body.Close() }()
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("creating cache directory: %w", err)
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(path), "cache")
+	if err != nil {
+		return nil, fmt.Errorf("creating cache file: %w", err)
+	}
+	defer func() { _ = os.Remove(file.Name()) }()
+
+	if req.Body != nil {
+		_, err = io.Copy(file, req.Body)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("writing cache file: %w", err)
+	}
+
+	err = os.Rename(file.Name(), path)
+	if err != nil {
+		return nil, fmt.Errorf("storing cache file: %w", err)
+	}
+
+	return newFileResponse(req, http.StatusOK, nil), nil
+}
+
+func (t *fileTransport) delete(req *http.Request, path string) (*http.Response, error) {
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return newFileResponse(req, http.StatusNotFound, nil), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("removing cache file: %w", err)
+	}
+
+	return newFileResponse(req, http.StatusNoContent, nil), nil
+}
+
+func newFileResponse(req *http.Request, status int, body io.ReadCloser) *http.Response {
+	if body == nil {
+		body = ioutil.NopCloser(strings.NewReader(""))
+	}
+
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Proto:      "HTTP/1.0",
+		ProtoMajor: 1,
+		Header:     make(http.Header),
+		Body:       body,
+		Request:    req,
+	}
+}
+
+func fileURLPath(u *url.URL) string {
+	path := u.Path
+
+	// file:///C:/path is the URL of a Windows path
+	if runtime.GOOS == "windows" && len(path) > 2 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+
+	return filepath.FromSlash(path)
+}
@@ -0,0 +1,154 @@
+package helpers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fileURL(path string) string {
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(path)}
+	if !strings.HasPrefix(u.Path, "/") {
+		u.Path = "/" + u.Path
+	}
+
+	return u.String()
+}
+
+func TestFileTransportRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache-file-transport")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	client := NewCacheClient(1)
+	objectURL := fileURL(filepath.Join(dir, "project", "1", "key"))
+
+	resp, err := client.Get(objectURL)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	req, err := http.NewRequest(http.MethodPut, objectURL, strings.NewReader("cache content"))
+	require.NoError(t, err)
+
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = client.Get(objectURL)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "13", resp.Header.Get("Content-Length"))
+
+	lastModified, err := time.Parse(http.TimeFormat, resp.Header.Get("Last-Modified"))
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), lastModified, time.Minute)
+
+	content, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "cache content", string(content))
+}
+
+func TestFileTransportRangeAndDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache-file-transport")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "pack")
+	require.NoError(t, ioutil.WriteFile(path, []byte("0123456789"), 0600))
+
+	client := NewCacheClient(1)
+
+	tests := map[string]struct {
+		rangeHeader          string
+		expectedStatus       int
+		expectedContentRange string
+		expectedContent      string
+	}{
+		"range": {
+			rangeHeader:          "bytes=2-5",
+			expectedStatus:       http.StatusPartialContent,
+			expectedContentRange: "bytes 2-5/10",
+			expectedContent:      "2345",
+		},
+		"range past the end": {
+			rangeHeader:          "bytes=8-20",
+			expectedStatus:       http.StatusPartialContent,
+			expectedContentRange: "bytes 8-9/10",
+			expectedContent:      "89",
+		},
+		"range after the end": {
+			rangeHeader:    "bytes=10-20",
+			expectedStatus: http.StatusRequestedRangeNotSatisfiable,
+		},
+		"invalid range": {
+			rangeHeader:    "bytes=5-2",
+			expectedStatus: http.StatusRequestedRangeNotSatisfiable,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, fileURL(path), nil)
+			require.NoError(t, err)
+			req.Header.Set("Range", tt.rangeHeader)
+
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			defer func() { _ = resp.Body.Close() }()
+
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+			assert.Equal(t, tt.expectedContentRange, resp.Header.Get("Content-Range"))
+
+			content, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedContent, string(content))
+		})
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, fileURL(path), nil)
+	require.NoError(t, err)
+
+	for _, expectedStatus := range []int{http.StatusNoContent, http.StatusNotFound} {
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, expectedStatus, resp.StatusCode)
+	}
+
+	assert.NoFileExists(t, path)
+}
+
+func TestCacheExtractorFromFileURL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache-file-transport")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	remote := filepath.Join(dir, "remote", "cache.zip")
+	require.NoError(t, os.MkdirAll(filepath.Dir(remote), 0755))
+	writeTestFile(t, remote)
+
+	local := filepath.Join(dir, "local", "cache.zip")
+	cmd := CacheExtractorCommand{
+		File: local,
+		URL:  fileURL(remote),
+	}
+	require.NoError(t, cmd.download(0))
+
+	expected, err := ioutil.ReadFile(remote)
+	require.NoError(t, err)
+	actual, err := ioutil.ReadFile(local)
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
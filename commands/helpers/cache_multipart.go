@@ -0,0 +1,693 @@
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
+	"gitlab.com/gitlab-org/gitlab-runner/commands/helpers/meter"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/encryption"
+)
+
+const (
+	s3MaxPartSize      = 5 * 1024 * 1024 * 1024
+	gcsChunkSize       = 256 * 1024
+	azureMaxBlockCount = 50000
+	azureMaxBlockSize  = 100 * 1024 * 1024
+)
+
+var errTooLargeForParts = errors.New("cache too large for the multipart upload")
+
+// part is a part of the uploaded file
+type part struct {
+	number int
+	offset int64
+	length int64
+	// size is the size of the whole file
+	size int64
+}
+
+// partUploader implements the multipart upload of a provider
+type partUploader interface {
+	// partSize returns the size of the parts of a file of the given size
+	partSize(size int64) (int64, error)
+	// resume returns the offset from which the upload continues, the
+	// uploaded offset being the end of the parts uploaded so far
+	resume(ctx context.Context, size int64, uploaded int64) (int64, error)
+	uploadPart(ctx context.Context, p part, r io.Reader) error
+	complete(ctx context.Context) error
+	abort(ctx context.Context) error
+}
+
+// multipartUpload uploads the cache in parts. The uploaded parts are kept
+// across the retries of the upload, which is resumed from the last one.
+type multipartUpload struct {
+	uploader partUploader
+
+	size     int64
+	partSize int64
+	uploaded int64
+}
+
+func newMultipartUpload(description string, client *CacheClient) (*multipartUpload, error) {
+	if description == "" {
+		return nil, nil
+	}
+
+	var upload cache.MultipartUpload
+	err := json.Unmarshal([]byte(description), &upload)
+	if err != nil {
+		return nil, fmt.Errorf("decoding the multipart upload: %w", err)
+	}
+
+	if upload.PartSize <= 0 {
+		return nil, fmt.Errorf("invalid multipart upload part size %d", upload.PartSize)
+	}
+
+	var uploader partUploader
+	switch upload.Type {
+	case cache.MultipartUploadS3:
+		if len(upload.PartURLs) == 0 || upload.CompleteURL == "" {
+			return nil, errors.New("missing S3 multipart upload URLs")
+		}
+		uploader = &s3PartUploader{MultipartUpload: &upload, client: client}
+	case cache.MultipartUploadGCS:
+		if upload.URL == "" {
+			return nil, errors.New("missing GCS resumable upload URL")
+		}
+		uploader = &gcsPartUploader{MultipartUpload: &upload, client: client}
+	case cache.MultipartUploadAzure:
+		if upload.URL == "" {
+			return nil, errors.New("missing Azure block blob URL")
+		}
+		uploader = &azurePartUploader{MultipartUpload: &upload, client: client}
+	default:
+		return nil, fmt.Errorf("unsupported multipart upload type %q", upload.Type)
+	}
+
+	return &multipartUpload{uploader: uploader}, nil
+}
+
+// upload uploads the remaining parts of the file of the given size, read
+// from the offset by the reader returned by newReader
+func (u *multipartUpload) upload(ctx context.Context, size int64, newReader func(offset int64) io.ReadCloser) error {
+	if u.partSize == 0 {
+		partSize, err := u.uploader.partSize(size)
+		if err != nil {
+			return err
+		}
+
+		u.size = size
+		u.partSize = partSize
+	}
+
+	if size != u.size {
+		return fmt.Errorf("the size of the uploaded file changed from %d to %d bytes", u.size, size)
+	}
+
+	offset, err := u.uploader.resume(ctx, size, u.uploaded)
+	if err != nil {
+		return err
+	}
+	u.uploaded = offset
+
+	r := newReader(offset)
+	defer func() { _ = r.Close() }()
+
+	for u.uploaded < size {
+		length := u.partSize
+		if size-u.uploaded < length {
+			length = size - u.uploaded
+		}
+
+		p := part{
+			number: int(u.uploaded / u.partSize),
+			offset: u.uploaded,
+			length: length,
+			size:   size,
+		}
+
+		err = u.uploader.uploadPart(ctx, p, io.LimitReader(r, length))
+		if err != nil {
+			return err
+		}
+
+		u.uploaded += length
+	}
+
+	return u.uploader.complete(ctx)
+}
+
+func (u *multipartUpload) abort(ctx context.Context) error {
+	return u.uploader.abort(ctx)
+}
+
+// uploadInParts uploads the archive in parts, resuming the upload of the
+// previous attempts
+func (c *CacheArchiverCommand) uploadInParts(retry int) error {
+	file, err := c.openMultipartFile()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	size := fi.Size()
+	if size == 0 {
+		logrus.Infoln("The archive is empty, uploading it in a single request")
+		c.abortUploadInParts()
+		c.multipart = nil
+
+		return c.upload(retry)
+	}
+
+	logrus.Infoln("Uploading", filepath.Base(c.archivePath()), "in parts")
+
+	err = c.multipart.upload(context.Background(), size, func(offset int64) io.ReadCloser {
+		if offset > 0 {
+			logrus.Infoln("Resuming the upload from", meter.FormatBytes(uint64(offset)))
+		}
+
+		return meter.NewReader(
+			ioutil.NopCloser(io.NewSectionReader(file, offset, size-offset)),
+			c.TransferMeterFrequency,
+			meter.LabelledRateFormat(os.Stdout, "Uploading cache", size-offset),
+		)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.uploaded += size
+
+	return nil
+}
+
+// openMultipartFile opens the file uploaded in parts. The encryption isn't
+// deterministic, so an encrypted archive is written once to a temporary file
+// uploaded by all the attempts.
+func (c *CacheArchiverCommand) openMultipartFile() (*os.File, error) {
+	if c.keyring == nil {
+		return os.Open(c.archivePath())
+	}
+
+	if c.encryptedArchive == "" {
+		name, err := c.encryptArchive()
+		if err != nil {
+			return nil, fmt.Errorf("encrypting the archive: %w", err)
+		}
+
+		c.encryptedArchive = name
+	}
+
+	return os.Open(c.encryptedArchive)
+}
+
+func (c *CacheArchiverCommand) encryptArchive() (string, error) {
+	src, err := os.Open(c.archivePath())
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = src.Close() }()
+
+	encrypter, err := encryption.NewEncrypter(src, c.keyring)
+	if err != nil {
+		return "", err
+	}
+
+	dst, err := ioutil.TempFile(filepath.Dir(c.archivePath()), "encrypted_")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(dst, encrypter)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(dst.Name())
+		return "", err
+	}
+
+	return dst.Name(), nil
+}
+
+// finishUploadInParts removes the encrypted archive, and aborts the multipart
+// upload when it failed
+func (c *CacheArchiverCommand) finishUploadInParts(err error) {
+	if c.encryptedArchive != "" {
+		_ = os.Remove(c.encryptedArchive)
+		c.encryptedArchive = ""
+	}
+
+	if err != nil {
+		c.abortUploadInParts()
+	}
+}
+
+func (c *CacheArchiverCommand) abortUploadInParts() {
+	if c.multipart == nil {
+		return
+	}
+
+	err := c.multipart.abort(context.Background())
+	if err != nil {
+		logrus.WithError(err).Warningln("Failed to abort the multipart upload")
+	}
+}
+
+// send sends the request, returning a retryable error on network and server
+// errors
+func send(client *CacheClient, req *http.Request) (*http.Response, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, retryableErr{err: err}
+	}
+
+	return resp, nil
+}
+
+func newPartRequest(ctx context.Context, method string, u string, r io.Reader, length int64) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, ioutil.NopCloser(r))
+	if err != nil {
+		return nil, err
+	}
+
+	req.ContentLength = length
+	if length == 0 {
+		req.Body = http.NoBody
+	}
+
+	return req, nil
+}
+
+// s3PartUploader uploads the parts to their pre-signed URLs, and completes
+// the upload with their ETags
+type s3PartUploader struct {
+	*cache.MultipartUpload
+	client *CacheClient
+
+	etags []string
+}
+
+type s3CompletedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+type s3CompleteMultipartUpload struct {
+	XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletedPart `xml:"Part"`
+}
+
+func (s *s3PartUploader) partSize(size int64) (int64, error) {
+	partSize := s.PartSize
+	parts := int64(len(s.PartURLs))
+	if minPartSize := (size + parts - 1) / parts; minPartSize > partSize {
+		partSize = minPartSize
+	}
+
+	if partSize > s3MaxPartSize {
+		return 0, errTooLargeForParts
+	}
+
+	return partSize, nil
+}
+
+func (s *s3PartUploader) resume(_ context.Context, _ int64, uploaded int64) (int64, error) {
+	return uploaded, nil
+}
+
+func (s *s3PartUploader) uploadPart(ctx context.Context, p part, r io.Reader) error {
+	req, err := newPartRequest(ctx, http.MethodPut, s.PartURLs[p.number], r, p.length)
+	if err != nil {
+		return err
+	}
+
+	resp, err := send(s.client, req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	err = retryOnServerError(resp)
+	if err != nil {
+		return err
+	}
+
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		return fmt.Errorf("missing ETag of the part %d", p.number+1)
+	}
+
+	s.etags = append(s.etags[:p.number], etag)
+
+	return nil
+}
+
+func (s *s3PartUploader) complete(ctx context.Context) error {
+	body := s3CompleteMultipartUpload{}
+	for i, etag := range s.etags {
+		body.Parts = append(body.Parts, s3CompletedPart{PartNumber: i + 1, ETag: etag})
+	}
+
+	data, err := xml.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.CompleteURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+
+	resp, err := send(s.client, req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	err = retryOnServerError(resp)
+	if err != nil {
+		return err
+	}
+
+	// S3 can report an error after the response status was sent
+	data, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return retryableErr{err: err}
+	}
+
+	if bytes.Contains(data, []byte("<Error>")) {
+		return retryableErr{err: fmt.Errorf("completing the multipart upload: %s", data)}
+	}
+
+	return nil
+}
+
+func (s *s3PartUploader) abort(ctx context.Context) error {
+	if s.AbortURL == "" {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.AbortURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := send(s.client, req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	return retryOnServerError(resp)
+}
+
+// gcsPartUploader starts a resumable upload session and uploads the parts to
+// it. The session reports how much data it persisted, from which the upload is
+// resumed.
+type gcsPartUploader struct {
+	*cache.MultipartUpload
+	client *CacheClient
+
+	session string
+}
+
+func (g *gcsPartUploader) partSize(int64) (int64, error) {
+	return (g.PartSize + gcsChunkSize - 1) / gcsChunkSize * gcsChunkSize, nil
+}
+
+func (g *gcsPartUploader) resume(ctx context.Context, size int64, _ int64) (int64, error) {
+	if g.session != "" {
+		offset, err := g.persistedOffset(ctx, size)
+		if err == nil || !errors.Is(err, errSessionExpired) {
+			return offset, err
+		}
+	}
+
+	return 0, g.startSession(ctx)
+}
+
+var errSessionExpired = errors.New("upload session expired")
+
+func (g *gcsPartUploader) startSession(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.URL, nil)
+	if err != nil {
+		return err
+	}
+
+	for key, values := range g.Headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	resp, err := send(g.client, req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	err = retryOnServerError(resp)
+	if err != nil {
+		return err
+	}
+
+	g.session = resp.Header.Get("Location")
+	if g.session == "" {
+		return errors.New("missing location of the upload session")
+	}
+
+	return nil
+}
+
+// persistedOffset queries the amount of data persisted by the session
+func (g *gcsPartUploader) persistedOffset(ctx context.Context, size int64) (int64, error) {
+	req, err := newPartRequest(ctx, http.MethodPut, g.session, nil, 0)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+
+	resp, err := send(g.client, req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return size, nil
+	case http.StatusPermanentRedirect:
+		return persistedRange(resp)
+	case http.StatusNotFound, http.StatusGone:
+		return 0, errSessionExpired
+	}
+
+	return 0, retryOnServerError(resp)
+}
+
+// persistedRange returns the end of the range persisted by the session, the
+// offset from which the upload continues
+func persistedRange(resp *http.Response) (int64, error) {
+	r := resp.Header.Get("Range")
+	if r == "" {
+		return 0, nil
+	}
+
+	end, err := strconv.ParseInt(r[strings.LastIndex(r, "-")+1:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid persisted range %q", r)
+	}
+
+	return end + 1, nil
+}
+
+func (g *gcsPartUploader) uploadPart(ctx context.Context, p part, r io.Reader) error {
+	req, err := newPartRequest(ctx, http.MethodPut, g.session, r, p.length)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", p.offset, p.offset+p.length-1, p.size))
+
+	resp, err := send(g.client, req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusPermanentRedirect {
+		return retryOnServerError(resp)
+	}
+
+	persisted, err := persistedRange(resp)
+	if err != nil {
+		return err
+	}
+
+	// The rest of the part is uploaded again when the upload is resumed
+	if persisted != p.offset+p.length {
+		return retryableErr{err: fmt.Errorf("only %d bytes of the part %d persisted", persisted-p.offset, p.number+1)}
+	}
+
+	return nil
+}
+
+// complete does nothing, the last part completes the upload
+func (g *gcsPartUploader) complete(context.Context) error {
+	return nil
+}
+
+func (g *gcsPartUploader) abort(ctx context.Context) error {
+	if g.session == "" {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, g.session, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := send(g.client, req)
+	if err != nil {
+		return err
+	}
+
+	// The session answers 499 once it's canceled
+	_ = resp.Body.Close()
+
+	return nil
+}
+
+// azurePartUploader uploads the parts as the blocks of a block blob, and
+// commits the list of the blocks
+type azurePartUploader struct {
+	*cache.MultipartUpload
+	client *CacheClient
+
+	blocks []string
+}
+
+type azureBlockList struct {
+	XMLName xml.Name `xml:"BlockList"`
+	Latest  []string `xml:"Latest"`
+}
+
+func (a *azurePartUploader) partSize(size int64) (int64, error) {
+	partSize := a.PartSize
+	if minPartSize := (size + azureMaxBlockCount - 1) / azureMaxBlockCount; minPartSize > partSize {
+		partSize = minPartSize
+	}
+
+	if partSize > azureMaxBlockSize {
+		return 0, errTooLargeForParts
+	}
+
+	return partSize, nil
+}
+
+func (a *azurePartUploader) resume(_ context.Context, _ int64, uploaded int64) (int64, error) {
+	return uploaded, nil
+}
+
+func (a *azurePartUploader) blobURL(params url.Values) (string, error) {
+	u, err := url.Parse(a.URL)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+func (a *azurePartUploader) uploadPart(ctx context.Context, p part, r io.Reader) error {
+	// The IDs of the blocks of a blob must have the same length
+	blockID := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%06d", p.number)))
+
+	u, err := a.blobURL(url.Values{"comp": []string{"block"}, "blockid": []string{blockID}})
+	if err != nil {
+		return err
+	}
+
+	req, err := newPartRequest(ctx, http.MethodPut, u, r, p.length)
+	if err != nil {
+		return err
+	}
+
+	resp, err := send(a.client, req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	err = retryOnServerError(resp)
+	if err != nil {
+		return err
+	}
+
+	a.blocks = append(a.blocks[:p.number], blockID)
+
+	return nil
+}
+
+func (a *azurePartUploader) complete(ctx context.Context) error {
+	data, err := xml.Marshal(azureBlockList{Latest: a.blocks})
+	if err != nil {
+		return err
+	}
+
+	u, err := a.blobURL(url.Values{"comp": []string{"blocklist"}})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	for key, values := range a.Headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	resp, err := send(a.client, req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	return retryOnServerError(resp)
+}
+
+// abort does nothing, the uncommitted blocks are removed by Azure
+func (a *azurePartUploader) abort(context.Context) error {
+	return nil
+}
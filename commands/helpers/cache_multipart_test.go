@@ -0,0 +1,471 @@
+package helpers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/encryption"
+)
+
+const testPartSize = 256 * 1024
+
+// fakeStorage stores the parts uploaded by the multipart uploads, failing
+// the upload of a part once
+type fakeStorage struct {
+	t *testing.T
+
+	lock     sync.Mutex
+	parts    map[int][]byte
+	requests map[int]int
+	object   []byte
+	headers  http.Header
+	failPart int
+	failed   bool
+	aborts   int
+	// session is the data persisted by the GCS upload session
+	session []byte
+}
+
+func newFakeStorage(t *testing.T, failPart int) *fakeStorage {
+	return &fakeStorage{
+		t:        t,
+		parts:    map[int][]byte{},
+		requests: map[int]int{},
+		failPart: failPart,
+	}
+}
+
+// fail reports whether the upload of the part fails
+func (s *fakeStorage) fail(part int) bool {
+	s.requests[part]++
+	if part != s.failPart || s.failed {
+		return false
+	}
+
+	s.failed = true
+	return true
+}
+
+func (s *fakeStorage) assemble() {
+	var numbers []int
+	for number := range s.parts {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+
+	s.object = nil
+	for _, number := range numbers {
+		s.object = append(s.object, s.parts[number]...)
+	}
+}
+
+func (s *fakeStorage) serveS3(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	body, err := ioutil.ReadAll(r.Body)
+	require.NoError(s.t, err)
+
+	switch {
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/part/"):
+		number, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/part/"))
+		require.NoError(s.t, err)
+
+		if s.fail(number) {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		s.parts[number] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, number))
+
+	case r.Method == http.MethodPost && r.URL.Path == "/complete":
+		var complete s3CompleteMultipartUpload
+		require.NoError(s.t, xml.Unmarshal(body, &complete))
+		require.Len(s.t, complete.Parts, len(s.parts))
+		for i, p := range complete.Parts {
+			assert.Equal(s.t, i+1, p.PartNumber)
+			assert.Equal(s.t, fmt.Sprintf(`"etag-%d"`, i+1), p.ETag)
+		}
+
+		s.assemble()
+		_, _ = w.Write([]byte("<CompleteMultipartUploadResult/>"))
+
+	case r.Method == http.MethodDelete && r.URL.Path == "/abort":
+		s.parts = map[int][]byte{}
+		s.aborts++
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (s *fakeStorage) serveGCS(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	body, err := ioutil.ReadAll(r.Body)
+	require.NoError(s.t, err)
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/start":
+		assert.Equal(s.t, "start", r.Header.Get("x-goog-resumable"))
+		s.headers = r.Header
+		w.Header().Set("Location", "http://"+r.Host+"/session")
+		w.WriteHeader(http.StatusCreated)
+
+	case r.Method == http.MethodPut && r.URL.Path == "/session":
+		var start, end, size int64
+		contentRange := r.Header.Get("Content-Range")
+		if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &size); err == nil {
+			require.Equal(s.t, int64(len(s.session)), start, "the chunks are sent in order")
+
+			// The second chunk is persisted only partially once
+			if s.fail(int(start/testPartSize) + 1) {
+				body = body[:len(body)/2]
+			}
+			s.session = append(s.session, body...)
+		}
+
+		if int64(len(s.session)) == size && size > 0 {
+			s.object = s.session
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if len(s.session) > 0 {
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(s.session)-1))
+		}
+		w.WriteHeader(http.StatusPermanentRedirect)
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (s *fakeStorage) serveAzure(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	body, err := ioutil.ReadAll(r.Body)
+	require.NoError(s.t, err)
+	assert.Equal(s.t, "token", r.URL.Query().Get("sig"))
+
+	switch r.URL.Query().Get("comp") {
+	case "block":
+		blockID, err := base64.StdEncoding.DecodeString(r.URL.Query().Get("blockid"))
+		require.NoError(s.t, err)
+
+		number, err := strconv.Atoi(string(blockID))
+		require.NoError(s.t, err)
+
+		if s.fail(number + 1) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		s.parts[number] = body
+		w.WriteHeader(http.StatusCreated)
+
+	case "blocklist":
+		var list azureBlockList
+		require.NoError(s.t, xml.Unmarshal(body, &list))
+		require.Len(s.t, list.Latest, len(s.parts))
+
+		s.headers = r.Header
+		s.assemble()
+		w.WriteHeader(http.StatusCreated)
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestCacheArchiverUploadsInParts(t *testing.T) {
+	tests := map[string]struct {
+		serve           func(s *fakeStorage) http.HandlerFunc
+		upload          func(url string) *cache.MultipartUpload
+		size            int
+		expectedHeaders http.Header
+	}{
+		"s3": {
+			serve: func(s *fakeStorage) http.HandlerFunc { return s.serveS3 },
+			upload: func(url string) *cache.MultipartUpload {
+				upload := &cache.MultipartUpload{
+					Type:        cache.MultipartUploadS3,
+					PartSize:    testPartSize,
+					CompleteURL: url + "/complete",
+					AbortURL:    url + "/abort",
+				}
+				for i := 1; i <= 16; i++ {
+					upload.PartURLs = append(upload.PartURLs, fmt.Sprintf("%s/part/%d", url, i))
+				}
+				return upload
+			},
+			size: 3*testPartSize + 100,
+		},
+		"gcs": {
+			serve: func(s *fakeStorage) http.HandlerFunc { return s.serveGCS },
+			upload: func(url string) *cache.MultipartUpload {
+				return &cache.MultipartUpload{
+					Type:     cache.MultipartUploadGCS,
+					PartSize: testPartSize - 1,
+					URL:      url + "/start",
+					Headers:  http.Header{"X-Goog-Resumable": []string{"start"}, "X-Goog-Meta-Key": []string{"v1"}},
+				}
+			},
+			size:            3*testPartSize + 100,
+			expectedHeaders: http.Header{"X-Goog-Meta-Key": []string{"v1"}},
+		},
+		"azure": {
+			serve: func(s *fakeStorage) http.HandlerFunc { return s.serveAzure },
+			upload: func(url string) *cache.MultipartUpload {
+				return &cache.MultipartUpload{
+					Type:     cache.MultipartUploadAzure,
+					PartSize: testPartSize,
+					URL:      url + "/container/cache.zip?sig=token",
+					Headers:  http.Header{"X-Ms-Meta-Key": []string{"v1"}},
+				}
+			},
+			size:            3*testPartSize + 100,
+			expectedHeaders: http.Header{"X-Ms-Meta-Key": []string{"v1"}},
+		},
+	}
+
+	for tn, tt := range tests {
+		for _, encrypted := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s encrypted=%v", tn, encrypted), func(t *testing.T) {
+				storage := newFakeStorage(t, 2)
+				ts := httptest.NewServer(tt.serve(storage))
+				defer ts.Close()
+
+				dir, err := ioutil.TempDir("", "cache-multipart")
+				require.NoError(t, err)
+				defer os.RemoveAll(dir)
+
+				data := make([]byte, tt.size)
+				_, err = rand.Read(data)
+				require.NoError(t, err)
+
+				file := filepath.Join(dir, "cache.zip")
+				require.NoError(t, ioutil.WriteFile(file, data, 0600))
+
+				description, err := json.Marshal(tt.upload(ts.URL))
+				require.NoError(t, err)
+
+				cmd := CacheArchiverCommand{
+					File:        file,
+					URL:         ts.URL + "/single",
+					retryHelper: retryHelper{Retry: 1},
+				}
+
+				if encrypted {
+					cmd.keyring, err = encryption.ParseKeyring(testEncryptionKeys)
+					require.NoError(t, err)
+				}
+
+				cmd.multipart, err = newMultipartUpload(string(description), cmd.getClient())
+				require.NoError(t, err)
+
+				err = cmd.doRetry(cmd.upload)
+				cmd.finishUploadInParts(err)
+				require.NoError(t, err)
+
+				uploaded := storage.object
+				if encrypted {
+					decrypter, err := encryption.NewDecrypter(bytes.NewReader(uploaded), cmd.keyring)
+					require.NoError(t, err)
+
+					uploaded, err = ioutil.ReadAll(decrypter)
+					require.NoError(t, err)
+				}
+
+				assert.Equal(t, data, uploaded)
+				assert.Equal(t, int64(len(storage.object)), cmd.uploaded)
+				assert.Equal(t, 1, storage.requests[1], "the first part is uploaded once")
+				assert.Equal(t, 2, storage.requests[2], "the failed part is uploaded again")
+
+				for key := range tt.expectedHeaders {
+					assert.Equal(t, tt.expectedHeaders.Get(key), storage.headers.Get(key))
+				}
+
+				files, err := ioutil.ReadDir(dir)
+				require.NoError(t, err)
+				assert.Len(t, files, 1, "the encrypted archive is removed")
+			})
+		}
+	}
+}
+
+func TestCacheArchiverAbortsFailedUploadInParts(t *testing.T) {
+	storage := newFakeStorage(t, 2)
+	ts := httptest.NewServer(http.HandlerFunc(storage.serveS3))
+	defer ts.Close()
+
+	file, err := ioutil.TempFile("", "cache-multipart")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(bytes.Repeat([]byte("cache"), testPartSize))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	description, err := json.Marshal(&cache.MultipartUpload{
+		Type:        cache.MultipartUploadS3,
+		PartSize:    testPartSize,
+		PartURLs:    []string{ts.URL + "/part/1", ts.URL + "/part/2"},
+		CompleteURL: ts.URL + "/complete",
+		AbortURL:    ts.URL + "/abort",
+	})
+	require.NoError(t, err)
+
+	cmd := CacheArchiverCommand{File: file.Name(), URL: ts.URL + "/single"}
+	cmd.multipart, err = newMultipartUpload(string(description), cmd.getClient())
+	require.NoError(t, err)
+
+	err = cmd.doRetry(cmd.upload)
+	cmd.finishUploadInParts(err)
+	assert.Error(t, err)
+	assert.Equal(t, 1, storage.requests[1])
+	assert.Empty(t, storage.parts, "the upload is aborted")
+}
+
+func TestCacheArchiverAbortsUploadInPartsOfUpToDateArchive(t *testing.T) {
+	storage := newFakeStorage(t, 0)
+	ts := httptest.NewServer(http.HandlerFunc(storage.serveS3))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "cache-multipart")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	wd := filepath.Join(dir, "wd")
+	require.NoError(t, os.Mkdir(wd, 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(wd, "cached"), []byte("cache"), 0600))
+
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(wd))
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	// the archive is newer than the cached file
+	file := filepath.Join(dir, "cache.zip")
+	require.NoError(t, ioutil.WriteFile(file, []byte("archive"), 0600))
+	future := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(file, future, future))
+
+	description, err := json.Marshal(&cache.MultipartUpload{
+		Type:        cache.MultipartUploadS3,
+		PartSize:    testPartSize,
+		PartURLs:    []string{ts.URL + "/part/1"},
+		CompleteURL: ts.URL + "/complete",
+		AbortURL:    ts.URL + "/abort",
+	})
+	require.NoError(t, err)
+
+	cmd := CacheArchiverCommand{
+		File:            file,
+		URL:             ts.URL + "/single",
+		MultipartUpload: string(description),
+		fileArchiver:    fileArchiver{Paths: []string{"cached"}},
+	}
+	cmd.Execute(nil)
+
+	assert.Equal(t, 1, storage.aborts, "the upload is aborted")
+	assert.Empty(t, storage.requests, "no part is uploaded")
+}
+
+func TestMultipartUploadPartSize(t *testing.T) {
+	tests := map[string]struct {
+		upload           cache.MultipartUpload
+		size             int64
+		expectedPartSize int64
+		expectedErr      error
+	}{
+		"s3 minimum part size": {
+			upload:           cache.MultipartUpload{Type: cache.MultipartUploadS3, PartSize: 100, PartURLs: make([]string, 4)},
+			size:             200,
+			expectedPartSize: 100,
+		},
+		"s3 parts bigger than the minimum": {
+			upload:           cache.MultipartUpload{Type: cache.MultipartUploadS3, PartSize: 100, PartURLs: make([]string, 4)},
+			size:             1001,
+			expectedPartSize: 251,
+		},
+		"s3 too large": {
+			upload:      cache.MultipartUpload{Type: cache.MultipartUploadS3, PartSize: 100, PartURLs: make([]string, 2)},
+			size:        2*s3MaxPartSize + 1,
+			expectedErr: errTooLargeForParts,
+		},
+		"gcs chunks": {
+			upload:           cache.MultipartUpload{Type: cache.MultipartUploadGCS, PartSize: gcsChunkSize + 1},
+			size:             1000,
+			expectedPartSize: 2 * gcsChunkSize,
+		},
+		"azure blocks": {
+			upload:           cache.MultipartUpload{Type: cache.MultipartUploadAzure, PartSize: 100},
+			size:             azureMaxBlockCount*200 + 1,
+			expectedPartSize: 201,
+		},
+		"azure too large": {
+			upload:      cache.MultipartUpload{Type: cache.MultipartUploadAzure, PartSize: 100},
+			size:        azureMaxBlockCount*azureMaxBlockSize + 1,
+			expectedErr: errTooLargeForParts,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			tt.upload.CompleteURL = "http://example.com/complete"
+			tt.upload.URL = "http://example.com/upload"
+
+			description, err := json.Marshal(tt.upload)
+			require.NoError(t, err)
+
+			upload, err := newMultipartUpload(string(description), nil)
+			require.NoError(t, err)
+
+			partSize, err := upload.uploader.partSize(tt.size)
+			assert.Equal(t, tt.expectedErr, err)
+			assert.Equal(t, tt.expectedPartSize, partSize)
+		})
+	}
+}
+
+func TestNewMultipartUploadErrors(t *testing.T) {
+	tests := map[string]string{
+		"invalid JSON":      "{",
+		"missing part size": `{"type":"gcs","url":"http://example.com"}`,
+		"unsupported type":  `{"type":"ftp","part_size":1}`,
+		"missing S3 URLs":   `{"type":"s3","part_size":1}`,
+		"missing GCS URL":   `{"type":"gcs","part_size":1}`,
+		"missing Azure URL": `{"type":"azure","part_size":1}`,
+	}
+
+	for tn, description := range tests {
+		t.Run(tn, func(t *testing.T) {
+			_, err := newMultipartUpload(description, nil)
+			assert.Error(t, err)
+		})
+	}
+}
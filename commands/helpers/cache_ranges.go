@@ -0,0 +1,185 @@
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// downloadRangeSize is the size of the ranges of the caches downloaded with
+// parallel range requests. The caches not bigger than one range are
+// downloaded with a single request.
+const downloadRangeSize = 8 * 1024 * 1024
+
+// rangeReader downloads a cache with parallel range requests, and returns
+// the ranges in order. The first range is read from the response of the
+// initial request, and the number of the ranges kept in memory is limited by
+// the concurrency.
+type rangeReader struct {
+	size   int64
+	cancel context.CancelFunc
+
+	ranges  chan chan rangeResult
+	current io.Reader
+}
+
+type rangeResult struct {
+	r   io.Reader
+	err error
+}
+
+// newRangeReader returns the reader of the cache whose first range was
+// returned by the partial content response. The header is sent with the
+// requests of the other ranges.
+func newRangeReader(
+	client *CacheClient,
+	url string,
+	header http.Header,
+	resp *http.Response,
+	concurrency int,
+) (*rangeReader, error) {
+	end, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r := &rangeReader{
+		size:   size,
+		cancel: cancel,
+		ranges: make(chan chan rangeResult, concurrency),
+	}
+
+	first := make(chan rangeResult, 1)
+	first <- rangeResult{r: resp.Body}
+	r.ranges <- first
+
+	// The other ranges must come from the same version of the cache
+	go r.fetchAll(ctx, client, url, header, resp.Header.Get("ETag"), end+1)
+
+	return r, nil
+}
+
+// parseContentRange returns the end of the range and the size of the
+// object of a Content-Range header, e.g. "bytes 0-1023/4096"
+func parseContentRange(contentRange string) (int64, int64, error) {
+	var start, end, size int64
+
+	_, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &size)
+	if err != nil || start != 0 || end >= size {
+		return 0, 0, fmt.Errorf("invalid content range %q", contentRange)
+	}
+
+	return end, size, nil
+}
+
+func (r *rangeReader) fetchAll(
+	ctx context.Context,
+	client *CacheClient,
+	url string,
+	header http.Header,
+	etag string,
+	offset int64,
+) {
+	defer close(r.ranges)
+
+	for ; offset < r.size; offset += downloadRangeSize {
+		length := r.size - offset
+		if length > downloadRangeSize {
+			length = downloadRangeSize
+		}
+
+		result := make(chan rangeResult, 1)
+
+		select {
+		case r.ranges <- result:
+		case <-ctx.Done():
+			return
+		}
+
+		go func(offset int64, length int64) {
+			data, err := fetchRange(ctx, client, url, header, etag, offset, length)
+			result <- rangeResult{r: bytes.NewReader(data), err: err}
+		}(offset, length)
+	}
+}
+
+func fetchRange(
+	ctx context.Context,
+	client *CacheClient,
+	url string,
+	header http.Header,
+	etag string,
+	offset int64,
+	length int64,
+) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header = header.Clone()
+
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("downloading range %s: received: %s", req.Header.Get("Range"), resp.Status)
+	}
+
+	data := make([]byte, length)
+	_, err = io.ReadFull(resp.Body, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			result, ok := <-r.ranges
+			if !ok {
+				return 0, io.EOF
+			}
+
+			res := <-result
+			if res.err != nil {
+				return 0, res.err
+			}
+
+			r.current = res.r
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current = nil
+			if n == 0 {
+				continue
+			}
+
+			err = nil
+		}
+
+		return n, err
+	}
+}
+
+// Close cancels the range requests in progress
+func (r *rangeReader) Close() error {
+	r.cancel()
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package helpers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := map[string]struct {
+		contentRange string
+		expectedEnd  int64
+		expectedSize int64
+		expectedErr  bool
+	}{
+		"first range": {
+			contentRange: "bytes 0-1023/4096",
+			expectedEnd:  1023,
+			expectedSize: 4096,
+		},
+		"whole object": {
+			contentRange: "bytes 0-4095/4096",
+			expectedEnd:  4095,
+			expectedSize: 4096,
+		},
+		"not the first range": {
+			contentRange: "bytes 1024-2047/4096",
+			expectedErr:  true,
+		},
+		"unknown size": {
+			contentRange: "bytes 0-1023/*",
+			expectedErr:  true,
+		},
+		"missing": {
+			expectedErr: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			end, size, err := parseContentRange(tt.contentRange)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedEnd, end)
+			assert.Equal(t, tt.expectedSize, size)
+		})
+	}
+}
+
+func TestRangeReaderCacheChanged(t *testing.T) {
+	original := bytes.Repeat([]byte("1"), 3*downloadRangeSize)
+	updated := bytes.Repeat([]byte("2"), 3*downloadRangeSize)
+
+	var lock sync.Mutex
+	requests := 0
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests++
+		content, etag := original, `"v1"`
+		// The cache is replaced after the first request
+		if requests > 1 {
+			content, etag = updated, `"v2"`
+		}
+		lock.Unlock()
+
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "cache", time.Now(), bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	cmd := CacheExtractorCommand{
+		URL:                 ts.URL + "/cache.zip",
+		DownloadConcurrency: 2,
+	}
+
+	resp, err := cmd.getCache()
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+
+	r, err := cmd.getCacheReader(resp)
+	require.NoError(t, err)
+	defer r.Close()
+
+	_, err = ioutil.ReadAll(r)
+	assert.EqualError(t, err, "downloading range bytes=8388608-16777215: received: 412 Precondition Failed")
+}
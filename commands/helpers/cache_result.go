@@ -0,0 +1,32 @@
+package helpers
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+// cacheResultOutput receives the results of the cache commands. They're
+// picked up from the job output by the runner for its metrics.
+var cacheResultOutput io.Writer = os.Stdout
+
+// reportCacheResult reports the result signed with the key passed by the
+// runner. Nothing is reported when the runner doesn't collect the results.
+func reportCacheResult(operation string, hit bool, bytes int64, started time.Time) {
+	key := os.Getenv(common.CacheResultKeyVariable)
+	if key == "" {
+		return
+	}
+
+	result := common.CacheResult{
+		Operation: operation,
+		Hit:       hit,
+		Bytes:     bytes,
+		Duration:  time.Since(started),
+	}
+
+	_, _ = fmt.Fprint(cacheResultOutput, result.Line(key))
+}
@@ -0,0 +1,126 @@
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-runner/commands/helpers/archive"
+)
+
+// streamExtractor extracts the downloaded cache while it's written to the
+// local archive. The format of the cache is detected from its first bytes,
+// and the formats needing random access, like zip whose file modes are
+// stored at the end of the archive, are extracted once downloaded.
+type streamExtractor struct {
+	dir    string
+	header []byte
+
+	pw          *io.PipeWriter
+	done        chan error
+	writeFailed bool
+	skipped     bool
+}
+
+func newStreamExtractor(dir string) *streamExtractor {
+	return &streamExtractor{dir: dir}
+}
+
+func (s *streamExtractor) Write(p []byte) (int, error) {
+	n := len(p)
+	if s.skipped {
+		return n, nil
+	}
+
+	if s.pw == nil {
+		missing := len(zstdMagic) - len(s.header)
+		if missing > len(p) {
+			missing = len(p)
+		}
+
+		s.header = append(s.header, p[:missing]...)
+		p = p[missing:]
+
+		if len(s.header) < len(zstdMagic) {
+			return n, nil
+		}
+
+		if !s.start() {
+			return n, nil
+		}
+
+		p = append(s.header, p...)
+	}
+
+	_, err := s.pw.Write(p)
+	if err != nil {
+		s.writeFailed = true
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// start starts the extraction of the stream, and returns whether the format
+// of the cache can be extracted as a stream
+func (s *streamExtractor) start() bool {
+	format := detectArchiveFormat(bytes.NewReader(s.header))
+
+	pr, pw := io.Pipe()
+
+	extractor, err := archive.NewStreamExtractor(format, pr, s.dir)
+	if err != nil {
+		if !errors.Is(err, archive.ErrUnsupportedArchiveFormat) {
+			logrus.WithError(err).Warningln("Extracting the cache once it's downloaded")
+		}
+
+		s.skipped = true
+		return false
+	}
+
+	logrus.Infoln("Extracting the cache while it's downloaded")
+
+	s.pw = pw
+	s.done = make(chan error, 1)
+
+	go func() {
+		err := extractor.Extract(context.Background())
+		if err == nil {
+			// The end of the compressed stream can follow the end of the
+			// archive
+			_, err = io.Copy(ioutil.Discard, pr)
+		}
+
+		_ = pr.CloseWithError(err)
+		s.done <- err
+	}()
+
+	return true
+}
+
+// finish waits for the end of the extraction, which is stopped when the
+// download failed with downloadErr. Extraction errors aren't retried.
+func (s *streamExtractor) finish(downloadErr error) error {
+	if s.pw == nil {
+		return downloadErr
+	}
+
+	_ = s.pw.CloseWithError(downloadErr)
+
+	err := <-s.done
+	if err != nil && (downloadErr == nil || s.writeFailed) {
+		return fmt.Errorf("extracting cache: %w", err)
+	}
+
+	return downloadErr
+}
+
+// extracted reports whether the cache was extracted as a stream
+func (s *streamExtractor) extracted() bool {
+	return s.pw != nil
+}
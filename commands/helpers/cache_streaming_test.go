@@ -0,0 +1,283 @@
+package helpers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/commands/helpers/archive"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/encryption"
+)
+
+// testCacheServer serves a cache supporting range requests, failing the
+// first request once when failOnce is set
+type testCacheServer struct {
+	content  []byte
+	modTime  time.Time
+	failOnce bool
+
+	lock     sync.Mutex
+	requests int
+	ranged   int
+}
+
+func (s *testCacheServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	s.requests++
+	if r.Header.Get("Range") != "" {
+		s.ranged++
+	}
+	fail := s.failOnce
+	s.failOnce = false
+	s.lock.Unlock()
+
+	if fail {
+		// The connection is closed in the middle of the cache
+		w.Header().Set("Content-Length", strconv.Itoa(len(s.content)))
+		_, _ = w.Write(s.content[:len(s.content)/2])
+		panic(http.ErrAbortHandler)
+	}
+
+	// Like S3, the ranges of an empty cache can't be satisfied
+	if len(s.content) == 0 && r.Header.Get("Range") != "" {
+		w.Header().Set("Content-Range", "bytes */0")
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	w.Header().Set("ETag", `"v1"`)
+	http.ServeContent(w, r, "cache", s.modTime, bytes.NewReader(s.content))
+}
+
+// createTestArchive returns an archive of a file of random content bigger
+// than the ranges downloaded in parallel
+func createTestArchive(t *testing.T, format archive.Format) ([]byte, []byte) {
+	dir, err := ioutil.TempDir("", "cache-streaming-src")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	content := make([]byte, 2*downloadRangeSize+100)
+	_, err = rand.Read(content)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "file"), content, 0600))
+
+	buf := new(bytes.Buffer)
+	if format == archive.Zip {
+		writer := zip.NewWriter(buf)
+		w, err := writer.Create("file")
+		require.NoError(t, err)
+		_, err = w.Write(content)
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+
+		return content, buf.Bytes()
+	}
+
+	fi, err := os.Lstat(filepath.Join(dir, "file"))
+	require.NoError(t, err)
+
+	archiver, err := archive.NewArchiver(format, buf, dir, archive.FastestCompression)
+	require.NoError(t, err)
+	require.NoError(t, archiver.Archive(context.Background(), map[string]os.FileInfo{"file": fi}))
+
+	return content, buf.Bytes()
+}
+
+func TestCacheExtractorDownloadModes(t *testing.T) {
+	keyring, err := encryption.ParseKeyring(testEncryptionKeys)
+	require.NoError(t, err)
+
+	archives := make(map[archive.Format][]byte)
+	contents := make(map[archive.Format][]byte)
+	for _, format := range []archive.Format{archive.Zip, archive.TarZstd} {
+		contents[format], archives[format] = createTestArchive(t, format)
+	}
+
+	tests := map[string]struct {
+		format            archive.Format
+		streaming         bool
+		concurrency       int
+		encrypted         bool
+		failOnce          bool
+		expectedExtracted bool
+		expectedRequests  int
+	}{
+		"zip": {
+			format:           archive.Zip,
+			streaming:        true,
+			expectedRequests: 1,
+		},
+		"zip with ranges": {
+			format:           archive.Zip,
+			concurrency:      4,
+			expectedRequests: 3,
+		},
+		"tarzstd": {
+			format:           archive.TarZstd,
+			expectedRequests: 1,
+		},
+		"tarzstd streamed": {
+			format:            archive.TarZstd,
+			streaming:         true,
+			expectedExtracted: true,
+			expectedRequests:  1,
+		},
+		"tarzstd streamed with ranges": {
+			format:            archive.TarZstd,
+			streaming:         true,
+			concurrency:       2,
+			expectedExtracted: true,
+			expectedRequests:  3,
+		},
+		"encrypted tarzstd streamed with ranges": {
+			format:            archive.TarZstd,
+			streaming:         true,
+			concurrency:       4,
+			encrypted:         true,
+			expectedExtracted: true,
+			expectedRequests:  3,
+		},
+		"tarzstd streamed after a failure": {
+			format:            archive.TarZstd,
+			streaming:         true,
+			failOnce:          true,
+			expectedExtracted: true,
+			expectedRequests:  2,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			served := archives[tt.format]
+			if tt.encrypted {
+				encrypter, err := encryption.NewEncrypter(bytes.NewReader(served), keyring)
+				require.NoError(t, err)
+
+				served, err = ioutil.ReadAll(encrypter)
+				require.NoError(t, err)
+			}
+
+			server := &testCacheServer{
+				content:  served,
+				modTime:  time.Now().Add(-time.Hour).Truncate(time.Second),
+				failOnce: tt.failOnce,
+			}
+			ts := httptest.NewServer(server)
+			defer ts.Close()
+
+			dir, err := ioutil.TempDir("", "cache-streaming")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			// The zip extractor extracts the files to the working directory
+			wd := filepath.Join(dir, "wd")
+			require.NoError(t, os.Mkdir(wd, 0700))
+
+			oldWd, err := os.Getwd()
+			require.NoError(t, err)
+			require.NoError(t, os.Chdir(wd))
+			defer func() { _ = os.Chdir(oldWd) }()
+
+			cmd := CacheExtractorCommand{
+				File:                filepath.Join(dir, "cache", "cache.zip"),
+				URL:                 ts.URL + "/cache.zip",
+				DownloadConcurrency: tt.concurrency,
+				retryHelper:         retryHelper{Retry: 1},
+			}
+			if tt.streaming {
+				cmd.streamDir = wd
+			}
+			if tt.encrypted {
+				cmd.keyring = keyring
+			}
+
+			found, err := cmd.extract(wd)
+			require.NoError(t, err)
+			assert.True(t, found)
+			assert.Equal(t, tt.expectedExtracted, cmd.extracted)
+			assert.Equal(t, tt.expectedRequests, server.requests)
+			if tt.concurrency > 1 {
+				assert.Equal(t, tt.expectedRequests, server.ranged)
+			}
+
+			extracted, err := ioutil.ReadFile(filepath.Join(wd, "file"))
+			require.NoError(t, err)
+			assert.Equal(t, contents[tt.format], extracted)
+
+			local, err := ioutil.ReadFile(cmd.File)
+			require.NoError(t, err)
+			assert.Equal(t, archives[tt.format], local)
+
+			fi, err := os.Stat(cmd.File)
+			require.NoError(t, err)
+			assert.Equal(t, server.modTime.Unix(), fi.ModTime().Unix())
+
+			// The local cache is up to date
+			_, err = cmd.extract(wd)
+			require.NoError(t, err)
+			assert.False(t, cmd.extracted)
+			assert.Equal(t, tt.expectedRequests+1, server.requests)
+		})
+	}
+}
+
+func TestCacheExtractorStreamingExtractionError(t *testing.T) {
+	server := &testCacheServer{
+		content: append(append([]byte{}, zstdMagic...), bytes.Repeat([]byte("invalid"), 1000)...),
+		modTime: time.Now(),
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "cache-streaming")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cmd := CacheExtractorCommand{
+		File:        filepath.Join(dir, "cache.zip"),
+		URL:         ts.URL + "/cache.zip",
+		retryHelper: retryHelper{Retry: 2},
+		streamDir:   dir,
+	}
+
+	_, err = cmd.extract(dir)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "extracting cache")
+	assert.Equal(t, 1, server.requests, "extraction errors aren't retried")
+}
+
+func TestCacheExtractorEmptyCacheWithRanges(t *testing.T) {
+	server := &testCacheServer{modTime: time.Now()}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "cache-streaming")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cmd := CacheExtractorCommand{
+		File:                filepath.Join(dir, "cache.zip"),
+		URL:                 ts.URL + "/cache.zip",
+		DownloadConcurrency: 4,
+	}
+
+	require.NoError(t, cmd.download(0))
+	assert.Equal(t, 2, server.requests)
+
+	fi, err := os.Stat(cmd.File)
+	require.NoError(t, err)
+	assert.Zero(t, fi.Size())
+}
@@ -0,0 +1,209 @@
+package chunked
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/sync/errgroup"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/archives"
+)
+
+// Archive writes the files as a tar stream split in content-defined chunks,
+// which are stored in local unless already present. The paths of the files
+// are relative to dir. The tar stream is deterministic, so unchanged files
+// produce the same chunks.
+func Archive(ctx context.Context, local Store, dir string, files map[string]os.FileInfo) (*Manifest, error) {
+	return archive(ctx, local, dir, files, defaultChunkSizes)
+}
+
+func archive(
+	ctx context.Context,
+	local Store,
+	dir string,
+	files map[string]os.FileInfo,
+	sizes chunkSizes,
+) (*Manifest, error) {
+	manifest := &Manifest{Version: manifestVersion}
+
+	c := newChunker(sizes, func(chunk []byte) error {
+		sum := sha256.Sum256(chunk)
+		hash := hex.EncodeToString(sum[:])
+		manifest.Chunks = append(manifest.Chunks, Chunk{Hash: hash, Size: int64(len(chunk))})
+
+		exists, err := local.Has(hash)
+		if err != nil || exists {
+			return err
+		}
+
+		return local.Put(hash, bytes.NewReader(chunk))
+	})
+
+	err := archives.CreateTarArchive(ctx, c, dir, files)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// Transfer copies the chunks of the manifest missing from dst, using up to
+// concurrency parallel copies. It returns the number of copied chunks and
+// their total size.
+func Transfer(ctx context.Context, src Store, dst Store, manifest *Manifest, concurrency int) (int, int64, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	sizes := make(map[string]int64, len(manifest.Chunks))
+	for _, chunk := range manifest.Chunks {
+		sizes[chunk.Hash] = chunk.Size
+	}
+
+	hashes := make(chan string)
+	copied := make(chan int64, len(sizes))
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		defer close(hashes)
+
+		for hash := range sizes {
+			select {
+			case hashes <- hash:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		return nil
+	})
+
+	for i := 0; i < concurrency; i++ {
+		g.Go(func() error {
+			for hash := range hashes {
+				ok, err := transferChunk(src, dst, hash)
+				if err != nil {
+					return err
+				}
+				if ok {
+					copied <- sizes[hash]
+				}
+			}
+
+			return nil
+		})
+	}
+
+	err := g.Wait()
+	close(copied)
+
+	var size int64
+	count := len(copied)
+	for chunkSize := range copied {
+		size += chunkSize
+	}
+
+	return count, size, err
+}
+
+func transferChunk(src Store, dst Store, hash string) (bool, error) {
+	exists, err := dst.Has(hash)
+	if err != nil || exists {
+		return false, err
+	}
+
+	r, err := src.Open(hash)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = r.Close() }()
+
+	return true, dst.Put(hash, r)
+}
+
+// Extract restores the files of the manifest in dir, reading the chunks
+// from local
+func Extract(ctx context.Context, local Store, manifest *Manifest, dir string) error {
+	r := &chunksReader{ctx: ctx, store: local, chunks: manifest.Chunks}
+	defer r.Close()
+
+	return archives.ExtractTarArchive(r, dir)
+}
+
+// chunksReader reads the content of the chunks one after another,
+// verifying their size
+type chunksReader struct {
+	ctx    context.Context
+	store  Store
+	chunks []Chunk
+
+	current   io.ReadCloser
+	remaining int64
+	hash      string
+}
+
+func (r *chunksReader) Read(p []byte) (int, error) {
+	for r.current == nil || r.remaining == 0 {
+		err := r.next()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+
+	n, err := r.current.Read(p)
+	r.remaining -= int64(n)
+	if err == io.EOF {
+		if r.remaining > 0 {
+			return n, fmt.Errorf("chunk %s: %w", r.hash, io.ErrUnexpectedEOF)
+		}
+		err = nil
+	}
+
+	return n, err
+}
+
+func (r *chunksReader) next() error {
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+
+	r.Close()
+	if len(r.chunks) == 0 {
+		return io.EOF
+	}
+
+	chunk := r.chunks[0]
+	r.chunks = r.chunks[1:]
+
+	current, err := r.store.Open(chunk.Hash)
+	if err != nil {
+		return err
+	}
+
+	r.current = current
+	r.remaining = chunk.Size
+	r.hash = chunk.Hash
+
+	return nil
+}
+
+func (r *chunksReader) Close() {
+	if r.current != nil {
+		_ = r.current.Close()
+		r.current = nil
+	}
+}
@@ -0,0 +1,255 @@
+package chunked
+
+import (
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testChunkSizes = chunkSizes{min: 1024, avgBits: 12, max: 16 * 1024}
+
+func splitChunks(t *testing.T, data []byte) []string {
+	var chunks []string
+
+	c := newChunker(testChunkSizes, func(chunk []byte) error {
+		assert.LessOrEqual(t, len(chunk), testChunkSizes.max)
+		chunks = append(chunks, string(chunk))
+		return nil
+	})
+
+	// write in small pieces, boundaries must not depend on them
+	for len(data) > 0 {
+		n := 333
+		if n > len(data) {
+			n = len(data)
+		}
+		_, err := c.Write(data[:n])
+		require.NoError(t, err)
+		data = data[n:]
+	}
+	require.NoError(t, c.Close())
+
+	return chunks
+}
+
+func TestChunkerBoundariesDependOnContent(t *testing.T) {
+	data := make([]byte, 256*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	original := splitChunks(t, data)
+	require.Greater(t, len(original), 4)
+
+	joined := ""
+	for _, chunk := range original {
+		joined += chunk
+	}
+	assert.Equal(t, string(data), joined)
+
+	// inserting data in the middle keeps the chunks before and most of the
+	// chunks after the change
+	modified := append(append(append([]byte{}, data[:100000]...), []byte("inserted")...), data[100000:]...)
+	changed := splitChunks(t, modified)
+
+	common := 0
+	seen := make(map[string]bool)
+	for _, chunk := range original {
+		seen[chunk] = true
+	}
+	for _, chunk := range changed {
+		if seen[chunk] {
+			common++
+		}
+	}
+
+	assert.GreaterOrEqual(t, common, len(original)-2)
+}
+
+func writeTestFile(t *testing.T, path string, content string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0640))
+}
+
+func enumerateTestFiles(t *testing.T, dir string) map[string]os.FileInfo {
+	files := make(map[string]os.FileInfo)
+
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+
+		name, err := filepath.Rel(dir, path)
+		files[name] = fi
+
+		return err
+	})
+	require.NoError(t, err)
+
+	return files
+}
+
+func uniqueChunksSize(manifest *Manifest) int64 {
+	sizes := make(map[string]int64)
+	for _, chunk := range manifest.Chunks {
+		sizes[chunk.Hash] = chunk.Size
+	}
+
+	var size int64
+	for _, chunkSize := range sizes {
+		size += chunkSize
+	}
+
+	return size
+}
+
+func TestArchiveAndExtract(t *testing.T) {
+	src, err := ioutil.TempDir("", "chunked-src")
+	require.NoError(t, err)
+	defer os.RemoveAll(src)
+
+	large := make([]byte, 100*1024)
+	rand.New(rand.NewSource(2)).Read(large)
+
+	writeTestFile(t, filepath.Join(src, "cache", "small.txt"), "small")
+	writeTestFile(t, filepath.Join(src, "cache", "nested", "large.bin"), string(large))
+	require.NoError(t, os.Symlink("small.txt", filepath.Join(src, "cache", "link")))
+
+	local := NewDirStore(filepath.Join(src, ".chunks"))
+	files := enumerateTestFiles(t, filepath.Join(src, "cache"))
+
+	manifest, err := archive(context.Background(), local, filepath.Join(src, "cache"), files, testChunkSizes)
+	require.NoError(t, err)
+	require.NoError(t, manifest.validate())
+	assert.Greater(t, len(manifest.Chunks), 1)
+
+	// archiving the same files again produces the same chunks
+	again, err := archive(context.Background(), local, filepath.Join(src, "cache"), files, testChunkSizes)
+	require.NoError(t, err)
+	assert.Equal(t, manifest, again)
+
+	remote := NewDirStore(filepath.Join(src, "remote"))
+	copied, size, err := Transfer(context.Background(), local, remote, manifest, 4)
+	require.NoError(t, err)
+	assert.Equal(t, len(manifest.Hashes()), copied)
+	assert.Equal(t, uniqueChunksSize(manifest), size)
+
+	copied, size, err = Transfer(context.Background(), local, remote, manifest, 4)
+	require.NoError(t, err)
+	assert.Zero(t, copied)
+	assert.Zero(t, size)
+
+	dst, err := ioutil.TempDir("", "chunked-dst")
+	require.NoError(t, err)
+	defer os.RemoveAll(dst)
+
+	err = Extract(context.Background(), remote, manifest, dst)
+	require.NoError(t, err)
+
+	content, err := ioutil.ReadFile(filepath.Join(dst, "small.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "small", string(content))
+
+	content, err = ioutil.ReadFile(filepath.Join(dst, "nested", "large.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, large, content)
+
+	link, err := os.Readlink(filepath.Join(dst, "link"))
+	require.NoError(t, err)
+	assert.Equal(t, "small.txt", link)
+
+	removed, err := local.Prune(map[string]bool{manifest.Chunks[0].Hash: true})
+	require.NoError(t, err)
+	assert.Equal(t, len(manifest.Hashes())-1, removed)
+}
+
+func TestExtractMissingChunk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chunked")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	manifest := &Manifest{
+		Version: manifestVersion,
+		Chunks:  []Chunk{{Hash: "0000000000000000000000000000000000000000000000000000000000000000", Size: 10}},
+	}
+
+	err = Extract(context.Background(), NewDirStore(dir), manifest, dir)
+	assert.ErrorIs(t, err, ErrChunkNotFound)
+}
+
+func TestDirStorePutVerifiesHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chunked")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store := NewDirStore(dir)
+	hash := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+	err = store.Put(hash, strings.NewReader("tampered"))
+	assert.Error(t, err)
+
+	err = store.Put(hash, strings.NewReader("hello"))
+	require.NoError(t, err)
+
+	exists, err := store.Has(hash)
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestReadManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chunked")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "manifest.json")
+	hash := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+	manifest := &Manifest{
+		Version: manifestVersion,
+		Chunks:  []Chunk{{Hash: hash, Size: 5, Location: Location{Pack: "1-abcd", Length: 33}}},
+	}
+	require.NoError(t, manifest.WriteFile(path))
+
+	read, err := ReadManifest(path)
+	require.NoError(t, err)
+	assert.Equal(t, manifest, read)
+	assert.Equal(t, int64(5), read.Size())
+
+	for _, invalid := range []string{
+		`{"version":2,"chunks":[{"hash":"../../etc/passwd","size":5}]}`,
+		`{"version":2,"chunks":[{"hash":"` + hash + `","size":5,"pack":"1-abcd"}]}`,
+		`{"version":1,"chunks":[{"hash":"` + hash + `","size":5}]}`,
+	} {
+		writeTestFile(t, path, invalid)
+		_, err = ReadManifest(path)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestManifestLocations(t *testing.T) {
+	const (
+		first  = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+		second = "486ea46224d1bb4fb680f34f7c9ad96a8f24ec88be73ea8e5a6c65260e9cb8a7"
+	)
+
+	manifest := &Manifest{
+		Version: manifestVersion,
+		Chunks:  []Chunk{{Hash: first, Size: 5}, {Hash: second, Size: 5}, {Hash: first, Size: 5}},
+	}
+	assert.Empty(t, manifest.Locations())
+	assert.Empty(t, manifest.Packs())
+
+	manifest.Locate(map[string]Location{
+		first: {Pack: "1-abcd", Offset: 10, Length: 5},
+	})
+
+	assert.Equal(t, map[string]Location{first: {Pack: "1-abcd", Offset: 10, Length: 5}}, manifest.Locations())
+	assert.Equal(t, map[string]bool{"1-abcd": true}, manifest.Packs())
+	assert.Equal(t, Location{Pack: "1-abcd", Offset: 10, Length: 5}, manifest.Chunks[2].Location)
+	assert.Equal(t, Location{}, manifest.Chunks[1].Location)
+}
@@ -0,0 +1,114 @@
+package chunked
+
+const (
+	defaultMinChunkSize = 256 * 1024
+	defaultAvgChunkBits = 20 // 1 MiB
+	defaultMaxChunkSize = 4 * 1024 * 1024
+
+	// gearSeed is fixed, so the same content is always split the same way,
+	// on every machine and by every version of the helper
+	gearSeed = 0x5d1c2b8f3a7e9046
+)
+
+var gearTable = newGearTable(gearSeed)
+
+func newGearTable(seed uint64) [256]uint64 {
+	var table [256]uint64
+
+	// splitmix64
+	state := seed
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+
+	return table
+}
+
+// chunkSizes configures the boundaries of the chunks. A boundary is placed
+// where the rolling hash matches the mask, as long as the chunk is between
+// min and max bytes long.
+type chunkSizes struct {
+	min     int
+	avgBits uint
+	max     int
+}
+
+var defaultChunkSizes = chunkSizes{
+	min:     defaultMinChunkSize,
+	avgBits: defaultAvgChunkBits,
+	max:     defaultMaxChunkSize,
+}
+
+// chunker splits the data written to it into content-defined chunks using
+// a gear rolling hash. Since the boundaries depend on the content only,
+// a change in the data affects the chunks around it and not all the
+// following ones.
+type chunker struct {
+	sizes chunkSizes
+	mask  uint64
+	hash  uint64
+	buf   []byte
+
+	// emit is called with every chunk. The slice is reused after the call
+	// returns.
+	emit func(chunk []byte) error
+}
+
+func newChunker(sizes chunkSizes, emit func(chunk []byte) error) *chunker {
+	return &chunker{
+		sizes: sizes,
+		// the high bits of the gear hash depend on the last 64 bytes, while
+		// the low ones only depend on the last few
+		mask: ((uint64(1) << sizes.avgBits) - 1) << (64 - sizes.avgBits),
+		buf:  make([]byte, 0, sizes.max),
+		emit: emit,
+	}
+}
+
+func (c *chunker) Write(p []byte) (int, error) {
+	start := 0
+
+	for i, b := range p {
+		c.hash = (c.hash << 1) + gearTable[b]
+
+		size := len(c.buf) + i - start + 1
+		if size < c.sizes.min {
+			continue
+		}
+		if c.hash&c.mask != 0 && size < c.sizes.max {
+			continue
+		}
+
+		c.buf = append(c.buf, p[start:i+1]...)
+		start = i + 1
+
+		if err := c.flush(); err != nil {
+			return start, err
+		}
+	}
+
+	c.buf = append(c.buf, p[start:]...)
+
+	return len(p), nil
+}
+
+// Close emits the remaining data as the last chunk
+func (c *chunker) Close() error {
+	return c.flush()
+}
+
+func (c *chunker) flush() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+
+	err := c.emit(c.buf)
+	c.buf = c.buf[:0]
+	c.hash = 0
+
+	return err
+}
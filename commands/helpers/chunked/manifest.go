@@ -0,0 +1,157 @@
+package chunked
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+const manifestVersion = 2
+
+var hashRegexp = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Manifest lists the chunks of a tar stream of the cached files, in order
+type Manifest struct {
+	Version int     `json:"version"`
+	Chunks  []Chunk `json:"chunks"`
+}
+
+// Chunk is identified by the SHA-256 of its content
+type Chunk struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+	Location
+}
+
+// Location locates a chunk in the packs of a remote cache. The length of the
+// stored chunk includes the encryption overhead.
+type Location struct {
+	Pack   string `json:"pack,omitempty"`
+	Offset int64  `json:"offset,omitempty"`
+	Length int64  `json:"length,omitempty"`
+}
+
+// Size returns the total size of the chunks
+func (m *Manifest) Size() int64 {
+	var size int64
+	for _, chunk := range m.Chunks {
+		size += chunk.Size
+	}
+
+	return size
+}
+
+// Hashes returns the set of the hashes of the chunks
+func (m *Manifest) Hashes() map[string]bool {
+	hashes := make(map[string]bool, len(m.Chunks))
+	for _, chunk := range m.Chunks {
+		hashes[chunk.Hash] = true
+	}
+
+	return hashes
+}
+
+// Locations returns the locations of the chunks stored in packs, by hash
+func (m *Manifest) Locations() map[string]Location {
+	locations := make(map[string]Location, len(m.Chunks))
+	for _, chunk := range m.Chunks {
+		if chunk.Pack != "" {
+			locations[chunk.Hash] = chunk.Location
+		}
+	}
+
+	return locations
+}
+
+// Locate sets the locations of the chunks, by hash
+func (m *Manifest) Locate(locations map[string]Location) {
+	for i, chunk := range m.Chunks {
+		m.Chunks[i].Location = locations[chunk.Hash]
+	}
+}
+
+// Packs returns the set of the packs storing the chunks
+func (m *Manifest) Packs() map[string]bool {
+	packs := make(map[string]bool)
+	for _, chunk := range m.Chunks {
+		if chunk.Pack != "" {
+			packs[chunk.Pack] = true
+		}
+	}
+
+	return packs
+}
+
+func (m *Manifest) validate() error {
+	if m.Version != manifestVersion {
+		return fmt.Errorf("unsupported manifest version %d", m.Version)
+	}
+
+	for _, chunk := range m.Chunks {
+		if !hashRegexp.MatchString(chunk.Hash) {
+			return fmt.Errorf("invalid chunk hash %q", chunk.Hash)
+		}
+		if chunk.Size <= 0 {
+			return fmt.Errorf("invalid size %d of chunk %s", chunk.Size, chunk.Hash)
+		}
+		if chunk.Pack != "" && (chunk.Offset < 0 || chunk.Length <= 0) {
+			return fmt.Errorf("invalid location of chunk %s", chunk.Hash)
+		}
+	}
+
+	return nil
+}
+
+// ReadManifest reads and validates the manifest stored at path
+func ReadManifest(path string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("parsing manifest: %w", err)
+	}
+
+	err = manifest.validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	return &manifest, nil
+}
+
+// WriteFile stores the manifest at path, replacing the previous one
+// atomically
+func (m *Manifest) WriteFile(path string) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(path), "manifest_")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(file.Name()) }()
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+
+	return os.Rename(file.Name(), path)
+}
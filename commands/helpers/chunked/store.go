@@ -0,0 +1,117 @@
+package chunked
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ErrChunkNotFound is returned when a chunk is missing from a store
+var ErrChunkNotFound = errors.New("chunk not found")
+
+// Store keeps chunks by their hash
+type Store interface {
+	Has(hash string) (bool, error)
+	Open(hash string) (io.ReadCloser, error)
+	// Put stores the content read from r, which must match the hash
+	Put(hash string, r io.Reader) error
+}
+
+// DirStore stores the chunks as files of a directory, in subdirectories
+// named after the first two characters of the hash
+type DirStore struct {
+	Dir string
+}
+
+func NewDirStore(dir string) *DirStore {
+	return &DirStore{Dir: dir}
+}
+
+func (s *DirStore) path(hash string) string {
+	return filepath.Join(s.Dir, hash[:2], hash)
+}
+
+func (s *DirStore) Has(hash string) (bool, error) {
+	_, err := os.Stat(s.path(hash))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (s *DirStore) Open(hash string) (io.ReadCloser, error) {
+	file, err := os.Open(s.path(hash))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrChunkNotFound, hash)
+	}
+
+	return file, err
+}
+
+// Put writes the chunk to a temporary file first, so concurrent jobs never
+// read a partial chunk
+func (s *DirStore) Put(hash string, r io.Reader) error {
+	path := s.path(hash)
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("creating chunk directory: %w", err)
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(path), "chunk_")
+	if err != nil {
+		return fmt.Errorf("creating chunk file: %w", err)
+	}
+	defer func() { _ = os.Remove(file.Name()) }()
+
+	digest := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, digest), r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("writing chunk %s: %w", hash, err)
+	}
+
+	if actual := hex.EncodeToString(digest.Sum(nil)); actual != hash {
+		return fmt.Errorf("chunk %s has unexpected hash %s", hash, actual)
+	}
+
+	err = os.Chmod(file.Name(), 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+// Prune removes the chunks which aren't listed in keep
+func (s *DirStore) Prune(keep map[string]bool) (int, error) {
+	paths, err := filepath.Glob(filepath.Join(s.Dir, "??", "*"))
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, path := range paths {
+		hash := filepath.Base(path)
+		if !hashRegexp.MatchString(hash) || keep[hash] {
+			continue
+		}
+
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+
+		removed++
+	}
+
+	return removed, nil
+}
@@ -145,7 +145,7 @@ func (mr *RunCommand) loadConfig() error {
 
 	mr.healthy = nil
 	mr.log().Println("Configuration loaded")
-	mr.log().Debugln(helpers.ToYAML(mr.config))
+	mr.logConfig()
 
 	// initialize sentry
 	if mr.config.SentryDSN != nil {
@@ -161,6 +161,18 @@ func (mr *RunCommand) loadConfig() error {
 	return nil
 }
 
+// logConfig logs the configuration with its references instead of the
+// values they resolved
+func (mr *RunCommand) logConfig() {
+	config, err := mr.config.Masked()
+	if err != nil {
+		mr.log().WithError(err).Warningln("Failed to mask the configuration")
+		return
+	}
+
+	mr.log().Debugln(helpers.ToYAML(config))
+}
+
 func (mr *RunCommand) updateLoggingConfiguration() error {
 	reloadNeeded := false
 
@@ -481,6 +493,9 @@ func (mr *RunCommand) processRunner(
 	}
 	build.Session = buildSession
 	build.ArtifactUploader = mr.network.UploadRawArtifacts
+	build.CacheResultHandler = func(result common.CacheResult) {
+		mr.buildsHelper.observeCacheResult(build, result)
+	}
 
 	// Add build to list of builds to assign numbers
 	mr.buildsHelper.addBuild(build)
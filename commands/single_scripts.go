@@ -0,0 +1,80 @@
+package commands
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	clihelpers "gitlab.com/ayufan/golang-cli-helpers"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+// RunSingleScriptsCommand prints the scripts `run-single` would execute for
+// a job payload, as received from GitLab. It's meant to debug the shell
+// script generation, so it's hidden from the list of commands.
+type RunSingleScriptsCommand struct {
+	common.RunnerConfig
+	JobResponseFile string `long:"job-response" description:"File with the JSON job payload, as returned by the jobs request API"`
+	OutputDir       string `long:"output-dir" description:"Directory in which the scripts are written, one file per stage (defaults to stdout)"`
+}
+
+func (r *RunSingleScriptsCommand) Execute(_ *cli.Context) {
+	if r.Executor == "" {
+		logrus.Fatalln("Missing Executor")
+	}
+	if r.JobResponseFile == "" {
+		logrus.Fatalln("Missing job response file")
+	}
+
+	data, err := ioutil.ReadFile(r.JobResponseFile)
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+
+	var jobResponse common.JobResponse
+	err = json.Unmarshal(data, &jobResponse)
+	if err != nil {
+		logrus.Fatalln("Parsing job response:", err)
+	}
+
+	build, err := common.NewBuild(jobResponse, &r.RunnerConfig, nil, nil)
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+
+	err = prepareBuildDirs(build, wd)
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+
+	info, err := getShellScriptInfo(build)
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+
+	writer := &buildScriptsWriter{OutputDir: r.OutputDir, Output: os.Stdout}
+	err = writer.Write(build, info)
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+}
+
+func init() {
+	cmd := &RunSingleScriptsCommand{}
+
+	common.RegisterCommand(cli.Command{
+		Name:   "run-single-scripts",
+		Usage:  "print the scripts run-single would execute for a job payload (debug)",
+		Hidden: true,
+		Action: cmd.Execute,
+		Flags:  clihelpers.GetFlagsFromStruct(cmd),
+	})
+}
@@ -2,6 +2,7 @@ package common
 
 import (
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
@@ -155,10 +156,52 @@ type Build struct {
 	allVariables     JobVariables
 	secretsVariables JobVariables
 
+	// secretLeases are the leases of the secrets generated for the job,
+	// revoked when the job ends by the resolver that generated them
+	secretLeasesResolver SecretsResolver
+	secretLeases         SecretSources
+	secretLeasesLock     sync.Mutex
+
+	// jobEndHandlers are called once when the job ends, like the aborts of
+	// the multipart cache uploads started for the job
+	jobEndHandlers     []func()
+	jobEndHandlersLock sync.Mutex
+
 	createdAt time.Time
 
 	Referees         []referees.Referee
 	ArtifactUploader func(config JobCredentials, reader io.ReadCloser, options ArtifactsOptions) UploadState
+
+	// CacheResultHandler, when set, is called with the results reported by
+	// the cache helpers in the job output
+	CacheResultHandler func(result CacheResult)
+	// cacheResultKey signs the results reported by the cache helpers
+	cacheResultKey string
+
+	// LocalArtifactsDir, when set, makes the job store its artifacts in and
+	// read the artifacts of its dependencies from this directory instead of
+	// GitLab. It's used by `exec`, where there is no GitLab instance to talk to.
+	LocalArtifactsDir string `json:"-" yaml:"-"`
+}
+
+// CacheResultKey returns the key the cache helpers sign their results with,
+// or an empty string when the results aren't collected
+func (b *Build) CacheResultKey() string {
+	return b.cacheResultKey
+}
+
+// maskedValues returns the values hidden in the job output: the masked
+// variables and the keys passed to the helpers through the scripts
+func (b *Build) maskedValues() []string {
+	masked := b.GetAllVariables().Masked()
+	if b.cacheResultKey != "" {
+		masked = append(masked, b.cacheResultKey)
+	}
+	if b.Runner != nil && b.Runner.Cache != nil && b.Runner.Cache.Encryption != nil {
+		masked = append(masked, b.Runner.Cache.Encryption.maskedValues(b.Runner.GetVariables())...)
+	}
+
+	return masked
 }
 
 func (b *Build) setCurrentStage(stage BuildStage) {
@@ -244,6 +287,35 @@ func (b *Build) ProjectUniqueDir(sharedDir bool) string {
 	return dir
 }
 
+// LocalArtifactsPath returns the path of the artifacts archive of the job with
+// the given name, when LocalArtifactsDir is used
+func (b *Build) LocalArtifactsPath(jobName string) string {
+	return LocalArtifactsPath(b.LocalArtifactsDir, jobName)
+}
+
+// LocalArtifactsPath returns the path below dir of the artifacts archive of
+// the job with the given name
+func LocalArtifactsPath(dir string, jobName string) string {
+	return filepath.Join(dir, LocalJobDirName(jobName), "artifacts.zip")
+}
+
+// LocalJobDirName returns the name of a directory dedicated to the job with
+// the given name. Job names can contain characters that aren't allowed in
+// file names, so they're replaced with dashes and a short hash of the name
+// is appended to keep the names unique.
+func LocalJobDirName(jobName string) string {
+	slug := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '-'
+	}, jobName)
+
+	sum := sha256.Sum256([]byte(jobName))
+
+	return fmt.Sprintf("%s-%x", slug, sum[:4])
+}
+
 func (b *Build) FullProjectDir() string {
 	return helpers.ToSlash(b.BuildDir)
 }
@@ -491,6 +563,8 @@ func (b *Build) executeScript(ctx context.Context, executor Executor) error {
 	b.executeUploadReferees(ctx, startTime, endTime)
 
 	b.removeFileBasedVariables(ctx, executor)
+	b.revokeSecretLeases()
+	b.runJobEndHandlers()
 
 	return b.pickPriorityError(err, archiveCacheErr, artifactUploadErr)
 }
@@ -840,6 +914,15 @@ func (b *Build) CurrentExecutorStage() ExecutorStage {
 func (b *Build) Run(globalConfig *Config, trace JobTrace) (err error) {
 	var executor Executor
 
+	if b.CacheResultHandler != nil {
+		b.cacheResultKey, err = newCacheResultKey()
+		if err != nil {
+			return err
+		}
+
+		trace = newCacheResultsTrace(trace, b.cacheResultKey, b.CacheResultHandler)
+	}
+
 	b.logger = NewBuildLogger(trace, b.Log())
 	b.printRunningWithHeader()
 
@@ -857,6 +940,11 @@ func (b *Build) Run(globalConfig *Config, trace JobTrace) (err error) {
 
 	defer func() { b.cleanupBuild(executor) }()
 
+	// The leases are revoked and the job end handlers are called when the
+	// script ends, or here when the script didn't run or didn't end in time
+	defer b.runJobEndHandlers()
+	defer b.revokeSecretLeases()
+
 	err = b.resolveSecrets()
 	if err != nil {
 		return err
@@ -867,7 +955,7 @@ func (b *Build) Run(globalConfig *Config, trace JobTrace) (err error) {
 
 	trace.SetCancelFunc(cancel)
 	trace.SetAbortFunc(cancel)
-	trace.SetMasked(b.GetAllVariables().Masked())
+	trace.SetMasked(b.maskedValues())
 
 	options := b.createExecutorPrepareOptions(ctx, globalConfig, trace)
 	provider := GetExecutorProvider(b.Runner.Executor)
@@ -916,6 +1004,8 @@ func (b *Build) resolveSecrets() error {
 	}
 
 	b.Secrets.expandVariables(b.GetAllVariables())
+	b.Secrets.applyVaultAuthDefaults(b.Runner.Vault)
+	b.Secrets.applySecretPlugins(b.Runner.SecretPlugins, b.pluginSecretJob())
 
 	section := helpers.BuildSection{
 		Name:        string(BuildStageResolveSecrets),
@@ -927,6 +1017,7 @@ func (b *Build) resolveSecrets() error {
 			}
 
 			variables, err := resolver.Resolve(b.Secrets)
+			b.setSecretLeases(resolver, resolver.Leases())
 			if err != nil {
 				return fmt.Errorf("resolving secrets: %w", err)
 			}
@@ -941,6 +1032,65 @@ func (b *Build) resolveSecrets() error {
 	return section.Execute(&b.logger)
 }
 
+func (b *Build) pluginSecretJob() PluginSecretJob {
+	return PluginSecretJob{
+		ID:          b.ID,
+		Name:        b.JobInfo.Name,
+		Stage:       b.JobInfo.Stage,
+		ProjectID:   b.JobInfo.ProjectID,
+		ProjectName: b.JobInfo.ProjectName,
+		PipelineID:  b.GetAllVariables().Get("CI_PIPELINE_ID"),
+		Ref:         b.GitInfo.Ref,
+		Sha:         b.GitInfo.Sha,
+		URL:         b.JobURL(),
+	}
+}
+
+func (b *Build) setSecretLeases(resolver SecretsResolver, leases SecretSources) {
+	b.secretLeasesLock.Lock()
+	defer b.secretLeasesLock.Unlock()
+
+	b.secretLeasesResolver = resolver
+	b.secretLeases = leases
+}
+
+// revokeSecretLeases revokes the leases of the secrets generated for the job,
+// once whatever the outcome of the job
+func (b *Build) revokeSecretLeases() {
+	b.secretLeasesLock.Lock()
+	resolver := b.secretLeasesResolver
+	leases := b.secretLeases
+	b.secretLeasesResolver = nil
+	b.secretLeases = nil
+	b.secretLeasesLock.Unlock()
+
+	if resolver == nil || len(leases) < 1 {
+		return
+	}
+
+	resolver.RevokeLeases(leases)
+}
+
+// OnJobEnd registers a handler called once when the job ends, whatever its
+// outcome
+func (b *Build) OnJobEnd(handler func()) {
+	b.jobEndHandlersLock.Lock()
+	defer b.jobEndHandlersLock.Unlock()
+
+	b.jobEndHandlers = append(b.jobEndHandlers, handler)
+}
+
+func (b *Build) runJobEndHandlers() {
+	b.jobEndHandlersLock.Lock()
+	handlers := b.jobEndHandlers
+	b.jobEndHandlers = nil
+	b.jobEndHandlersLock.Unlock()
+
+	for _, handler := range handlers {
+		handler()
+	}
+}
+
 func (b *Build) executeBuildSection(
 	executor Executor,
 	options ExecutorPrepareOptions,
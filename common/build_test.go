@@ -1801,6 +1801,7 @@ func TestSecretsResolving(t *testing.T) {
 		resolverCreationError   error
 		prepareExecutorProvider func(t *testing.T) (*MockExecutorProvider, func())
 		returnVariables         JobVariables
+		returnLeases            SecretSources
 		resolvingError          error
 		expectedVariables       JobVariables
 		expectedError           error
@@ -1835,6 +1836,22 @@ func TestSecretsResolving(t *testing.T) {
 			expectedVariables: exampleVariables,
 			expectedError:     nil,
 		},
+		"leases revoked after secrets resolving error": {
+			secrets:                 secrets,
+			prepareExecutorProvider: setupFailureExecutorMocks,
+			returnLeases:            SecretSources{{LeaseID: "lease"}},
+			resolvingError:          assert.AnError,
+			expectedError:           assert.AnError,
+		},
+		"leases revoked after the job": {
+			secrets: secrets,
+			prepareExecutorProvider: func(t *testing.T) (*MockExecutorProvider, func()) {
+				return setupSuccessfulMockExecutor(t, func(options ExecutorPrepareOptions) error { return nil })
+			},
+			returnVariables:   exampleVariables,
+			returnLeases:      SecretSources{{LeaseID: "lease"}},
+			expectedVariables: exampleVariables,
+		},
 	}
 
 	for tn, tt := range tests {
@@ -1856,6 +1873,12 @@ func TestSecretsResolving(t *testing.T) {
 				secretsResolverMock.On("Resolve", tt.secrets).
 					Return(tt.returnVariables, tt.resolvingError).
 					Once()
+				secretsResolverMock.On("Leases").
+					Return(tt.returnLeases).
+					Once()
+				if tt.returnLeases != nil {
+					secretsResolverMock.On("RevokeLeases", tt.returnLeases).Once()
+				}
 			}
 
 			rc := new(RunnerConfig)
@@ -1880,3 +1903,16 @@ func TestSecretsResolving(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildRunJobEndHandlers(t *testing.T) {
+	build := new(Build)
+
+	var calls []int
+	build.OnJobEnd(func() { calls = append(calls, 1) })
+	build.OnJobEnd(func() { calls = append(calls, 2) })
+
+	build.runJobEndHandlers()
+	build.runJobEndHandlers()
+
+	assert.Equal(t, []int{1, 2}, calls)
+}
@@ -0,0 +1,190 @@
+package common
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers"
+)
+
+const (
+	CacheOperationArchive = "archive"
+	CacheOperationExtract = "extract"
+)
+
+// CacheResultKeyVariable passes the key signing the results to the cache
+// helpers. It's only exported in the scripts of the cache stages, so the job
+// script can't forge results.
+const CacheResultKeyVariable = "CACHE_RESULT_KEY"
+
+// cacheResultKeySize is the size of the random key generated for each job
+const cacheResultKeySize = 32
+
+// cacheResultPrefix starts the lines reporting the results of the cache
+// helpers in the job output. Like the trace section markers, these lines end
+// with a carriage return and a clear line sequence, hiding them in the job
+// log.
+const cacheResultPrefix = "cache_result:"
+
+// maxCacheResultLine limits the length of the lines kept while looking for
+// cache results in the job output
+const maxCacheResultLine = 1024
+
+// CacheResult is the result of a cache helper command
+type CacheResult struct {
+	Operation string        `json:"operation"`
+	Hit       bool          `json:"hit,omitempty"`
+	Bytes     int64         `json:"bytes"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// Line returns the line reporting the result in the job output, signed with
+// the key of the job: cache_result:<HMAC-SHA256 of the JSON>:<JSON>
+func (r CacheResult) Line(key string) string {
+	data, _ := json.Marshal(r)
+
+	return cacheResultPrefix + signCacheResult(key, data) + ":" + string(data) + "\r" + helpers.ANSI_CLEAR + "\n"
+}
+
+func (r CacheResult) valid() bool {
+	if r.Operation != CacheOperationArchive && r.Operation != CacheOperationExtract {
+		return false
+	}
+
+	return r.Bytes >= 0 && r.Duration >= 0
+}
+
+func signCacheResult(key string, data []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	_, _ = mac.Write(data)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newCacheResultKey generates the key signing the cache results of a job
+func newCacheResultKey() (string, error) {
+	key := make([]byte, cacheResultKeySize)
+
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", fmt.Errorf("generating cache result key: %w", err)
+	}
+
+	return hex.EncodeToString(key), nil
+}
+
+// parseCacheResult returns the result reported in the line and its
+// signature, when it's signed with the key and valid
+func parseCacheResult(line []byte, key string) (CacheResult, string, bool) {
+	var result CacheResult
+
+	idx := bytes.Index(line, []byte(cacheResultPrefix))
+	if idx < 0 {
+		return result, "", false
+	}
+
+	line = line[idx+len(cacheResultPrefix):]
+	if end := bytes.IndexByte(line, '\r'); end >= 0 {
+		line = line[:end]
+	}
+
+	sep := bytes.IndexByte(line, ':')
+	if sep < 0 {
+		return result, "", false
+	}
+
+	signature, data := string(line[:sep]), line[sep+1:]
+	if !hmac.Equal([]byte(signature), []byte(signCacheResult(key, data))) {
+		return result, "", false
+	}
+
+	err := json.Unmarshal(data, &result)
+	if err != nil || !result.valid() {
+		return result, "", false
+	}
+
+	return result, signature, true
+}
+
+// cacheResultsTrace passes the job output to the trace unchanged, calling
+// handler with the cache results found in it. Only the results signed with
+// the key of the job are handled, each of them once.
+type cacheResultsTrace struct {
+	JobTrace
+
+	key     string
+	handler func(result CacheResult)
+
+	lock     sync.Mutex
+	line     []byte
+	overflow bool
+	seen     map[string]bool
+}
+
+func newCacheResultsTrace(trace JobTrace, key string, handler func(result CacheResult)) *cacheResultsTrace {
+	return &cacheResultsTrace{
+		JobTrace: trace,
+		key:      key,
+		handler:  handler,
+		seen:     make(map[string]bool),
+	}
+}
+
+func (t *cacheResultsTrace) Write(p []byte) (int, error) {
+	t.scan(p)
+
+	return t.JobTrace.Write(p)
+}
+
+func (t *cacheResultsTrace) scan(p []byte) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for len(p) > 0 {
+		end := bytes.IndexByte(p, '\n')
+		if end < 0 {
+			t.appendLine(p)
+			return
+		}
+
+		t.appendLine(p[:end])
+		if !t.overflow {
+			t.handleLine()
+		}
+
+		t.line = t.line[:0]
+		t.overflow = false
+		p = p[end+1:]
+	}
+}
+
+func (t *cacheResultsTrace) handleLine() {
+	result, signature, ok := parseCacheResult(t.line, t.key)
+	if !ok || t.seen[signature] {
+		return
+	}
+
+	t.seen[signature] = true
+	t.handler(result)
+}
+
+func (t *cacheResultsTrace) appendLine(p []byte) {
+	if t.overflow {
+		return
+	}
+
+	if len(t.line)+len(p) > maxCacheResultLine {
+		t.line = t.line[:0]
+		t.overflow = true
+		return
+	}
+
+	t.line = append(t.line, p...)
+}
@@ -0,0 +1,103 @@
+package common
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheResultsTrace(t *testing.T) {
+	const key = "job-key"
+
+	hit := CacheResult{Operation: CacheOperationExtract, Hit: true, Bytes: 1024, Duration: time.Second}
+	miss := CacheResult{Operation: CacheOperationExtract, Bytes: 0, Duration: time.Second}
+	archive := CacheResult{Operation: CacheOperationArchive, Bytes: 10, Duration: time.Millisecond}
+
+	tests := map[string]struct {
+		writes          []string
+		expectedResults []CacheResult
+	}{
+		"no results": {
+			writes: []string{"Checking cache for key...\n", "Successfully extracted cache\n"},
+		},
+		"results in single writes": {
+			writes:          []string{"Downloading cache.zip\n" + hit.Line(key), archive.Line(key)},
+			expectedResults: []CacheResult{hit, archive},
+		},
+		"result split across writes": {
+			writes:          []string{hit.Line(key)[:5], hit.Line(key)[5:20], hit.Line(key)[20:]},
+			expectedResults: []CacheResult{hit},
+		},
+		"result prefixed with other output": {
+			writes:          []string{"\033[0;m" + hit.Line(key)},
+			expectedResults: []CacheResult{hit},
+		},
+		"invalid result": {
+			writes: []string{cacheResultPrefix + "{invalid\n", cacheResultPrefix + "{}\n"},
+		},
+		"unsigned result": {
+			writes: []string{cacheResultPrefix + `{"operation":"extract","hit":true,"bytes":1024}` + "\n"},
+		},
+		"result signed with another key": {
+			writes: []string{hit.Line("other-key")},
+		},
+		"unknown operation": {
+			writes: []string{CacheResult{Operation: "unknown", Bytes: 10}.Line(key)},
+		},
+		"negative bytes": {
+			writes: []string{CacheResult{Operation: CacheOperationArchive, Bytes: -10}.Line(key)},
+		},
+		"replayed result": {
+			writes:          []string{hit.Line(key), hit.Line(key), miss.Line(key)},
+			expectedResults: []CacheResult{hit, miss},
+		},
+		"result after a too long line": {
+			writes:          []string{strings.Repeat("x", 2*maxCacheResultLine) + cacheResultPrefix, "\n", hit.Line(key)},
+			expectedResults: []CacheResult{hit},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			var results []CacheResult
+			buf := new(bytes.Buffer)
+
+			trace := newCacheResultsTrace(&Trace{Writer: buf}, key, func(result CacheResult) {
+				results = append(results, result)
+			})
+
+			for _, write := range tt.writes {
+				n, err := trace.Write([]byte(write))
+				require.NoError(t, err)
+				assert.Equal(t, len(write), n)
+			}
+
+			assert.Equal(t, tt.expectedResults, results)
+			assert.Equal(t, strings.Join(tt.writes, ""), buf.String())
+		})
+	}
+}
+
+func TestBuildCacheResultKeyMasked(t *testing.T) {
+	key, err := newCacheResultKey()
+	require.NoError(t, err)
+	assert.Len(t, key, 2*cacheResultKeySize)
+
+	otherKey, err := newCacheResultKey()
+	require.NoError(t, err)
+	assert.NotEqual(t, key, otherKey)
+
+	build := &Build{
+		JobResponse: JobResponse{
+			Variables: JobVariables{{Key: "TOKEN", Value: "masked-token", Masked: true}},
+		},
+		cacheResultKey: key,
+	}
+
+	assert.Equal(t, key, build.CacheResultKey())
+	assert.Equal(t, []string{"masked-token", key}, build.maskedValues())
+}
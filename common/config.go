@@ -3,6 +3,7 @@ package common
 import (
 	"bufio"
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -531,15 +532,113 @@ type CacheAzureConfig struct {
 	StorageDomain string `toml:"StorageDomain,omitempty" long:"storage-domain" env:"CACHE_AZURE_STORAGE_DOMAIN" description:"Domain name of the Azure storage (e.g. blob.core.windows.net)"`
 }
 
+//nolint:lll
+type CacheRegistryConfig struct {
+	Repository string `toml:"Repository,omitempty" long:"repository" env:"CACHE_REGISTRY_REPOSITORY" description:"Repository of the container registry in which the caches are stored as OCI artifacts (e.g. registry.example.com/group/cache)"`
+	Username   string `toml:"Username,omitempty" long:"username" env:"CACHE_REGISTRY_USERNAME" description:"Username authenticating to the registry, by default the Docker credentials of the runner are used"`
+	Password   string `toml:"Password,omitempty" long:"password" env:"CACHE_REGISTRY_PASSWORD" description:"Password authenticating to the registry"`
+	Insecure   bool   `toml:"Insecure,omitempty" long:"insecure" env:"CACHE_REGISTRY_INSECURE" description:"Use insecure mode (without https)"`
+}
+
+//nolint:lll
+type CacheFilesystemConfig struct {
+	Path string `toml:"Path,omitempty" long:"path" env:"CACHE_FILESYSTEM_PATH" description:"Directory in which the cache archives are stored"`
+}
+
+//nolint:lll
+type CacheEncryptionConfig struct {
+	KeyID        string            `toml:"KeyID,omitempty" long:"key-id" env:"CACHE_ENCRYPTION_KEY_ID" description:"ID of the key encrypting the caches, recorded with the cache objects"`
+	Key          string            `toml:"Key,omitempty" long:"key" env:"CACHE_ENCRYPTION_KEY" description:"Base64 encoded 256-bit key encrypting the caches"`
+	KeyVariable  string            `toml:"KeyVariable,omitempty" long:"key-variable" env:"CACHE_ENCRYPTION_KEY_VARIABLE" description:"Name of the variable of the runner's environment holding the base64 encoded key"`
+	PreviousKeys map[string]string `toml:"PreviousKeys,omitempty" json:"previous_keys" description:"Base64 encoded keys of previous key IDs, decrypting the caches created before a key rotation"`
+}
+
+//nolint:lll
+type VaultConfig struct {
+	Servers []VaultServerDefaults `toml:"servers,omitempty" json:"servers" description:"Vault servers receiving the runner's default auth data"`
+}
+
+//nolint:lll
+type VaultServerDefaults struct {
+	URL  string                   `toml:"url" json:"url" description:"URL of the Vault server"`
+	Auth map[string]VaultAuthData `toml:"auth,omitempty" json:"auth" description:"Default data of the Vault auth methods, by auth method name, used for the keys the job doesn't provide"`
+}
+
+// GetAuthDefaults returns the default data of the auth method for the
+// configured Vault server with the URL, or nil when the server isn't configured
+func (c *VaultConfig) GetAuthDefaults(serverURL string, method string) VaultAuthData {
+	if c == nil {
+		return nil
+	}
+
+	serverURL = strings.TrimRight(serverURL, "/")
+	for _, server := range c.Servers {
+		if server.URL != "" && strings.TrimRight(server.URL, "/") == serverURL {
+			return server.Auth[method]
+		}
+	}
+
+	return nil
+}
+
+//nolint:lll
+type VaultServerConfig struct {
+	URL    string                  `toml:"url" json:"url" description:"URL of the Vault server"`
+	Auth   VaultServerAuthConfig   `toml:"auth" json:"auth"`
+	Engine VaultServerEngineConfig `toml:"engine" json:"engine"`
+}
+
+//nolint:lll
+type VaultServerAuthConfig struct {
+	Name string        `toml:"name" json:"name" description:"Name of the Vault auth method"`
+	Path string        `toml:"path" json:"path" description:"Path of the Vault auth method"`
+	Data VaultAuthData `toml:"data,omitempty" json:"data" description:"Data of the Vault auth method"`
+}
+
+//nolint:lll
+type VaultServerEngineConfig struct {
+	Name string `toml:"name" json:"name" description:"Name of the Vault secret engine, kv-v2 by default"`
+	Path string `toml:"path" json:"path" description:"Path of the Vault secret engine, secret by default"`
+}
+
+//nolint:lll
+type SecretPluginConfig struct {
+	Exec        string   `toml:"exec" json:"exec" description:"Executable resolving the secrets of the plugin"`
+	Args        []string `toml:"args,omitempty" json:"args" description:"Arguments for the executable"`
+	ExecTimeout *int     `toml:"exec_timeout,omitempty" json:"exec_timeout" description:"Timeout for resolving a secret with the executable (in seconds)"`
+}
+
+// GetExecTimeout returns the timeout of the executable resolving a secret
+func (c *SecretPluginConfig) GetExecTimeout() time.Duration {
+	if c.ExecTimeout == nil || *c.ExecTimeout <= 0 {
+		return DefaultSecretPluginExecTimeout
+	}
+
+	return time.Duration(*c.ExecTimeout) * time.Second
+}
+
+const (
+	CacheFormatZip     = "zip"
+	CacheFormatTarZstd = "tarzstd"
+	CacheFormatChunked = "chunked"
+)
+
 //nolint:lll
 type CacheConfig struct {
 	Type   string `toml:"Type,omitempty" long:"type" env:"CACHE_TYPE" description:"Select caching method"`
 	Path   string `toml:"Path,omitempty" long:"path" env:"CACHE_PATH" description:"Name of the path to prepend to the cache URL"`
 	Shared bool   `toml:"Shared,omitempty" long:"shared" env:"CACHE_SHARED" description:"Enable cache sharing between runners."`
+	Format string `toml:"Format,omitempty" long:"format" env:"CACHE_FORMAT" description:"Format of the cache: zip (default), tarzstd or chunked"`
+
+	UploadPartSize string `toml:"UploadPartSize,omitempty" long:"upload-part-size" env:"CACHE_UPLOAD_PART_SIZE" description:"Minimum size of the parts of the caches uploaded in parts (e.g. 64MB). Enables the multipart uploads of the s3, gcs and azure types, resumed from the last uploaded part when retried"`
 
-	S3    *CacheS3Config    `toml:"s3,omitempty" json:"s3" namespace:"s3"`
-	GCS   *CacheGCSConfig   `toml:"gcs,omitempty" json:"gcs" namespace:"gcs"`
-	Azure *CacheAzureConfig `toml:"azure,omitempty" json:"azure" namespace:"azure"`
+	S3         *CacheS3Config         `toml:"s3,omitempty" json:"s3" namespace:"s3"`
+	GCS        *CacheGCSConfig        `toml:"gcs,omitempty" json:"gcs" namespace:"gcs"`
+	Azure      *CacheAzureConfig      `toml:"azure,omitempty" json:"azure" namespace:"azure"`
+	Filesystem *CacheFilesystemConfig `toml:"filesystem,omitempty" json:"filesystem" namespace:"filesystem"`
+	Registry   *CacheRegistryConfig   `toml:"registry,omitempty" json:"registry" namespace:"registry"`
+
+	Encryption *CacheEncryptionConfig `toml:"encryption,omitempty" json:"encryption" namespace:"encryption"`
 }
 
 //nolint:lll
@@ -560,6 +659,9 @@ type RunnerSettings struct {
 	CustomBuildDir *CustomBuildDir  `toml:"custom_build_dir,omitempty" json:"custom_build_dir" group:"custom build dir configuration" namespace:"custom_build_dir"`
 	Referees       *referees.Config `toml:"referees,omitempty" json:"referees" group:"referees configuration" namespace:"referees"`
 	Cache          *CacheConfig     `toml:"cache,omitempty" json:"cache" group:"cache configuration" namespace:"cache"`
+	Vault          *VaultConfig     `toml:"vault,omitempty" json:"vault" group:"vault configuration" namespace:"vault"`
+
+	SecretPlugins map[string]*SecretPluginConfig `toml:"secret_plugins,omitempty" json:"secret_plugins" description:"Executables resolving the plugin secrets of the jobs, by plugin name"`
 
 	// GracefulKillTimeout and ForceKillTimeout aren't exposed to the users yet
 	// because not every executor supports it. We also have to keep in mind that
@@ -612,6 +714,10 @@ type Config struct {
 	SentryDSN     *string         `toml:"sentry_dsn"`
 	ModTime       time.Time       `toml:"-"`
 	Loaded        bool            `toml:"-"`
+
+	Vault *VaultServerConfig `toml:"vault,omitempty" json:"vault" description:"Vault server resolving the vault:// references of the configuration"`
+
+	references []*configReference
 }
 
 //nolint:lll
@@ -631,6 +737,62 @@ func (c *CacheConfig) GetShared() bool {
 	return c.Shared
 }
 
+// GetUploadPartSize returns the minimum size of the parts of the multipart
+// uploads, or 0 when the caches are uploaded in a single request
+func (c *CacheConfig) GetUploadPartSize() (int64, error) {
+	if c.UploadPartSize == "" {
+		return 0, nil
+	}
+
+	size, err := units.RAMInBytes(c.UploadPartSize)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cache upload part size: %w", err)
+	}
+
+	if size <= 0 {
+		return 0, fmt.Errorf("invalid cache upload part size %q", c.UploadPartSize)
+	}
+
+	return size, nil
+}
+
+// GetKey returns the encoded key, read from the variables of the runner's
+// configuration when it's set with KeyVariable. The variables of the jobs
+// aren't used, so that a job can't choose the key.
+func (c *CacheEncryptionConfig) GetKey(runnerVariables JobVariables) string {
+	if c.KeyVariable != "" {
+		return runnerVariables.Get(c.KeyVariable)
+	}
+
+	return c.Key
+}
+
+// maskedValues returns the encoded keys, as configured and as passed to the
+// cache helpers, to be hidden in the job output
+func (c *CacheEncryptionConfig) maskedValues(runnerVariables JobVariables) []string {
+	keys := []string{c.GetKey(runnerVariables)}
+	for _, key := range c.PreviousKeys {
+		keys = append(keys, key)
+	}
+
+	var masked []string
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+
+		masked = append(masked, key)
+		if decoded, err := base64.StdEncoding.DecodeString(key); err == nil {
+			if encoded := base64.StdEncoding.EncodeToString(decoded); encoded != key {
+				masked = append(masked, encoded)
+			}
+		}
+	}
+
+	return masked
+}
+
 func (r *RunnerSettings) GetGracefulKillTimeout() time.Duration {
 	return getDuration(r.GracefulKillTimeout, process.GracefulTimeout)
 }
@@ -1102,6 +1264,11 @@ func (c *Config) LoadConfig(configFile string) error {
 		return err
 	}
 
+	err = c.resolveReferences()
+	if err != nil {
+		return fmt.Errorf("resolving config references: %w", err)
+	}
+
 	for _, runner := range c.Runners {
 		if runner.Machine == nil {
 			continue
@@ -1123,7 +1290,11 @@ func (c *Config) SaveConfig(configFile string) error {
 	var newConfig bytes.Buffer
 	newBuffer := bufio.NewWriter(&newConfig)
 
-	if err := toml.NewEncoder(newBuffer).Encode(c); err != nil {
+	// the references are saved instead of the values they resolved
+	err := c.withReferences(func() error {
+		return toml.NewEncoder(newBuffer).Encode(c)
+	})
+	if err != nil {
 		logrus.Fatalf("Error encoding TOML: %s", err)
 		return err
 	}
@@ -1133,7 +1304,7 @@ func (c *Config) SaveConfig(configFile string) error {
 	}
 
 	// create directory to store configuration
-	err := os.MkdirAll(filepath.Dir(configFile), 0700)
+	err = os.MkdirAll(filepath.Dir(configFile), 0700)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,287 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+)
+
+const configReferenceSeparator = "://"
+
+// ConfigReferenceResolver resolves the references of a scheme used as values
+// in the configuration, like env://NAME
+type ConfigReferenceResolver interface {
+	// Resolve returns the value referenced by the location, which is the
+	// reference without its scheme
+	Resolve(location string) (string, error)
+}
+
+// ConfigReferenceResolverFactory creates the resolver of the references of a
+// configuration. The resolvers implementing io.Closer are closed once the
+// references are resolved.
+type ConfigReferenceResolverFactory func(config *Config) ConfigReferenceResolver
+
+type configReferenceScheme struct {
+	name    string
+	factory ConfigReferenceResolverFactory
+}
+
+// configReferenceSchemes are resolved in their registration order, so that
+// the configuration of a resolver can use the schemes registered before
+var configReferenceSchemes = []configReferenceScheme{
+	{name: "env", factory: newEnvConfigReferenceResolver},
+	{name: "file", factory: newFileConfigReferenceResolver},
+}
+
+func RegisterConfigReferenceResolver(scheme string, factory ConfigReferenceResolverFactory) {
+	configReferenceSchemes = append(configReferenceSchemes, configReferenceScheme{
+		name:    scheme,
+		factory: factory,
+	})
+}
+
+// configReference is a value of the configuration resolved from a reference.
+// The reference is written back instead of the value when saving the
+// configuration.
+type configReference struct {
+	path      string
+	scheme    string
+	location  string
+	reference string
+	value     string
+
+	get func() string
+	set func(value string)
+}
+
+func parseConfigReference(value string) (string, string, bool) {
+	i := strings.Index(value, configReferenceSeparator)
+	if i < 1 {
+		return "", "", false
+	}
+
+	scheme := value[:i]
+	for _, s := range configReferenceSchemes {
+		if s.name == scheme {
+			return scheme, value[i+len(configReferenceSeparator):], true
+		}
+	}
+
+	return "", "", false
+}
+
+// resolveReferences replaces the references of the configuration with the
+// values they reference
+func (c *Config) resolveReferences() error {
+	c.references = nil
+
+	walkConfigStrings(reflect.ValueOf(c), "", func(path string, get func() string, set func(string)) {
+		reference := get()
+
+		scheme, location, ok := parseConfigReference(reference)
+		if !ok {
+			return
+		}
+
+		c.references = append(c.references, &configReference{
+			path:      path,
+			scheme:    scheme,
+			location:  location,
+			reference: reference,
+			get:       get,
+			set:       set,
+		})
+	})
+
+	for _, scheme := range configReferenceSchemes {
+		err := c.resolveSchemeReferences(scheme)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) resolveSchemeReferences(scheme configReferenceScheme) error {
+	var resolver ConfigReferenceResolver
+
+	for _, ref := range c.references {
+		if ref.scheme != scheme.name {
+			continue
+		}
+
+		if resolver == nil {
+			resolver = scheme.factory(c)
+			if closer, ok := resolver.(io.Closer); ok {
+				defer func() { _ = closer.Close() }()
+			}
+		}
+
+		value, err := resolver.Resolve(ref.location)
+		if err != nil {
+			return fmt.Errorf("resolving %s reference of %s: %w", ref.scheme, ref.path, err)
+		}
+
+		ref.value = value
+		ref.set(value)
+	}
+
+	return nil
+}
+
+// withReferences runs fn with the references in place of the values they
+// resolved. The values changed since they were resolved are kept.
+func (c *Config) withReferences(fn func() error) error {
+	var replaced []*configReference
+	for _, ref := range c.references {
+		if ref.get() != ref.value {
+			continue
+		}
+
+		ref.set(ref.reference)
+		replaced = append(replaced, ref)
+	}
+
+	defer func() {
+		for _, ref := range replaced {
+			ref.set(ref.value)
+		}
+	}()
+
+	return fn()
+}
+
+// Masked returns a copy of the configuration with the references in place of
+// the values they resolved, safe to be logged
+func (c *Config) Masked() (*Config, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("serialization of config failed: %w", err)
+	}
+
+	masked := new(Config)
+
+	err = json.Unmarshal(data, masked)
+	if err != nil {
+		return nil, fmt.Errorf("deserialization of config failed: %w", err)
+	}
+
+	references := make(map[string]*configReference, len(c.references))
+	for _, ref := range c.references {
+		references[ref.path] = ref
+	}
+
+	walkConfigStrings(reflect.ValueOf(masked), "", func(path string, get func() string, set func(string)) {
+		if ref, ok := references[path]; ok && get() == ref.value {
+			set(ref.reference)
+		}
+	})
+
+	return masked, nil
+}
+
+// walkConfigStrings calls fn for each settable string of the exported
+// fields, slices and maps of the value
+func walkConfigStrings(v reflect.Value, path string, fn func(path string, get func() string, set func(string))) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			walkConfigStrings(v.Elem(), path, fn)
+		}
+
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+
+			walkConfigStrings(v.Field(i), path+"."+field.Name, fn)
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			walkConfigStrings(v.Index(i), fmt.Sprintf("%s[%d]", path, i), fn)
+		}
+
+	case reflect.Map:
+		walkConfigMapStrings(v, path, fn)
+
+	case reflect.String:
+		if v.CanSet() {
+			fn(path, v.String, v.SetString)
+		}
+	}
+}
+
+func walkConfigMapStrings(m reflect.Value, path string, fn func(path string, get func() string, set func(string))) {
+	for _, key := range m.MapKeys() {
+		key := key
+		elemPath := fmt.Sprintf("%s[%v]", path, key.Interface())
+
+		elem := m.MapIndex(key)
+		if elem.Kind() == reflect.Interface && !elem.IsNil() {
+			elem = elem.Elem()
+		}
+
+		if elem.Kind() != reflect.String {
+			walkConfigStrings(elem, elemPath, fn)
+			continue
+		}
+
+		get := func() string {
+			value := m.MapIndex(key)
+			if value.Kind() == reflect.Interface && !value.IsNil() {
+				value = value.Elem()
+			}
+			if value.Kind() != reflect.String {
+				return ""
+			}
+
+			return value.String()
+		}
+		set := func(value string) {
+			m.SetMapIndex(key, reflect.ValueOf(value).Convert(m.Type().Elem()))
+		}
+
+		fn(elemPath, get, set)
+	}
+}
+
+type envConfigReferenceResolver struct{}
+
+func newEnvConfigReferenceResolver(_ *Config) ConfigReferenceResolver {
+	return new(envConfigReferenceResolver)
+}
+
+// Resolve reads the environment variable of env://NAME
+func (r *envConfigReferenceResolver) Resolve(location string) (string, error) {
+	value, ok := os.LookupEnv(location)
+	if !ok {
+		return "", fmt.Errorf("environment variable %q not set", location)
+	}
+
+	return value, nil
+}
+
+type fileConfigReferenceResolver struct{}
+
+func newFileConfigReferenceResolver(_ *Config) ConfigReferenceResolver {
+	return new(fileConfigReferenceResolver)
+}
+
+// Resolve reads the file of file:///path/to/file, without its trailing new
+// line
+func (r *fileConfigReferenceResolver) Resolve(location string) (string, error) {
+	data, err := ioutil.ReadFile(location)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
@@ -0,0 +1,176 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const configReferencesTestEnv = "CONFIG_REFERENCES_TEST_TOKEN"
+
+// writeReferencesTestConfig writes the config, with {secret_file} replaced by
+// the path of a file storing a secret
+func writeReferencesTestConfig(t *testing.T, dir string, config string) string {
+	secretFile := filepath.Join(dir, "secret-key")
+	err := ioutil.WriteFile(secretFile, []byte("file-secret-key\n"), 0600)
+	require.NoError(t, err)
+
+	config = strings.Replace(config, "{secret_file}", filepath.ToSlash(secretFile), -1)
+
+	configFile := filepath.Join(dir, "config.toml")
+	err = ioutil.WriteFile(configFile, []byte(config), 0600)
+	require.NoError(t, err)
+
+	return configFile
+}
+
+func TestConfig_LoadConfigReferences(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config-references")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	configFile := writeReferencesTestConfig(t, dir, `
+[[runners]]
+  token = "env://`+configReferencesTestEnv+`"
+  url = "https://gitlab.example.com"
+  [runners.cache.s3]
+    SecretKey = "file://{secret_file}"
+  [[runners.vault.servers]]
+    url = "https://vault.example.com"
+    [runners.vault.servers.auth.approle]
+      secret_id = "env://`+configReferencesTestEnv+`"
+`)
+
+	oldValue, oldSet := os.LookupEnv(configReferencesTestEnv)
+	defer func() {
+		if oldSet {
+			_ = os.Setenv(configReferencesTestEnv, oldValue)
+			return
+		}
+		_ = os.Unsetenv(configReferencesTestEnv)
+	}()
+	require.NoError(t, os.Setenv(configReferencesTestEnv, "env-token"))
+
+	config := NewConfig()
+	err = config.LoadConfig(configFile)
+	require.NoError(t, err)
+
+	require.Len(t, config.Runners, 1)
+	runner := config.Runners[0]
+	assert.Equal(t, "env-token", runner.Token)
+	assert.Equal(t, "https://gitlab.example.com", runner.URL)
+	assert.Equal(t, "file-secret-key", runner.Cache.S3.SecretKey)
+	assert.Equal(t, "env-token", runner.Vault.Servers[0].Auth["approle"]["secret_id"])
+
+	t.Run("masked", func(t *testing.T) {
+		masked, err := config.Masked()
+		require.NoError(t, err)
+
+		assert.Equal(t, "env://"+configReferencesTestEnv, masked.Runners[0].Token)
+		assert.Contains(t, masked.Runners[0].Cache.S3.SecretKey, "file://")
+		assert.Equal(t, "env://"+configReferencesTestEnv, masked.Runners[0].Vault.Servers[0].Auth["approle"]["secret_id"])
+
+		// the configuration keeps the resolved values
+		assert.Equal(t, "env-token", runner.Token)
+	})
+
+	t.Run("saved", func(t *testing.T) {
+		runner.Cache.S3.SecretKey = "changed-secret-key"
+
+		savedFile := filepath.Join(dir, "saved.toml")
+		err := config.SaveConfig(savedFile)
+		require.NoError(t, err)
+
+		saved, err := ioutil.ReadFile(savedFile)
+		require.NoError(t, err)
+
+		assert.Contains(t, string(saved), `token = "env://`+configReferencesTestEnv+`"`)
+		assert.Contains(t, string(saved), `secret_id = "env://`+configReferencesTestEnv+`"`)
+		assert.Contains(t, string(saved), `SecretKey = "changed-secret-key"`)
+		assert.NotContains(t, string(saved), "env-token")
+
+		// the configuration keeps the resolved values
+		assert.Equal(t, "env-token", runner.Token)
+		assert.Equal(t, "env-token", runner.Vault.Servers[0].Auth["approle"]["secret_id"])
+	})
+}
+
+func TestConfig_LoadConfigReferencesErrors(t *testing.T) {
+	tests := map[string]struct {
+		config        string
+		expectedError string
+	}{
+		"environment variable not set": {
+			config: `
+[[runners]]
+  token = "env://CONFIG_REFERENCES_TEST_NOT_SET"
+`,
+			expectedError: `resolving config references: resolving env reference of ` +
+				`.Runners[0].RunnerCredentials.Token: environment variable "CONFIG_REFERENCES_TEST_NOT_SET" not set`,
+		},
+		"file not found": {
+			config: `
+[[runners]]
+  token = "file:///config-references-test/not-found"
+`,
+			expectedError: "resolving config references: resolving file reference of " +
+				".Runners[0].RunnerCredentials.Token: open /config-references-test/not-found",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "config-references")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			configFile := writeReferencesTestConfig(t, dir, tt.config)
+
+			err = NewConfig().LoadConfig(configFile)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedError)
+		})
+	}
+}
+
+func TestParseConfigReference(t *testing.T) {
+	tests := map[string]struct {
+		value            string
+		expectedScheme   string
+		expectedLocation string
+		expectedOK       bool
+	}{
+		"env reference": {
+			value:            "env://NAME",
+			expectedScheme:   "env",
+			expectedLocation: "NAME",
+			expectedOK:       true,
+		},
+		"file reference": {
+			value:            "file:///run/secrets/token",
+			expectedScheme:   "file",
+			expectedLocation: "/run/secrets/token",
+			expectedOK:       true,
+		},
+		"URL": {
+			value: "https://gitlab.example.com",
+		},
+		"plain value": {
+			value: "token",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			scheme, location, ok := parseConfigReference(tt.value)
+			assert.Equal(t, tt.expectedOK, ok)
+			assert.Equal(t, tt.expectedScheme, scheme)
+			assert.Equal(t, tt.expectedLocation, location)
+		})
+	}
+}
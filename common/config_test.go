@@ -131,6 +131,24 @@ func TestConfigParse(t *testing.T) {
 				assert.Equal(t, "", config.Runners[0].Docker.Services[1].Alias)
 			},
 		},
+		"parse secret plugins": {
+			config: `
+				[[runners]]
+				[runners.secret_plugins.internal]
+				exec = "/usr/local/bin/internal-secrets"
+				args = ["resolve"]
+				exec_timeout = 10
+			`,
+			validateConfig: func(t *testing.T, config *Config) {
+				require.Equal(t, 1, len(config.Runners))
+				require.Contains(t, config.Runners[0].SecretPlugins, "internal")
+
+				plugin := config.Runners[0].SecretPlugins["internal"]
+				assert.Equal(t, "/usr/local/bin/internal-secrets", plugin.Exec)
+				assert.Equal(t, []string{"resolve"}, plugin.Args)
+				assert.Equal(t, 10*time.Second, plugin.GetExecTimeout())
+			},
+		},
 		"parse Service as table with only alias": {
 			config: `
 				[[runners]]
@@ -755,6 +773,110 @@ func TestRunnerSettings_GetGracefulKillTimeout_GetForceKillTimeout(t *testing.T)
 	}
 }
 
+func TestCacheConfig_GetUploadPartSize(t *testing.T) {
+	tests := map[string]struct {
+		uploadPartSize   string
+		expectedPartSize int64
+		expectedErr      bool
+	}{
+		"undefined": {
+			expectedPartSize: 0,
+		},
+		"size with unit": {
+			uploadPartSize:   "64MB",
+			expectedPartSize: 64 * 1024 * 1024,
+		},
+		"invalid size": {
+			uploadPartSize: "large",
+			expectedErr:    true,
+		},
+		"size lower than 0": {
+			uploadPartSize: "-1",
+			expectedErr:    true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			config := CacheConfig{UploadPartSize: tt.uploadPartSize}
+
+			partSize, err := config.GetUploadPartSize()
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedPartSize, partSize)
+		})
+	}
+}
+
+func TestSecretPluginConfig_GetExecTimeout(t *testing.T) {
+	timeout := func(seconds int) *int {
+		return &seconds
+	}
+
+	tests := map[string]struct {
+		execTimeout     *int
+		expectedTimeout time.Duration
+	}{
+		"undefined": {
+			expectedTimeout: DefaultSecretPluginExecTimeout,
+		},
+		"timeout defined": {
+			execTimeout:     timeout(5),
+			expectedTimeout: 5 * time.Second,
+		},
+		"timeout lower than 1": {
+			execTimeout:     timeout(0),
+			expectedTimeout: DefaultSecretPluginExecTimeout,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			config := SecretPluginConfig{ExecTimeout: tt.execTimeout}
+			assert.Equal(t, tt.expectedTimeout, config.GetExecTimeout())
+		})
+	}
+}
+
+func TestCacheEncryptionConfig_maskedValues(t *testing.T) {
+	tests := map[string]struct {
+		config         CacheEncryptionConfig
+		environment    JobVariables
+		expectedMasked []string
+	}{
+		"key": {
+			config:         CacheEncryptionConfig{Key: "a2V5"},
+			expectedMasked: []string{"a2V5"},
+		},
+		"key with spaces": {
+			config:         CacheEncryptionConfig{Key: " a2V5\n"},
+			expectedMasked: []string{"a2V5"},
+		},
+		"key from the runner's environment": {
+			config:         CacheEncryptionConfig{KeyVariable: "CACHE_KEY"},
+			environment:    JobVariables{{Key: "CACHE_KEY", Value: "a2V5"}},
+			expectedMasked: []string{"a2V5"},
+		},
+		"previous keys": {
+			config:         CacheEncryptionConfig{Key: "a2V5", PreviousKeys: map[string]string{"v1": "b2xk"}},
+			expectedMasked: []string{"a2V5", "b2xk"},
+		},
+		"no key": {
+			config: CacheEncryptionConfig{KeyVariable: "CACHE_KEY"},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			assert.Equal(t, tt.expectedMasked, tt.config.maskedValues(tt.environment))
+		})
+	}
+}
+
 func TestDockerConfig_GetPullPolicies(t *testing.T) {
 	tests := map[string]struct {
 		config               DockerConfig
@@ -9,6 +9,22 @@ type MockSecretsResolver struct {
 	mock.Mock
 }
 
+// Leases provides a mock function with given fields:
+func (_m *MockSecretsResolver) Leases() SecretSources {
+	ret := _m.Called()
+
+	var r0 SecretSources
+	if rf, ok := ret.Get(0).(func() SecretSources); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(SecretSources)
+		}
+	}
+
+	return r0
+}
+
 // Resolve provides a mock function with given fields: secrets
 func (_m *MockSecretsResolver) Resolve(secrets Secrets) (JobVariables, error) {
 	ret := _m.Called(secrets)
@@ -31,3 +47,8 @@ func (_m *MockSecretsResolver) Resolve(secrets Secrets) (JobVariables, error) {
 
 	return r0, r1
 }
+
+// RevokeLeases provides a mock function with given fields: leases
+func (_m *MockSecretsResolver) RevokeLeases(leases SecretSources) {
+	_m.Called(leases)
+}
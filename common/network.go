@@ -1,7 +1,9 @@
 package common
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"strings"
@@ -249,6 +251,7 @@ const (
 	ArtifactFormatZip     ArtifactFormat = "zip"
 	ArtifactFormatGzip    ArtifactFormat = "gzip"
 	ArtifactFormatRaw     ArtifactFormat = "raw"
+	ArtifactFormatTarZstd ArtifactFormat = "tarzstd"
 )
 
 type Artifact struct {
@@ -265,11 +268,60 @@ type Artifact struct {
 type Artifacts []Artifact
 
 type Cache struct {
-	Key       string        `json:"key"`
-	Untracked bool          `json:"untracked"`
-	Policy    CachePolicy   `json:"policy"`
-	Paths     ArtifactPaths `json:"paths"`
-	When      CacheWhen     `json:"when"`
+	Key string `json:"key"`
+	// KeyFiles and KeyPrefix configure the key computed from the files
+	// (cache:key:files), when it isn't computed by GitLab
+	KeyFiles     []string      `json:"key_files,omitempty"`
+	KeyPrefix    string        `json:"key_prefix,omitempty"`
+	FallbackKeys []string      `json:"fallback_keys"`
+	Untracked    bool          `json:"untracked"`
+	Policy       CachePolicy   `json:"policy"`
+	Paths        ArtifactPaths `json:"paths"`
+	When         CacheWhen     `json:"when"`
+}
+
+// CacheKeyFiles is the cache:key:files configuration
+type CacheKeyFiles struct {
+	Files  []string `json:"files"`
+	Prefix string   `json:"prefix"`
+}
+
+// UnmarshalJSON accepts the cache:key:files configuration in place of the
+// computed key, as sent by the GitLab instances not computing it
+func (c *Cache) UnmarshalJSON(data []byte) error {
+	type cache Cache
+
+	var raw struct {
+		cache
+		Key json.RawMessage `json:"key"`
+	}
+
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+
+	*c = Cache(raw.cache)
+
+	trimmed := bytes.TrimSpace(raw.Key)
+	if len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+		return nil
+	}
+
+	if trimmed[0] != '{' {
+		return json.Unmarshal(trimmed, &c.Key)
+	}
+
+	var keyFiles CacheKeyFiles
+	err = json.Unmarshal(trimmed, &keyFiles)
+	if err != nil {
+		return fmt.Errorf("decoding cache key files: %w", err)
+	}
+
+	c.KeyFiles = keyFiles.Files
+	c.KeyPrefix = keyFiles.Prefix
+
+	return nil
 }
 
 type CacheWhen string
@@ -360,7 +412,32 @@ type JobResponse struct {
 type Secrets map[string]Secret
 
 type Secret struct {
-	Vault *VaultSecret `json:"vault,omitempty"`
+	Vault  *VaultSecret  `json:"vault,omitempty"`
+	Plugin *PluginSecret `json:"plugin,omitempty"`
+}
+
+// PluginSecret is a secret resolved by an executable configured in the
+// runner's secret_plugins section. The job only selects the plugin by name,
+// the executable and the job metadata being set by the runner.
+type PluginSecret struct {
+	Name string                 `json:"name"`
+	Data map[string]interface{} `json:"data"`
+
+	Config *SecretPluginConfig `json:"-"`
+	Job    PluginSecretJob     `json:"-"`
+}
+
+// PluginSecretJob is the metadata of the job resolving the secret
+type PluginSecretJob struct {
+	ID          int
+	Name        string
+	Stage       string
+	ProjectID   int
+	ProjectName string
+	PipelineID  string
+	Ref         string
+	Sha         string
+	URL         string
 }
 
 type VaultSecret struct {
@@ -398,6 +475,18 @@ func (s Secret) expandVariables(vars JobVariables) {
 	if s.Vault != nil {
 		s.Vault.expandVariables(vars)
 	}
+
+	if s.Plugin != nil {
+		s.Plugin.expandVariables(vars)
+	}
+}
+
+func (s *PluginSecret) expandVariables(vars JobVariables) {
+	for key, value := range s.Data {
+		if str, ok := value.(string); ok {
+			s.Data[key] = vars.ExpandValue(str)
+		}
+	}
 }
 
 func (s *VaultSecret) expandVariables(vars JobVariables) {
@@ -407,14 +496,58 @@ func (s *VaultSecret) expandVariables(vars JobVariables) {
 
 	s.Server.Auth.Path = vars.ExpandValue(s.Server.Auth.Path)
 
-	jwt, ok := s.Server.Auth.Data["jwt"]
-	if ok {
-		s.Server.Auth.Data["jwt"] = vars.ExpandValue(fmt.Sprintf("%s", jwt))
+	for key, value := range s.Server.Auth.Data {
+		if str, ok := value.(string); ok {
+			s.Server.Auth.Data[key] = vars.ExpandValue(str)
+		}
 	}
+}
+
+// vaultAuthFileKeys are the keys of the auth data naming files of the runner's
+// host, which are only read from the runner's configuration
+var vaultAuthFileKeys = []string{"token_path", "cert_file", "key_file", "ca_cert"}
+
+// applyVaultAuthDefaults removes the file keys provided by the job, and fills
+// the auth data not provided by the job with the runner's defaults for the
+// auth method, when the job uses a Vault server of the runner's configuration
+func (s Secrets) applyVaultAuthDefaults(config *VaultConfig) {
+	for _, secret := range s {
+		if secret.Vault == nil {
+			continue
+		}
+
+		auth := &secret.Vault.Server.Auth
+		for _, key := range vaultAuthFileKeys {
+			delete(auth.Data, key)
+		}
+
+		defaults := config.GetAuthDefaults(secret.Vault.Server.URL, auth.Name)
+		if len(defaults) < 1 {
+			continue
+		}
+
+		if auth.Data == nil {
+			auth.Data = make(VaultAuthData, len(defaults))
+		}
+
+		for key, value := range defaults {
+			if _, ok := auth.Data[key]; !ok {
+				auth.Data[key] = value
+			}
+		}
+	}
+}
+
+// applySecretPlugins sets the configuration of the plugins selected by the
+// plugin secrets, and the metadata of the job resolving them
+func (s Secrets) applySecretPlugins(plugins map[string]*SecretPluginConfig, job PluginSecretJob) {
+	for _, secret := range s {
+		if secret.Plugin == nil {
+			continue
+		}
 
-	role, ok := s.Server.Auth.Data["role"]
-	if ok {
-		s.Server.Auth.Data["role"] = vars.ExpandValue(fmt.Sprintf("%s", role))
+		secret.Plugin.Config = plugins[secret.Plugin.Name]
+		secret.Plugin.Job = job
 	}
 }
 
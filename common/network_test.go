@@ -1,6 +1,7 @@
 package common
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"testing"
@@ -71,6 +72,49 @@ func TestShouldCache(t *testing.T) {
 	}
 }
 
+func TestCache_UnmarshalJSON(t *testing.T) {
+	tests := map[string]struct {
+		json          string
+		expectedCache Cache
+		expectedErr   bool
+	}{
+		"key": {
+			json:          `{"key":"key","paths":["vendor"]}`,
+			expectedCache: Cache{Key: "key", Paths: ArtifactPaths{"vendor"}},
+		},
+		"no key": {
+			json:          `{"paths":["vendor"]}`,
+			expectedCache: Cache{Paths: ArtifactPaths{"vendor"}},
+		},
+		"key files": {
+			json: `{"key":{"files":["Gemfile.lock"],"prefix":"deps"},"paths":["vendor"]}`,
+			expectedCache: Cache{
+				KeyFiles:  []string{"Gemfile.lock"},
+				KeyPrefix: "deps",
+				Paths:     ArtifactPaths{"vendor"},
+			},
+		},
+		"invalid key": {
+			json:        `{"key":1}`,
+			expectedErr: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			var cache Cache
+			err := json.Unmarshal([]byte(tt.json), &cache)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedCache, cache)
+		})
+	}
+}
+
 func TestSecrets_expandVariables(t *testing.T) {
 	jobJWT := "job-jwt"
 	testRole := "role"
@@ -166,6 +210,54 @@ func TestSecrets_expandVariables(t *testing.T) {
 				)
 			},
 		},
+		"vault secret with other auth method": {
+			secrets: Secrets{
+				"VAULT": Secret{
+					Vault: &VaultSecret{
+						Server: VaultServer{
+							Auth: VaultAuth{
+								Name: "approle",
+								Data: map[string]interface{}{
+									"role_id": "role ${CI_JOB_JWT}",
+									"number":  1,
+								},
+							},
+						},
+					},
+				},
+			},
+			assertSecrets: func(t *testing.T, secrets Secrets) {
+				require.NotNil(t, secrets["VAULT"].Vault)
+				assert.Equal(
+					t,
+					fmt.Sprintf("role %s", jobJWT),
+					secrets["VAULT"].Vault.Server.Auth.Data["role_id"],
+				)
+				assert.Equal(t, 1, secrets["VAULT"].Vault.Server.Auth.Data["number"])
+			},
+		},
+		"plugin secret defined": {
+			secrets: Secrets{
+				"PLUGIN": Secret{
+					Plugin: &PluginSecret{
+						Name: "internal",
+						Data: map[string]interface{}{
+							"token":  "token ${CI_JOB_JWT}",
+							"number": 1,
+						},
+					},
+				},
+			},
+			assertSecrets: func(t *testing.T, secrets Secrets) {
+				require.NotNil(t, secrets["PLUGIN"].Plugin)
+				assert.Equal(
+					t,
+					fmt.Sprintf("token %s", jobJWT),
+					secrets["PLUGIN"].Plugin.Data["token"],
+				)
+				assert.Equal(t, 1, secrets["PLUGIN"].Plugin.Data["number"])
+			},
+		},
 	}
 
 	for tn, tt := range tests {
@@ -178,6 +270,154 @@ func TestSecrets_expandVariables(t *testing.T) {
 	}
 }
 
+func TestSecrets_applyVaultAuthDefaults(t *testing.T) {
+	config := &VaultConfig{
+		Servers: []VaultServerDefaults{
+			{
+				URL: "https://vault.example.com/",
+				Auth: map[string]VaultAuthData{
+					"approle": {
+						"role_id":   "default-role-id",
+						"secret_id": "default-secret-id",
+					},
+					"cert": {
+						"cert_file": "/etc/vault/cert.pem",
+						"key_file":  "/etc/vault/key.pem",
+					},
+				},
+			},
+		},
+	}
+
+	tests := map[string]struct {
+		config       *VaultConfig
+		url          string
+		auth         VaultAuth
+		expectedData VaultAuthData
+	}{
+		"no defaults": {
+			url: "https://vault.example.com",
+			auth: VaultAuth{
+				Name: "approle",
+				Data: VaultAuthData{"role_id": "role-id"},
+			},
+			expectedData: VaultAuthData{"role_id": "role-id"},
+		},
+		"data not provided by the job": {
+			config: config,
+			url:    "https://vault.example.com",
+			auth:   VaultAuth{Name: "approle"},
+			expectedData: VaultAuthData{
+				"role_id":   "default-role-id",
+				"secret_id": "default-secret-id",
+			},
+		},
+		"data partially provided by the job": {
+			config: config,
+			url:    "https://vault.example.com/",
+			auth: VaultAuth{
+				Name: "approle",
+				Data: VaultAuthData{"role_id": "role-id"},
+			},
+			expectedData: VaultAuthData{
+				"role_id":   "role-id",
+				"secret_id": "default-secret-id",
+			},
+		},
+		"no defaults for the auth method": {
+			config: config,
+			url:    "https://vault.example.com",
+			auth: VaultAuth{
+				Name: "jwt",
+				Data: VaultAuthData{"jwt": "jwt"},
+			},
+			expectedData: VaultAuthData{"jwt": "jwt"},
+		},
+		"server not configured": {
+			config: config,
+			url:    "https://attacker.example.com",
+			auth: VaultAuth{
+				Name: "approle",
+				Data: VaultAuthData{"role_id": "role-id"},
+			},
+			expectedData: VaultAuthData{"role_id": "role-id"},
+		},
+		"file keys provided by the job": {
+			config: config,
+			url:    "https://vault.example.com",
+			auth: VaultAuth{
+				Name: "cert",
+				Data: VaultAuthData{
+					"cert_file": "/etc/shadow",
+					"key_file":  "/etc/shadow",
+					"ca_cert":   "/etc/shadow",
+					"name":      "web",
+				},
+			},
+			expectedData: VaultAuthData{
+				"cert_file": "/etc/vault/cert.pem",
+				"key_file":  "/etc/vault/key.pem",
+				"name":      "web",
+			},
+		},
+		"file keys provided by the job for a server not configured": {
+			config: config,
+			url:    "https://attacker.example.com",
+			auth: VaultAuth{
+				Name: "kubernetes",
+				Data: VaultAuthData{
+					"role":       "role",
+					"token_path": "/var/run/secrets/kubernetes.io/serviceaccount/token",
+				},
+			},
+			expectedData: VaultAuthData{"role": "role"},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			secrets := Secrets{
+				"VAULT": Secret{
+					Vault: &VaultSecret{
+						Server: VaultServer{URL: tt.url, Auth: tt.auth},
+					},
+				},
+				"OTHER": Secret{},
+			}
+
+			secrets.applyVaultAuthDefaults(tt.config)
+			assert.Equal(t, tt.expectedData, secrets["VAULT"].Vault.Server.Auth.Data)
+		})
+	}
+}
+
+func TestSecrets_applySecretPlugins(t *testing.T) {
+	var secrets Secrets
+	err := json.Unmarshal(
+		[]byte(`{
+			"CONFIGURED": {"plugin": {"name": "internal", "config": {"exec": "/bin/false"}}},
+			"UNKNOWN": {"plugin": {"name": "unknown"}},
+			"VAULT": {"vault": {}}
+		}`),
+		&secrets,
+	)
+	require.NoError(t, err)
+
+	// the executable can't be set by the job
+	require.Nil(t, secrets["CONFIGURED"].Plugin.Config)
+
+	config := &SecretPluginConfig{Exec: "/usr/local/bin/internal-secrets"}
+	job := PluginSecretJob{ID: 1, Name: "test"}
+
+	secrets.applySecretPlugins(map[string]*SecretPluginConfig{"internal": config}, job)
+
+	assert.Equal(t, config, secrets["CONFIGURED"].Plugin.Config)
+	assert.Equal(t, job, secrets["CONFIGURED"].Plugin.Job)
+	assert.Nil(t, secrets["UNKNOWN"].Plugin.Config)
+	assert.Equal(t, job, secrets["UNKNOWN"].Plugin.Job)
+	assert.Nil(t, secrets["VAULT"].Plugin)
+}
+
 func TestJobResponse_JobURL(t *testing.T) {
 	jobID := 1
 	//nolint:lll
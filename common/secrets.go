@@ -3,10 +3,16 @@ package common
 import (
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 
 	"gitlab.com/gitlab-org/gitlab-runner/helpers"
 )
 
+// secretsReadConcurrency limits the number of secrets read at the same time
+const secretsReadConcurrency = 8
+
 type logger interface {
 	Println(args ...interface{})
 	Warningln(args ...interface{})
@@ -14,6 +20,9 @@ type logger interface {
 
 type SecretsResolver interface {
 	Resolve(secrets Secrets) (JobVariables, error)
+	// Leases returns the sources of the resolved secrets having a lease
+	Leases() SecretSources
+	RevokeLeases(leases SecretSources)
 }
 
 type SecretResolverRegistry interface {
@@ -29,6 +38,101 @@ type SecretResolver interface {
 	Resolve() (string, error)
 }
 
+// SessionSecretResolver is implemented by the resolvers reading the secrets
+// through a session, which is authenticated once for all the secrets of the
+// same server and authentication
+type SessionSecretResolver interface {
+	SecretResolver
+	// SessionKey identifies the server and the authentication of the secret
+	SessionKey() (string, error)
+	// SourceKey identifies the secret regardless of its field, the variables
+	// reading the fields of the same secret sharing its source
+	SourceKey() (string, error)
+	// Value returns the value of the field of the secret read from the source
+	Value(source *SecretSource) string
+	NewSession() (SecretSession, error)
+}
+
+// SecretSession reads the secrets with an authenticated client. It's used
+// concurrently.
+type SecretSession interface {
+	// Read returns all the fields of the secret, and the ID of its lease when
+	// it's generated with a lease
+	Read(secret Secret) (map[string]interface{}, string, error)
+	RevokeLease(leaseID string) error
+	// Close revokes the authentication of the session
+	Close() error
+}
+
+// SecretSource is a secret read while resolving the secrets of a job. The
+// variables reading its fields share it, so that the fields of generated
+// credentials, like a username and its password, match. The generated
+// secrets have a lease, revoked when the job ends.
+type SecretSource struct {
+	Key        string
+	SessionKey string
+	Secret     Secret
+	Data       map[string]interface{}
+	LeaseID    string
+}
+
+type SecretSources []*SecretSource
+
+func (s SecretSources) Get(key string) *SecretSource {
+	for _, source := range s {
+		if source.Key == key {
+			return source
+		}
+	}
+
+	return nil
+}
+
+func (s SecretSources) leased() SecretSources {
+	var leased SecretSources
+	for _, source := range s {
+		if source.LeaseID != "" {
+			leased = append(leased, source)
+		}
+	}
+
+	return leased
+}
+
+// SecretsResolvingError reports the variables whose secret couldn't be
+// resolved
+type SecretsResolvingError struct {
+	Errors map[string]error
+}
+
+func (e *SecretsResolvingError) Error() string {
+	keys := make([]string, 0, len(e.Errors))
+	for key := range e.Errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	messages := make([]string, 0, len(keys))
+	for _, key := range keys {
+		messages = append(messages, fmt.Sprintf("%s: %v", key, e.Errors[key]))
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// Unwrap returns the error of the variable when only one variable failed
+func (e *SecretsResolvingError) Unwrap() error {
+	if len(e.Errors) != 1 {
+		return nil
+	}
+
+	for _, err := range e.Errors {
+		return err
+	}
+
+	return nil
+}
+
 var (
 	secretResolverRegistry = new(defaultSecretResolverRegistry)
 
@@ -68,6 +172,7 @@ func newSecretsResolver(l logger, registry SecretResolverRegistry) (SecretsResol
 	sr := &defaultSecretsResolver{
 		logger:                 l,
 		secretResolverRegistry: registry,
+		sessions:               make(map[string]SecretSession),
 	}
 
 	return sr, nil
@@ -76,6 +181,40 @@ func newSecretsResolver(l logger, registry SecretResolverRegistry) (SecretsResol
 type defaultSecretsResolver struct {
 	logger                 logger
 	secretResolverRegistry SecretResolverRegistry
+
+	sources SecretSources
+	// sessions are the sessions kept open to revoke the leases of the
+	// secrets they generated
+	sessions map[string]SecretSession
+}
+
+// pendingSecret is a secret being resolved for a variable
+type pendingSecret struct {
+	variableKey string
+	resolver    SecretResolver
+
+	session   *pendingSession
+	sourceKey string
+
+	value string
+	err   error
+}
+
+// pendingSession is a session shared by the secrets of the same server and
+// authentication
+type pendingSession struct {
+	key      string
+	resolver SessionSecretResolver
+
+	session SecretSession
+	err     error
+}
+
+// pendingSource is a secret read once for the variables reading its fields
+type pendingSource struct {
+	source  *SecretSource
+	session *pendingSession
+	err     error
 }
 
 func (r *defaultSecretsResolver) Resolve(secrets Secrets) (JobVariables, error) {
@@ -90,42 +229,257 @@ func (r *defaultSecretsResolver) Resolve(secrets Secrets) (JobVariables, error)
 	)
 	r.logger.Println(msg)
 
-	variables := make(JobVariables, 0)
-	for variableKey, secret := range secrets {
+	variableKeys := make([]string, 0, len(secrets))
+	for variableKey := range secrets {
+		variableKeys = append(variableKeys, variableKey)
+	}
+	sort.Strings(variableKeys)
+
+	var pending []*pendingSecret
+	sessions := make(map[string]*pendingSession)
+	sources := make(map[string]*pendingSource)
+	var sourceKeys []string
+
+	for _, variableKey := range variableKeys {
 		r.logger.Println(fmt.Sprintf("Resolving secret %q...", variableKey))
 
-		v, err := r.handleSecret(variableKey, secret)
-		if err != nil {
-			return nil, err
+		p := r.prepareSecret(variableKey, secrets[variableKey], sessions)
+		if p == nil {
+			continue
+		}
+
+		if p.session != nil && p.err == nil {
+			if _, ok := sources[p.sourceKey]; !ok {
+				sources[p.sourceKey] = &pendingSource{
+					source:  &SecretSource{Key: p.sourceKey, SessionKey: p.session.key, Secret: secrets[variableKey]},
+					session: p.session,
+				}
+				sourceKeys = append(sourceKeys, p.sourceKey)
+			}
 		}
 
-		if v != nil {
-			variables = append(variables, *v)
+		pending = append(pending, p)
+	}
+
+	r.readSecrets(pending, sessions, sources, sourceKeys)
+	r.closeSessions(sessions)
+
+	variables := make(JobVariables, 0, len(pending))
+	errs := make(map[string]error)
+	for _, p := range pending {
+		if p.err != nil {
+			errs[p.variableKey] = p.err
+			continue
 		}
+
+		variables = append(variables, JobVariable{
+			Key:   p.variableKey,
+			Value: p.value,
+			File:  true,
+		})
+	}
+
+	if len(errs) > 0 {
+		return nil, &SecretsResolvingError{Errors: errs}
 	}
 
 	return variables, nil
 }
 
-func (r *defaultSecretsResolver) handleSecret(variableKey string, secret Secret) (*JobVariable, error) {
+// prepareSecret finds the resolver of the secret, and the session reading it
+// when the resolver uses sessions. It returns nil when the secret can't be
+// resolved.
+func (r *defaultSecretsResolver) prepareSecret(
+	variableKey string,
+	secret Secret,
+	sessions map[string]*pendingSession,
+) *pendingSecret {
 	sr, err := r.secretResolverRegistry.GetFor(secret)
 	if err != nil {
 		r.logger.Warningln(fmt.Sprintf("Not resolved: %v", err))
-		return nil, nil
+		return nil
 	}
 
 	r.logger.Println(fmt.Sprintf("Using %q secret resolver...", sr.Name()))
 
-	value, err := sr.Resolve()
+	p := &pendingSecret{
+		variableKey: variableKey,
+		resolver:    sr,
+	}
+
+	ssr, ok := sr.(SessionSecretResolver)
+	if !ok {
+		return p
+	}
+
+	sessionKey, err := ssr.SessionKey()
 	if err != nil {
-		return nil, err
+		p.err = err
+		return p
 	}
 
-	variable := &JobVariable{
-		Key:   variableKey,
-		Value: value,
-		File:  true,
+	p.sourceKey, err = ssr.SourceKey()
+	if err != nil {
+		p.err = err
+		return p
 	}
 
-	return variable, nil
+	p.session = sessions[sessionKey]
+	if p.session == nil {
+		p.session = &pendingSession{key: sessionKey, resolver: ssr}
+		sessions[sessionKey] = p.session
+	}
+
+	return p
+}
+
+// readSecrets authenticates the sessions once, reads each source once and
+// resolves the other secrets, with at most secretsReadConcurrency operations
+// at the same time
+func (r *defaultSecretsResolver) readSecrets(
+	pending []*pendingSecret,
+	sessions map[string]*pendingSession,
+	sources map[string]*pendingSource,
+	sourceKeys []string,
+) {
+	sessionKeys := make([]string, 0, len(sessions))
+	for key := range sessions {
+		sessionKeys = append(sessionKeys, key)
+	}
+	sort.Strings(sessionKeys)
+
+	runConcurrently(len(sessionKeys), func(i int) {
+		s := sessions[sessionKeys[i]]
+
+		session, err := s.resolver.NewSession()
+		if err != nil {
+			s.err = fmt.Errorf("creating secrets session: %w", err)
+			return
+		}
+
+		s.session = session
+	})
+
+	runConcurrently(len(sourceKeys), func(i int) {
+		ps := sources[sourceKeys[i]]
+		if ps.session.err != nil {
+			ps.err = ps.session.err
+			return
+		}
+
+		ps.source.Data, ps.source.LeaseID, ps.err = ps.session.session.Read(ps.source.Secret)
+	})
+
+	runConcurrently(len(pending), func(i int) {
+		p := pending[i]
+		if p.err != nil {
+			return
+		}
+
+		if p.session == nil {
+			p.value, p.err = p.resolver.Resolve()
+			return
+		}
+
+		ps := sources[p.sourceKey]
+		if ps.err != nil {
+			p.err = ps.err
+			return
+		}
+
+		p.value = p.resolver.(SessionSecretResolver).Value(ps.source)
+	})
+
+	for _, key := range sourceKeys {
+		if ps := sources[key]; ps.err == nil {
+			r.sources = append(r.sources, ps.source)
+		}
+	}
+}
+
+// closeSessions closes the sessions, except the ones that generated secrets
+// with a lease, kept until the leases are revoked
+func (r *defaultSecretsResolver) closeSessions(sessions map[string]*pendingSession) {
+	leasing := make(map[string]bool)
+	for _, source := range r.sources.leased() {
+		leasing[source.SessionKey] = true
+	}
+
+	for key, s := range sessions {
+		if s.session == nil {
+			continue
+		}
+
+		if leasing[key] {
+			r.sessions[key] = s.session
+			continue
+		}
+
+		err := s.session.Close()
+		if err != nil {
+			r.logger.Warningln(fmt.Sprintf("Closing secrets session: %v", err))
+		}
+	}
+}
+
+// runConcurrently calls fn for each index, with at most
+// secretsReadConcurrency calls at the same time
+func runConcurrently(count int, fn func(i int)) {
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < secretsReadConcurrency && w < count; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		indexes <- i
+	}
+	close(indexes)
+
+	wg.Wait()
+}
+
+func (r *defaultSecretsResolver) Leases() SecretSources {
+	return r.sources.leased()
+}
+
+// RevokeLeases revokes the leases of the generated secrets with the sessions
+// that generated them, and closes the sessions. The failures are reported as
+// warnings since the leases expire anyway.
+func (r *defaultSecretsResolver) RevokeLeases(leases SecretSources) {
+	if len(leases) < 1 {
+		return
+	}
+
+	r.logger.Println("Revoking the leases of the secrets...")
+
+	for _, lease := range leases {
+		session, ok := r.sessions[lease.SessionKey]
+		if !ok {
+			r.logger.Warningln(fmt.Sprintf("Not revoked: no session for lease %q", lease.LeaseID))
+			continue
+		}
+
+		err := session.RevokeLease(lease.LeaseID)
+		if err != nil {
+			r.logger.Warningln(fmt.Sprintf("Revoking lease %q: %v", lease.LeaseID, err))
+		}
+	}
+
+	for key, session := range r.sessions {
+		err := session.Close()
+		if err != nil {
+			r.logger.Warningln(fmt.Sprintf("Closing secrets session: %v", err))
+		}
+
+		delete(r.sessions, key)
+	}
 }
@@ -1,6 +1,9 @@
 package common
 
 import (
+	"errors"
+	"fmt"
+	"sync/atomic"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -149,3 +152,238 @@ func TestDefaultResolver_Resolve(t *testing.T) {
 		})
 	}
 }
+
+// sessionSecretResolverStub resolves the secrets of the server of the
+// secret with a session reading their path
+type sessionSecretResolverStub struct {
+	secret Secret
+
+	newSession func() (SecretSession, error)
+}
+
+func (r *sessionSecretResolverStub) Name() string {
+	return "session_resolver"
+}
+
+func (r *sessionSecretResolverStub) IsSupported() bool {
+	return r.secret.Vault != nil
+}
+
+func (r *sessionSecretResolverStub) Resolve() (string, error) {
+	return "", errors.New("resolved without session")
+}
+
+func (r *sessionSecretResolverStub) SessionKey() (string, error) {
+	return r.secret.Vault.Server.URL, nil
+}
+
+func (r *sessionSecretResolverStub) SourceKey() (string, error) {
+	return r.secret.Vault.Server.URL + "/" + r.secret.Vault.Path, nil
+}
+
+func (r *sessionSecretResolverStub) Value(source *SecretSource) string {
+	return fmt.Sprintf("%v", source.Data[r.secret.Vault.Field])
+}
+
+func (r *sessionSecretResolverStub) NewSession() (SecretSession, error) {
+	return r.newSession()
+}
+
+type mockSecretSession struct {
+	mock.Mock
+}
+
+func (s *mockSecretSession) Read(secret Secret) (map[string]interface{}, string, error) {
+	ret := s.Called(secret)
+
+	data, _ := ret.Get(0).(map[string]interface{})
+
+	return data, ret.String(1), ret.Error(2)
+}
+
+func (s *mockSecretSession) RevokeLease(leaseID string) error {
+	return s.Called(leaseID).Error(0)
+}
+
+func (s *mockSecretSession) Close() error {
+	return s.Called().Error(0)
+}
+
+func newSessionTestSecret(url string, path string, field string) Secret {
+	return Secret{
+		Vault: &VaultSecret{
+			Server: VaultServer{URL: url},
+			Path:   path,
+			Field:  field,
+		},
+	}
+}
+
+func TestDefaultResolver_ResolveWithSessions(t *testing.T) {
+	secrets := Secrets{
+		"DB_USERNAME": newSessionTestSecret("vault", "database", "username"),
+		"DB_PASSWORD": newSessionTestSecret("vault", "database", "password"),
+		"API_TOKEN":   newSessionTestSecret("vault", "kv", "token"),
+		"OTHER_TOKEN": newSessionTestSecret("other", "kv", "token"),
+	}
+
+	tests := map[string]struct {
+		newSessionError   error
+		assertSession     func(s *mockSecretSession)
+		expectedVariables JobVariables
+		expectedErrors    map[string]error
+		expectedLeases    int
+	}{
+		"secrets read once per source": {
+			assertSession: func(s *mockSecretSession) {
+				s.On("Read", secrets["DB_PASSWORD"]).
+					Return(map[string]interface{}{"username": "user", "password": "pass"}, "lease", nil).
+					Once()
+				s.On("Read", secrets["API_TOKEN"]).
+					Return(map[string]interface{}{"token": "token"}, "", nil).
+					Once()
+				s.On("Read", secrets["OTHER_TOKEN"]).
+					Return(map[string]interface{}{"token": "token"}, "", nil).
+					Once()
+				// the session of "other" is closed, the one of "vault" is
+				// kept to revoke the lease
+				s.On("Close").Return(nil).Once()
+			},
+			expectedVariables: JobVariables{
+				{Key: "API_TOKEN", Value: "token", File: true},
+				{Key: "DB_PASSWORD", Value: "pass", File: true},
+				{Key: "DB_USERNAME", Value: "user", File: true},
+				{Key: "OTHER_TOKEN", Value: "token", File: true},
+			},
+			expectedLeases: 1,
+		},
+		"errors aggregated per variable": {
+			assertSession: func(s *mockSecretSession) {
+				s.On("Read", secrets["DB_PASSWORD"]).
+					Return(nil, "", assert.AnError).
+					Once()
+				s.On("Read", secrets["API_TOKEN"]).
+					Return(map[string]interface{}{"token": "token"}, "", nil).
+					Once()
+				s.On("Read", secrets["OTHER_TOKEN"]).
+					Return(map[string]interface{}{"token": "token"}, "", nil).
+					Once()
+				s.On("Close").Return(nil).Twice()
+			},
+			expectedErrors: map[string]error{
+				"DB_USERNAME": assert.AnError,
+				"DB_PASSWORD": assert.AnError,
+			},
+		},
+		"error on session creation": {
+			newSessionError: assert.AnError,
+			assertSession:   func(s *mockSecretSession) {},
+			expectedErrors: map[string]error{
+				"DB_USERNAME": assert.AnError,
+				"DB_PASSWORD": assert.AnError,
+				"API_TOKEN":   assert.AnError,
+				"OTHER_TOKEN": assert.AnError,
+			},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			session := new(mockSecretSession)
+			defer session.AssertExpectations(t)
+
+			tt.assertSession(session)
+
+			var sessionsCount int32
+			registry := new(defaultSecretResolverRegistry)
+			registry.Register(func(secret Secret) SecretResolver {
+				return &sessionSecretResolverStub{
+					secret: secret,
+					newSession: func() (SecretSession, error) {
+						atomic.AddInt32(&sessionsCount, 1)
+						return session, tt.newSessionError
+					},
+				}
+			})
+
+			logger := new(mockLogger)
+			defer logger.AssertExpectations(t)
+			logger.On("Println", mock.Anything)
+
+			r, err := newSecretsResolver(logger, registry)
+			require.NoError(t, err)
+
+			variables, err := r.Resolve(secrets)
+			assert.Equal(t, int32(2), atomic.LoadInt32(&sessionsCount))
+
+			if tt.expectedErrors != nil {
+				var resolvingErr *SecretsResolvingError
+				require.ErrorAs(t, err, &resolvingErr)
+				require.Len(t, resolvingErr.Errors, len(tt.expectedErrors))
+				for key, expectedErr := range tt.expectedErrors {
+					assert.ErrorIs(t, resolvingErr.Errors[key], expectedErr, key)
+				}
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedVariables, variables)
+			assert.Len(t, r.Leases(), tt.expectedLeases)
+		})
+	}
+}
+
+func TestDefaultResolver_RevokeLeases(t *testing.T) {
+	secret := newSessionTestSecret("vault", "database", "username")
+
+	session := new(mockSecretSession)
+	defer session.AssertExpectations(t)
+
+	session.On("Read", secret).
+		Return(map[string]interface{}{"username": "user"}, "lease", nil).
+		Once()
+
+	registry := new(defaultSecretResolverRegistry)
+	registry.Register(func(secret Secret) SecretResolver {
+		return &sessionSecretResolverStub{
+			secret: secret,
+			newSession: func() (SecretSession, error) {
+				return session, nil
+			},
+		}
+	})
+
+	logger := new(mockLogger)
+	defer logger.AssertExpectations(t)
+	logger.On("Println", mock.Anything)
+
+	r, err := newSecretsResolver(logger, registry)
+	require.NoError(t, err)
+
+	_, err = r.Resolve(Secrets{"DB_USERNAME": secret})
+	require.NoError(t, err)
+
+	leases := r.Leases()
+	require.Len(t, leases, 1)
+
+	session.On("RevokeLease", "lease").Return(assert.AnError).Once()
+	session.On("Close").Return(nil).Once()
+	logger.On("Warningln", fmt.Sprintf("Revoking lease %q: %v", "lease", assert.AnError)).Once()
+
+	r.RevokeLeases(leases)
+}
+
+func TestSecretsResolvingError(t *testing.T) {
+	err := &SecretsResolvingError{
+		Errors: map[string]error{
+			"B": errors.New("error b"),
+			"A": assert.AnError,
+		},
+	}
+
+	assert.Equal(t, "A: "+assert.AnError.Error()+"; B: error b", err.Error())
+	assert.Nil(t, errors.Unwrap(err))
+
+	err = &SecretsResolvingError{Errors: map[string]error{"A": assert.AnError}}
+	assert.ErrorIs(t, err, assert.AnError)
+}
@@ -0,0 +1,88 @@
+package archives
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// CreateTarArchive writes the files as a tar stream to w. The paths of the
+// files are relative to dir. The entries are sorted and only keep what's
+// restored on extraction, so the same files always produce the same stream.
+func CreateTarArchive(ctx context.Context, w io.Writer, dir string, files map[string]os.FileInfo) error {
+	tw := tar.NewWriter(w)
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := createTarEntry(tw, dir, name, files[name])
+		if err != nil {
+			return fmt.Errorf("archiving %s: %w", name, err)
+		}
+	}
+
+	return tw.Close()
+}
+
+func createTarEntry(tw *tar.Writer, dir string, name string, fi os.FileInfo) error {
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, name)
+	}
+
+	var link string
+	if fi.Mode()&os.ModeSymlink != 0 {
+		var err error
+		link, err = os.Readlink(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return err
+	}
+
+	hdr.Name = filepath.ToSlash(name)
+	if fi.IsDir() {
+		hdr.Name += "/"
+	}
+	hdr.Uid, hdr.Gid = 0, 0
+	hdr.Uname, hdr.Gname = "", ""
+	hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+	hdr.Xattrs = nil //nolint:staticcheck
+	hdr.PAXRecords = nil
+
+	if hdr.Typeflag != tar.TypeReg {
+		hdr.Size = 0
+	}
+
+	err = tw.WriteHeader(hdr)
+	if err != nil || hdr.Typeflag != tar.TypeReg {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	_, err = io.CopyN(tw, file, hdr.Size)
+
+	return err
+}
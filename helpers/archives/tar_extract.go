@@ -0,0 +1,146 @@
+package archives
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type dirTime struct {
+	path    string
+	modTime time.Time
+}
+
+// ExtractTarArchive extracts the tar stream read from r to dir. Entries which
+// would be extracted outside of dir are refused.
+func ExtractTarArchive(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+
+	// the modification time of directories is restored last, since
+	// extracting their content changes it
+	var dirs []dirTime
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		path, err := tarExtractPath(dir, hdr.Name)
+		if err != nil {
+			return err
+		}
+
+		err = extractTarEntry(tr, hdr, path)
+		if err != nil {
+			return fmt.Errorf("extracting %s: %w", hdr.Name, err)
+		}
+
+		if hdr.Typeflag == tar.TypeDir {
+			dirs = append(dirs, dirTime{path: path, modTime: hdr.ModTime})
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		err := os.Chtimes(dirs[i].path, time.Now(), dirs[i].modTime)
+		if err != nil {
+			logrus.Warningf("%s: %s", dirs[i].path, err)
+		}
+	}
+
+	return nil
+}
+
+// tarExtractPath returns the path of an entry, refusing the ones which would
+// be extracted outside of dir
+func tarExtractPath(dir string, name string) (string, error) {
+	cleaned := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid path %q outside of the extraction directory", name)
+	}
+
+	// a symlink extracted earlier must not redirect the following entries
+	parent := dir
+	for _, element := range strings.Split(filepath.Dir(cleaned), string(filepath.Separator)) {
+		if element == "." {
+			continue
+		}
+
+		parent = filepath.Join(parent, element)
+		fi, err := os.Lstat(parent)
+		if err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("invalid path %q through the symlink %s", name, parent)
+		}
+	}
+
+	return filepath.Join(dir, cleaned), nil
+}
+
+func extractTarEntry(tr *tar.Reader, hdr *tar.Header, path string) error {
+	err := os.MkdirAll(filepath.Dir(path), 0777)
+	if err != nil {
+		return err
+	}
+
+	mode := os.FileMode(hdr.Mode).Perm()
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		err = os.Mkdir(path, mode)
+		if os.IsExist(err) {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return os.Chmod(path, mode)
+
+	case tar.TypeSymlink:
+		// Remove symlink before creating a new one, otherwise we can error that file does exist
+		_ = os.Remove(path)
+
+		return os.Symlink(hdr.Linkname, path)
+
+	case tar.TypeReg:
+		return extractTarFile(tr, hdr, path, mode)
+
+	default:
+		logrus.Warningf("File ignored: %q", hdr.Name)
+		return nil
+	}
+}
+
+func extractTarFile(r io.Reader, hdr *tar.Header, path string, mode os.FileMode) error {
+	// Remove file before creating a new one, otherwise we can error that file does exist
+	_ = os.Remove(path)
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(path, mode)
+	if err != nil {
+		return err
+	}
+
+	return os.Chtimes(path, time.Now(), hdr.ModTime)
+}
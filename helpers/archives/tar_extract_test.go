@@ -0,0 +1,102 @@
+package archives
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTarExtractPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chunked")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, os.Symlink(os.TempDir(), filepath.Join(dir, "link")))
+
+	tests := map[string]struct {
+		name        string
+		expectedErr bool
+	}{
+		"relative path": {
+			name: "dir/file",
+		},
+		"parent directory": {
+			name:        "../file",
+			expectedErr: true,
+		},
+		"nested parent directory": {
+			name:        "dir/../../file",
+			expectedErr: true,
+		},
+		"absolute path": {
+			name:        "/etc/passwd",
+			expectedErr: true,
+		},
+		"through symlink": {
+			name:        "link/file",
+			expectedErr: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			path, err := tarExtractPath(dir, tt.name)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, filepath.Join(dir, filepath.FromSlash(tt.name)), path)
+		})
+	}
+}
+
+func TestTarArchiveRoundTrip(t *testing.T) {
+	src, err := ioutil.TempDir("", "tar-src")
+	require.NoError(t, err)
+	defer os.RemoveAll(src)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "dir"), 0750))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "dir", "script.sh"), []byte("#!/bin/sh"), 0755))
+	require.NoError(t, os.Symlink("dir/script.sh", filepath.Join(src, "link")))
+
+	files := make(map[string]os.FileInfo)
+	for _, name := range []string{"dir", filepath.Join("dir", "script.sh"), "link"} {
+		fi, err := os.Lstat(filepath.Join(src, name))
+		require.NoError(t, err)
+		files[name] = fi
+	}
+
+	archive, err := ioutil.TempFile("", "archive.tar")
+	require.NoError(t, err)
+	defer os.Remove(archive.Name())
+
+	require.NoError(t, CreateTarArchive(context.Background(), archive, src, files))
+	_, err = archive.Seek(0, 0)
+	require.NoError(t, err)
+
+	dst, err := ioutil.TempDir("", "tar-dst")
+	require.NoError(t, err)
+	defer os.RemoveAll(dst)
+
+	require.NoError(t, ExtractTarArchive(archive, dst))
+	_ = archive.Close()
+
+	fi, err := os.Stat(filepath.Join(dst, "dir", "script.sh"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), fi.Mode().Perm())
+
+	fi, err = os.Stat(filepath.Join(dst, "dir"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0750), fi.Mode().Perm())
+
+	link, err := os.Readlink(filepath.Join(dst, "link"))
+	require.NoError(t, err)
+	assert.Equal(t, "dir/script.sh", link)
+}
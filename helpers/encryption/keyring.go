@@ -0,0 +1,127 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// KeySize is the size of the AES-256 keys encrypting the caches
+const KeySize = 32
+
+const maxKeyIDLength = 255
+
+// KeyIDMetadata is the name of the object metadata recording the ID of the
+// key encrypting a cache. Underscores keep it valid for all the storages.
+const KeyIDMetadata = "gitlab_runner_cache_key_id"
+
+// ErrUnknownKey is returned when decrypting data encrypted with a key
+// missing from the keyring
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// Keyring holds the keys decrypting the caches by ID. The key of KeyID
+// encrypts the new caches, the others allow decrypting the caches created
+// before a key rotation.
+type Keyring struct {
+	KeyID string
+	Keys  map[string][]byte
+}
+
+// NewKeyring returns a keyring encrypting with the given key
+func NewKeyring(keyID string, key []byte) (*Keyring, error) {
+	k := &Keyring{KeyID: keyID, Keys: make(map[string][]byte)}
+
+	err := k.Add(keyID, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// Add adds a key decrypting the caches encrypted with the key ID
+func (k *Keyring) Add(keyID string, key []byte) error {
+	if keyID == "" || len(keyID) > maxKeyIDLength || strings.ContainsAny(keyID, ",=") {
+		return fmt.Errorf("invalid encryption key ID %q", keyID)
+	}
+
+	if len(key) != KeySize {
+		return fmt.Errorf("encryption key %q must be %d bytes long, got %d", keyID, KeySize, len(key))
+	}
+
+	k.Keys[keyID] = key
+
+	return nil
+}
+
+func (k *Keyring) key(keyID string) ([]byte, error) {
+	key, ok := k.Keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, keyID)
+	}
+
+	return key, nil
+}
+
+// String encodes the keyring as comma separated `id=base64 key` pairs,
+// starting with the key encrypting the caches
+func (k *Keyring) String() string {
+	ids := make([]string, 0, len(k.Keys))
+	for id := range k.Keys {
+		if id != k.KeyID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	pairs := make([]string, 0, len(k.Keys))
+	for _, id := range append([]string{k.KeyID}, ids...) {
+		pairs = append(pairs, id+"="+base64.StdEncoding.EncodeToString(k.Keys[id]))
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// ParseKeyring decodes a keyring encoded by String
+func ParseKeyring(s string) (*Keyring, error) {
+	var k *Keyring
+
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("invalid encryption keys, expected comma separated id=key pairs")
+		}
+
+		key, err := DecodeKey(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q: %w", parts[0], err)
+		}
+
+		if k == nil {
+			k, err = NewKeyring(parts[0], key)
+		} else {
+			err = k.Add(parts[0], key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return k, nil
+}
+
+// DecodeKey decodes a base64 encoded key
+func DecodeKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("decoding base64 key: %w", err)
+	}
+
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes long, got %d", KeySize, len(key))
+	}
+
+	return key, nil
+}
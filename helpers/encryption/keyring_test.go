@@ -0,0 +1,46 @@
+package encryption
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyringString(t *testing.T) {
+	keyring, err := NewKeyring("new", bytes.Repeat([]byte{1}, KeySize))
+	require.NoError(t, err)
+	require.NoError(t, keyring.Add("old", bytes.Repeat([]byte{2}, KeySize)))
+	require.NoError(t, keyring.Add("older", bytes.Repeat([]byte{3}, KeySize)))
+
+	encoded := keyring.String()
+	assert.Equal(
+		t,
+		"new=AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=,"+
+			"old=AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI=,"+
+			"older=AwMDAwMDAwMDAwMDAwMDAwMDAwMDAwMDAwMDAwMDAwM=",
+		encoded,
+	)
+
+	parsed, err := ParseKeyring(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, keyring, parsed)
+}
+
+func TestParseKeyringErrors(t *testing.T) {
+	tests := map[string]string{
+		"empty":          "",
+		"missing key":    "key-1",
+		"invalid base64": "key-1=not base64",
+		"short key":      "key-1=AQEB",
+		"empty key ID":   "=AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=",
+	}
+
+	for tn, encoded := range tests {
+		t.Run(tn, func(t *testing.T) {
+			_, err := ParseKeyring(encoded)
+			assert.Error(t, err)
+		})
+	}
+}
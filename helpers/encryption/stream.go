@@ -0,0 +1,282 @@
+// Package encryption implements the envelope encryption of the cache
+// archives.
+//
+// Each archive is encrypted with a random data key, itself encrypted with a
+// key of the keyring. The encrypted archive starts with a header holding the
+// ID of the keyring key and the encrypted data key, followed by the archive
+// split in segments, each sealed with AES-GCM:
+//
+//	header:  magic | key ID length (1 byte) | key ID | nonce | encrypted data key
+//	segment: plaintext length (4 bytes) | sealed segment
+//
+// All the segments but the last one are segmentSize long. The nonce of a
+// segment is its index, with a flag marking the last segment, so reordered,
+// removed or truncated segments fail the authentication.
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	magic       = "GLRCENC\x01"
+	segmentSize = 64 * 1024
+	nonceSize   = 12
+	tagSize     = 16
+
+	segmentOverhead = 4 + tagSize
+	wrappedKeySize  = KeySize + tagSize
+)
+
+var (
+	// ErrNotEncrypted is returned when decrypting data not encrypted by
+	// this package
+	ErrNotEncrypted = errors.New("data isn't encrypted")
+	// ErrCorrupted is returned when the encrypted data fails the
+	// authentication
+	ErrCorrupted = errors.New("encrypted data is corrupted")
+)
+
+func headerSize(keyID string) int64 {
+	return int64(len(magic) + 1 + len(keyID) + nonceSize + wrappedKeySize)
+}
+
+// EncryptedSize returns the size of size bytes once encrypted with the key
+// ID
+func EncryptedSize(size int64, keyID string) int64 {
+	segments := size/segmentSize + 1
+
+	return headerSize(keyID) + size + segments*segmentOverhead
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func segmentNonce(index uint64, last bool) []byte {
+	nonce := make([]byte, nonceSize)
+	binary.BigEndian.PutUint64(nonce, index)
+	if last {
+		nonce[nonceSize-1] = 1
+	}
+
+	return nonce
+}
+
+type encrypter struct {
+	src  io.Reader
+	aead cipher.AEAD
+
+	pending []byte
+	segment []byte
+	index   uint64
+	done    bool
+}
+
+// NewEncrypter returns a reader encrypting src with the key of the keyring
+// KeyID
+func NewEncrypter(src io.Reader, keyring *Keyring) (io.Reader, error) {
+	key, err := keyring.key(keyring.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey := make([]byte, KeySize)
+	nonce := make([]byte, nonceSize)
+	for _, b := range [][]byte{dataKey, nonce} {
+		if _, err := io.ReadFull(rand.Reader, b); err != nil {
+			return nil, fmt.Errorf("generating data key: %w", err)
+		}
+	}
+
+	keyAEAD, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := new(bytes.Buffer)
+	header.WriteString(magic)
+	header.WriteByte(byte(len(keyring.KeyID)))
+	header.WriteString(keyring.KeyID)
+	header.Write(nonce)
+	header.Write(keyAEAD.Seal(nil, nonce, dataKey, header.Bytes()[:len(magic)+1+len(keyring.KeyID)]))
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &encrypter{
+		src:     src,
+		aead:    aead,
+		pending: header.Bytes(),
+		segment: make([]byte, segmentSize),
+	}, nil
+}
+
+func (e *encrypter) Read(p []byte) (int, error) {
+	for len(e.pending) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+
+		err := e.seal()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, e.pending)
+	e.pending = e.pending[n:]
+
+	return n, nil
+}
+
+func (e *encrypter) seal() error {
+	n, err := io.ReadFull(e.src, e.segment)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		e.done = true
+	} else if err != nil {
+		return err
+	}
+
+	out := make([]byte, 4, segmentOverhead+n)
+	binary.BigEndian.PutUint32(out, uint32(n))
+	e.pending = e.aead.Seal(out, segmentNonce(e.index, e.done), e.segment[:n], nil)
+	e.index++
+
+	return nil
+}
+
+type decrypter struct {
+	src  io.Reader
+	aead cipher.AEAD
+
+	pending []byte
+	segment []byte
+	index   uint64
+	done    bool
+}
+
+// NewDecrypter returns a reader decrypting src with the keys of the keyring.
+// The header of src is read immediately, returning ErrNotEncrypted for
+// unencrypted data and ErrUnknownKey when the key isn't in the keyring.
+func NewDecrypter(src io.Reader, keyring *Keyring) (io.Reader, error) {
+	prefix := make([]byte, len(magic)+1)
+	_, err := io.ReadFull(src, prefix)
+	if err == io.EOF || err == io.ErrUnexpectedEOF || (err == nil && string(prefix[:len(magic)]) != magic) {
+		return nil, ErrNotEncrypted
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rest := make([]byte, int(prefix[len(magic)])+nonceSize+wrappedKeySize)
+	_, err = io.ReadFull(src, rest)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keyID := string(rest[:prefix[len(magic)]])
+	nonce := rest[len(keyID) : len(keyID)+nonceSize]
+	wrappedKey := rest[len(keyID)+nonceSize:]
+
+	key, err := keyring.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	keyAEAD, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := keyAEAD.Open(nil, nonce, wrappedKey, append(prefix, keyID...))
+	if err != nil {
+		return nil, fmt.Errorf("%w: decrypting the data key with %q", ErrCorrupted, keyID)
+	}
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decrypter{
+		src:     src,
+		aead:    aead,
+		segment: make([]byte, segmentSize+segmentOverhead),
+	}, nil
+}
+
+func (d *decrypter) Read(p []byte) (int, error) {
+	for len(d.pending) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+
+		err := d.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+
+	return n, nil
+}
+
+func (d *decrypter) open() error {
+	_, err := io.ReadFull(d.src, d.segment[:4])
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+
+	n := int(binary.BigEndian.Uint32(d.segment))
+	if n > segmentSize {
+		return ErrCorrupted
+	}
+
+	sealed := d.segment[4 : 4+n+tagSize]
+	_, err = io.ReadFull(d.src, sealed)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+
+	last := n < segmentSize
+	d.pending, err = d.aead.Open(sealed[:0], segmentNonce(d.index, last), sealed, nil)
+	if err != nil {
+		return ErrCorrupted
+	}
+	d.index++
+
+	if last {
+		d.done = true
+		if _, err := io.ReadFull(d.src, d.segment[:1]); err != io.EOF {
+			return fmt.Errorf("%w: data after the last segment", ErrCorrupted)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestKeyring(t *testing.T, keyID string) *Keyring {
+	key := make([]byte, KeySize)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+
+	keyring, err := NewKeyring(keyID, key)
+	require.NoError(t, err)
+
+	return keyring
+}
+
+func encrypt(t *testing.T, data []byte, keyring *Keyring) []byte {
+	encrypter, err := NewEncrypter(bytes.NewReader(data), keyring)
+	require.NoError(t, err)
+
+	encrypted, err := ioutil.ReadAll(encrypter)
+	require.NoError(t, err)
+
+	return encrypted
+}
+
+func decrypt(data []byte, keyring *Keyring) ([]byte, error) {
+	decrypter, err := NewDecrypter(bytes.NewReader(data), keyring)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(decrypter)
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	keyring := newTestKeyring(t, "key-1")
+
+	for _, size := range []int{0, 1, segmentSize - 1, segmentSize, segmentSize + 1, 3*segmentSize + 100} {
+		data := make([]byte, size)
+		_, err := rand.Read(data)
+		require.NoError(t, err)
+
+		encrypted := encrypt(t, data, keyring)
+		assert.Equal(t, EncryptedSize(int64(size), "key-1"), int64(len(encrypted)), "size %d", size)
+		if size > 16 {
+			assert.NotContains(t, string(encrypted), string(data))
+		}
+
+		decrypted, err := decrypt(encrypted, keyring)
+		require.NoError(t, err, "size %d", size)
+		assert.Equal(t, data, decrypted, "size %d", size)
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	keyring := newTestKeyring(t, "key-1")
+	data := bytes.Repeat([]byte("cache"), segmentSize)
+	encrypted := encrypt(t, data, keyring)
+
+	tamper := func(offset int) []byte {
+		tampered := append([]byte{}, encrypted...)
+		tampered[offset] ^= 1
+		return tampered
+	}
+
+	tests := map[string]struct {
+		data          []byte
+		keyring       *Keyring
+		expectedError error
+	}{
+		"not encrypted": {
+			data:          data,
+			keyring:       keyring,
+			expectedError: ErrNotEncrypted,
+		},
+		"empty": {
+			keyring:       keyring,
+			expectedError: ErrNotEncrypted,
+		},
+		"unknown key": {
+			data:          encrypted,
+			keyring:       newTestKeyring(t, "key-2"),
+			expectedError: ErrUnknownKey,
+		},
+		"wrong key with the same ID": {
+			data:          encrypted,
+			keyring:       newTestKeyring(t, "key-1"),
+			expectedError: ErrCorrupted,
+		},
+		"tampered data key": {
+			data:          tamper(int(headerSize("key-1")) - 1),
+			keyring:       keyring,
+			expectedError: ErrCorrupted,
+		},
+		"tampered segment": {
+			data:          tamper(len(encrypted) - 100),
+			keyring:       keyring,
+			expectedError: ErrCorrupted,
+		},
+		"truncated": {
+			data:          encrypted[:len(encrypted)-10],
+			keyring:       keyring,
+			expectedError: io.ErrUnexpectedEOF,
+		},
+		"last segment removed": {
+			data:          encrypted[:headerSize("key-1")+segmentSize+segmentOverhead],
+			keyring:       keyring,
+			expectedError: io.ErrUnexpectedEOF,
+		},
+		"trailing data": {
+			data:          append(append([]byte{}, encrypted...), 0),
+			keyring:       keyring,
+			expectedError: ErrCorrupted,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			_, err := decrypt(tt.data, tt.keyring)
+			assert.ErrorIs(t, err, tt.expectedError)
+		})
+	}
+}
+
+func TestDecryptWithRotatedKeys(t *testing.T) {
+	old := newTestKeyring(t, "old")
+	encrypted := encrypt(t, []byte("cache"), old)
+
+	keyring := newTestKeyring(t, "new")
+	require.NoError(t, keyring.Add("old", old.Keys["old"]))
+
+	decrypted, err := decrypt(encrypted, keyring)
+	require.NoError(t, err)
+	assert.Equal(t, "cache", string(decrypted))
+}
@@ -21,6 +21,7 @@ const (
 	EnableBashExitCodeCheck                     string = "FF_ENABLE_BASH_EXIT_CODE_CHECK"
 	UseWindowsLegacyProcessStrategy             string = "FF_USE_WINDOWS_LEGACY_PROCESS_STRATEGY"
 	SkipDockerMachineProvisionOnCreationFailure string = "FF_SKIP_DOCKER_MACHINE_PROVISION_ON_CREATION_FAILURE"
+	UseStreamingCacheExtraction                 string = "FF_USE_STREAMING_CACHE_EXTRACTION"
 )
 
 type FeatureFlag struct {
@@ -160,6 +161,14 @@ var flags = []FeatureFlag{
 			"this feature flag is set to `true`, it skips `docker-machine provision` " +
 			"removes the machine, and creates another machine instead.",
 	},
+	{
+		Name:            UseStreamingCacheExtraction,
+		DefaultValue:    false,
+		Deprecated:      false,
+		ToBeRemovedWith: "",
+		Description: "Extracts the `tarzstd` caches while they're downloaded, instead of after the download. " +
+			"When the download fails, the cache can be partially extracted.",
+	},
 }
 
 func GetAll() []FeatureFlag {
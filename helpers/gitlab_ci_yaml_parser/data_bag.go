@@ -46,14 +46,30 @@ func contains(s []string, str string) bool {
 	return false
 }
 
-func Filter(vs []string, f func(string) bool) []string {
-	vsf := make([]string, 0)
-	for _, v := range vs {
-		if f(v) && len(v) > 7 {
-			vsf = append(vsf, v)
-		}
-	}
-	return vsf
+// reservedKeywords lists the top-level keys of .gitlab-ci.yml that are
+// global configuration and can't be used as job names
+var reservedKeywords = []string{
+	"after_script",
+	"before_script",
+	"cache",
+	"default",
+	"image",
+	"include",
+	"services",
+	"stages",
+	"types",
+	"variables",
+	"workflow",
+}
+
+func isReservedKeyword(key string) bool {
+	return contains(reservedKeywords, key)
+}
+
+// IsHiddenJob reports whether the job name starts with a dot. Such jobs are
+// only used as templates (e.g. for `extends:`) and are never executed.
+func IsHiddenJob(name string) bool {
+	return strings.HasPrefix(name, ".")
 }
 
 func (m *DataBag) GetAllJobsSorted() (result []string, ok bool) {
@@ -104,6 +120,10 @@ func (m *DataBag) getAllJobsAndStages() ([]string, []string, bool) {
 		panic(fmt.Errorf("could not find 'stages' key in .gitlab-ci.yml file!"))
 	}
 	for i := range keys {
+		if isReservedKeyword(keys[i]) || IsHiddenJob(keys[i]) {
+			continue
+		}
+
 		value, ok := helpers.GetMapKey(*m, keys[i])
 		if ok {
 			value, ok = value.(map[string]interface{})
@@ -112,8 +132,6 @@ func (m *DataBag) getAllJobsAndStages() ([]string, []string, bool) {
 			}
 		}
 	}
-	var out = []string{"variables", "workflow"}
-	result = Filter(result, func(el string) bool { return !contains(out, el) })
 
 	var arr []string = make([]string, len(stages_ordered))
 	for i := range stages_ordered {
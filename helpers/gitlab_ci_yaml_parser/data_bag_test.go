@@ -112,3 +112,432 @@ func TestDataBagSanitizeWithYamlDecode(t *testing.T) {
 		},
 	}, options)
 }
+
+func parseDataBag(t *testing.T, content string) DataBag {
+	options := make(DataBag)
+	require.NoError(t, yaml.Unmarshal([]byte(content), options))
+	require.NoError(t, options.Sanitize())
+	return options
+}
+
+const exampleExtendsYAML = `
+.base:
+  image: base:latest
+  variables:
+    BASE: base
+    SHARED: base
+  script: base
+
+.tests:
+  extends: .base
+  variables:
+    SHARED: tests
+  tags: [docker]
+
+.other:
+  tags: [shell]
+  before_script: [other]
+
+job:
+  extends: [.tests, .other]
+  variables:
+    JOB: job
+  script: job
+`
+
+func TestDataBagResolveExtends(t *testing.T) {
+	options := parseDataBag(t, exampleExtendsYAML)
+	require.NoError(t, options.ResolveExtends())
+
+	job, ok := options.GetSubOptions("job")
+	require.True(t, ok)
+	assert.Equal(t, DataBag{
+		"image": "base:latest",
+		"variables": map[string]interface{}{
+			"BASE":   "base",
+			"SHARED": "tests",
+			"JOB":    "job",
+		},
+		"script":        "job",
+		"tags":          []interface{}{"shell"},
+		"before_script": []interface{}{"other"},
+	}, job)
+
+	base, ok := options.GetSubOptions(".base")
+	require.True(t, ok)
+	assert.Equal(t, map[string]interface{}{"BASE": "base", "SHARED": "base"}, base["variables"])
+}
+
+func TestDataBagResolveExtendsErrors(t *testing.T) {
+	tests := map[string]struct {
+		content       string
+		expectedError string
+	}{
+		"circular dependency": {
+			content: `
+job1:
+  extends: job2
+job2:
+  extends: job1
+`,
+			expectedError: "circular dependency detected in `extends`",
+		},
+		"self reference": {
+			content: `
+job:
+  extends: job
+`,
+			expectedError: "circular dependency detected in `extends`",
+		},
+		"unknown base": {
+			content: `
+job:
+  extends: .missing
+`,
+			expectedError: "job: unknown key in `extends`: .missing",
+		},
+		"reserved keyword as base": {
+			content: `
+variables:
+  KEY: value
+job:
+  extends: variables
+`,
+			expectedError: "job: invalid base hash name in `extends`: variables",
+		},
+		"invalid extends type": {
+			content: `
+job:
+  extends: {key: value}
+`,
+			expectedError: "job: extends should be an array of strings or a string",
+		},
+		"nesting too deep": {
+			content: `
+.l0: {script: test}
+.l1: {extends: .l0}
+.l2: {extends: .l1}
+.l3: {extends: .l2}
+.l4: {extends: .l3}
+.l5: {extends: .l4}
+.l6: {extends: .l5}
+.l7: {extends: .l6}
+.l8: {extends: .l7}
+.l9: {extends: .l8}
+.l10: {extends: .l9}
+.l11: {extends: .l10}
+.l12: {extends: .l11}
+`,
+			expectedError: "nesting too deep in `extends`",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			options := parseDataBag(t, tt.content)
+			err := options.ResolveExtends()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedError)
+		})
+	}
+}
+
+func TestDataBagResolveExtendsMaxDepth(t *testing.T) {
+	options := parseDataBag(t, `
+.l0: {script: test}
+.l1: {extends: .l0}
+.l2: {extends: .l1}
+.l3: {extends: .l2}
+.l4: {extends: .l3}
+.l5: {extends: .l4}
+.l6: {extends: .l5}
+.l7: {extends: .l6}
+.l8: {extends: .l7}
+.l9: {extends: .l8}
+.l10: {extends: .l9}
+job: {extends: .l10}
+`)
+	require.NoError(t, options.ResolveExtends())
+
+	script, _ := options.GetString("job", "script")
+	assert.Equal(t, "test", script)
+}
+
+const exampleDefaultYAML = `
+default:
+  image: default:latest
+  before_script: [default]
+  retry: 2
+
+job1:
+  script: job1
+
+job2:
+  image: job2:latest
+  script: job2
+
+job3:
+  script: job3
+  inherit:
+    default: false
+
+job4:
+  script: job4
+  inherit:
+    default: [image]
+`
+
+func TestDataBagApplyDefault(t *testing.T) {
+	options := parseDataBag(t, exampleDefaultYAML)
+	require.NoError(t, options.ApplyDefault())
+
+	image, _ := options.GetString("job1", "image")
+	assert.Equal(t, "default:latest", image)
+	beforeScript, _ := options.GetStringSlice("job1", "before_script")
+	assert.Equal(t, []string{"default"}, beforeScript)
+
+	image, _ = options.GetString("job2", "image")
+	assert.Equal(t, "job2:latest", image)
+
+	_, ok := options.Get("job3", "image")
+	assert.False(t, ok)
+
+	image, _ = options.GetString("job4", "image")
+	assert.Equal(t, "default:latest", image)
+	_, ok = options.Get("job4", "before_script")
+	assert.False(t, ok)
+}
+
+func TestDataBagApplyDefaultUnknownKey(t *testing.T) {
+	options := parseDataBag(t, `
+default:
+  script: test
+job:
+  script: job
+`)
+	assert.EqualError(t, options.ApplyDefault(), "default config contains unknown keys: script")
+}
+
+func TestDataBagYAMLAnchors(t *testing.T) {
+	options := parseDataBag(t, `
+.defaults: &defaults
+  image: anchor:latest
+  variables: &variables
+    KEY: anchor
+
+job:
+  <<: *defaults
+  variables:
+    <<: *variables
+    OTHER: job
+  script: job
+`)
+	require.NoError(t, options.ResolveExtends())
+
+	image, _ := options.GetString("job", "image")
+	assert.Equal(t, "anchor:latest", image)
+	variables, _ := options.GetSubOptions("job", "variables")
+	assert.Equal(t, DataBag{"KEY": "anchor", "OTHER": "job"}, variables)
+}
+
+func TestDataBagGetAllJobsSortedSkipsHiddenJobs(t *testing.T) {
+	options := parseDataBag(t, `
+stages: [build, test]
+default:
+  image: alpine
+.template:
+  script: template
+build:
+  stage: build
+  script: build
+job:
+  script: job
+`)
+
+	jobs, ok := options.GetAllJobsSorted()
+	assert.True(t, ok)
+	assert.Equal(t, []string{"build", "job"}, jobs)
+}
+
+func TestDataBagGetPipelineJobs(t *testing.T) {
+	options := parseDataBag(t, `
+stages: [build, test, deploy]
+compile:
+  stage: build
+  script: compile
+unit:
+  stage: test
+  script: unit
+lint:
+  stage: test
+  needs: []
+  allow_failure: true
+  script: lint
+integration:
+  stage: test
+  needs: [compile, {job: missing, optional: true}]
+  allow_failure:
+    exit_codes: [137, 255]
+  script: integration
+release:
+  stage: deploy
+  when: always
+  script: release
+.hidden:
+  script: hidden
+`)
+
+	jobs, err := options.GetPipelineJobs(nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, []PipelineJob{
+		{Name: "compile", Stage: "build", When: JobWhenOnSuccess, Definition: "compile"},
+		{
+			Name:                  "integration",
+			Stage:                 "test",
+			When:                  JobWhenOnSuccess,
+			Definition:            "integration",
+			Needs:                 []string{"compile"},
+			Dependencies:          []string{"compile"},
+			AllowFailure:          true,
+			AllowFailureExitCodes: []int{137, 255},
+		},
+		{
+			Name:         "lint",
+			Stage:        "test",
+			When:         JobWhenOnSuccess,
+			Definition:   "lint",
+			Needs:        []string{},
+			Dependencies: []string{},
+			AllowFailure: true,
+		},
+		{
+			Name:         "unit",
+			Stage:        "test",
+			When:         JobWhenOnSuccess,
+			Definition:   "unit",
+			Needs:        []string{"compile"},
+			Dependencies: []string{"compile"},
+		},
+		{
+			Name:         "release",
+			Stage:        "deploy",
+			When:         JobWhenAlways,
+			Definition:   "release",
+			Needs:        []string{"compile", "integration", "lint", "unit"},
+			Dependencies: []string{"compile", "integration", "lint", "unit"},
+		},
+	}, jobs)
+}
+
+func TestDataBagGetPipelineJobsDependencies(t *testing.T) {
+	options := parseDataBag(t, `
+compile: {stage: build, script: compile}
+docs: {stage: build, script: docs}
+unit:
+  stage: test
+  dependencies: [compile]
+  script: unit
+lint:
+  stage: test
+  needs: [compile, {job: docs, artifacts: false}]
+  script: lint
+package:
+  stage: test
+  needs: [compile, docs]
+  dependencies: [docs]
+  script: package
+release:
+  stage: deploy
+  dependencies: []
+  script: release
+`)
+
+	jobs, err := options.GetPipelineJobs(nil)
+	require.NoError(t, err)
+
+	dependencies := make(map[string][]string)
+	for _, job := range jobs {
+		dependencies[job.Name] = job.Dependencies
+	}
+
+	assert.Equal(t, map[string][]string{
+		"compile": nil,
+		"docs":    nil,
+		"unit":    {"compile"},
+		"lint":    {"compile"},
+		"package": {"docs"},
+		"release": {},
+	}, dependencies)
+}
+
+func TestDataBagGetPipelineJobsDefaultStages(t *testing.T) {
+	options := parseDataBag(t, `
+job: {script: job}
+prepare: {stage: .pre, script: prepare}
+`)
+
+	stages, err := options.GetStages()
+	require.NoError(t, err)
+	assert.Equal(t, []string{".pre", "build", "test", "deploy", ".post"}, stages)
+
+	jobs, err := options.GetPipelineJobs(nil)
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+	assert.Equal(t, "prepare", jobs[0].Name)
+	assert.Equal(t, []string{"prepare"}, jobs[1].Needs)
+}
+
+func TestDataBagGetPipelineJobsErrors(t *testing.T) {
+	tests := map[string]struct {
+		content       string
+		expectedError string
+	}{
+		"unknown stage": {
+			content:       "job: {stage: unknown, script: job}",
+			expectedError: "job job: chosen stage unknown does not exist",
+		},
+		"undefined need": {
+			content:       "job: {needs: [missing], script: job}",
+			expectedError: "job job: undefined need: missing",
+		},
+		"need from later stage": {
+			content: `
+build: {stage: build, needs: [deploy], script: build}
+deploy: {stage: deploy, script: deploy}
+`,
+			expectedError: "build job: need deploy is not defined in current or prior stages",
+		},
+		"dependency from later stage": {
+			content: `
+build: {stage: build, dependencies: [deploy], script: build}
+deploy: {stage: deploy, script: deploy}
+`,
+			expectedError: "build job: undefined dependency: deploy",
+		},
+		"dependency not in needs": {
+			content: `
+build: {stage: build, script: build}
+docs: {stage: build, script: docs}
+test: {needs: [build], dependencies: [docs], script: test}
+`,
+			expectedError: "test job: dependency docs should be part of needs",
+		},
+		"cycle": {
+			content: `
+a: {needs: [b], script: a}
+b: {needs: [a], script: b}
+`,
+			expectedError: "circular dependency detected in `needs`: a -> b -> a",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			options := parseDataBag(t, tt.content)
+			_, err := options.GetPipelineJobs(nil)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedError)
+		})
+	}
+}
@@ -0,0 +1,348 @@
+package gitlab_ci_yaml_parser
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// EvaluateExpression evaluates a CI/CD variable expression, as used by
+// `rules:if` and `only/except:variables`, against the given variables.
+//
+// Supported are variable presence checks (`$VAR`), (in)equality with strings,
+// other variables and `null` (`==`, `!=`), regular expression matching
+// (`=~`, `!~`), the `&&` and `||` operators and parentheses.
+func EvaluateExpression(expression string, variables map[string]string) (bool, error) {
+	tokens, err := tokenizeExpression(expression)
+	if err != nil {
+		return false, fmt.Errorf("invalid expression %q: %w", expression, err)
+	}
+
+	p := &expressionParser{tokens: tokens, variables: variables}
+	result, err := p.parseOr()
+	if err == nil && !p.done() {
+		err = fmt.Errorf("unexpected %q", p.peek().text)
+	}
+	if err != nil {
+		return false, fmt.Errorf("invalid expression %q: %w", expression, err)
+	}
+
+	return result.truthy(), nil
+}
+
+type expressionTokenType int
+
+const (
+	tokenVariable expressionTokenType = iota
+	tokenString
+	tokenPattern
+	tokenNull
+	tokenOperator
+	tokenAnd
+	tokenOr
+	tokenOpenParen
+	tokenCloseParen
+)
+
+type expressionToken struct {
+	typ  expressionTokenType
+	text string
+}
+
+var errUnterminated = errors.New("unterminated literal")
+
+func tokenizeExpression(expression string) ([]expressionToken, error) {
+	var tokens []expressionToken
+	runes := []rune(expression)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		rest := string(runes[i:])
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '$':
+			j := i + 1
+			braced := j < len(runes) && runes[j] == '{'
+			if braced {
+				j++
+			}
+			start := j
+			for j < len(runes) && (runes[j] == '_' || unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j])) {
+				j++
+			}
+			if j == start {
+				return nil, fmt.Errorf("invalid variable at %d", i)
+			}
+			tokens = append(tokens, expressionToken{typ: tokenVariable, text: string(runes[start:j])})
+			if braced {
+				if j >= len(runes) || runes[j] != '}' {
+					return nil, fmt.Errorf("invalid variable at %d", i)
+				}
+				j++
+			}
+			i = j
+
+		case r == '"' || r == '\'':
+			j := i + 1
+			for j < len(runes) && runes[j] != r {
+				j++
+			}
+			if j >= len(runes) {
+				return nil, errUnterminated
+			}
+			tokens = append(tokens, expressionToken{typ: tokenString, text: string(runes[i+1 : j])})
+			i = j + 1
+
+		case r == '/':
+			j := i + 1
+			for j < len(runes) && runes[j] != '/' {
+				if runes[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(runes) {
+				return nil, errUnterminated
+			}
+			j++
+			for j < len(runes) && unicode.IsLetter(runes[j]) {
+				j++
+			}
+			tokens = append(tokens, expressionToken{typ: tokenPattern, text: string(runes[i:j])})
+			i = j
+
+		case strings.HasPrefix(rest, "null"):
+			tokens = append(tokens, expressionToken{typ: tokenNull, text: "null"})
+			i += 4
+
+		case strings.HasPrefix(rest, "=="), strings.HasPrefix(rest, "!="),
+			strings.HasPrefix(rest, "=~"), strings.HasPrefix(rest, "!~"):
+			tokens = append(tokens, expressionToken{typ: tokenOperator, text: rest[:2]})
+			i += 2
+
+		case strings.HasPrefix(rest, "&&"):
+			tokens = append(tokens, expressionToken{typ: tokenAnd, text: "&&"})
+			i += 2
+
+		case strings.HasPrefix(rest, "||"):
+			tokens = append(tokens, expressionToken{typ: tokenOr, text: "||"})
+			i += 2
+
+		case r == '(':
+			tokens = append(tokens, expressionToken{typ: tokenOpenParen, text: "("})
+			i++
+
+		case r == ')':
+			tokens = append(tokens, expressionToken{typ: tokenCloseParen, text: ")"})
+			i++
+
+		default:
+			return nil, fmt.Errorf("unexpected character %q at %d", r, i)
+		}
+	}
+
+	return tokens, nil
+}
+
+// expressionValue is the result of evaluating a part of an expression. A nil
+// value represents an undefined variable or `null`.
+type expressionValue struct {
+	value   *string
+	pattern *regexp.Regexp
+	boolean *bool
+}
+
+func (v expressionValue) truthy() bool {
+	switch {
+	case v.boolean != nil:
+		return *v.boolean
+	case v.value != nil:
+		return *v.value != ""
+	case v.pattern != nil:
+		return true
+	}
+
+	return false
+}
+
+func booleanValue(b bool) expressionValue {
+	return expressionValue{boolean: &b}
+}
+
+type expressionParser struct {
+	tokens    []expressionToken
+	pos       int
+	variables map[string]string
+}
+
+func (p *expressionParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *expressionParser) peek() expressionToken {
+	return p.tokens[p.pos]
+}
+
+func (p *expressionParser) parseOr() (expressionValue, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return left, err
+	}
+
+	for !p.done() && p.peek().typ == tokenOr {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return right, err
+		}
+		left = booleanValue(left.truthy() || right.truthy())
+	}
+
+	return left, nil
+}
+
+func (p *expressionParser) parseAnd() (expressionValue, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return left, err
+	}
+
+	for !p.done() && p.peek().typ == tokenAnd {
+		p.pos++
+		right, err := p.parseComparison()
+		if err != nil {
+			return right, err
+		}
+		left = booleanValue(left.truthy() && right.truthy())
+	}
+
+	return left, nil
+}
+
+func (p *expressionParser) parseComparison() (expressionValue, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return left, err
+	}
+
+	if p.done() || p.peek().typ != tokenOperator {
+		return left, nil
+	}
+
+	operator := p.peek().text
+	p.pos++
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return right, err
+	}
+
+	switch operator {
+	case "==":
+		return booleanValue(equalValues(left, right)), nil
+	case "!=":
+		return booleanValue(!equalValues(left, right)), nil
+	case "=~":
+		matches, err := matchValues(left, right)
+		return booleanValue(matches), err
+	default:
+		matches, err := matchValues(left, right)
+		return booleanValue(!matches), err
+	}
+}
+
+func (p *expressionParser) parseOperand() (expressionValue, error) {
+	if p.done() {
+		return expressionValue{}, errors.New("unexpected end of expression")
+	}
+
+	token := p.peek()
+	p.pos++
+
+	switch token.typ {
+	case tokenVariable:
+		value, ok := p.variables[token.text]
+		if !ok {
+			return expressionValue{}, nil
+		}
+		return expressionValue{value: &value}, nil
+
+	case tokenString:
+		value := token.text
+		return expressionValue{value: &value}, nil
+
+	case tokenNull:
+		return expressionValue{}, nil
+
+	case tokenPattern:
+		pattern, err := compilePattern(token.text)
+		return expressionValue{pattern: pattern}, err
+
+	case tokenOpenParen:
+		value, err := p.parseOr()
+		if err != nil {
+			return value, err
+		}
+		if p.done() || p.peek().typ != tokenCloseParen {
+			return value, errors.New("missing closing parenthesis")
+		}
+		p.pos++
+		return value, nil
+	}
+
+	return expressionValue{}, fmt.Errorf("unexpected %q", token.text)
+}
+
+func equalValues(left, right expressionValue) bool {
+	if left.value == nil || right.value == nil {
+		return left.value == nil && right.value == nil && left.pattern == nil && right.pattern == nil
+	}
+
+	return *left.value == *right.value
+}
+
+func matchValues(left, right expressionValue) (bool, error) {
+	pattern := right.pattern
+	if pattern == nil && right.value != nil {
+		// The pattern can be stored in a variable
+		var err error
+		pattern, err = compilePattern(*right.value)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if pattern == nil {
+		return false, errors.New("right side of a pattern match must be a pattern")
+	}
+
+	if left.value == nil {
+		return false, nil
+	}
+
+	return pattern.MatchString(*left.value), nil
+}
+
+// compilePattern compiles a `/pattern/flags` literal. Only the `i` flag is
+// supported, which is also the only one accepted by GitLab.
+func compilePattern(literal string) (*regexp.Regexp, error) {
+	end := strings.LastIndex(literal, "/")
+	if !strings.HasPrefix(literal, "/") || end < 1 {
+		return nil, fmt.Errorf("invalid pattern %q", literal)
+	}
+
+	expression := literal[1:end]
+	for _, flag := range literal[end+1:] {
+		if flag != 'i' {
+			return nil, fmt.Errorf("unsupported pattern flag %q", flag)
+		}
+		expression = "(?i)" + expression
+	}
+
+	return regexp.Compile(expression)
+}
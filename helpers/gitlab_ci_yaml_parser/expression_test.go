@@ -0,0 +1,79 @@
+package gitlab_ci_yaml_parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluateExpression(t *testing.T) {
+	variables := map[string]string{
+		"CI_COMMIT_BRANCH":   "main",
+		"CI_COMMIT_MESSAGE":  "Fix the Build",
+		"CI_PIPELINE_SOURCE": "push",
+		"EMPTY":              "",
+		"PATTERN":            "/^ma/",
+		"OTHER_BRANCH":       "main",
+	}
+
+	tests := map[string]struct {
+		expression    string
+		expected      bool
+		expectedError bool
+	}{
+		"defined variable":          {expression: "$CI_COMMIT_BRANCH", expected: true},
+		"braced variable":           {expression: "${CI_COMMIT_BRANCH}", expected: true},
+		"undefined variable":        {expression: "$UNDEFINED", expected: false},
+		"empty variable":            {expression: "$EMPTY", expected: false},
+		"equal string":              {expression: `$CI_COMMIT_BRANCH == "main"`, expected: true},
+		"equal single quoted":       {expression: `$CI_COMMIT_BRANCH == 'main'`, expected: true},
+		"not equal string":          {expression: `$CI_COMMIT_BRANCH != "main"`, expected: false},
+		"equal variables":           {expression: "$CI_COMMIT_BRANCH == $OTHER_BRANCH", expected: true},
+		"undefined equals null":     {expression: "$UNDEFINED == null", expected: true},
+		"empty is not null":         {expression: "$EMPTY == null", expected: false},
+		"empty equals empty string": {expression: `$EMPTY == ""`, expected: true},
+		"pattern match":             {expression: "$CI_COMMIT_BRANCH =~ /^ma/", expected: true},
+		"pattern mismatch":          {expression: "$CI_COMMIT_BRANCH !~ /^ma/", expected: false},
+		"case sensitive pattern":    {expression: "$CI_COMMIT_MESSAGE =~ /build/", expected: false},
+		"case insensitive pattern":  {expression: "$CI_COMMIT_MESSAGE =~ /build/i", expected: true},
+		"pattern from variable":     {expression: "$CI_COMMIT_BRANCH =~ $PATTERN", expected: true},
+		"escaped slash in pattern":  {expression: `"a/b" =~ /a\/b/`, expected: true},
+		"undefined never matches":   {expression: "$UNDEFINED =~ /.*/", expected: false},
+		"and": {
+			expression: `$CI_COMMIT_BRANCH == "main" && $CI_PIPELINE_SOURCE == "push"`,
+			expected:   true,
+		},
+		"or": {
+			expression: `$CI_COMMIT_BRANCH == "dev" || $CI_PIPELINE_SOURCE == "push"`,
+			expected:   true,
+		},
+		"and takes precedence over or": {
+			expression: `$CI_COMMIT_BRANCH == "main" || $UNDEFINED && $EMPTY`,
+			expected:   true,
+		},
+		"parentheses": {
+			expression: `($CI_COMMIT_BRANCH == "main" || $UNDEFINED) && $EMPTY`,
+			expected:   false,
+		},
+		"unterminated string":   {expression: `$CI_COMMIT_BRANCH == "main`, expectedError: true},
+		"missing operand":       {expression: `$CI_COMMIT_BRANCH ==`, expectedError: true},
+		"missing parenthesis":   {expression: `($CI_COMMIT_BRANCH`, expectedError: true},
+		"unexpected character":  {expression: `$CI_COMMIT_BRANCH = "main"`, expectedError: true},
+		"match without pattern": {expression: `$CI_COMMIT_BRANCH =~ $UNDEFINED`, expectedError: true},
+		"unsupported flag":      {expression: `$CI_COMMIT_BRANCH =~ /main/m`, expectedError: true},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			result, err := EvaluateExpression(tt.expression, variables)
+			if tt.expectedError {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
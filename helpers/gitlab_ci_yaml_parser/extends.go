@@ -0,0 +1,246 @@
+package gitlab_ci_yaml_parser
+
+import (
+	"fmt"
+	"sort"
+)
+
+// maxExtendsDepth matches the nesting limit enforced by GitLab for `extends:`
+const maxExtendsDepth = 11
+
+// defaultKeywords lists the keywords that can be set in the `default:` block
+// and are inherited by every job that doesn't define them itself
+var defaultKeywords = []string{
+	"after_script",
+	"artifacts",
+	"before_script",
+	"cache",
+	"image",
+	"interruptible",
+	"retry",
+	"services",
+	"tags",
+	"timeout",
+}
+
+// ResolveExtends replaces the `extends:` keyword of every job with the deep
+// merged content of the jobs it extends. Hashes are merged recursively, while
+// any other value (including arrays) defined closer to the job wins.
+func (m *DataBag) ResolveExtends() error {
+	resolver := &extendsResolver{
+		config:   *m,
+		resolved: make(map[string]*resolvedJob),
+	}
+
+	for _, name := range m.jobLikeKeys() {
+		job, err := resolver.resolve(name, nil)
+		if err != nil {
+			return err
+		}
+
+		(*m)[name] = job.config
+	}
+
+	return nil
+}
+
+// ApplyDefault copies the keywords defined in the `default:` block into every
+// job that doesn't define them and doesn't opt out using `inherit:default:`
+func (m *DataBag) ApplyDefault() error {
+	defaults, ok := m.GetSubOptions("default")
+	if !ok {
+		return nil
+	}
+
+	for key := range defaults {
+		if !contains(defaultKeywords, key) {
+			return fmt.Errorf("default config contains unknown keys: %s", key)
+		}
+	}
+
+	for _, name := range m.jobLikeKeys() {
+		job, _ := m.GetSubOptions(name)
+
+		inherited, err := inheritedDefaults(name, job)
+		if err != nil {
+			return err
+		}
+
+		for key, value := range defaults {
+			if _, ok := job[key]; ok || !inherited(key) {
+				continue
+			}
+
+			job[key] = deepCopy(value)
+		}
+	}
+
+	return nil
+}
+
+func inheritedDefaults(name string, job DataBag) (func(key string) bool, error) {
+	value, ok := job.Get("inherit", "default")
+	if !ok {
+		return func(string) bool { return true }, nil
+	}
+
+	switch inherit := value.(type) {
+	case bool:
+		return func(string) bool { return inherit }, nil
+	case []interface{}:
+		var keys []string
+		for _, key := range inherit {
+			keyText, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: inherit:default must be a boolean or an array of strings", name)
+			}
+			keys = append(keys, keyText)
+		}
+		return func(key string) bool { return contains(keys, key) }, nil
+	default:
+		return nil, fmt.Errorf("%s: inherit:default must be a boolean or an array of strings", name)
+	}
+}
+
+// jobLikeKeys returns, in a stable order, all top-level keys that can be used
+// as a job or as a base for `extends:`, hidden jobs included
+func (m *DataBag) jobLikeKeys() []string {
+	var keys []string
+	for key, value := range *m {
+		if isReservedKeyword(key) {
+			continue
+		}
+
+		if _, ok := value.(map[string]interface{}); ok {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+type extendsResolver struct {
+	config   DataBag
+	resolved map[string]*resolvedJob
+}
+
+type resolvedJob struct {
+	config map[string]interface{}
+	depth  int
+}
+
+func (r *extendsResolver) resolve(name string, chain []string) (*resolvedJob, error) {
+	if job, ok := r.resolved[name]; ok {
+		return job, nil
+	}
+
+	if contains(chain, name) {
+		return nil, fmt.Errorf("%s: circular dependency detected in `extends`", chain[0])
+	}
+	chain = append(chain, name)
+
+	job, ok := r.config.GetSubOptions(name)
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown key in `extends`: %s", chain[0], name)
+	}
+
+	bases, err := getExtends(name, job)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &resolvedJob{config: make(map[string]interface{})}
+	for _, base := range bases {
+		if isReservedKeyword(base) {
+			return nil, fmt.Errorf("%s: invalid base hash name in `extends`: %s", name, base)
+		}
+
+		baseJob, err := r.resolve(base, chain)
+		if err != nil {
+			return nil, err
+		}
+
+		if baseJob.depth+1 > result.depth {
+			result.depth = baseJob.depth + 1
+		}
+		result.config = deepMerge(result.config, baseJob.config)
+	}
+
+	if result.depth > maxExtendsDepth {
+		return nil, fmt.Errorf("%s: nesting too deep in `extends`", name)
+	}
+
+	own := make(map[string]interface{}, len(job))
+	for key, value := range job {
+		if key != "extends" {
+			own[key] = value
+		}
+	}
+
+	result.config = deepMerge(result.config, own)
+	r.resolved[name] = result
+
+	return result, nil
+}
+
+func getExtends(name string, job DataBag) ([]string, error) {
+	value, ok := job["extends"]
+	if !ok {
+		return nil, nil
+	}
+
+	switch extends := value.(type) {
+	case string:
+		return []string{extends}, nil
+	case []interface{}:
+		var bases []string
+		for _, base := range extends {
+			baseText, ok := base.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: extends should be an array of strings or a string", name)
+			}
+			bases = append(bases, baseText)
+		}
+		return bases, nil
+	default:
+		return nil, fmt.Errorf("%s: extends should be an array of strings or a string", name)
+	}
+}
+
+// deepMerge returns a copy of dst with src merged into it. Nested hashes are
+// merged recursively and any other value from src replaces the one in dst.
+func deepMerge(dst, src map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(dst)+len(src))
+	for key, value := range dst {
+		result[key] = deepCopy(value)
+	}
+
+	for key, value := range src {
+		srcMap, srcIsMap := value.(map[string]interface{})
+		dstMap, dstIsMap := result[key].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			result[key] = deepMerge(dstMap, srcMap)
+			continue
+		}
+
+		result[key] = deepCopy(value)
+	}
+
+	return result
+}
+
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return deepMerge(nil, v)
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i := range v {
+			result[i] = deepCopy(v[i])
+		}
+		return result
+	default:
+		return value
+	}
+}
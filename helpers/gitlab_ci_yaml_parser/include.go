@@ -0,0 +1,241 @@
+package gitlab_ci_yaml_parser
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// maxIncludes matches the limit of included files enforced by GitLab
+const maxIncludes = 100
+
+var unsupportedIncludeTypes = []string{"remote", "template", "project", "file"}
+
+// includeResolver loads a configuration file together with all the local
+// files it includes. Included files are merged in the order they are listed
+// and the including file always takes precedence over them.
+type includeResolver struct {
+	projectDir string
+	stack      []string
+	count      int
+}
+
+func newIncludeResolver(filename string) (*includeResolver, error) {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return &includeResolver{
+		projectDir: filepath.Dir(path),
+	}, nil
+}
+
+func (r *includeResolver) load(filename string) (DataBag, error) {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if contains(r.stack, path) {
+		return nil, fmt.Errorf("include cycle detected: %s -> %s", strings.Join(r.relative(r.stack), " -> "), r.relative([]string{path})[0])
+	}
+
+	r.count++
+	if r.count > maxIncludes {
+		return nil, fmt.Errorf("maximum of %d includes exceeded", maxIncludes)
+	}
+
+	r.stack = append(r.stack, path)
+	defer func() { r.stack = r.stack[:len(r.stack)-1] }()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := make(DataBag)
+	err = yaml.Unmarshal(data, config)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	err = config.Sanitize()
+	if err != nil {
+		return nil, err
+	}
+
+	err = restoreMatrixOrder(data, config)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	includes, err := r.getIncludes(config)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	delete(config, "include")
+
+	result := make(map[string]interface{})
+	for _, include := range includes {
+		included, err := r.load(include)
+		if err != nil {
+			return nil, err
+		}
+
+		result = deepMerge(result, included)
+	}
+
+	return deepMerge(result, config), nil
+}
+
+func (r *includeResolver) getIncludes(config DataBag) ([]string, error) {
+	value, ok := config["include"]
+	if !ok || value == nil {
+		return nil, nil
+	}
+
+	entries, ok := value.([]interface{})
+	if !ok {
+		entries = []interface{}{value}
+	}
+
+	var files []string
+	for _, entry := range entries {
+		entry, err := convertMapToStringMap(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		local, err := getLocalInclude(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		matches, err := r.expandLocal(local)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, matches...)
+	}
+
+	return files, nil
+}
+
+func getLocalInclude(entry interface{}) (string, error) {
+	switch include := entry.(type) {
+	case string:
+		if strings.HasPrefix(include, "http://") || strings.HasPrefix(include, "https://") {
+			return "", fmt.Errorf("remote include %q is not supported in local mode", include)
+		}
+		return include, nil
+	case map[string]interface{}:
+		if local, ok := include["local"].(string); ok {
+			return local, nil
+		}
+
+		for _, includeType := range unsupportedIncludeTypes {
+			if value, ok := include[includeType]; ok {
+				return "", fmt.Errorf("%s include %v is not supported in local mode", includeType, value)
+			}
+		}
+	}
+
+	return "", fmt.Errorf("invalid include: %v", entry)
+}
+
+// expandLocal resolves a local include, which is always relative to the
+// project directory and can't leave it. Wildcards are expanded the same way
+// GitLab does: * matches within a directory and ** across directories.
+func (r *includeResolver) expandLocal(local string) ([]string, error) {
+	pattern := path.Clean(strings.TrimPrefix(filepath.ToSlash(local), "/"))
+	if pattern == ".." || strings.HasPrefix(pattern, "../") {
+		return nil, fmt.Errorf("included file %q is outside of the project directory", local)
+	}
+
+	if !strings.ContainsAny(pattern, "*?[") {
+		return []string{filepath.Join(r.projectDir, filepath.FromSlash(pattern))}, nil
+	}
+
+	// validate the pattern upfront, since path.Match reports malformed
+	// patterns only when reaching them
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid include pattern %q: %w", local, err)
+	}
+
+	var matches []string
+	err := filepath.Walk(r.projectDir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(r.projectDir, file)
+		if err != nil {
+			return err
+		}
+
+		if matchIncludePattern(strings.Split(pattern, "/"), strings.Split(filepath.ToSlash(rel), "/")) {
+			matches = append(matches, file)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("expanding include pattern %q: %w", local, err)
+	}
+
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("included file pattern %q doesn't match any file", local)
+	}
+
+	return matches, nil
+}
+
+// matchIncludePattern matches the segments of a path with the segments of a
+// pattern, a ** segment matching any number of directories
+func matchIncludePattern(pattern []string, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchIncludePattern(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if len(name) == 0 {
+		return false
+	}
+
+	// the pattern is validated upfront
+	matched, _ := path.Match(pattern[0], name[0])
+
+	return matched && matchIncludePattern(pattern[1:], name[1:])
+}
+
+func (r *includeResolver) relative(paths []string) []string {
+	result := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if rel, err := filepath.Rel(r.projectDir, path); err == nil {
+			path = rel
+		}
+		result = append(result, filepath.ToSlash(path))
+	}
+
+	return result
+}
@@ -0,0 +1,199 @@
+package gitlab_ci_yaml_parser
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func prepareProjectDir(t *testing.T, files map[string]string) (string, func()) {
+	dir, err := ioutil.TempDir("", "gitlab-ci-include")
+	require.NoError(t, err)
+
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+
+	return dir, func() { _ = os.RemoveAll(dir) }
+}
+
+func loadProjectConfig(t *testing.T, files map[string]string) (DataBag, error) {
+	dir, cleanup := prepareProjectDir(t, files)
+	defer cleanup()
+
+	filename := filepath.Join(dir, ".gitlab-ci.yml")
+	resolver, err := newIncludeResolver(filename)
+	require.NoError(t, err)
+
+	return resolver.load(filename)
+}
+
+func TestIncludeLocalFiles(t *testing.T) {
+	config, err := loadProjectConfig(t, map[string]string{
+		".gitlab-ci.yml": `
+include:
+  - local: /ci/first.yml
+  - ci/second.yml
+variables:
+  MAIN: main
+  SHARED: main
+job:
+  script: main
+`,
+		"ci/first.yml": `
+include: ci/nested.yml
+variables:
+  FIRST: first
+  SHARED: first
+job:
+  image: first:image
+  script: first
+`,
+		"ci/second.yml": `
+variables:
+  SHARED: second
+job:
+  image: second:image
+`,
+		"ci/nested.yml": `
+variables:
+  NESTED: nested
+nested_job:
+  script: nested
+`,
+	})
+	require.NoError(t, err)
+
+	_, ok := config["include"]
+	assert.False(t, ok)
+
+	variables, _ := config.GetSubOptions("variables")
+	assert.Equal(t, DataBag{
+		"MAIN":   "main",
+		"SHARED": "main",
+		"FIRST":  "first",
+		"NESTED": "nested",
+	}, variables)
+
+	job, _ := config.GetSubOptions("job")
+	assert.Equal(t, DataBag{"image": "second:image", "script": "main"}, job)
+
+	script, _ := config.GetString("nested_job", "script")
+	assert.Equal(t, "nested", script)
+}
+
+func TestIncludeLocalWildcard(t *testing.T) {
+	config, err := loadProjectConfig(t, map[string]string{
+		".gitlab-ci.yml": `
+include: 'ci/*.yml'
+`,
+		"ci/a.yml": "job_a: {script: a}",
+		"ci/b.yml": "job_b: {script: b}",
+	})
+	require.NoError(t, err)
+
+	assert.Contains(t, config, "job_a")
+	assert.Contains(t, config, "job_b")
+}
+
+func TestIncludeLocalRecursiveWildcard(t *testing.T) {
+	config, err := loadProjectConfig(t, map[string]string{
+		".gitlab-ci.yml": `
+include: 'ci/**/*.yml'
+`,
+		"ci/a.yml":        "job_a: {script: a}",
+		"ci/jobs/b.yml":   "job_b: {script: b}",
+		"ci/jobs/x/c.yml": "job_c: {script: c}",
+		"ci/jobs/d.txt":   "job_d: {script: d}",
+	})
+	require.NoError(t, err)
+
+	assert.Contains(t, config, "job_a")
+	assert.Contains(t, config, "job_b")
+	assert.Contains(t, config, "job_c")
+	assert.NotContains(t, config, "job_d")
+}
+
+func TestIncludeErrors(t *testing.T) {
+	tests := map[string]struct {
+		files         map[string]string
+		expectedError string
+	}{
+		"cycle": {
+			files: map[string]string{
+				".gitlab-ci.yml": "include: a.yml",
+				"a.yml":          "include: {local: b.yml}",
+				"b.yml":          "include: a.yml",
+			},
+			expectedError: "include cycle detected: .gitlab-ci.yml -> a.yml -> b.yml -> a.yml",
+		},
+		"remote string": {
+			files: map[string]string{
+				".gitlab-ci.yml": "include: https://example.com/ci.yml",
+			},
+			expectedError: `remote include "https://example.com/ci.yml" is not supported in local mode`,
+		},
+		"remote hash": {
+			files: map[string]string{
+				".gitlab-ci.yml": "include: {remote: 'https://example.com/ci.yml'}",
+			},
+			expectedError: "remote include https://example.com/ci.yml is not supported in local mode",
+		},
+		"template": {
+			files: map[string]string{
+				".gitlab-ci.yml": "include: [{template: Auto-DevOps.gitlab-ci.yml}]",
+			},
+			expectedError: "template include Auto-DevOps.gitlab-ci.yml is not supported in local mode",
+		},
+		"project": {
+			files: map[string]string{
+				".gitlab-ci.yml": "include: [{project: group/project, file: ci.yml}]",
+			},
+			expectedError: "project include group/project is not supported in local mode",
+		},
+		"missing file": {
+			files: map[string]string{
+				".gitlab-ci.yml": "include: missing.yml",
+			},
+			expectedError: "missing.yml",
+		},
+		"wildcard without matches": {
+			files: map[string]string{
+				".gitlab-ci.yml": "include: 'ci/*.yml'",
+			},
+			expectedError: `included file pattern "ci/*.yml" doesn't match any file`,
+		},
+		"outside of the project directory": {
+			files: map[string]string{
+				".gitlab-ci.yml": "include: ../../etc/x.yml",
+			},
+			expectedError: `included file "../../etc/x.yml" is outside of the project directory`,
+		},
+		"wildcard outside of the project directory": {
+			files: map[string]string{
+				".gitlab-ci.yml": "include: {local: '/ci/../../*.yml'}",
+			},
+			expectedError: `included file "/ci/../../*.yml" is outside of the project directory`,
+		},
+		"invalid wildcard": {
+			files: map[string]string{
+				".gitlab-ci.yml": "include: 'ci/[.yml'",
+			},
+			expectedError: `invalid include pattern "ci/[.yml"`,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			_, err := loadProjectConfig(t, tt.files)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedError)
+		})
+	}
+}
@@ -0,0 +1,301 @@
+package gitlab_ci_yaml_parser
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+const (
+	minParallel = 2
+	maxParallel = 200
+)
+
+// jobInstance is a single job created from a job definition. Jobs using
+// `parallel:` are expanded into multiple instances, all other jobs have
+// exactly one instance named the same as the definition.
+type jobInstance struct {
+	Name       string
+	Definition string
+
+	// Variables are the CI_NODE_* and matrix variables of the instance
+	Variables common.JobVariables
+
+	// matrix holds the matrix variables, used to match `needs:parallel:matrix`
+	matrix map[string]string
+}
+
+func variablesMap(variables common.JobVariables) map[string]string {
+	result := make(map[string]string, len(variables))
+	for _, variable := range variables {
+		result[variable.Key] = variable.Value
+	}
+
+	return result
+}
+
+// getJobInstances expands the job definition according to its `parallel:`
+// keyword, using the same names as GitLab: `job 1/3` for `parallel: 3` and
+// `job: [value1, value2]` for `parallel:matrix`
+func (m *DataBag) getJobInstances(name string) ([]jobInstance, error) {
+	value, ok := m.Get(name, "parallel")
+	if !ok || value == nil {
+		return []jobInstance{{Name: name, Definition: name}}, nil
+	}
+
+	switch parallel := value.(type) {
+	case int:
+		return expandParallel(name, parallel)
+	case map[string]interface{}:
+		matrix, ok := parallel["matrix"].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s job: parallel:matrix should be an array of hashes", name)
+		}
+
+		return expandMatrix(name, matrix)
+	}
+
+	return nil, fmt.Errorf("%s job: parallel should be an integer or a hash", name)
+}
+
+func expandParallel(name string, total int) ([]jobInstance, error) {
+	if total < minParallel || total > maxParallel {
+		return nil, fmt.Errorf("%s job: parallel must be between %d and %d", name, minParallel, maxParallel)
+	}
+
+	instances := make([]jobInstance, 0, total)
+	for index := 1; index <= total; index++ {
+		instances = append(instances, jobInstance{
+			Name:       fmt.Sprintf("%s %d/%d", name, index, total),
+			Definition: name,
+			Variables:  nodeVariables(index, total),
+		})
+	}
+
+	return instances, nil
+}
+
+func expandMatrix(name string, matrix []interface{}) ([]jobInstance, error) {
+	combinations, err := getMatrixCombinations(matrix)
+	if err != nil {
+		return nil, fmt.Errorf("%s job: parallel:matrix: %w", name, err)
+	}
+
+	total := len(combinations)
+	if total > maxParallel {
+		return nil, fmt.Errorf("%s job: parallel:matrix generates too many jobs (maximum is %d)", name, maxParallel)
+	}
+
+	instances := make([]jobInstance, 0, total)
+	for i, combination := range combinations {
+		instance := jobInstance{
+			Definition: name,
+			Variables:  nodeVariables(i+1, total),
+			matrix:     variablesMap(combination),
+		}
+		instance.Variables = append(instance.Variables, combination...)
+
+		values := make([]string, 0, len(combination))
+		for _, variable := range combination {
+			values = append(values, variable.Value)
+		}
+		instance.Name = fmt.Sprintf("%s: [%s]", name, strings.Join(values, ", "))
+
+		instances = append(instances, instance)
+	}
+
+	return instances, nil
+}
+
+func nodeVariables(index int, total int) common.JobVariables {
+	return common.JobVariables{
+		{Key: "CI_NODE_INDEX", Value: strconv.Itoa(index), Public: true, Internal: true, File: false},
+		{Key: "CI_NODE_TOTAL", Value: strconv.Itoa(total), Public: true, Internal: true, File: false},
+	}
+}
+
+// getMatrixCombinations returns all the variable combinations defined by the
+// matrix. Every entry of the matrix adds the cartesian product of its values,
+// with the first variable changing the slowest.
+func getMatrixCombinations(matrix []interface{}) ([]common.JobVariables, error) {
+	var combinations []common.JobVariables
+
+	for _, entry := range matrix {
+		keys, values, err := getMatrixEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		entryCombinations := []common.JobVariables{{}}
+		for i, key := range keys {
+			var next []common.JobVariables
+			for _, combination := range entryCombinations {
+				for _, value := range values[i] {
+					variables := append(append(common.JobVariables{}, combination...), common.JobVariable{
+						Key:    key,
+						Value:  value,
+						Public: true,
+					})
+					next = append(next, variables)
+				}
+			}
+			entryCombinations = next
+		}
+
+		combinations = append(combinations, entryCombinations...)
+	}
+
+	return combinations, nil
+}
+
+// getMatrixEntry returns the variable names of the matrix entry in the order
+// in which they're defined, together with their values
+func getMatrixEntry(entry interface{}) ([]string, [][]string, error) {
+	var keys []string
+	var rawValues []interface{}
+
+	switch definition := entry.(type) {
+	case yaml.MapSlice:
+		for _, item := range definition {
+			key, ok := item.Key.(string)
+			if !ok {
+				return nil, nil, fmt.Errorf("variable names should be strings")
+			}
+			keys = append(keys, key)
+			rawValues = append(rawValues, item.Value)
+		}
+	default:
+		converted, err := convertMapToStringMap(entry)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		definitionMap, ok := converted.(map[string]interface{})
+		if !ok {
+			return nil, nil, fmt.Errorf("entries should be hashes")
+		}
+
+		// The order of the variables is unknown, so sort them to keep the
+		// names stable
+		for key := range definitionMap {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			rawValues = append(rawValues, definitionMap[key])
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, nil, fmt.Errorf("entries should define at least one variable")
+	}
+
+	values := make([][]string, len(keys))
+	for i, rawValue := range rawValues {
+		switch value := rawValue.(type) {
+		case []interface{}:
+			for _, item := range value {
+				values[i] = append(values[i], fmt.Sprint(item))
+			}
+		case nil:
+			return nil, nil, fmt.Errorf("variable %s has no values", keys[i])
+		default:
+			values[i] = []string{fmt.Sprint(value)}
+		}
+
+		if len(values[i]) == 0 {
+			return nil, nil, fmt.Errorf("variable %s has no values", keys[i])
+		}
+	}
+
+	return keys, values, nil
+}
+
+// findJobInstance looks up the job instance with the given name
+func (m *DataBag) findJobInstance(name string) (jobInstance, bool, error) {
+	for _, definition := range m.jobLikeKeys() {
+		if IsHiddenJob(definition) {
+			continue
+		}
+
+		instances, err := m.getJobInstances(definition)
+		if err != nil {
+			return jobInstance{}, false, err
+		}
+
+		for _, instance := range instances {
+			if instance.Name == name {
+				return instance, true, nil
+			}
+		}
+
+		if definition == name {
+			names := make([]string, 0, len(instances))
+			for _, instance := range instances {
+				names = append(names, fmt.Sprintf("%q", instance.Name))
+			}
+
+			return jobInstance{}, false, fmt.Errorf("job %q is expanded by parallel into %s",
+				name, strings.Join(names, ", "))
+		}
+	}
+
+	return jobInstance{}, false, nil
+}
+
+// restoreMatrixOrder replaces the entries of `parallel:matrix` with their
+// ordered version, since GitLab names the matrix jobs after the values in
+// the order in which the variables are defined
+func restoreMatrixOrder(data []byte, config DataBag) error {
+	var ordered yaml.MapSlice
+	err := yaml.Unmarshal(data, &ordered)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range ordered {
+		name, ok := item.Key.(string)
+		if !ok {
+			continue
+		}
+
+		matrix, ok := mapSliceValue(item.Value, "parallel", "matrix").([]interface{})
+		if !ok {
+			continue
+		}
+
+		parallel, ok := config.GetSubOptions(name, "parallel")
+		if !ok {
+			continue
+		}
+
+		parallel["matrix"] = matrix
+	}
+
+	return nil
+}
+
+func mapSliceValue(value interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		slice, ok := value.(yaml.MapSlice)
+		if !ok {
+			return nil
+		}
+
+		value = nil
+		for _, item := range slice {
+			if item.Key == key {
+				value = item.Value
+				break
+			}
+		}
+	}
+
+	return value
+}
@@ -0,0 +1,170 @@
+package gitlab_ci_yaml_parser
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+const exampleParallelYAML = `
+stages: [build, test, deploy]
+
+.matrix: &matrix
+  - STACK: [monitoring, app]
+    PROVIDER: aws
+  - PROVIDER: gcp
+    STACK: data
+
+build:
+  stage: build
+  script: build
+  parallel:
+    matrix: *matrix
+
+test:
+  stage: test
+  script: test
+  parallel: 3
+
+deploy-aws:
+  stage: deploy
+  script: deploy
+  needs:
+    - job: build
+      parallel:
+        matrix:
+          - PROVIDER: aws
+            STACK: monitoring
+
+report:
+  stage: deploy
+  script: report
+  needs: [test]
+  dependencies: [test]
+`
+
+func loadParallelConfig(t *testing.T) DataBag {
+	config, err := loadProjectConfig(t, map[string]string{".gitlab-ci.yml": exampleParallelYAML})
+	require.NoError(t, err)
+
+	return config
+}
+
+func TestGetPipelineJobsExpandsParallel(t *testing.T) {
+	config := loadParallelConfig(t)
+
+	jobs, err := config.GetPipelineJobs(nil)
+	require.NoError(t, err)
+
+	var names []string
+	for _, job := range jobs {
+		names = append(names, job.Name)
+	}
+
+	assert.Equal(t, []string{
+		"build: [monitoring, aws]",
+		"build: [app, aws]",
+		"build: [gcp, data]",
+		"test 1/3",
+		"test 2/3",
+		"test 3/3",
+		"deploy-aws",
+		"report",
+	}, names)
+
+	selected := selectedJobs(jobs)
+	assert.Equal(t, "test", selected["test 2/3"].Definition)
+	assert.Equal(t, []string{"build: [monitoring, aws]"}, selected["deploy-aws"].Needs)
+	assert.Equal(t, []string{"test 1/3", "test 2/3", "test 3/3"}, selected["report"].Needs)
+	assert.Equal(t, []string{"test 1/3", "test 2/3", "test 3/3"}, selected["report"].Dependencies)
+	assert.Equal(t,
+		[]string{"build: [monitoring, aws]", "build: [app, aws]", "build: [gcp, data]"},
+		selected["test 1/3"].Needs,
+	)
+}
+
+func TestGetPipelineJobsRulesUseMatrixVariables(t *testing.T) {
+	config := parseDataBag(t, `
+deploy:
+  script: deploy
+  parallel:
+    matrix:
+      - PROVIDER: [aws, gcp]
+  rules:
+    - if: $PROVIDER == "aws"
+`)
+
+	jobs, err := config.GetPipelineJobs(newTestSelector("main", common.RefTypeBranch))
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, "deploy: [aws]", jobs[0].Name)
+}
+
+func TestGetJobInstancesErrors(t *testing.T) {
+	tests := map[string]struct {
+		parallel      string
+		expectedError string
+	}{
+		"too few": {
+			parallel:      "1",
+			expectedError: "job job: parallel must be between 2 and 200",
+		},
+		"too many": {
+			parallel:      "201",
+			expectedError: "job job: parallel must be between 2 and 200",
+		},
+		"invalid type": {
+			parallel:      "[1, 2]",
+			expectedError: "job job: parallel should be an integer or a hash",
+		},
+		"missing matrix": {
+			parallel:      "{other: 1}",
+			expectedError: "job job: parallel:matrix should be an array of hashes",
+		},
+		"empty variable": {
+			parallel:      "{matrix: [{A: []}]}",
+			expectedError: "job job: parallel:matrix: variable A has no values",
+		},
+		"too many matrix jobs": {
+			parallel:      "{matrix: [{A: [1,2,3,4,5,6,7,8,9,10,11,12,13,14,15], B: [1,2,3,4,5,6,7,8,9,10,11,12,13,14,15]}]}",
+			expectedError: "job job: parallel:matrix generates too many jobs (maximum is 200)",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			config := parseDataBag(t, "job:\n  script: test\n  parallel: "+tt.parallel+"\n")
+
+			_, err := config.GetPipelineJobs(nil)
+			assert.EqualError(t, err, tt.expectedError)
+		})
+	}
+}
+
+func TestParseYamlParallelInstance(t *testing.T) {
+	jobResponse := getJobResponse(t, exampleParallelYAML, "build: [app, aws]", false)
+
+	assert.Equal(t, "build: [app, aws]", jobResponse.JobInfo.Name)
+	assert.Equal(t, "build: [app, aws]", jobResponse.Variables.Get("CI_JOB_NAME"))
+	assert.Equal(t, "2", jobResponse.Variables.Get("CI_NODE_INDEX"))
+	assert.Equal(t, "3", jobResponse.Variables.Get("CI_NODE_TOTAL"))
+	assert.Equal(t, "app", jobResponse.Variables.Get("STACK"))
+	assert.Equal(t, "aws", jobResponse.Variables.Get("PROVIDER"))
+
+	jobResponse = getJobResponse(t, exampleParallelYAML, "test 3/3", false)
+	assert.Equal(t, "3", jobResponse.Variables.Get("CI_NODE_INDEX"))
+	assert.Equal(t, "3", jobResponse.Variables.Get("CI_NODE_TOTAL"))
+}
+
+func TestParseYamlParallelDefinition(t *testing.T) {
+	file := prepareTestFile(t, exampleParallelYAML)
+	defer os.Remove(file)
+
+	parser := &GitLabCiYamlParser{filename: file, jobName: "test"}
+	err := parser.ParseYaml(&common.JobResponse{})
+	assert.EqualError(t, err, `job "test" is expanded by parallel into "test 1/3", "test 2/3", "test 3/3"`)
+}
@@ -3,21 +3,26 @@ package gitlab_ci_yaml_parser
 import (
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"strconv"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
 	"gitlab.com/gitlab-org/gitlab-runner/common"
-
-	"gopkg.in/yaml.v2"
 )
 
 type GitLabCiYamlParser struct {
-	filename  string
-	jobName   string
-	config    DataBag
-	jobConfig DataBag
+	filename    string
+	jobName     string
+	config      DataBag
+	jobConfig   DataBag
+	jobInstance jobInstance
+	selector    *JobSelector
+}
+
+// SetJobSelector makes the parser add the variables set by `workflow:rules`
+// and by the job `rules:`, together with the selector variables
+func (c *GitLabCiYamlParser) SetJobSelector(selector *JobSelector) {
+	c.selector = selector
 }
 
 func (c *GitLabCiYamlParser) ParseFile() (DataBag, error) {
@@ -26,19 +31,22 @@ func (c *GitLabCiYamlParser) ParseFile() (DataBag, error) {
 }
 
 func (c *GitLabCiYamlParser) parseFile() (err error) {
-	data, err := ioutil.ReadFile(c.filename)
+	resolver, err := newIncludeResolver(c.filename)
+	if err != nil {
+		return err
+	}
+
+	config, err := resolver.load(c.filename)
 	if err != nil {
 		return err
 	}
 
-	config := make(DataBag)
-	err = yaml.Unmarshal(data, config)
-	// panic(fmt.Sprintf("config: %+v", config))
+	err = config.ResolveExtends()
 	if err != nil {
 		return err
 	}
 
-	err = config.Sanitize()
+	err = config.ApplyDefault()
 	if err != nil {
 		return err
 	}
@@ -49,12 +57,18 @@ func (c *GitLabCiYamlParser) parseFile() (err error) {
 }
 
 func (c *GitLabCiYamlParser) loadJob() (err error) {
-	jobConfig, ok := c.config.GetSubOptions(c.jobName)
-	if !ok {
+	instance, ok, err := c.config.findJobInstance(c.jobName)
+	if err != nil {
+		return err
+	}
+
+	jobConfig, found := c.config.GetSubOptions(instance.Definition)
+	if !ok || !found {
 		return fmt.Errorf("no job named %q", c.jobName)
 	}
 
 	c.jobConfig = jobConfig
+	c.jobInstance = instance
 
 	return
 }
@@ -159,9 +173,7 @@ func (c *GitLabCiYamlParser) prepareSteps(job *common.JobResponse) error {
 }
 
 func (c *GitLabCiYamlParser) buildDefaultVariables(job *common.JobResponse) common.JobVariables {
-	return common.JobVariables{
-		{Key: "CI", Value: "true", Public: true, Internal: true, File: false},
-		{Key: "GITLAB_CI", Value: "true", Public: true, Internal: true, File: false},
+	variables := common.JobVariables{
 		{Key: "CI_SERVER_NAME", Value: "GitLab CI", Public: true, Internal: true, File: false},
 		{Key: "CI_SERVER_VERSION", Value: "", Public: true, Internal: true, File: false},
 		{Key: "CI_SERVER_REVISION", Value: "", Public: true, Internal: true, File: false},
@@ -171,39 +183,42 @@ func (c *GitLabCiYamlParser) buildDefaultVariables(job *common.JobResponse) comm
 		{Key: "CI_JOB_STAGE", Value: job.JobInfo.Stage, Public: true, Internal: true, File: false},
 		{Key: "CI_JOB_TOKEN", Value: job.Token, Public: true, Internal: true, File: false},
 		{Key: "CI_REPOSITORY_URL", Value: job.GitInfo.RepoURL, Public: true, Internal: true, File: false},
-		{Key: "CI_COMMIT_SHA", Value: job.GitInfo.Sha, Public: true, Internal: true, File: false},
-		{Key: "CI_COMMIT_BEFORE_SHA", Value: job.GitInfo.BeforeSha, Public: true, Internal: true, File: false},
-		{Key: "CI_COMMIT_REF_NAME", Value: job.GitInfo.Ref, Public: true, Internal: true, File: false},
 	}
+
+	return append(variables, pipelineVariables(job.GitInfo)...)
 }
 
-func (c *GitLabCiYamlParser) buildVariables(
-	configVariables interface{},
-) (buildVariables common.JobVariables, err error) {
-	if variables, ok := configVariables.(map[string]interface{}); ok {
-		for key, value := range variables {
-			if valueText, ok := value.(string); ok {
-				buildVariables = append(buildVariables, common.JobVariable{
-					Key:    key,
-					Value:  valueText,
-					Public: true,
-				})
-			} else {
-				err = fmt.Errorf("invalid value for variable %q", key)
-			}
+func (c *GitLabCiYamlParser) buildVariables(configVariables interface{}) (common.JobVariables, error) {
+	if configVariables == nil {
+		return nil, nil
+	}
+
+	definitions, ok := configVariables.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("unsupported variables")
+	}
+
+	values := make(map[string]string, len(definitions))
+	for key, definition := range definitions {
+		value, ok := variableValue(definition)
+		if !ok {
+			return nil, fmt.Errorf("invalid value for variable %q", key)
 		}
-	} else if configVariables != nil {
-		err = errors.New("unsupported variables")
+		values[key] = value
 	}
 
-	return
+	return sortedVariables(values), nil
 }
 
+// prepareVariables adds the job variables following the precedence used by
+// GitLab: predefined variables, global variables, `workflow:rules`
+// variables, job variables, `parallel:matrix` variables, `rules:` variables
+// and finally the variables of the project settings.
 func (c *GitLabCiYamlParser) prepareVariables(job *common.JobResponse) error {
-	job.Variables = common.JobVariables{}
-
-	defaultVariables := c.buildDefaultVariables(job)
-	job.Variables = append(job.Variables, defaultVariables...)
+	job.Variables = c.buildDefaultVariables(job)
+	if c.selector != nil {
+		job.Variables = append(job.Variables, c.selector.predefinedVariables(job.Variables)...)
+	}
 
 	globalVariables, err := c.buildVariables(c.config["variables"])
 	if err != nil {
@@ -212,12 +227,42 @@ func (c *GitLabCiYamlParser) prepareVariables(job *common.JobResponse) error {
 
 	job.Variables = append(job.Variables, globalVariables...)
 
+	var workflowVariables map[string]string
+	if c.selector != nil {
+		// The job is run on its own even if the pipeline is filtered out
+		workflowVariables, err = c.selector.evaluateWorkflow(&c.config)
+		if err != nil && !errors.Is(err, ErrPipelineFilteredOut) {
+			return err
+		}
+
+		job.Variables = append(job.Variables, sortedVariables(workflowVariables)...)
+	}
+
 	jobVariables, err := c.buildVariables(c.jobConfig["variables"])
 	if err != nil {
 		return err
 	}
 
 	job.Variables = append(job.Variables, jobVariables...)
+	job.Variables = append(job.Variables, c.jobInstance.Variables...)
+
+	if c.selector == nil {
+		return nil
+	}
+
+	decision, err := c.selector.evaluate(
+		&c.config,
+		c.jobName,
+		c.jobConfig,
+		workflowVariables,
+		variablesMap(c.jobInstance.Variables),
+	)
+	if err != nil {
+		return err
+	}
+
+	job.Variables = append(job.Variables, sortedVariables(decision.variables)...)
+	job.Variables = append(job.Variables, c.selector.ProjectVariables...)
 
 	return nil
 }
@@ -328,9 +373,37 @@ func (c *GitLabCiYamlParser) prepareArtifacts(job *common.JobResponse) error {
 }
 
 func (c *GitLabCiYamlParser) prepareCache(job *common.JobResponse) error {
-	var ok bool
+	cacheConfig, ok := c.jobConfig["cache"]
+	if !ok {
+		cacheConfig, ok = c.config["cache"]
+	}
+
+	// A job can define several independent caches
+	var cacheMaps []DataBag
+	if cacheList, isList := cacheConfig.([]interface{}); ok && isList {
+		for _, cacheValue := range cacheList {
+			converted, err := convertMapToStringMap(cacheValue)
+			if err != nil {
+				return err
+			}
+
+			cacheMap, _ := converted.(map[string]interface{})
+			cacheMaps = append(cacheMaps, cacheMap)
+		}
+	} else {
+		cacheMaps = append(cacheMaps, getOptionsMap("cache", c.jobConfig, c.config))
+	}
+
+	job.Cache = make(common.Caches, 0, len(cacheMaps))
+	for _, cacheMap := range cacheMaps {
+		job.Cache = append(job.Cache, prepareCacheOptions(cacheMap))
+	}
+
+	return nil
+}
 
-	cacheMap := getOptionsMap("cache", c.jobConfig, c.config)
+func prepareCacheOptions(cacheMap DataBag) common.Cache {
+	var ok bool
 
 	cachePaths, _ := cacheMap.GetSlice("paths")
 	paths := common.ArtifactPaths{}
@@ -338,24 +411,36 @@ func (c *GitLabCiYamlParser) prepareCache(job *common.JobResponse) error {
 		paths = append(paths, path.(string))
 	}
 
-	var cacheKey string
-	if cacheKey, ok = cacheMap.GetString("key"); !ok {
+	// The key of cache:key:files is computed when the cache is used, from
+	// the checked out files
+	var cacheKey, keyPrefix string
+	var keyFiles []string
+	if keyMap, isMap := cacheMap.GetSubOptions("key"); isMap {
+		keyFiles, _ = keyMap.GetStringSlice("files")
+		keyPrefix, _ = keyMap.GetString("prefix")
+	} else if cacheKey, ok = cacheMap.GetString("key"); !ok {
 		cacheKey = ""
 	}
 
+	cacheFallbackKeys, _ := cacheMap.GetSlice("fallback_keys")
+	var fallbackKeys []string
+	for _, key := range cacheFallbackKeys {
+		fallbackKeys = append(fallbackKeys, key.(string))
+	}
+
 	var cacheUntracked interface{}
 	if cacheUntracked, ok = cacheMap.Get("untracked"); !ok {
 		cacheUntracked = false
 	}
 
-	job.Cache = make(common.Caches, 1)
-	job.Cache[0] = common.Cache{
-		Key:       cacheKey,
-		Untracked: cacheUntracked.(bool),
-		Paths:     paths,
+	return common.Cache{
+		Key:          cacheKey,
+		KeyFiles:     keyFiles,
+		KeyPrefix:    keyPrefix,
+		FallbackKeys: fallbackKeys,
+		Untracked:    cacheUntracked.(bool),
+		Paths:        paths,
 	}
-
-	return nil
 }
 
 func (c *GitLabCiYamlParser) ParseYaml(job *common.JobResponse) (err error) {
@@ -166,3 +166,101 @@ func TestFileParsing(t *testing.T) {
 	assert.Empty(t, jobResponse.Services[1].Command)
 	assert.Empty(t, jobResponse.Services[1].Entrypoint)
 }
+
+var testFileExtends = `
+default:
+  image: default:image
+  before_script: [default before]
+
+.template:
+  script: [template]
+  image: template:image
+  variables:
+    TEMPLATE: "true"
+
+job1:
+  extends: .template
+  variables:
+    JOB: "true"
+
+job2:
+  script: job2
+`
+
+func TestFileParsingExtendsAndDefault(t *testing.T) {
+	jobResponse := getJobResponse(t, testFileExtends, "job1", false)
+	require.Len(t, jobResponse.Steps, 2)
+	assert.Equal(t, common.StepScript{"default before", "template"}, jobResponse.Steps[0].Script)
+	assert.Equal(t, "template:image", jobResponse.Image.Name)
+	assert.Equal(t, "true", jobResponse.Variables.Get("TEMPLATE"))
+	assert.Equal(t, "true", jobResponse.Variables.Get("JOB"))
+
+	jobResponse = getJobResponse(t, testFileExtends, "job2", false)
+	assert.Equal(t, common.StepScript{"default before", "job2"}, jobResponse.Steps[0].Script)
+	assert.Equal(t, "default:image", jobResponse.Image.Name)
+}
+
+var testFileCache = `
+cache:
+  key: global
+  paths: [global]
+
+job1:
+  script: job1
+
+job2:
+  script: job2
+  cache:
+    key:
+      files: [Gemfile.lock, yarn.lock]
+      prefix: deps
+    paths: [vendor]
+
+job3:
+  script: job3
+  cache:
+  - key:
+      files: [Gemfile.lock]
+    paths: [vendor/ruby]
+  - key: node
+    paths: [node_modules]
+    untracked: true
+`
+
+func TestFileParsingCache(t *testing.T) {
+	tests := map[string]struct {
+		jobName        string
+		expectedCaches common.Caches
+	}{
+		"global cache": {
+			jobName: "job1",
+			expectedCaches: common.Caches{
+				{Key: "global", Paths: common.ArtifactPaths{"global"}},
+			},
+		},
+		"key computed from files": {
+			jobName: "job2",
+			expectedCaches: common.Caches{
+				{
+					KeyFiles:  []string{"Gemfile.lock", "yarn.lock"},
+					KeyPrefix: "deps",
+					Paths:     common.ArtifactPaths{"vendor"},
+				},
+			},
+		},
+		"multiple caches": {
+			jobName: "job3",
+			expectedCaches: common.Caches{
+				{KeyFiles: []string{"Gemfile.lock"}, Paths: common.ArtifactPaths{"vendor/ruby"}},
+				{Key: "node", Paths: common.ArtifactPaths{"node_modules"}, Untracked: true},
+			},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			jobResponse := getJobResponse(t, testFileCache, tt.jobName, false)
+			assert.Equal(t, tt.expectedCaches, jobResponse.Cache)
+		})
+	}
+}
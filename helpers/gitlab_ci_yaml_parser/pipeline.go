@@ -0,0 +1,527 @@
+package gitlab_ci_yaml_parser
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	stagePre  = ".pre"
+	stagePost = ".post"
+
+	defaultStage = "test"
+)
+
+// defaultStages are used when `.gitlab-ci.yml` doesn't define `stages:`
+var defaultStages = []string{"build", "test", "deploy"}
+
+type JobWhen string
+
+const (
+	JobWhenOnSuccess JobWhen = "on_success"
+	JobWhenOnFailure JobWhen = "on_failure"
+	JobWhenAlways    JobWhen = "always"
+	JobWhenManual    JobWhen = "manual"
+	JobWhenDelayed   JobWhen = "delayed"
+	JobWhenNever     JobWhen = "never"
+)
+
+// PipelineJob describes a job of the local pipeline and its position in the
+// job graph
+type PipelineJob struct {
+	Name  string
+	Stage string
+	When  JobWhen
+
+	// Definition is the name of the job in .gitlab-ci.yml. It differs from
+	// Name for the jobs created by `parallel:`.
+	Definition string
+
+	// Needs lists the jobs that must finish before this job can be started.
+	// It's built either from the `needs:` keyword or, when the job doesn't
+	// use it, from all the jobs of the previous stages.
+	Needs []string
+
+	// Dependencies lists the jobs whose artifacts are passed to this job.
+	// It's built from `dependencies:`, from `needs:` entries that don't
+	// disable `artifacts:` or, when neither is used, from all the jobs of the
+	// previous stages.
+	Dependencies []string
+
+	// AllowFailure is true when the job failure shouldn't affect the jobs
+	// that depend on it
+	AllowFailure bool
+	// AllowFailureExitCodes limits AllowFailure to the listed exit codes
+	AllowFailureExitCodes []int
+
+	// Variables are set by the matching `rules:` entry and take precedence
+	// over the variables defined by the job
+	Variables map[string]string
+}
+
+// IsFailureAllowed reports whether a failure with the given exit code
+// should be treated as a warning only
+func (j *PipelineJob) IsFailureAllowed(exitCode int) bool {
+	if len(j.AllowFailureExitCodes) == 0 {
+		return j.AllowFailure
+	}
+
+	for _, code := range j.AllowFailureExitCodes {
+		if code == exitCode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetStages returns the ordered list of stages, including the implicit
+// `.pre` and `.post` ones
+func (m *DataBag) GetStages() ([]string, error) {
+	stages := defaultStages
+
+	value, ok := m.Get("stages")
+	if !ok {
+		value, ok = m.Get("types")
+	}
+
+	if ok {
+		rawStages, isSlice := value.([]interface{})
+		if !isSlice {
+			return nil, fmt.Errorf("stages should be an array of strings")
+		}
+
+		stages = nil
+		for _, rawStage := range rawStages {
+			stage, isString := rawStage.(string)
+			if !isString {
+				return nil, fmt.Errorf("stages should be an array of strings")
+			}
+
+			if stage != stagePre && stage != stagePost {
+				stages = append(stages, stage)
+			}
+		}
+	}
+
+	result := []string{stagePre}
+	result = append(result, stages...)
+	result = append(result, stagePost)
+
+	return result, nil
+}
+
+// GetPipelineJobs returns all jobs of the pipeline ordered by stage, with
+// their dependencies computed from `needs:` and the stage ordering. When
+// a selector is given, the jobs excluded by `rules:` or `only:`/`except:` are
+// left out of the pipeline and ErrPipelineFilteredOut is returned when
+// `workflow:rules` prevent the pipeline from being created.
+func (m *DataBag) GetPipelineJobs(selector *JobSelector) ([]PipelineJob, error) {
+	stages, err := m.GetStages()
+	if err != nil {
+		return nil, err
+	}
+
+	var workflowVariables map[string]string
+	if selector != nil {
+		workflowVariables, err = selector.evaluateWorkflow(m)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stageIndex := make(map[string]int, len(stages))
+	for i, stage := range stages {
+		stageIndex[stage] = i
+	}
+
+	var jobs []PipelineJob
+	instances := make(map[string][]jobInstance)
+	for _, name := range m.jobLikeKeys() {
+		if IsHiddenJob(name) {
+			continue
+		}
+
+		definitionInstances, err := m.getJobInstances(name)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, instance := range definitionInstances {
+			job, err := m.newPipelineJob(instance)
+			if err != nil {
+				return nil, err
+			}
+
+			if selector != nil {
+				job, err = m.selectPipelineJob(selector, job, instance, workflowVariables)
+				if err != nil {
+					return nil, err
+				}
+
+				if job.When == JobWhenNever {
+					continue
+				}
+			}
+
+			if _, ok := stageIndex[job.Stage]; !ok {
+				return nil, fmt.Errorf("%s job: chosen stage %s does not exist; available stages are %s",
+					name, job.Stage, strings.Join(stages, ", "))
+			}
+
+			jobs = append(jobs, job)
+			instances[name] = append(instances[name], instance)
+		}
+	}
+
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return stageIndex[jobs[i].Stage] < stageIndex[jobs[j].Stage]
+	})
+
+	err = resolveNeeds(m, jobs, instances, stageIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+func (m *DataBag) newPipelineJob(instance jobInstance) (PipelineJob, error) {
+	name := instance.Definition
+	config, _ := m.GetSubOptions(name)
+
+	job := PipelineJob{
+		Name:       instance.Name,
+		Stage:      defaultStage,
+		When:       JobWhenOnSuccess,
+		Definition: name,
+	}
+
+	if stage, ok := config.GetString("stage"); ok {
+		job.Stage = stage
+	}
+
+	if when, ok := config.GetString("when"); ok {
+		job.When = JobWhen(when)
+	}
+
+	switch allowFailure := config["allow_failure"].(type) {
+	case nil:
+		// Manual jobs don't block the pipeline by default, unless they're
+		// made manual by `rules:`
+		_, hasRules := config["rules"]
+		job.AllowFailure = job.When == JobWhenManual && !hasRules
+	case bool:
+		job.AllowFailure = allowFailure
+	case map[string]interface{}:
+		job.AllowFailure = true
+		codes, err := getExitCodes(allowFailure["exit_codes"])
+		if err != nil {
+			return job, fmt.Errorf("%s job: allow_failure: %w", name, err)
+		}
+		job.AllowFailureExitCodes = codes
+	default:
+		return job, fmt.Errorf("%s job: allow_failure should be a boolean or a hash", name)
+	}
+
+	return job, nil
+}
+
+// selectPipelineJob applies the result of the job selection to the job
+func (m *DataBag) selectPipelineJob(
+	selector *JobSelector,
+	job PipelineJob,
+	instance jobInstance,
+	workflowVariables map[string]string,
+) (PipelineJob, error) {
+	config, _ := m.GetSubOptions(job.Definition)
+
+	decision, err := selector.evaluate(m, job.Name, config, workflowVariables, variablesMap(instance.Variables))
+	if err != nil {
+		return job, err
+	}
+
+	job.When = decision.when
+	if job.When == JobWhenManual &&
+		(contains(selector.SelectedJobs, job.Name) || contains(selector.SelectedJobs, job.Definition)) {
+		job.When = JobWhenOnSuccess
+	}
+
+	if job.When == JobWhenDelayed {
+		// There's no point in waiting when running locally
+		job.When = JobWhenOnSuccess
+	}
+
+	if decision.allowFailure != nil {
+		job.AllowFailure = *decision.allowFailure
+		job.AllowFailureExitCodes = nil
+	}
+
+	job.Variables = decision.variables
+
+	return job, nil
+}
+
+func getExitCodes(value interface{}) ([]int, error) {
+	switch codes := value.(type) {
+	case int:
+		return []int{codes}, nil
+	case []interface{}:
+		var result []int
+		for _, code := range codes {
+			exitCode, ok := code.(int)
+			if !ok {
+				return nil, fmt.Errorf("exit_codes should be an integer or an array of integers")
+			}
+			result = append(result, exitCode)
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("exit_codes should be an integer or an array of integers")
+	}
+}
+
+// JobNeed is a single entry of the `needs:` keyword
+type JobNeed struct {
+	Job       string
+	Artifacts bool
+	Optional  bool
+
+	// Matrix limits the need to the `parallel:matrix` instances of the job
+	// with the listed variable values
+	Matrix []map[string]string
+}
+
+// GetNeeds returns the parsed `needs:` keyword of the job. The second value
+// is false when the job doesn't use `needs:` at all, which is different from
+// an empty list that allows the job to start immediately.
+func (m *DataBag) GetNeeds(jobName string) ([]JobNeed, bool, error) {
+	value, ok := m.Get(jobName, "needs")
+	if !ok {
+		return nil, false, nil
+	}
+
+	rawNeeds, ok := value.([]interface{})
+	if !ok {
+		return nil, true, fmt.Errorf("%s job: needs should be an array", jobName)
+	}
+
+	needs := make([]JobNeed, 0, len(rawNeeds))
+	for _, rawNeed := range rawNeeds {
+		rawNeed, err := convertMapToStringMap(rawNeed)
+		if err != nil {
+			return nil, true, err
+		}
+
+		switch need := rawNeed.(type) {
+		case string:
+			needs = append(needs, JobNeed{Job: need, Artifacts: true})
+		case map[string]interface{}:
+			needJob, ok := need["job"].(string)
+			if !ok {
+				return nil, true, fmt.Errorf("%s job: needs entry is missing the job name", jobName)
+			}
+
+			jobNeed := JobNeed{Job: needJob, Artifacts: true}
+			if artifacts, ok := need["artifacts"].(bool); ok {
+				jobNeed.Artifacts = artifacts
+			}
+			if optional, ok := need["optional"].(bool); ok {
+				jobNeed.Optional = optional
+			}
+			needConfig := DataBag(need)
+			if matrix, ok := needConfig.GetSlice("parallel", "matrix"); ok {
+				combinations, err := getMatrixCombinations(matrix)
+				if err != nil {
+					return nil, true, fmt.Errorf("%s job: needs:parallel:matrix: %w", jobName, err)
+				}
+				for _, combination := range combinations {
+					jobNeed.Matrix = append(jobNeed.Matrix, variablesMap(combination))
+				}
+			}
+			needs = append(needs, jobNeed)
+		default:
+			return nil, true, fmt.Errorf("%s job: needs entry should be a string or a hash", jobName)
+		}
+	}
+
+	return needs, true, nil
+}
+
+func resolveNeeds(m *DataBag, jobs []PipelineJob, instances map[string][]jobInstance, stageIndex map[string]int) error {
+	jobStage := make(map[string]int, len(jobs))
+	for _, job := range jobs {
+		jobStage[job.Name] = stageIndex[job.Stage]
+	}
+
+	for i := range jobs {
+		job := &jobs[i]
+
+		needs, defined, err := m.GetNeeds(job.Definition)
+		if err != nil {
+			return err
+		}
+
+		if !defined {
+			for _, other := range jobs {
+				if jobStage[other.Name] < jobStage[job.Name] {
+					job.Needs = append(job.Needs, other.Name)
+				}
+			}
+			job.Dependencies = job.Needs
+		} else {
+			job.Needs = []string{}
+			job.Dependencies = []string{}
+			for _, need := range needs {
+				names := needInstances(need, instances, jobStage)
+				if len(names) == 0 {
+					if need.Optional {
+						continue
+					}
+					return fmt.Errorf("%s job: undefined need: %s", job.Name, need.Job)
+				}
+
+				for _, name := range names {
+					if jobStage[name] > jobStage[job.Name] {
+						return fmt.Errorf("%s job: need %s is not defined in current or prior stages", job.Name, need.Job)
+					}
+
+					job.Needs = append(job.Needs, name)
+					if need.Artifacts {
+						job.Dependencies = append(job.Dependencies, name)
+					}
+				}
+			}
+		}
+
+		err = resolveDependencies(m, job, defined, instances, jobStage)
+		if err != nil {
+			return err
+		}
+	}
+
+	return checkNeedsCycles(jobs)
+}
+
+// needInstances returns the names of the jobs matching the need. A need can
+// refer to a single job, or to all or some of the jobs created by `parallel:`.
+func needInstances(need JobNeed, instances map[string][]jobInstance, jobStage map[string]int) []string {
+	definitionInstances, ok := instances[need.Job]
+	if !ok {
+		if _, ok := jobStage[need.Job]; ok {
+			return []string{need.Job}
+		}
+		return nil
+	}
+
+	var names []string
+	for _, instance := range definitionInstances {
+		if need.Matrix == nil || matchesAnyMatrix(instance.matrix, need.Matrix) {
+			names = append(names, instance.Name)
+		}
+	}
+
+	return names
+}
+
+func matchesAnyMatrix(variables map[string]string, matrix []map[string]string) bool {
+	for _, combination := range matrix {
+		if reflect.DeepEqual(variables, combination) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolveDependencies overrides the dependencies computed from `needs:` and
+// stages when the job limits them with the `dependencies:` keyword
+func resolveDependencies(
+	m *DataBag,
+	job *PipelineJob,
+	needsDefined bool,
+	instances map[string][]jobInstance,
+	jobStage map[string]int,
+) error {
+	value, ok := m.Get(job.Definition, "dependencies")
+	if !ok || value == nil {
+		return nil
+	}
+
+	rawDependencies, ok := value.([]interface{})
+	if !ok {
+		return fmt.Errorf("%s job: dependencies should be an array of strings", job.Name)
+	}
+
+	dependencies := make([]string, 0, len(rawDependencies))
+	for _, rawDependency := range rawDependencies {
+		dependency, ok := rawDependency.(string)
+		if !ok {
+			return fmt.Errorf("%s job: dependencies should be an array of strings", job.Name)
+		}
+
+		names := needInstances(JobNeed{Job: dependency}, instances, jobStage)
+		if len(names) == 0 {
+			return fmt.Errorf("%s job: undefined dependency: %s", job.Name, dependency)
+		}
+
+		for _, name := range names {
+			if needsDefined && !contains(job.Needs, name) {
+				return fmt.Errorf("%s job: dependency %s should be part of needs", job.Name, dependency)
+			}
+
+			if jobStage[name] >= jobStage[job.Name] && !contains(job.Needs, name) {
+				return fmt.Errorf("%s job: undefined dependency: %s", job.Name, dependency)
+			}
+
+			dependencies = append(dependencies, name)
+		}
+	}
+
+	job.Dependencies = dependencies
+
+	return nil
+}
+
+func checkNeedsCycles(jobs []PipelineJob) error {
+	needs := make(map[string][]string, len(jobs))
+	for _, job := range jobs {
+		needs[job.Name] = job.Needs
+	}
+
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[string]int, len(jobs))
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("circular dependency detected in `needs`: %s", strings.Join(append(path, name), " -> "))
+		case visited:
+			return nil
+		}
+
+		state[name] = visiting
+		for _, need := range needs[name] {
+			if err := visit(need, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+
+		return nil
+	}
+
+	for _, job := range jobs {
+		if err := visit(job.Name, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,593 @@
+package gitlab_ci_yaml_parser
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bmatcuk/doublestar"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+const pipelineSourcePush = "push"
+
+// ErrPipelineFilteredOut is returned when `workflow:rules` prevent the
+// pipeline from being created
+var ErrPipelineFilteredOut = errors.New("pipeline filtered out by workflow rules")
+
+// defaultOnlyRefs is the implicit value of `only:refs` for jobs that use
+// neither `only:` nor `rules:`
+var defaultOnlyRefs = []string{"branches", "tags"}
+
+// refsKeywords are the special `only/except:refs` values that match the
+// pipeline source instead of the ref name
+var refsKeywords = []string{
+	"api",
+	"chat",
+	"external",
+	"external_pull_requests",
+	"merge_requests",
+	"pipelines",
+	"pushes",
+	"schedules",
+	"triggers",
+	"web",
+}
+
+// JobSelector decides which jobs are part of the local pipeline by evaluating
+// their `rules:`, `only:`/`except:` and `when:` keywords
+type JobSelector struct {
+	// Variables are the predefined variables of the pipeline
+	Variables map[string]string
+
+	Ref    string
+	Tag    bool
+	Source string
+
+	// ChangedFiles lists the files changed by the pipeline commits. When it's
+	// nil, every `changes:` clause matches, which is also what GitLab does
+	// when it can't compute the changes (e.g. for new branches).
+	ChangedFiles []string
+
+	// ProjectDir is used to evaluate `rules:exists`
+	ProjectDir string
+
+	// SelectedJobs are run even if they're manual. The name of a job using
+	// `parallel:` selects all of its instances.
+	SelectedJobs []string
+
+	// ProjectVariables stand in for the CI/CD variables defined in the
+	// project settings, which take precedence over all the variables
+	// defined in .gitlab-ci.yml
+	ProjectVariables common.JobVariables
+}
+
+// NewJobSelector creates a selector for a pipeline of the given commit
+func NewJobSelector(gitInfo common.GitInfo, projectDir string) *JobSelector {
+	selector := &JobSelector{
+		Variables:  make(map[string]string),
+		Ref:        gitInfo.Ref,
+		Tag:        gitInfo.RefType == common.RefTypeTag,
+		Source:     pipelineSourcePush,
+		ProjectDir: projectDir,
+	}
+
+	for _, variable := range pipelineVariables(gitInfo) {
+		selector.Variables[variable.Key] = variable.Value
+	}
+
+	return selector
+}
+
+// pipelineVariables returns the predefined variables describing the pipeline,
+// which are available both to the jobs and to the job selection
+func pipelineVariables(gitInfo common.GitInfo) common.JobVariables {
+	variables := common.JobVariables{
+		{Key: "CI", Value: "true", Public: true, Internal: true, File: false},
+		{Key: "GITLAB_CI", Value: "true", Public: true, Internal: true, File: false},
+		{Key: "CI_PIPELINE_SOURCE", Value: pipelineSourcePush, Public: true, Internal: true, File: false},
+		{Key: "CI_COMMIT_SHA", Value: gitInfo.Sha, Public: true, Internal: true, File: false},
+		{Key: "CI_COMMIT_BEFORE_SHA", Value: gitInfo.BeforeSha, Public: true, Internal: true, File: false},
+		{Key: "CI_COMMIT_REF_NAME", Value: gitInfo.Ref, Public: true, Internal: true, File: false},
+	}
+
+	if gitInfo.RefType == common.RefTypeTag {
+		variables = append(variables,
+			common.JobVariable{Key: "CI_COMMIT_TAG", Value: gitInfo.Ref, Public: true, Internal: true, File: false})
+	} else {
+		variables = append(variables,
+			common.JobVariable{Key: "CI_COMMIT_BRANCH", Value: gitInfo.Ref, Public: true, Internal: true, File: false})
+	}
+
+	return variables
+}
+
+// jobDecision is the result of the job selection
+type jobDecision struct {
+	when         JobWhen
+	allowFailure *bool
+	variables    map[string]string
+}
+
+// evaluate returns how the job should be run, with JobWhenNever meaning that
+// the job isn't part of the pipeline at all
+func (s *JobSelector) evaluate(
+	m *DataBag,
+	name string,
+	job DataBag,
+	workflowVariables map[string]string,
+	instanceVariables map[string]string,
+) (jobDecision, error) {
+	when := JobWhenOnSuccess
+	if jobWhen, ok := job.GetString("when"); ok {
+		when = JobWhen(jobWhen)
+	}
+
+	variables := s.jobVariables(m, job, workflowVariables, instanceVariables)
+
+	decision := jobDecision{when: when}
+	if rawRules, ok := job["rules"]; ok {
+		_, hasOnly := job["only"]
+		_, hasExcept := job["except"]
+		if hasOnly || hasExcept {
+			return decision, fmt.Errorf("%s job: may not use rules in combination with only/except", name)
+		}
+
+		var err error
+		decision, err = s.evaluateRules(name, rawRules, variables)
+		if err != nil {
+			return decision, err
+		}
+	} else {
+		included, err := s.evaluateOnlyExcept(name, job, variables)
+		if err != nil {
+			return decision, err
+		}
+
+		if !included {
+			decision.when = JobWhenNever
+		}
+	}
+
+	return decision, nil
+}
+
+// evaluateWorkflow returns the variables set by the matching `workflow:rules`
+// entry, or ErrPipelineFilteredOut when the pipeline shouldn't be created
+func (s *JobSelector) evaluateWorkflow(m *DataBag) (map[string]string, error) {
+	workflow, ok := m.GetSubOptions("workflow")
+	if !ok {
+		return nil, nil
+	}
+
+	rawRules, ok := workflow["rules"]
+	if !ok {
+		return nil, nil
+	}
+
+	variables := s.jobVariables(m, nil, nil, nil)
+
+	decision, err := s.evaluateRules("workflow", rawRules, variables)
+	if err != nil {
+		return nil, err
+	}
+
+	switch decision.when {
+	case JobWhenNever:
+		return nil, ErrPipelineFilteredOut
+	case JobWhenOnSuccess, JobWhenAlways:
+		return decision.variables, nil
+	}
+
+	return nil, fmt.Errorf("workflow: rules:when should be always or never")
+}
+
+// jobVariables returns the variables available to the job selection,
+// following the precedence used by GitLab
+func (s *JobSelector) jobVariables(
+	m *DataBag,
+	job DataBag,
+	workflowVariables map[string]string,
+	instanceVariables map[string]string,
+) map[string]string {
+	variables := make(map[string]string, len(s.Variables))
+	for key, value := range s.Variables {
+		variables[key] = value
+	}
+
+	mergeVariableDefinitions(variables, (*m)["variables"])
+	for key, value := range workflowVariables {
+		variables[key] = value
+	}
+
+	mergeVariableDefinitions(variables, job["variables"])
+	for key, value := range instanceVariables {
+		variables[key] = value
+	}
+
+	for _, variable := range s.ProjectVariables {
+		variables[variable.Key] = variable.Value
+	}
+
+	return variables
+}
+
+// predefinedVariables returns the selector variables that aren't part of
+// the given predefined variables, like CI_DEFAULT_BRANCH
+func (s *JobSelector) predefinedVariables(predefined common.JobVariables) common.JobVariables {
+	known := make(map[string]bool, len(predefined))
+	for _, variable := range predefined {
+		known[variable.Key] = true
+	}
+
+	extra := make(map[string]string)
+	for key, value := range s.Variables {
+		if !known[key] {
+			extra[key] = value
+		}
+	}
+
+	variables := sortedVariables(extra)
+	for i := range variables {
+		variables[i].Internal = true
+	}
+
+	return variables
+}
+
+func mergeVariableDefinitions(variables map[string]string, source interface{}) {
+	definitions, ok := source.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	for key, definition := range definitions {
+		if value, ok := variableValue(definition); ok {
+			variables[key] = value
+		}
+	}
+}
+
+// sortedVariables converts the variables to job variables, sorted by name
+// to keep the order stable
+func sortedVariables(values map[string]string) common.JobVariables {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	variables := make(common.JobVariables, 0, len(keys))
+	for _, key := range keys {
+		variables = append(variables, common.JobVariable{Key: key, Value: values[key], Public: true})
+	}
+
+	return variables
+}
+
+// variableValue returns the value of a `variables:` entry, which can be
+// either the value itself or a hash with `value:` and `description:`
+func variableValue(definition interface{}) (string, bool) {
+	switch value := definition.(type) {
+	case string:
+		return value, true
+	case int, int64, float64, bool:
+		return fmt.Sprint(value), true
+	case map[string]interface{}:
+		return variableValue(value["value"])
+	case map[interface{}]interface{}:
+		return variableValue(value["value"])
+	}
+
+	return "", false
+}
+
+func (s *JobSelector) evaluateRules(name string, rawRules interface{}, variables map[string]string) (jobDecision, error) {
+	rules, ok := rawRules.([]interface{})
+	if !ok {
+		return jobDecision{}, fmt.Errorf("%s job: rules should be an array of hashes", name)
+	}
+
+	for _, rawRule := range rules {
+		rawRule, err := convertMapToStringMap(rawRule)
+		if err != nil {
+			return jobDecision{}, err
+		}
+
+		rule, ok := rawRule.(map[string]interface{})
+		if !ok {
+			return jobDecision{}, fmt.Errorf("%s job: rules should be an array of hashes", name)
+		}
+
+		matches, err := s.ruleMatches(name, DataBag(rule), variables)
+		if err != nil {
+			return jobDecision{}, err
+		}
+
+		if !matches {
+			continue
+		}
+
+		return ruleDecision(name, DataBag(rule))
+	}
+
+	return jobDecision{when: JobWhenNever}, nil
+}
+
+func (s *JobSelector) ruleMatches(name string, rule DataBag, variables map[string]string) (bool, error) {
+	if expression, ok := rule.GetString("if"); ok {
+		matches, err := EvaluateExpression(expression, variables)
+		if err != nil || !matches {
+			return false, err
+		}
+	}
+
+	if rawChanges, ok := rule["changes"]; ok {
+		patterns, err := getPatterns(name, "rules:changes", rawChanges)
+		if err != nil || !s.changesMatch(patterns) {
+			return false, err
+		}
+	}
+
+	if rawExists, ok := rule["exists"]; ok {
+		patterns, err := getPatterns(name, "rules:exists", rawExists)
+		if err != nil {
+			return false, err
+		}
+
+		exists, err := s.filesExist(patterns)
+		if err != nil || !exists {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func ruleDecision(name string, rule DataBag) (jobDecision, error) {
+	decision := jobDecision{when: JobWhenOnSuccess}
+
+	if when, ok := rule.GetString("when"); ok {
+		decision.when = JobWhen(when)
+	}
+
+	if rawAllowFailure, ok := rule["allow_failure"]; ok {
+		allowFailure, ok := rawAllowFailure.(bool)
+		if !ok {
+			return decision, fmt.Errorf("%s job: rules:allow_failure should be a boolean", name)
+		}
+		decision.allowFailure = &allowFailure
+	}
+
+	if variables, ok := rule.GetSubOptions("variables"); ok {
+		decision.variables = make(map[string]string, len(variables))
+		for key, definition := range variables {
+			if value, ok := variableValue(definition); ok {
+				decision.variables[key] = value
+			}
+		}
+	}
+
+	return decision, nil
+}
+
+func (s *JobSelector) evaluateOnlyExcept(name string, job DataBag, variables map[string]string) (bool, error) {
+	only, err := getOnlyExcept(name, "only", job["only"])
+	if err != nil {
+		return false, err
+	}
+
+	if only == nil {
+		only = DataBag{"refs": toInterfaceSlice(defaultOnlyRefs)}
+	}
+
+	matches, err := s.onlyExceptMatches(name, "only", only, variables, true)
+	if err != nil || !matches {
+		return false, err
+	}
+
+	except, err := getOnlyExcept(name, "except", job["except"])
+	if err != nil || except == nil {
+		return true, err
+	}
+
+	excluded, err := s.onlyExceptMatches(name, "except", except, variables, false)
+	if err != nil {
+		return false, err
+	}
+
+	return !excluded, nil
+}
+
+// getOnlyExcept normalizes the `only:`/`except:` keyword to its hash form
+func getOnlyExcept(name string, keyword string, value interface{}) (DataBag, error) {
+	switch definition := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return DataBag{"refs": []interface{}{definition}}, nil
+	case []interface{}:
+		return DataBag{"refs": definition}, nil
+	case map[string]interface{}:
+		return definition, nil
+	}
+
+	return nil, fmt.Errorf("%s job: %s should be an array of strings or a hash", name, keyword)
+}
+
+// onlyExceptMatches checks the `only:`/`except:` conditions. For `only:` all
+// of the used conditions must match, while for `except:` any of them is
+// enough.
+func (s *JobSelector) onlyExceptMatches(
+	name string,
+	keyword string,
+	conditions DataBag,
+	variables map[string]string,
+	all bool,
+) (bool, error) {
+	var results []bool
+
+	if rawRefs, ok := conditions["refs"]; ok {
+		refs, err := getPatterns(name, keyword+":refs", rawRefs)
+		if err != nil {
+			return false, err
+		}
+		results = append(results, s.refsMatch(refs))
+	}
+
+	if rawVariables, ok := conditions["variables"]; ok {
+		expressions, err := getPatterns(name, keyword+":variables", rawVariables)
+		if err != nil {
+			return false, err
+		}
+
+		matches := false
+		for _, expression := range expressions {
+			matches, err = EvaluateExpression(expression, variables)
+			if err != nil {
+				return false, fmt.Errorf("%s job: %w", name, err)
+			}
+			if matches {
+				break
+			}
+		}
+		results = append(results, matches)
+	}
+
+	if rawChanges, ok := conditions["changes"]; ok {
+		patterns, err := getPatterns(name, keyword+":changes", rawChanges)
+		if err != nil {
+			return false, err
+		}
+		results = append(results, s.changesMatch(patterns))
+	}
+
+	if _, ok := conditions["kubernetes"]; ok {
+		// There is no Kubernetes integration available locally
+		results = append(results, false)
+	}
+
+	for _, result := range results {
+		if all && !result {
+			return false, nil
+		}
+		if !all && result {
+			return true, nil
+		}
+	}
+
+	return all, nil
+}
+
+func (s *JobSelector) refsMatch(refs []string) bool {
+	for _, ref := range refs {
+		// Refs can be limited to a specific project, which is always the
+		// local one when running locally
+		if at := strings.LastIndex(ref, "@"); at > 0 && !strings.HasPrefix(ref, "/") {
+			ref = ref[:at]
+		}
+
+		switch {
+		case ref == "branches":
+			if !s.Tag {
+				return true
+			}
+		case ref == "tags":
+			if s.Tag {
+				return true
+			}
+		case contains(refsKeywords, ref):
+			if ref == "pushes" && s.Source == pipelineSourcePush || ref == s.Source {
+				return true
+			}
+		case strings.HasPrefix(ref, "/") && strings.LastIndex(ref, "/") > 0:
+			pattern, err := compilePattern(ref)
+			if err == nil && pattern.MatchString(s.Ref) {
+				return true
+			}
+		case ref == s.Ref:
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *JobSelector) changesMatch(patterns []string) bool {
+	if s.ChangedFiles == nil {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		pattern = s.expandVariables(pattern)
+		for _, file := range s.ChangedFiles {
+			if matches, _ := doublestar.Match(pattern, file); matches {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (s *JobSelector) filesExist(patterns []string) (bool, error) {
+	for _, pattern := range patterns {
+		pattern = filepath.Join(s.ProjectDir, filepath.FromSlash(s.expandVariables(pattern)))
+		matches, err := doublestar.Glob(pattern)
+		if err != nil {
+			return false, err
+		}
+
+		if len(matches) > 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+var variableReference = regexp.MustCompile(`\$(\w+)|\$\{(\w+)\}`)
+
+func (s *JobSelector) expandVariables(text string) string {
+	return variableReference.ReplaceAllStringFunc(text, func(reference string) string {
+		name := strings.Trim(reference, "${}")
+		return s.Variables[name]
+	})
+}
+
+func getPatterns(name string, keyword string, value interface{}) ([]string, error) {
+	switch patterns := value.(type) {
+	case string:
+		return []string{patterns}, nil
+	case []interface{}:
+		result := make([]string, 0, len(patterns))
+		for _, pattern := range patterns {
+			text, ok := pattern.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s job: %s should be an array of strings", name, keyword)
+			}
+			result = append(result, text)
+		}
+		return result, nil
+	case map[string]interface{}:
+		// rules:changes:paths introduced together with rules:changes:compare_to
+		if paths, ok := patterns["paths"]; ok {
+			return getPatterns(name, keyword, paths)
+		}
+	}
+
+	return nil, fmt.Errorf("%s job: %s should be an array of strings", name, keyword)
+}
+
+func toInterfaceSlice(values []string) []interface{} {
+	result := make([]interface{}, len(values))
+	for i, value := range values {
+		result[i] = value
+	}
+
+	return result
+}
@@ -0,0 +1,245 @@
+package gitlab_ci_yaml_parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+func selectedJobs(jobs []PipelineJob) map[string]PipelineJob {
+	result := make(map[string]PipelineJob, len(jobs))
+	for _, job := range jobs {
+		result[job.Name] = job
+	}
+
+	return result
+}
+
+func newTestSelector(ref string, refType common.GitInfoRefType) *JobSelector {
+	return NewJobSelector(common.GitInfo{
+		Ref:     ref,
+		RefType: refType,
+		Sha:     "1234567890",
+	}, ".")
+}
+
+const exampleRulesYAML = `
+variables:
+  DEPLOY: "yes"
+
+always:
+  script: always
+
+main-only:
+  script: main
+  rules:
+    - if: $CI_COMMIT_BRANCH == "main"
+
+tags-manual:
+  script: release
+  rules:
+    - if: $CI_COMMIT_TAG
+      when: manual
+    - when: never
+
+docs:
+  script: docs
+  rules:
+    - changes:
+        - docs/**/*
+      allow_failure: true
+      variables:
+        DOCS: "changed"
+
+deploy:
+  script: deploy
+  rules:
+    - if: $DEPLOY == "yes" && $CI_PIPELINE_SOURCE == "push"
+      when: delayed
+
+only-tags:
+  script: tags
+  only:
+    - tags
+
+except-feature:
+  script: except
+  except:
+    refs:
+      - /^feature-/
+
+only-variables:
+  script: variables
+  only:
+    variables:
+      - $UNDEFINED
+      - $DEPLOY == "yes"
+
+manual:
+  script: manual
+  when: manual
+`
+
+func TestJobSelector(t *testing.T) {
+	tests := map[string]struct {
+		selector func() *JobSelector
+		expected []string
+	}{
+		"main branch": {
+			selector: func() *JobSelector { return newTestSelector("main", common.RefTypeBranch) },
+			expected: []string{
+				"always", "deploy", "docs", "except-feature",
+				"main-only", "manual", "only-variables",
+			},
+		},
+		"feature branch without docs changes": {
+			selector: func() *JobSelector {
+				selector := newTestSelector("feature-1", common.RefTypeBranch)
+				selector.ChangedFiles = []string{"main.go"}
+				return selector
+			},
+			expected: []string{"always", "deploy", "manual", "only-variables"},
+		},
+		"tag": {
+			selector: func() *JobSelector { return newTestSelector("v1.0.0", common.RefTypeTag) },
+			expected: []string{
+				"always", "deploy", "docs", "except-feature",
+				"manual", "only-tags", "only-variables", "tags-manual",
+			},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			config := parseDataBag(t, exampleRulesYAML)
+
+			jobs, err := config.GetPipelineJobs(tt.selector())
+			require.NoError(t, err)
+
+			var names []string
+			for _, job := range jobs {
+				names = append(names, job.Name)
+			}
+			assert.Equal(t, tt.expected, names)
+		})
+	}
+}
+
+func TestJobSelectorJobAttributes(t *testing.T) {
+	config := parseDataBag(t, exampleRulesYAML)
+
+	jobs, err := config.GetPipelineJobs(newTestSelector("v1.0.0", common.RefTypeTag))
+	require.NoError(t, err)
+
+	selected := selectedJobs(jobs)
+
+	assert.Equal(t, JobWhenManual, selected["tags-manual"].When)
+	assert.False(t, selected["tags-manual"].AllowFailure, "manual jobs from rules block the pipeline")
+
+	assert.Equal(t, JobWhenManual, selected["manual"].When)
+	assert.True(t, selected["manual"].AllowFailure, "manual jobs don't block the pipeline by default")
+
+	assert.Equal(t, JobWhenOnSuccess, selected["deploy"].When, "delayed jobs are run immediately")
+
+	assert.True(t, selected["docs"].AllowFailure)
+	assert.Equal(t, map[string]string{"DOCS": "changed"}, selected["docs"].Variables)
+}
+
+func TestJobSelectorSelectedManualJobs(t *testing.T) {
+	config := parseDataBag(t, exampleRulesYAML)
+
+	selector := newTestSelector("v1.0.0", common.RefTypeTag)
+	selector.SelectedJobs = []string{"tags-manual"}
+
+	jobs, err := config.GetPipelineJobs(selector)
+	require.NoError(t, err)
+
+	selected := selectedJobs(jobs)
+	assert.Equal(t, JobWhenOnSuccess, selected["tags-manual"].When)
+	assert.Equal(t, JobWhenManual, selected["manual"].When)
+}
+
+func TestJobSelectorExists(t *testing.T) {
+	dir, cleanup := prepareProjectDir(t, map[string]string{
+		"Dockerfile": "FROM alpine",
+	})
+	defer cleanup()
+
+	config := parseDataBag(t, `
+docker:
+  script: build
+  rules:
+    - exists:
+        - Dockerfile
+
+helm:
+  script: build
+  rules:
+    - exists:
+        - charts/**/Chart.yaml
+`)
+
+	selector := newTestSelector("main", common.RefTypeBranch)
+	selector.ProjectDir = dir
+
+	jobs, err := config.GetPipelineJobs(selector)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, "docker", jobs[0].Name)
+}
+
+func TestJobSelectorErrors(t *testing.T) {
+	tests := map[string]struct {
+		content       string
+		expectedError string
+	}{
+		"rules with only": {
+			content: `
+job:
+  script: test
+  only: [main]
+  rules:
+    - when: always
+`,
+			expectedError: "job job: may not use rules in combination with only/except",
+		},
+		"invalid rules": {
+			content: `
+job:
+  script: test
+  rules: always
+`,
+			expectedError: "job job: rules should be an array of hashes",
+		},
+		"invalid expression": {
+			content: `
+job:
+  script: test
+  rules:
+    - if: $A = "b"
+`,
+			expectedError: `invalid expression "$A = \"b\""`,
+		},
+		"invalid only": {
+			content: `
+job:
+  script: test
+  only: 1
+`,
+			expectedError: "job job: only should be an array of strings or a hash",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			config := parseDataBag(t, tt.content)
+
+			_, err := config.GetPipelineJobs(newTestSelector("main", common.RefTypeBranch))
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedError)
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package gitlab_ci_yaml_parser
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+const exampleVariablesYAML = `
+variables:
+  GLOBAL: global
+  OVERRIDDEN_BY_WORKFLOW: global
+  OVERRIDDEN_BY_JOB: global
+  DESCRIBED:
+    value: described
+    description: A variable with a description
+  NUMBER: 10
+
+workflow:
+  rules:
+    - if: $CI_COMMIT_BRANCH == "main"
+      variables:
+        OVERRIDDEN_BY_WORKFLOW: workflow
+        WORKFLOW_ONLY: workflow
+    - if: $CI_COMMIT_TAG
+      when: never
+    - when: always
+
+job:
+  script: job
+  variables:
+    OVERRIDDEN_BY_JOB: job
+    OVERRIDDEN_BY_RULES: job
+    OVERRIDDEN_BY_PROJECT: job
+  rules:
+    - if: $GLOBAL == "global" && $WORKFLOW_ONLY == "workflow"
+      variables:
+        OVERRIDDEN_BY_RULES: rules
+    - when: on_success
+`
+
+func parseTestVariables(t *testing.T, selector *JobSelector) common.JobVariables {
+	file := prepareTestFile(t, exampleVariablesYAML)
+	defer os.Remove(file)
+
+	parser := &GitLabCiYamlParser{filename: file, jobName: "job"}
+	parser.SetJobSelector(selector)
+
+	jobResponse := &common.JobResponse{}
+	require.NoError(t, parser.ParseYaml(jobResponse))
+
+	return jobResponse.Variables
+}
+
+func TestParseYamlVariablesPrecedence(t *testing.T) {
+	selector := newTestSelector("main", common.RefTypeBranch)
+	selector.Variables["CI_DEFAULT_BRANCH"] = "main"
+	selector.ProjectVariables = common.JobVariables{
+		{Key: "OVERRIDDEN_BY_PROJECT", Value: "project", Masked: true},
+		{Key: "PROJECT_FILE", Value: "content", File: true},
+	}
+
+	variables := parseTestVariables(t, selector)
+
+	expected := map[string]string{
+		"CI_DEFAULT_BRANCH":      "main",
+		"GLOBAL":                 "global",
+		"DESCRIBED":              "described",
+		"NUMBER":                 "10",
+		"OVERRIDDEN_BY_WORKFLOW": "workflow",
+		"WORKFLOW_ONLY":          "workflow",
+		"OVERRIDDEN_BY_JOB":      "job",
+		"OVERRIDDEN_BY_RULES":    "rules",
+		"OVERRIDDEN_BY_PROJECT":  "project",
+		"PROJECT_FILE":           "content",
+	}
+	for key, value := range expected {
+		assert.Equal(t, value, variables.Get(key), key)
+	}
+
+	assert.Equal(t, []string{"project"}, variables.Masked())
+
+	last := variables[len(variables)-1]
+	assert.Equal(t, "PROJECT_FILE", last.Key)
+	assert.True(t, last.File)
+}
+
+func TestParseYamlVariablesWithoutSelector(t *testing.T) {
+	variables := parseTestVariables(t, nil)
+
+	assert.Equal(t, "global", variables.Get("OVERRIDDEN_BY_WORKFLOW"))
+	assert.Equal(t, "described", variables.Get("DESCRIBED"))
+	assert.Equal(t, "job", variables.Get("OVERRIDDEN_BY_RULES"))
+	assert.Empty(t, variables.Get("WORKFLOW_ONLY"))
+}
+
+func TestParseYamlVariablesFilteredOutPipeline(t *testing.T) {
+	variables := parseTestVariables(t, newTestSelector("v1.0", common.RefTypeTag))
+
+	assert.Equal(t, "global", variables.Get("OVERRIDDEN_BY_WORKFLOW"))
+	assert.Equal(t, "job", variables.Get("OVERRIDDEN_BY_RULES"))
+}
+
+func TestGetPipelineJobsWorkflowRules(t *testing.T) {
+	config := parseDataBag(t, exampleVariablesYAML)
+
+	jobs, err := config.GetPipelineJobs(newTestSelector("main", common.RefTypeBranch))
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, map[string]string{"OVERRIDDEN_BY_RULES": "rules"}, jobs[0].Variables)
+
+	jobs, err = config.GetPipelineJobs(newTestSelector("feature", common.RefTypeBranch))
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Nil(t, jobs[0].Variables)
+
+	_, err = config.GetPipelineJobs(newTestSelector("v1.0", common.RefTypeTag))
+	assert.Equal(t, ErrPipelineFilteredOut, err)
+}
+
+func TestGetPipelineJobsWorkflowRulesInvalidWhen(t *testing.T) {
+	config := parseDataBag(t, "workflow:\n  rules:\n    - when: manual\njob:\n  script: job\n")
+
+	_, err := config.GetPipelineJobs(newTestSelector("main", common.RefTypeBranch))
+	assert.EqualError(t, err, "workflow: rules:when should be always or never")
+}
@@ -0,0 +1,52 @@
+package api
+
+// Request defines the input structure of the secret plugin executable call,
+// written as JSON to its standard input.
+type Request struct {
+	Secret Secret `json:"secret"`
+	Job    Job    `json:"job"`
+}
+
+// Secret is the definition of the secret in the job, the data being free for
+// the plugin to interpret
+type Secret struct {
+	Name string                 `json:"name"`
+	Data map[string]interface{} `json:"data"`
+}
+
+// Job wraps the metadata of the job resolving the secret
+type Job struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Stage       string `json:"stage"`
+	ProjectID   int    `json:"project_id"`
+	ProjectName string `json:"project_name"`
+	PipelineID  string `json:"pipeline_id"`
+	Ref         string `json:"ref"`
+	Sha         string `json:"sha"`
+	URL         string `json:"url"`
+}
+
+// Response defines the output structure of the secret plugin executable call,
+// read as JSON from its standard output.
+//
+// Either the value of the secret or the error resolving it should be set.
+type Response struct {
+	Value *string `json:"value,omitempty"`
+	Error *Error  `json:"error,omitempty"`
+}
+
+// Error is the failure of the plugin resolving the secret
+type Error struct {
+	Type    ErrorType `json:"type"`
+	Message string    `json:"message"`
+}
+
+type ErrorType string
+
+const (
+	ErrorTypeNotFound       ErrorType = "not_found"
+	ErrorTypeAccessDenied   ErrorType = "access_denied"
+	ErrorTypeInvalidRequest ErrorType = "invalid_request"
+	ErrorTypeUnavailable    ErrorType = "unavailable"
+)
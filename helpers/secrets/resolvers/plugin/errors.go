@@ -0,0 +1,35 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/secrets/resolvers/plugin/api"
+)
+
+var (
+	ErrNotConfigured = errors.New("secret plugin not configured")
+	ErrTimeout       = errors.New("secret plugin timed out")
+	ErrNoValue       = errors.New("secret plugin returned no value")
+)
+
+// Error is the failure reported by the plugin resolving the secret
+type Error struct {
+	Plugin  string
+	Type    api.ErrorType
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("secret plugin %q failed with %s: %s", e.Plugin, e.Type, e.Message)
+}
+
+// Is matches the errors of the same type
+func (e *Error) Is(err error) bool {
+	pluginErr, ok := err.(*Error)
+	if !ok {
+		return false
+	}
+
+	return pluginErr.Type == e.Type
+}
@@ -0,0 +1,17 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/secrets/resolvers/plugin/api"
+)
+
+func TestError_Is(t *testing.T) {
+	err := &Error{Plugin: "internal", Type: api.ErrorTypeAccessDenied, Message: "denied"}
+
+	assert.ErrorIs(t, err, &Error{Type: api.ErrorTypeAccessDenied})
+	assert.NotErrorIs(t, err, &Error{Type: api.ErrorTypeNotFound})
+	assert.NotErrorIs(t, err, assert.AnError)
+}
@@ -0,0 +1,176 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/process"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/secrets"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/secrets/resolvers/plugin/api"
+)
+
+const (
+	resolverName = "plugin"
+
+	gracefulKillTimeout = 5 * time.Second
+	forceKillTimeout    = 5 * time.Second
+)
+
+var (
+	newCommander         = process.NewOSCmd
+	newProcessKillWaiter = process.NewOSKillWait
+)
+
+type resolver struct {
+	secret common.Secret
+}
+
+func newResolver(secret common.Secret) common.SecretResolver {
+	return &resolver{
+		secret: secret,
+	}
+}
+
+func (p *resolver) Name() string {
+	return resolverName
+}
+
+func (p *resolver) IsSupported() bool {
+	return p.secret.Plugin != nil
+}
+
+// Resolve runs the executable of the plugin with the definition of the secret
+// and the metadata of the job written to its standard input, and reads the
+// value of the secret from its standard output
+func (p *resolver) Resolve() (string, error) {
+	if !p.IsSupported() {
+		return "", secrets.NewResolvingUnsupportedSecretError(resolverName)
+	}
+
+	secret := p.secret.Plugin
+	if secret.Config == nil || secret.Config.Exec == "" {
+		return "", fmt.Errorf("%q: %w", secret.Name, ErrNotConfigured)
+	}
+
+	request, err := json.Marshal(newRequest(secret))
+	if err != nil {
+		return "", fmt.Errorf("encoding secret plugin %q request: %w", secret.Name, err)
+	}
+
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+
+	timeout := secret.Config.GetExecTimeout()
+	ctx, cancelFn := context.WithTimeout(context.Background(), timeout)
+	defer cancelFn()
+
+	err = run(ctx, secret.Config, process.CommandOptions{
+		Env:                 os.Environ(),
+		Stdin:               bytes.NewReader(request),
+		Stdout:              stdout,
+		Stderr:              stderr,
+		Logger:              &logger{FieldLogger: logrus.WithField("secret_plugin", secret.Name)},
+		GracefulKillTimeout: gracefulKillTimeout,
+		ForceKillTimeout:    forceKillTimeout,
+	})
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("%q after %v: %w", secret.Name, timeout, ErrTimeout)
+	}
+	if err != nil {
+		return "", fmt.Errorf(
+			"running secret plugin %q: %w: %s",
+			secret.Name,
+			err,
+			strings.TrimSpace(stderr.String()),
+		)
+	}
+
+	return parseResponse(secret.Name, stdout.Bytes())
+}
+
+func newRequest(secret *common.PluginSecret) api.Request {
+	return api.Request{
+		Secret: api.Secret{
+			Name: secret.Name,
+			Data: secret.Data,
+		},
+		Job: api.Job{
+			ID:          secret.Job.ID,
+			Name:        secret.Job.Name,
+			Stage:       secret.Job.Stage,
+			ProjectID:   secret.Job.ProjectID,
+			ProjectName: secret.Job.ProjectName,
+			PipelineID:  secret.Job.PipelineID,
+			Ref:         secret.Job.Ref,
+			Sha:         secret.Job.Sha,
+			URL:         secret.Job.URL,
+		},
+	}
+}
+
+func run(ctx context.Context, config *common.SecretPluginConfig, options process.CommandOptions) error {
+	cmd := newCommander(config.Exec, config.Args, options)
+
+	err := cmd.Start()
+	if err != nil {
+		return fmt.Errorf("failed to start command: %w", err)
+	}
+
+	waitCh := make(chan error)
+	go func() {
+		waitCh <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-waitCh:
+		return err
+
+	case <-ctx.Done():
+		return newProcessKillWaiter(options.Logger, options.GracefulKillTimeout, options.ForceKillTimeout).
+			KillAndWait(cmd, waitCh)
+	}
+}
+
+func parseResponse(name string, output []byte) (string, error) {
+	response := new(api.Response)
+
+	err := json.Unmarshal(output, response)
+	if err != nil {
+		return "", fmt.Errorf("parsing secret plugin %q response: %w", name, err)
+	}
+
+	if response.Error != nil {
+		return "", &Error{
+			Plugin:  name,
+			Type:    response.Error.Type,
+			Message: response.Error.Message,
+		}
+	}
+
+	if response.Value == nil {
+		return "", fmt.Errorf("%q: %w", name, ErrNoValue)
+	}
+
+	return *response.Value, nil
+}
+
+// logger adapts logrus to the logger of the process killer
+type logger struct {
+	logrus.FieldLogger
+}
+
+func (l *logger) WithFields(fields logrus.Fields) process.Logger {
+	return &logger{FieldLogger: l.FieldLogger.WithFields(fields)}
+}
+
+func init() {
+	common.GetSecretResolverRegistry().Register(newResolver)
+}
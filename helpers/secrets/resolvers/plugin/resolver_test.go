@@ -0,0 +1,169 @@
+package plugin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/secrets"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/secrets/resolvers/plugin/api"
+)
+
+func TestResolver_Name(t *testing.T) {
+	r := newResolver(common.Secret{})
+	assert.Equal(t, resolverName, r.Name())
+}
+
+func TestResolver_IsSupported(t *testing.T) {
+	assert.False(t, newResolver(common.Secret{}).IsSupported())
+	assert.True(t, newResolver(common.Secret{Plugin: &common.PluginSecret{}}).IsSupported())
+}
+
+// writePlugin writes a shell script plugin, saving its standard input next to
+// it
+func writePlugin(t *testing.T, script string) (string, string, func()) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script plugins aren't supported on Windows")
+	}
+
+	dir, err := ioutil.TempDir("", "secret-plugin")
+	require.NoError(t, err)
+
+	requestFile := filepath.Join(dir, "request.json")
+	pluginFile := filepath.Join(dir, "plugin.sh")
+
+	content := "#!/bin/sh\ncat > " + requestFile + "\n" + script + "\n"
+	err = ioutil.WriteFile(pluginFile, []byte(content), 0700)
+	require.NoError(t, err)
+
+	return pluginFile, requestFile, func() { _ = os.RemoveAll(dir) }
+}
+
+func TestResolver_Resolve(t *testing.T) {
+	timeout := 1
+
+	tests := map[string]struct {
+		script        string
+		timeout       *int
+		expectedValue string
+		assertError   func(t *testing.T, err error)
+	}{
+		"value returned": {
+			script:        `echo '{"value":"secret value"}'`,
+			expectedValue: "secret value",
+		},
+		"empty value returned": {
+			script:        `echo '{"value":""}'`,
+			expectedValue: "",
+		},
+		"typed error returned": {
+			script: `echo '{"error":{"type":"not_found","message":"no such secret"}}'`,
+			assertError: func(t *testing.T, err error) {
+				assert.ErrorIs(t, err, &Error{Type: api.ErrorTypeNotFound})
+				assert.EqualError(t, err, `secret plugin "internal" failed with not_found: no such secret`)
+			},
+		},
+		"no value returned": {
+			script: `echo '{}'`,
+			assertError: func(t *testing.T, err error) {
+				assert.ErrorIs(t, err, ErrNoValue)
+			},
+		},
+		"invalid response": {
+			script: `echo 'not json'`,
+			assertError: func(t *testing.T, err error) {
+				var syntaxErr *json.SyntaxError
+				assert.ErrorAs(t, err, &syntaxErr)
+			},
+		},
+		"executable failure": {
+			script: `echo 'failure details' >&2; exit 3`,
+			assertError: func(t *testing.T, err error) {
+				var exitErr *exec.ExitError
+				require.ErrorAs(t, err, &exitErr)
+				assert.Equal(t, 3, exitErr.ExitCode())
+				assert.Contains(t, err.Error(), "failure details")
+			},
+		},
+		"timeout": {
+			script:  `sleep 5`,
+			timeout: &timeout,
+			assertError: func(t *testing.T, err error) {
+				assert.ErrorIs(t, err, ErrTimeout)
+			},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			pluginFile, requestFile, cleanup := writePlugin(t, tt.script)
+			defer cleanup()
+
+			secret := common.Secret{
+				Plugin: &common.PluginSecret{
+					Name: "internal",
+					Data: map[string]interface{}{"path": "team/db"},
+					Config: &common.SecretPluginConfig{
+						Exec:        pluginFile,
+						ExecTimeout: tt.timeout,
+					},
+					Job: common.PluginSecretJob{ID: 1, ProjectName: "project"},
+				},
+			}
+
+			value, err := newResolver(secret).Resolve()
+
+			request, readErr := ioutil.ReadFile(requestFile)
+			require.NoError(t, readErr)
+			assert.JSONEq(
+				t,
+				`{
+					"secret": {"name": "internal", "data": {"path": "team/db"}},
+					"job": {
+						"id": 1, "name": "", "stage": "", "project_id": 0, "project_name": "project",
+						"pipeline_id": "", "ref": "", "sha": "", "url": ""
+					}
+				}`,
+				string(request),
+			)
+
+			if tt.assertError != nil {
+				tt.assertError(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedValue, value)
+		})
+	}
+}
+
+func TestResolver_ResolveErrors(t *testing.T) {
+	tests := map[string]struct {
+		secret        common.Secret
+		expectedError error
+	}{
+		"unsupported secret": {
+			expectedError: secrets.NewResolvingUnsupportedSecretError(resolverName),
+		},
+		"plugin not configured": {
+			secret:        common.Secret{Plugin: &common.PluginSecret{Name: "unknown"}},
+			expectedError: ErrNotConfigured,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			_, err := newResolver(tt.secret).Resolve()
+			assert.ErrorIs(t, err, tt.expectedError)
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/vault/service"
+)
+
+const (
+	configReferenceScheme = "vault"
+
+	defaultConfigEngineName = "kv-v2"
+	defaultConfigEnginePath = "secret"
+)
+
+var errVaultNotConfigured = errors.New("vault section not configured")
+
+// configReferenceResolver resolves the vault://path#field references of the
+// configuration with the Vault server of its vault section, authenticating
+// once for all the references
+type configReferenceResolver struct {
+	config *common.VaultServerConfig
+
+	service service.Vault
+}
+
+func newConfigReferenceResolver(config *common.Config) common.ConfigReferenceResolver {
+	return &configReferenceResolver{
+		config: config.Vault,
+	}
+}
+
+func (r *configReferenceResolver) Resolve(location string) (string, error) {
+	if r.config == nil {
+		return "", errVaultNotConfigured
+	}
+
+	i := strings.LastIndex(location, "#")
+	if i < 0 || i == len(location)-1 {
+		return "", fmt.Errorf("missing field of %q, expected vault://path#field", location)
+	}
+
+	secret := r.secret(location[:i], location[i+1:])
+
+	if r.service == nil {
+		s, err := newVaultService(secret.Server.URL, secret)
+		if err != nil {
+			return "", err
+		}
+
+		r.service = s
+	}
+
+	data, err := r.service.GetField(secret, secret)
+	if err != nil {
+		return "", err
+	}
+
+	if data == nil {
+		return "", fmt.Errorf("field %q not found", secret.Field)
+	}
+
+	return fmt.Sprintf("%v", data), nil
+}
+
+func (r *configReferenceResolver) secret(path string, field string) *common.VaultSecret {
+	engine := common.VaultEngine{
+		Name: r.config.Engine.Name,
+		Path: r.config.Engine.Path,
+	}
+	if engine.Name == "" {
+		engine.Name = defaultConfigEngineName
+	}
+	if engine.Path == "" {
+		engine.Path = defaultConfigEnginePath
+	}
+
+	return &common.VaultSecret{
+		Server: common.VaultServer{
+			URL: r.config.URL,
+			Auth: common.VaultAuth{
+				Name: r.config.Auth.Name,
+				Path: r.config.Auth.Path,
+				Data: r.config.Auth.Data,
+			},
+		},
+		Engine: engine,
+		Path:   path,
+		Field:  field,
+	}
+}
+
+// Close revokes the token once the references are resolved
+func (r *configReferenceResolver) Close() error {
+	if r.service == nil {
+		return nil
+	}
+
+	return r.service.RevokeToken()
+}
+
+func init() {
+	common.RegisterConfigReferenceResolver(configReferenceScheme, newConfigReferenceResolver)
+}
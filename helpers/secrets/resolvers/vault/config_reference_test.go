@@ -0,0 +1,147 @@
+package vault
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/vault/service"
+)
+
+func TestConfigReferenceResolver_Resolve(t *testing.T) {
+	config := &common.VaultServerConfig{
+		URL: "test_url",
+		Auth: common.VaultServerAuthConfig{
+			Name: "approle",
+			Path: "approle",
+			Data: common.VaultAuthData{"role_id": "role"},
+		},
+	}
+
+	newSecret := func(path string, field string) *common.VaultSecret {
+		return &common.VaultSecret{
+			Server: common.VaultServer{
+				URL: "test_url",
+				Auth: common.VaultAuth{
+					Name: "approle",
+					Path: "approle",
+					Data: common.VaultAuthData{"role_id": "role"},
+				},
+			},
+			Engine: common.VaultEngine{Name: "kv-v2", Path: "secret"},
+			Path:   path,
+			Field:  field,
+		}
+	}
+
+	tests := map[string]struct {
+		config                    *common.VaultServerConfig
+		location                  string
+		vaultServiceCreationError error
+		assertVaultServiceMock    func(s *service.MockVault)
+		expectedValue             string
+		expectedError             string
+	}{
+		"vault not configured": {
+			location:      "runners/production#token",
+			expectedError: errVaultNotConfigured.Error(),
+		},
+		"missing field": {
+			config:        config,
+			location:      "runners/production",
+			expectedError: `missing field of "runners/production", expected vault://path#field`,
+		},
+		"error on vault service creation": {
+			config:                    config,
+			location:                  "runners/production#token",
+			vaultServiceCreationError: assert.AnError,
+			expectedError:             assert.AnError.Error(),
+		},
+		"field not found": {
+			config:   config,
+			location: "runners/production#token",
+			assertVaultServiceMock: func(s *service.MockVault) {
+				secret := newSecret("runners/production", "token")
+				s.On("GetField", secret, secret).Return(nil, nil).Once()
+			},
+			expectedError: `field "token" not found`,
+		},
+		"field resolved": {
+			config:   config,
+			location: "runners/production#token",
+			assertVaultServiceMock: func(s *service.MockVault) {
+				secret := newSecret("runners/production", "token")
+				s.On("GetField", secret, secret).Return("runner-token", nil).Once()
+			},
+			expectedValue: "runner-token",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			serviceMock := new(service.MockVault)
+			defer serviceMock.AssertExpectations(t)
+
+			if tt.assertVaultServiceMock != nil {
+				tt.assertVaultServiceMock(serviceMock)
+			}
+
+			oldNewVaultService := newVaultService
+			defer func() {
+				newVaultService = oldNewVaultService
+			}()
+			newVaultService = func(url string, auth service.Auth) (service.Vault, error) {
+				assert.Equal(t, "test_url", url)
+
+				return serviceMock, tt.vaultServiceCreationError
+			}
+
+			r := newConfigReferenceResolver(&common.Config{Vault: tt.config})
+
+			value, err := r.Resolve(tt.location)
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedValue, value)
+		})
+	}
+}
+
+func TestConfigReferenceResolver_Close(t *testing.T) {
+	serviceMock := new(service.MockVault)
+	defer serviceMock.AssertExpectations(t)
+
+	serviceMock.On("GetField", mock.Anything, mock.Anything).Return("value", nil).Twice()
+	serviceMock.On("RevokeToken").Return(nil).Once()
+
+	oldNewVaultService := newVaultService
+	defer func() {
+		newVaultService = oldNewVaultService
+	}()
+
+	logins := 0
+	newVaultService = func(_ string, _ service.Auth) (service.Vault, error) {
+		logins++
+		return serviceMock, nil
+	}
+
+	r := newConfigReferenceResolver(&common.Config{Vault: &common.VaultServerConfig{URL: "test_url"}})
+
+	_, err := r.Resolve("runners/production#token")
+	require.NoError(t, err)
+	_, err = r.Resolve("cache/s3#secret_key")
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, logins)
+
+	closer, ok := r.(io.Closer)
+	require.True(t, ok)
+	assert.NoError(t, closer.Close())
+}
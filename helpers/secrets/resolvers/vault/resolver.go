@@ -1,6 +1,7 @@
 package vault
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"gitlab.com/gitlab-org/gitlab-runner/common"
@@ -54,6 +55,86 @@ func (v *resolver) Resolve() (string, error) {
 	return fmt.Sprintf("%v", data), nil
 }
 
+// SessionKey identifies the Vault server and the authentication, the secrets
+// sharing them being read with the same token
+func (v *resolver) SessionKey() (string, error) {
+	if !v.IsSupported() {
+		return "", secrets.NewResolvingUnsupportedSecretError(resolverName)
+	}
+
+	key, err := json.Marshal(v.secret.Vault.Server)
+	if err != nil {
+		return "", fmt.Errorf("identifying Vault server: %w", err)
+	}
+
+	return string(key), nil
+}
+
+// SourceKey identifies the secret read from Vault, regardless of its field
+func (v *resolver) SourceKey() (string, error) {
+	if !v.IsSupported() {
+		return "", secrets.NewResolvingUnsupportedSecretError(resolverName)
+	}
+
+	secret := v.secret.Vault
+
+	key, err := json.Marshal(struct {
+		Server common.VaultServer
+		Engine common.VaultEngine
+		Path   string
+	}{
+		Server: secret.Server,
+		Engine: secret.Engine,
+		Path:   secret.Path,
+	})
+	if err != nil {
+		return "", fmt.Errorf("identifying secret: %w", err)
+	}
+
+	return string(key), nil
+}
+
+func (v *resolver) Value(source *common.SecretSource) string {
+	return fmt.Sprintf("%v", source.Data[v.secret.Vault.Field])
+}
+
+// NewSession authenticates to the Vault server once for all the secrets
+// having the same session key
+func (v *resolver) NewSession() (common.SecretSession, error) {
+	if !v.IsSupported() {
+		return nil, secrets.NewResolvingUnsupportedSecretError(resolverName)
+	}
+
+	secret := v.secret.Vault
+
+	s, err := newVaultService(secret.Server.URL, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session{service: s}, nil
+}
+
+type session struct {
+	service service.Vault
+}
+
+func (s *session) Read(secret common.Secret) (map[string]interface{}, string, error) {
+	if secret.Vault == nil {
+		return nil, "", secrets.NewResolvingUnsupportedSecretError(resolverName)
+	}
+
+	return s.service.GetLeasedData(secret.Vault, secret.Vault)
+}
+
+func (s *session) RevokeLease(leaseID string) error {
+	return s.service.RevokeLease(leaseID)
+}
+
+func (s *session) Close() error {
+	return s.service.RevokeToken()
+}
+
 func init() {
 	common.GetSecretResolverRegistry().Register(newResolver)
 }
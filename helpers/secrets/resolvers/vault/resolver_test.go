@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"gitlab.com/gitlab-org/gitlab-runner/common"
 	"gitlab.com/gitlab-org/gitlab-runner/helpers/secrets"
@@ -119,3 +120,191 @@ func TestResolver_Resolve(t *testing.T) {
 		})
 	}
 }
+
+func TestResolver_Keys(t *testing.T) {
+	newSecret := func(url string, role string, path string, field string) common.Secret {
+		return common.Secret{
+			Vault: &common.VaultSecret{
+				Server: common.VaultServer{
+					URL: url,
+					Auth: common.VaultAuth{
+						Name: "jwt",
+						Path: "jwt",
+						Data: map[string]interface{}{"role": role},
+					},
+				},
+				Engine: common.VaultEngine{Name: "database", Path: "database"},
+				Path:   path,
+				Field:  field,
+			},
+		}
+	}
+
+	keys := func(t *testing.T, secret common.Secret) (string, string) {
+		r := newResolver(secret).(common.SessionSecretResolver)
+
+		sessionKey, err := r.SessionKey()
+		require.NoError(t, err)
+
+		sourceKey, err := r.SourceKey()
+		require.NoError(t, err)
+
+		return sessionKey, sourceKey
+	}
+
+	username := newSecret("test_url", "role", "creds", "username")
+	usernameSession, usernameSource := keys(t, username)
+
+	tests := map[string]struct {
+		secret            common.Secret
+		expectSameSession bool
+		expectSameSource  bool
+	}{
+		"other field of the same secret": {
+			secret:            newSecret("test_url", "role", "creds", "password"),
+			expectSameSession: true,
+			expectSameSource:  true,
+		},
+		"other secret of the same server": {
+			secret:            newSecret("test_url", "role", "other", "username"),
+			expectSameSession: true,
+		},
+		"other authentication": {
+			secret: newSecret("test_url", "other", "creds", "username"),
+		},
+		"other server": {
+			secret: newSecret("other_url", "role", "creds", "username"),
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			sessionKey, sourceKey := keys(t, tt.secret)
+
+			assert.Equal(t, tt.expectSameSession, sessionKey == usernameSession)
+			assert.Equal(t, tt.expectSameSource, sourceKey == usernameSource)
+		})
+	}
+
+	t.Run("unsupported secret", func(t *testing.T) {
+		r := newResolver(common.Secret{}).(common.SessionSecretResolver)
+		expectedErr := new(secrets.ResolvingUnsupportedSecretError)
+
+		_, err := r.SessionKey()
+		assert.ErrorAs(t, err, &expectedErr)
+
+		_, err = r.SourceKey()
+		assert.ErrorAs(t, err, &expectedErr)
+	})
+}
+
+func TestResolver_Value(t *testing.T) {
+	secret := common.Secret{
+		Vault: &common.VaultSecret{Field: "port"},
+	}
+	source := &common.SecretSource{
+		Data: map[string]interface{}{"port": 5432},
+	}
+
+	value := newResolver(secret).(common.SessionSecretResolver).Value(source)
+	assert.Equal(t, "5432", value)
+}
+
+func TestResolver_NewSession(t *testing.T) {
+	secret := common.Secret{
+		Vault: &common.VaultSecret{
+			Server: common.VaultServer{URL: "test_url"},
+			Engine: common.VaultEngine{Name: "database", Path: "database"},
+			Path:   "role",
+		},
+	}
+	data := map[string]interface{}{"username": "generated-user"}
+	leaseID := "database/creds/role/lease"
+
+	tests := map[string]struct {
+		secret                    common.Secret
+		vaultServiceCreationError error
+		assertSession             func(t *testing.T, s common.SecretSession, serviceMock *service.MockVault)
+		expectedError             error
+	}{
+		"unsupported secret": {
+			expectedError: new(secrets.ResolvingUnsupportedSecretError),
+		},
+		"error on vault service creation": {
+			secret:                    secret,
+			vaultServiceCreationError: assert.AnError,
+			expectedError:             assert.AnError,
+		},
+		"secret read": {
+			secret: secret,
+			assertSession: func(t *testing.T, s common.SecretSession, serviceMock *service.MockVault) {
+				serviceMock.On("GetLeasedData", secret.Vault, secret.Vault).
+					Return(data, leaseID, nil).
+					Once()
+
+				readData, readLeaseID, err := s.Read(secret)
+				require.NoError(t, err)
+				assert.Equal(t, data, readData)
+				assert.Equal(t, leaseID, readLeaseID)
+			},
+		},
+		"error on reading secret": {
+			secret: secret,
+			assertSession: func(t *testing.T, s common.SecretSession, serviceMock *service.MockVault) {
+				serviceMock.On("GetLeasedData", secret.Vault, secret.Vault).
+					Return(nil, "", assert.AnError).
+					Once()
+
+				_, _, err := s.Read(secret)
+				assert.ErrorIs(t, err, assert.AnError)
+			},
+		},
+		"lease revoked": {
+			secret: secret,
+			assertSession: func(t *testing.T, s common.SecretSession, serviceMock *service.MockVault) {
+				serviceMock.On("RevokeLease", leaseID).
+					Return(nil).
+					Once()
+
+				assert.NoError(t, s.RevokeLease(leaseID))
+			},
+		},
+		"token revoked on close": {
+			secret: secret,
+			assertSession: func(t *testing.T, s common.SecretSession, serviceMock *service.MockVault) {
+				serviceMock.On("RevokeToken").
+					Return(assert.AnError).
+					Once()
+
+				assert.ErrorIs(t, s.Close(), assert.AnError)
+			},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			serviceMock := new(service.MockVault)
+			defer serviceMock.AssertExpectations(t)
+
+			oldNewVaultService := newVaultService
+			defer func() {
+				newVaultService = oldNewVaultService
+			}()
+			newVaultService = func(url string, auth service.Auth) (service.Vault, error) {
+				assert.Equal(t, tt.secret.Vault.Server.URL, url)
+				assert.Equal(t, tt.secret.Vault, auth)
+
+				return serviceMock, tt.vaultServiceCreationError
+			}
+
+			s, err := newResolver(tt.secret).(common.SessionSecretResolver).NewSession()
+			if tt.expectedError != nil {
+				assert.ErrorAs(t, err, &tt.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			tt.assertSession(t, s, serviceMock)
+		})
+	}
+}
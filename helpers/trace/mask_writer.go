@@ -0,0 +1,67 @@
+package trace
+
+import (
+	"io"
+	"sync"
+
+	"golang.org/x/text/transform"
+)
+
+// MaskWriter replaces the masked phrases in the data written to the
+// underlying writer with [MASKED]. Data that could be the start of a masked
+// phrase is held back until it can be decided, so Close must be called to
+// flush it.
+type MaskWriter struct {
+	lock sync.Mutex
+	w    io.Writer
+	tw   io.WriteCloser
+}
+
+func NewMaskWriter(w io.Writer) *MaskWriter {
+	return &MaskWriter{w: w}
+}
+
+func (m *MaskWriter) SetMasked(values []string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	// close existing writer to flush data
+	if m.tw != nil {
+		_ = m.tw.Close()
+		m.tw = nil
+	}
+
+	if len(values) == 0 {
+		return
+	}
+
+	transformers := make([]transform.Transformer, 0, len(values))
+	for _, value := range values {
+		transformers = append(transformers, newPhraseTransform(value))
+	}
+
+	m.tw = transform.NewWriter(m.w, transform.Chain(transformers...))
+}
+
+func (m *MaskWriter) Write(p []byte) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.tw == nil {
+		return m.w.Write(p)
+	}
+
+	return m.tw.Write(p)
+}
+
+// Close flushes the held back data. It doesn't close the underlying writer.
+func (m *MaskWriter) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.tw == nil {
+		return nil
+	}
+
+	return m.tw.Close()
+}
@@ -0,0 +1,26 @@
+package trace
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaskWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+
+	w := NewMaskWriter(buf)
+	_, err := w.Write([]byte("before masking secret\n"))
+	require.NoError(t, err)
+
+	w.SetMasked([]string{"secret", "token"})
+	for _, part := range []string{"the sec", "ret and the tok", "en\nend to"} {
+		_, err = w.Write([]byte(part))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	assert.Equal(t, "before masking secret\nthe [MASKED] and the [MASKED]\nend to", buf.String())
+}
@@ -1,7 +1,19 @@
 package vault
 
+import (
+	"github.com/hashicorp/vault/api"
+)
+
 type AuthMethod interface {
 	Name() string
 	Authenticate(client Client) error
 	Token() string
 }
+
+// TLSAuthMethod is implemented by the auth methods authenticating with the
+// TLS connection to the Vault server, which is configured before the client
+// is created
+type TLSAuthMethod interface {
+	AuthMethod
+	TLSConfig() *api.TLSConfig
+}
@@ -0,0 +1,94 @@
+package approle
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/vault"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/vault/auth_methods"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/vault/internal/vaulttest"
+)
+
+func TestNewMethod(t *testing.T) {
+	tests := map[string]struct {
+		providedData  map[string]interface{}
+		expectedData  map[string]interface{}
+		expectedError error
+	}{
+		"missing required key": {
+			providedData: map[string]interface{}{
+				secretIDKey: "secret-id",
+			},
+			expectedError: new(auth_methods.MissingRequiredConfigurationKeyError),
+		},
+		"unexpected key provided": {
+			providedData: map[string]interface{}{
+				roleIDKey:     "role-id",
+				"unknown-key": "value",
+			},
+			expectedData: map[string]interface{}{
+				roleIDKey: "role-id",
+			},
+		},
+		"proper configuration": {
+			providedData: map[string]interface{}{
+				roleIDKey:   "role-id",
+				secretIDKey: "secret-id",
+			},
+			expectedData: map[string]interface{}{
+				roleIDKey:   "role-id",
+				secretIDKey: "secret-id",
+			},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			a, err := NewMethod("", tt.providedData)
+
+			if tt.expectedError != nil {
+				assert.ErrorAs(t, err, &tt.expectedError)
+				return
+			}
+
+			approleAuth, ok := a.(*method)
+			require.True(t, ok)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedData, approleAuth.data)
+		})
+	}
+}
+
+func TestAppRoleAuth_Name(t *testing.T) {
+	a := new(method)
+	assert.Equal(t, methodName, a.Name())
+}
+
+func TestAppRoleAuth_Authenticate_Token(t *testing.T) {
+	vaultToken := "some.vault.token"
+
+	server := vaulttest.NewServer(vaultToken)
+	defer server.Close()
+
+	data := map[string]interface{}{
+		roleIDKey:   "role-id",
+		secretIDKey: "secret-id",
+	}
+
+	auth, err := NewMethod("some/path/to/approle", data)
+	require.NoError(t, err)
+
+	client, err := vault.NewClient(server.URL)
+	require.NoError(t, err)
+
+	require.NoError(t, client.Authenticate(auth))
+	assert.Equal(t, vaultToken, auth.Token())
+
+	logins := server.Logins()
+	require.Len(t, logins, 1)
+	assert.Equal(t, "/v1/auth/some/path/to/approle/login", logins[0].Path)
+	assert.Equal(t, data, logins[0].Payload)
+}
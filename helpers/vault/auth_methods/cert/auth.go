@@ -0,0 +1,110 @@
+package cert
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/hashicorp/vault/api"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/vault"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/vault/auth_methods"
+)
+
+const methodName = "cert"
+
+const (
+	nameKey     = "name"
+	certFileKey = "cert_file"
+	keyFileKey  = "key_file"
+	caCertKey   = "ca_cert"
+)
+
+var (
+	requiredPayloadFields = []string{
+		certFileKey,
+		keyFileKey,
+	}
+
+	allowedPayloadFields = []string{
+		nameKey,
+		certFileKey,
+		keyFileKey,
+		caCertKey,
+	}
+)
+
+type method struct {
+	path string
+	data map[string]interface{}
+
+	token string
+}
+
+func NewMethod(path string, data auth_methods.Data) (vault.AuthMethod, error) {
+	newData, err := data.Filter(requiredPayloadFields, allowedPayloadFields)
+	if err != nil {
+		return nil, fmt.Errorf("filtering auth method configuration: %w", err)
+	}
+
+	a := &method{
+		path: path,
+		data: newData,
+	}
+
+	return a, nil
+}
+
+func (a *method) Name() string {
+	return methodName
+}
+
+// TLSConfig returns the client certificate presented to Vault, and the CA
+// certificate verifying the Vault server when it's set
+func (a *method) TLSConfig() *api.TLSConfig {
+	return &api.TLSConfig{
+		ClientCert: a.stringValue(certFileKey),
+		ClientKey:  a.stringValue(keyFileKey),
+		CACert:     a.stringValue(caCertKey),
+	}
+}
+
+func (a *method) stringValue(key string) string {
+	value, ok := a.data[key]
+	if !ok {
+		return ""
+	}
+
+	return fmt.Sprintf("%v", value)
+}
+
+func (a *method) Authenticate(client vault.Client) error {
+	authPath := path.Join("auth", a.path, "login")
+
+	// Without a name, Vault tries all the roles matching the certificate
+	authPayload := make(map[string]interface{})
+	if name, ok := a.data[nameKey]; ok {
+		authPayload[nameKey] = name
+	}
+
+	result, err := client.Write(authPath, authPayload)
+	if err != nil {
+		return fmt.Errorf("writing to Vault: %w", err)
+	}
+
+	token, err := result.TokenID()
+	if err != nil {
+		return fmt.Errorf("getting token from the authentication response: %w", err)
+	}
+
+	a.token = token
+
+	return nil
+}
+
+func (a *method) Token() string {
+	return a.token
+}
+
+func init() {
+	auth_methods.MustRegisterFactory(methodName, NewMethod)
+}
@@ -0,0 +1,200 @@
+package cert
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/certificate"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/vault"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/vault/auth_methods"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/vault/internal/vaulttest"
+)
+
+func TestNewMethod(t *testing.T) {
+	tests := map[string]struct {
+		providedData  map[string]interface{}
+		expectedData  map[string]interface{}
+		expectedError error
+	}{
+		"missing required key": {
+			providedData: map[string]interface{}{
+				certFileKey: "/cert.pem",
+			},
+			expectedError: new(auth_methods.MissingRequiredConfigurationKeyError),
+		},
+		"unexpected key provided": {
+			providedData: map[string]interface{}{
+				certFileKey:   "/cert.pem",
+				keyFileKey:    "/key.pem",
+				"unknown-key": "value",
+			},
+			expectedData: map[string]interface{}{
+				certFileKey: "/cert.pem",
+				keyFileKey:  "/key.pem",
+			},
+		},
+		"proper configuration": {
+			providedData: map[string]interface{}{
+				nameKey:     "runner",
+				certFileKey: "/cert.pem",
+				keyFileKey:  "/key.pem",
+				caCertKey:   "/ca.pem",
+			},
+			expectedData: map[string]interface{}{
+				nameKey:     "runner",
+				certFileKey: "/cert.pem",
+				keyFileKey:  "/key.pem",
+				caCertKey:   "/ca.pem",
+			},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			a, err := NewMethod("", tt.providedData)
+
+			if tt.expectedError != nil {
+				assert.ErrorAs(t, err, &tt.expectedError)
+				return
+			}
+
+			certAuth, ok := a.(*method)
+			require.True(t, ok)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedData, certAuth.data)
+		})
+	}
+}
+
+func TestCertAuth_Name(t *testing.T) {
+	a := new(method)
+	assert.Equal(t, methodName, a.Name())
+}
+
+func TestCertAuth_TLSConfig(t *testing.T) {
+	a, err := NewMethod("", map[string]interface{}{
+		certFileKey: "/cert.pem",
+		keyFileKey:  "/key.pem",
+	})
+	require.NoError(t, err)
+
+	tlsAuth, ok := a.(vault.TLSAuthMethod)
+	require.True(t, ok)
+
+	expectedConfig := &api.TLSConfig{
+		ClientCert: "/cert.pem",
+		ClientKey:  "/key.pem",
+	}
+	assert.Equal(t, expectedConfig, tlsAuth.TLSConfig())
+}
+
+// writeCertificate writes the PEM encoded certificate and key to the
+// directory, and returns their paths
+func writeCertificate(t *testing.T, dir string, name string, cert tls.Certificate) (string, string) {
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+"-key.pem")
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	require.NoError(t, ioutil.WriteFile(certFile, certPEM, 0600))
+
+	key, ok := cert.PrivateKey.(*rsa.PrivateKey)
+	require.True(t, ok)
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	require.NoError(t, ioutil.WriteFile(keyFile, keyPEM, 0600))
+
+	return certFile, keyFile
+}
+
+func TestCertAuth_Authenticate_Token(t *testing.T) {
+	vaultToken := "some.vault.token"
+
+	dir, err := ioutil.TempDir("", "vault-cert-auth")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	generator := certificate.X509Generator{}
+
+	serverCert, _, err := generator.Generate("127.0.0.1")
+	require.NoError(t, err)
+	caFile, _ := writeCertificate(t, dir, "server", serverCert)
+
+	clientCert, _, err := generator.Generate("runner")
+	require.NoError(t, err)
+	certFile, keyFile := writeCertificate(t, dir, "client", clientCert)
+
+	server := vaulttest.NewTLSServer(vaultToken, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAnyClientCert,
+	})
+	defer server.Close()
+
+	tests := map[string]struct {
+		data            map[string]interface{}
+		expectedPayload map[string]interface{}
+	}{
+		"role name provided": {
+			data: map[string]interface{}{
+				nameKey:     "runner",
+				certFileKey: certFile,
+				keyFileKey:  keyFile,
+				caCertKey:   caFile,
+			},
+			expectedPayload: map[string]interface{}{
+				nameKey: "runner",
+			},
+		},
+		"any matching role": {
+			data: map[string]interface{}{
+				certFileKey: certFile,
+				keyFileKey:  keyFile,
+				caCertKey:   caFile,
+			},
+			expectedPayload: map[string]interface{}{},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			auth, err := NewMethod("cert", tt.data)
+			require.NoError(t, err)
+
+			tlsAuth, ok := auth.(vault.TLSAuthMethod)
+			require.True(t, ok)
+
+			client, err := vault.NewClient(server.URL, vault.WithTLSConfig(tlsAuth.TLSConfig()))
+			require.NoError(t, err)
+
+			require.NoError(t, client.Authenticate(auth))
+			assert.Equal(t, vaultToken, auth.Token())
+
+			logins := server.Logins()
+			require.NotEmpty(t, logins)
+
+			login := logins[len(logins)-1]
+			assert.Equal(t, "/v1/auth/cert/login", login.Path)
+			assert.Equal(t, tt.expectedPayload, login.Payload)
+			require.Len(t, login.PeerCertificates, 1)
+			assert.Equal(t, clientCert.Certificate[0], login.PeerCertificates[0].Raw)
+		})
+	}
+}
+
+func TestCertAuth_ClientWithoutCertificate(t *testing.T) {
+	_, err := vault.NewClient("https://vault.example.com", vault.WithTLSConfig(&api.TLSConfig{
+		ClientCert: "/missing/cert.pem",
+		ClientKey:  "/missing/key.pem",
+	}))
+	assert.Error(t, err)
+}
@@ -0,0 +1,115 @@
+package kubernetes
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/vault"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/vault/auth_methods"
+)
+
+const methodName = "kubernetes"
+
+const (
+	roleKey      = "role"
+	jwtKey       = "jwt"
+	tokenPathKey = "token_path"
+)
+
+var errMissingServiceAccountToken = errors.New("neither the service account token nor its path is configured")
+
+var (
+	requiredPayloadFields = []string{
+		roleKey,
+	}
+
+	allowedPayloadFields = []string{
+		roleKey,
+		jwtKey,
+		tokenPathKey,
+	}
+)
+
+type method struct {
+	path string
+	data map[string]interface{}
+
+	token string
+}
+
+func NewMethod(path string, data auth_methods.Data) (vault.AuthMethod, error) {
+	newData, err := data.Filter(requiredPayloadFields, allowedPayloadFields)
+	if err != nil {
+		return nil, fmt.Errorf("filtering auth method configuration: %w", err)
+	}
+
+	a := &method{
+		path: path,
+		data: newData,
+	}
+
+	return a, nil
+}
+
+func (a *method) Name() string {
+	return methodName
+}
+
+func (a *method) Authenticate(client vault.Client) error {
+	jwt, err := a.serviceAccountToken()
+	if err != nil {
+		return err
+	}
+
+	authPath := path.Join("auth", a.path, "login")
+	authPayload := map[string]interface{}{
+		roleKey: a.data[roleKey],
+		jwtKey:  jwt,
+	}
+
+	result, err := client.Write(authPath, authPayload)
+	if err != nil {
+		return fmt.Errorf("writing to Vault: %w", err)
+	}
+
+	token, err := result.TokenID()
+	if err != nil {
+		return fmt.Errorf("getting token from the authentication response: %w", err)
+	}
+
+	a.token = token
+
+	return nil
+}
+
+// serviceAccountToken returns the configured token, or reads the service
+// account token from the configured path, which is read for each
+// authentication as the projected tokens are rotated
+func (a *method) serviceAccountToken() (string, error) {
+	if jwt, ok := a.data[jwtKey]; ok {
+		return fmt.Sprintf("%v", jwt), nil
+	}
+
+	tokenPath, ok := a.data[tokenPathKey]
+	if !ok {
+		return "", errMissingServiceAccountToken
+	}
+
+	jwt, err := ioutil.ReadFile(fmt.Sprintf("%v", tokenPath))
+	if err != nil {
+		return "", fmt.Errorf("reading service account token: %w", err)
+	}
+
+	return strings.TrimSpace(string(jwt)), nil
+}
+
+func (a *method) Token() string {
+	return a.token
+}
+
+func init() {
+	auth_methods.MustRegisterFactory(methodName, NewMethod)
+}
@@ -0,0 +1,151 @@
+package kubernetes
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/vault"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/vault/auth_methods"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/vault/internal/vaulttest"
+)
+
+func TestNewMethod(t *testing.T) {
+	tests := map[string]struct {
+		providedData  map[string]interface{}
+		expectedData  map[string]interface{}
+		expectedError error
+	}{
+		"missing required key": {
+			providedData: map[string]interface{}{
+				tokenPathKey: "/token",
+			},
+			expectedError: new(auth_methods.MissingRequiredConfigurationKeyError),
+		},
+		"unexpected key provided": {
+			providedData: map[string]interface{}{
+				roleKey:       "role",
+				"unknown-key": "value",
+			},
+			expectedData: map[string]interface{}{
+				roleKey: "role",
+			},
+		},
+		"proper configuration": {
+			providedData: map[string]interface{}{
+				roleKey:      "role",
+				tokenPathKey: "/token",
+			},
+			expectedData: map[string]interface{}{
+				roleKey:      "role",
+				tokenPathKey: "/token",
+			},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			a, err := NewMethod("", tt.providedData)
+
+			if tt.expectedError != nil {
+				assert.ErrorAs(t, err, &tt.expectedError)
+				return
+			}
+
+			kubernetesAuth, ok := a.(*method)
+			require.True(t, ok)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedData, kubernetesAuth.data)
+		})
+	}
+}
+
+func TestKubernetesAuth_Name(t *testing.T) {
+	a := new(method)
+	assert.Equal(t, methodName, a.Name())
+}
+
+func TestKubernetesAuth_Authenticate_Token(t *testing.T) {
+	vaultToken := "some.vault.token"
+
+	dir, err := ioutil.TempDir("", "vault-kubernetes-auth")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tokenPath := filepath.Join(dir, "token")
+	require.NoError(t, ioutil.WriteFile(tokenPath, []byte("projected.service-account.token\n"), 0600))
+
+	tests := map[string]struct {
+		data            map[string]interface{}
+		expectedPayload map[string]interface{}
+		expectedError   bool
+	}{
+		"service account token read from the file": {
+			data: map[string]interface{}{
+				roleKey:      "runner",
+				tokenPathKey: tokenPath,
+			},
+			expectedPayload: map[string]interface{}{
+				roleKey: "runner",
+				jwtKey:  "projected.service-account.token",
+			},
+		},
+		"token provided": {
+			data: map[string]interface{}{
+				roleKey:      "runner",
+				jwtKey:       "provided.token",
+				tokenPathKey: tokenPath,
+			},
+			expectedPayload: map[string]interface{}{
+				roleKey: "runner",
+				jwtKey:  "provided.token",
+			},
+		},
+		"missing service account token path": {
+			data: map[string]interface{}{
+				roleKey: "runner",
+			},
+			expectedError: true,
+		},
+		"missing service account token": {
+			data: map[string]interface{}{
+				roleKey:      "runner",
+				tokenPathKey: filepath.Join(dir, "missing"),
+			},
+			expectedError: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			server := vaulttest.NewServer(vaultToken)
+			defer server.Close()
+
+			auth, err := NewMethod("kubernetes", tt.data)
+			require.NoError(t, err)
+
+			client, err := vault.NewClient(server.URL)
+			require.NoError(t, err)
+
+			err = client.Authenticate(auth)
+			if tt.expectedError {
+				assert.Error(t, err)
+				assert.Empty(t, server.Logins())
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, vaultToken, auth.Token())
+
+			logins := server.Logins()
+			require.Len(t, logins, 1)
+			assert.Equal(t, "/v1/auth/kubernetes/login", logins[0].Path)
+			assert.Equal(t, tt.expectedPayload, logins[0].Payload)
+		})
+	}
+}
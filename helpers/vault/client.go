@@ -63,11 +63,34 @@ var (
 	}
 )
 
-func NewClient(URL string) (Client, error) {
+// ClientOption configures the connection to the Vault server
+type ClientOption func(config *api.Config) error
+
+// WithTLSConfig configures the TLS connection to the Vault server, e.g. the
+// client certificate of the cert auth method
+func WithTLSConfig(tlsConfig *api.TLSConfig) ClientOption {
+	return func(config *api.Config) error {
+		err := config.ConfigureTLS(tlsConfig)
+		if err != nil {
+			return fmt.Errorf("configuring TLS: %w", err)
+		}
+
+		return nil
+	}
+}
+
+func NewClient(URL string, options ...ClientOption) (Client, error) {
 	config := &api.Config{
 		Address: URL,
 	}
 
+	for _, option := range options {
+		err := option(config)
+		if err != nil {
+			return nil, fmt.Errorf("creating new Vault client: %w", err)
+		}
+	}
+
 	client, err := newAPIClient(config)
 	if err != nil {
 		return nil, fmt.Errorf("creating new Vault client: %w", unwrapAPIResponseError(err))
@@ -0,0 +1,94 @@
+package vaulttest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// Login is a login request received by the fake Vault server
+type Login struct {
+	Path             string
+	Payload          map[string]interface{}
+	PeerCertificates []*x509.Certificate
+}
+
+// Server is a fake Vault server, initialized and unsealed, returning the
+// token to the logins sent to the auth methods
+type Server struct {
+	*httptest.Server
+
+	token string
+
+	lock   sync.Mutex
+	logins []Login
+}
+
+// NewServer starts a fake Vault server
+func NewServer(token string) *Server {
+	s := &Server{token: token}
+	s.Server = httptest.NewServer(s)
+
+	return s
+}
+
+// NewTLSServer starts a fake Vault server using the TLS configuration,
+// e.g. requiring client certificates
+func NewTLSServer(token string, tlsConfig *tls.Config) *Server {
+	s := &Server{token: token}
+	s.Server = httptest.NewUnstartedServer(s)
+	s.Server.TLS = tlsConfig
+	s.Server.StartTLS()
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.URL.Path == "/v1/sys/health" {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"initialized": true,
+			"sealed":      false,
+		})
+		return
+	}
+
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	login := Login{Path: r.URL.Path}
+	if r.TLS != nil {
+		login.PeerCertificates = r.TLS.PeerCertificates
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&login.Payload)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{err.Error()}})
+		return
+	}
+
+	s.lock.Lock()
+	s.logins = append(s.logins, login)
+	s.lock.Unlock()
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"auth": map[string]interface{}{
+			"client_token": s.token,
+		},
+	})
+}
+
+// Logins returns the login requests received by the server
+func (s *Server) Logins() []Login {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]Login{}, s.logins...)
+}
@@ -25,6 +25,20 @@ func (_m *MockResult) Data() map[string]interface{} {
 	return r0
 }
 
+// LeaseID provides a mock function with given fields:
+func (_m *MockResult) LeaseID() string {
+	ret := _m.Called()
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
 // TokenID provides a mock function with given fields:
 func (_m *MockResult) TokenID() (string, error) {
 	ret := _m.Called()
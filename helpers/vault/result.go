@@ -9,6 +9,7 @@ import (
 type Result interface {
 	Data() map[string]interface{}
 	TokenID() (string, error)
+	LeaseID() string
 }
 
 var ErrNoResult = errors.New("no result from Vault")
@@ -38,3 +39,11 @@ func (r *secretResult) TokenID() (string, error) {
 
 	return r.inner.TokenID()
 }
+
+func (r *secretResult) LeaseID() string {
+	if r.inner == nil {
+		return ""
+	}
+
+	return r.inner.LeaseID
+}
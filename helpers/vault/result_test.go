@@ -66,3 +66,28 @@ func TestSecretResult_TokenID(t *testing.T) {
 		})
 	}
 }
+
+func TestSecretResult_LeaseID(t *testing.T) {
+	tests := map[string]struct {
+		secret          *api.Secret
+		expectedLeaseID string
+	}{
+		"nil api.Secret": {
+			secret: nil,
+		},
+		"secret without lease": {
+			secret: &api.Secret{Data: map[string]interface{}{"key": "value"}},
+		},
+		"leased secret": {
+			secret:          &api.Secret{LeaseID: "database/creds/role/lease"},
+			expectedLeaseID: "database/creds/role/lease",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			r := newResult(tt.secret)
+			assert.Equal(t, tt.expectedLeaseID, r.LeaseID())
+		})
+	}
+}
@@ -6,3 +6,10 @@ type SecretEngine interface {
 	Put(path string, data map[string]interface{}) error
 	Delete(path string) error
 }
+
+// LeasedSecretEngine is implemented by the engines generating secrets with a
+// lease, like short-lived credentials, which is revoked once they're not used
+type LeasedSecretEngine interface {
+	SecretEngine
+	GetLeased(path string) (map[string]interface{}, string, error)
+}
@@ -0,0 +1,63 @@
+package aws
+
+import (
+	"fmt"
+	"path"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/vault"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/vault/secret_engines"
+)
+
+const engineName = "aws"
+
+// engine generates the AWS credentials, e.g. the access key, secret key and security token of an AWS role, the path of the secret being the name of the role
+type engine struct {
+	client vault.Client
+	path   string
+}
+
+func NewEngine(client vault.Client, path string) vault.SecretEngine {
+	return &engine{
+		client: client,
+		path:   path,
+	}
+}
+
+func (e *engine) EngineName() string {
+	return engineName
+}
+
+func (e *engine) Get(path string) (map[string]interface{}, error) {
+	data, _, err := e.GetLeased(path)
+
+	return data, err
+}
+
+func (e *engine) GetLeased(path string) (map[string]interface{}, string, error) {
+	secret, err := e.client.Read(e.credsPath(path))
+	if err != nil {
+		return nil, "", fmt.Errorf("reading from Vault: %w", err)
+	}
+
+	if secret == nil {
+		return nil, "", nil
+	}
+
+	return secret.Data(), secret.LeaseID(), nil
+}
+
+func (e *engine) credsPath(role string) string {
+	return path.Join(e.path, "creds", role)
+}
+
+func (e *engine) Put(path string, data map[string]interface{}) error {
+	return secret_engines.NewUnsupportedPutOperationErr(e)
+}
+
+func (e *engine) Delete(path string) error {
+	return secret_engines.NewUnsupportedDeleteOperationErr(e)
+}
+
+func init() {
+	secret_engines.MustRegisterFactory(engineName, NewEngine)
+}
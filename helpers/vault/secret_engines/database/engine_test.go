@@ -0,0 +1,113 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/vault"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/vault/secret_engines"
+)
+
+func TestEngine_EngineName(t *testing.T) {
+	e := new(engine)
+	assert.Equal(t, engineName, e.EngineName())
+}
+
+func TestEngine_GetLeased(t *testing.T) {
+	enginePath := "database/"
+	role := "/role/"
+	expectedPath := "database/creds/role"
+	leaseID := "database/creds/role/2f6a614c"
+
+	credentials := map[string]interface{}{"username": "v-token-role-1a2b", "password": "A1a-generated"}
+
+	tests := map[string]struct {
+		setupClientMock func(*testing.T, *vault.MockClient) func()
+		expectedError   error
+		expectedData    map[string]interface{}
+		expectedLeaseID string
+	}{
+		"client read error": {
+			setupClientMock: func(t *testing.T, c *vault.MockClient) func() {
+				c.On("Read", expectedPath).
+					Return(nil, assert.AnError).
+					Once()
+
+				return func() {
+					c.AssertExpectations(t)
+				}
+			},
+			expectedError: assert.AnError,
+		},
+		"client read succeeded with nil result": {
+			setupClientMock: func(t *testing.T, c *vault.MockClient) func() {
+				c.On("Read", expectedPath).
+					Return(nil, nil).
+					Once()
+
+				return func() {
+					c.AssertExpectations(t)
+				}
+			},
+		},
+		"credentials generated": {
+			setupClientMock: func(t *testing.T, c *vault.MockClient) func() {
+				result := new(vault.MockResult)
+				result.On("Data").
+					Return(credentials).
+					Once()
+				result.On("LeaseID").
+					Return(leaseID).
+					Once()
+
+				c.On("Read", expectedPath).
+					Return(result, nil).
+					Once()
+
+				return func() {
+					c.AssertExpectations(t)
+					result.AssertExpectations(t)
+				}
+			},
+			expectedData:    credentials,
+			expectedLeaseID: leaseID,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			clientMock := new(vault.MockClient)
+
+			assertions := tt.setupClientMock(t, clientMock)
+			defer assertions()
+
+			e := NewEngine(clientMock, enginePath)
+
+			leasedEngine, ok := e.(vault.LeasedSecretEngine)
+			if !assert.True(t, ok) {
+				return
+			}
+
+			data, lease, err := leasedEngine.GetLeased(role)
+			if tt.expectedError != nil {
+				assert.ErrorAs(t, err, &tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedData, data)
+			assert.Equal(t, tt.expectedLeaseID, lease)
+		})
+	}
+}
+
+func TestEngine_PutAndDelete(t *testing.T) {
+	e := NewEngine(new(vault.MockClient), "database")
+
+	err := e.Put("role", map[string]interface{}{"key": "value"})
+	assert.ErrorIs(t, err, secret_engines.NewUnsupportedPutOperationErr(e))
+
+	err = e.Delete("role")
+	assert.ErrorIs(t, err, secret_engines.NewUnsupportedDeleteOperationErr(e))
+}
@@ -0,0 +1,83 @@
+package pki
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/vault"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/vault/secret_engines"
+)
+
+const engineName = "pki"
+
+const commonNameKey = "common_name"
+
+// engine issues certificates, the path of the secret being the name of the
+// role followed by the common name of the certificate, e.g.
+// "web/build.example.com". The certificates have a lease only when the role
+// is configured with generate_lease.
+type engine struct {
+	client vault.Client
+	path   string
+}
+
+func NewEngine(client vault.Client, path string) vault.SecretEngine {
+	return &engine{
+		client: client,
+		path:   path,
+	}
+}
+
+func (e *engine) EngineName() string {
+	return engineName
+}
+
+func (e *engine) Get(path string) (map[string]interface{}, error) {
+	data, _, err := e.GetLeased(path)
+
+	return data, err
+}
+
+func (e *engine) GetLeased(secretPath string) (map[string]interface{}, string, error) {
+	role, commonName, err := parsePath(secretPath)
+	if err != nil {
+		return nil, "", err
+	}
+
+	payload := map[string]interface{}{
+		commonNameKey: commonName,
+	}
+
+	secret, err := e.client.Write(path.Join(e.path, "issue", role), payload)
+	if err != nil {
+		return nil, "", fmt.Errorf("writing to Vault: %w", err)
+	}
+
+	if secret == nil {
+		return nil, "", nil
+	}
+
+	return secret.Data(), secret.LeaseID(), nil
+}
+
+func parsePath(secretPath string) (string, string, error) {
+	parts := strings.SplitN(strings.Trim(secretPath, "/"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid path %q, expected <role>/<common name>", secretPath)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+func (e *engine) Put(path string, data map[string]interface{}) error {
+	return secret_engines.NewUnsupportedPutOperationErr(e)
+}
+
+func (e *engine) Delete(path string) error {
+	return secret_engines.NewUnsupportedDeleteOperationErr(e)
+}
+
+func init() {
+	secret_engines.MustRegisterFactory(engineName, NewEngine)
+}
@@ -46,6 +46,36 @@ func (_m *MockVault) GetField(engineDetails Engine, secretDetails Secret) (inter
 	return r0, r1
 }
 
+// GetLeasedData provides a mock function with given fields: engineDetails, secretDetails
+func (_m *MockVault) GetLeasedData(engineDetails Engine, secretDetails Secret) (map[string]interface{}, string, error) {
+	ret := _m.Called(engineDetails, secretDetails)
+
+	var r0 map[string]interface{}
+	if rf, ok := ret.Get(0).(func(Engine, Secret) map[string]interface{}); ok {
+		r0 = rf(engineDetails, secretDetails)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[string]interface{})
+		}
+	}
+
+	var r1 string
+	if rf, ok := ret.Get(1).(func(Engine, Secret) string); ok {
+		r1 = rf(engineDetails, secretDetails)
+	} else {
+		r1 = ret.Get(1).(string)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(Engine, Secret) error); ok {
+		r2 = rf(engineDetails, secretDetails)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
 // Put provides a mock function with given fields: engineDetails, secretDetails, data
 func (_m *MockVault) Put(engineDetails Engine, secretDetails Secret, data map[string]interface{}) error {
 	ret := _m.Called(engineDetails, secretDetails, data)
@@ -59,3 +89,31 @@ func (_m *MockVault) Put(engineDetails Engine, secretDetails Secret, data map[st
 
 	return r0
 }
+
+// RevokeLease provides a mock function with given fields: leaseID
+func (_m *MockVault) RevokeLease(leaseID string) error {
+	ret := _m.Called(leaseID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(leaseID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RevokeToken provides a mock function with given fields:
+func (_m *MockVault) RevokeToken() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
@@ -5,10 +5,16 @@ import (
 
 	"gitlab.com/gitlab-org/gitlab-runner/helpers/vault"
 	"gitlab.com/gitlab-org/gitlab-runner/helpers/vault/auth_methods"
-	_ "gitlab.com/gitlab-org/gitlab-runner/helpers/vault/auth_methods/jwt" // register auth method
+	_ "gitlab.com/gitlab-org/gitlab-runner/helpers/vault/auth_methods/approle"    // register auth method
+	_ "gitlab.com/gitlab-org/gitlab-runner/helpers/vault/auth_methods/cert"       // register auth method
+	_ "gitlab.com/gitlab-org/gitlab-runner/helpers/vault/auth_methods/jwt"        // register auth method
+	_ "gitlab.com/gitlab-org/gitlab-runner/helpers/vault/auth_methods/kubernetes" // register auth method
 	"gitlab.com/gitlab-org/gitlab-runner/helpers/vault/secret_engines"
-	_ "gitlab.com/gitlab-org/gitlab-runner/helpers/vault/secret_engines/kv_v1" // register secret engine
-	_ "gitlab.com/gitlab-org/gitlab-runner/helpers/vault/secret_engines/kv_v2" // register secret engine
+	_ "gitlab.com/gitlab-org/gitlab-runner/helpers/vault/secret_engines/aws"      // register secret engine
+	_ "gitlab.com/gitlab-org/gitlab-runner/helpers/vault/secret_engines/database" // register secret engine
+	_ "gitlab.com/gitlab-org/gitlab-runner/helpers/vault/secret_engines/kv_v1"    // register secret engine
+	_ "gitlab.com/gitlab-org/gitlab-runner/helpers/vault/secret_engines/kv_v2"    // register secret engine
+	_ "gitlab.com/gitlab-org/gitlab-runner/helpers/vault/secret_engines/pki"      // register secret engine
 )
 
 type Auth interface {
@@ -29,10 +35,18 @@ type Secret interface {
 
 type Vault interface {
 	GetField(engineDetails Engine, secretDetails Secret) (interface{}, error)
+	GetLeasedData(engineDetails Engine, secretDetails Secret) (map[string]interface{}, string, error)
 	Put(engineDetails Engine, secretDetails Secret, data map[string]interface{}) error
 	Delete(engineDetails Engine, secretDetails Secret) error
+	RevokeLease(leaseID string) error
+	RevokeToken() error
 }
 
+const (
+	revokeLeasePath = "sys/leases/revoke"
+	revokeTokenPath = "auth/token/revoke-self"
+)
+
 type defaultVault struct {
 	client vault.Client
 }
@@ -60,12 +74,17 @@ func (v *defaultVault) initialize(url string, auth Auth) error {
 }
 
 func (v *defaultVault) prepareAuthenticatedClient(url string, authDetails Auth) error {
-	client, err := newVaultClient(url)
+	auth, err := v.prepareAuthMethodAdapter(authDetails)
 	if err != nil {
 		return err
 	}
 
-	auth, err := v.prepareAuthMethodAdapter(authDetails)
+	var options []vault.ClientOption
+	if tlsAuth, ok := auth.(vault.TLSAuthMethod); ok {
+		options = append(options, vault.WithTLSConfig(tlsAuth.TLSConfig()))
+	}
+
+	client, err := newVaultClient(url, options...)
 	if err != nil {
 		return err
 	}
@@ -117,6 +136,32 @@ func (v *defaultVault) GetField(engineDetails Engine, secretDetails Secret) (int
 	return nil, nil
 }
 
+// GetLeasedData returns all the fields of the secret, and the ID of its lease
+// when the engine generates secrets with a lease
+func (v *defaultVault) GetLeasedData(engineDetails Engine, secretDetails Secret) (map[string]interface{}, string, error) {
+	engine, err := v.getSecretEngine(engineDetails)
+	if err != nil {
+		return nil, "", err
+	}
+
+	leasedEngine, ok := engine.(vault.LeasedSecretEngine)
+	if !ok {
+		secret, err := engine.Get(secretDetails.SecretPath())
+		if err != nil {
+			return nil, "", fmt.Errorf("reading secret: %w", err)
+		}
+
+		return secret, "", nil
+	}
+
+	secret, leaseID, err := leasedEngine.GetLeased(secretDetails.SecretPath())
+	if err != nil {
+		return nil, "", fmt.Errorf("reading secret: %w", err)
+	}
+
+	return secret, leaseID, nil
+}
+
 func (v *defaultVault) getSecretEngine(engineDetails Engine) (vault.SecretEngine, error) {
 	engineFactory, err := secret_engines.GetFactory(engineDetails.EngineName())
 	if err != nil {
@@ -155,3 +200,23 @@ func (v *defaultVault) Delete(engineDetails Engine, secretDetails Secret) error
 
 	return nil
 }
+
+func (v *defaultVault) RevokeLease(leaseID string) error {
+	_, err := v.client.Write(revokeLeasePath, map[string]interface{}{"lease_id": leaseID})
+	if err != nil {
+		return fmt.Errorf("revoking lease: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeToken revokes the token of the authenticated client, which can't be
+// used anymore. The leases created with the token are revoked too.
+func (v *defaultVault) RevokeToken() error {
+	_, err := v.client.Write(revokeTokenPath, nil)
+	if err != nil {
+		return fmt.Errorf("revoking token: %w", err)
+	}
+
+	return nil
+}
@@ -32,7 +32,7 @@ func TestNewVault(t *testing.T) {
 	}{
 		"error on vault client creation": {
 			vaultClientCreationError: assert.AnError,
-			assertAuthMock:           func(_ string, _ *MockAuth) {},
+			assertAuthMock:           assertAuthMock,
 			assertClientMock:         func(_ *vault.MockClient, _ vault.AuthMethod) {},
 			expectedError:            assert.AnError,
 		},
@@ -86,7 +86,7 @@ func TestNewVault(t *testing.T) {
 			defer func() {
 				newVaultClient = oldNewVaultClient
 			}()
-			newVaultClient = func(URL string) (vault.Client, error) {
+			newVaultClient = func(URL string, _ ...vault.ClientOption) (vault.Client, error) {
 				assert.Equal(t, testURL, URL)
 
 				return clientMock, tt.vaultClientCreationError
@@ -227,6 +227,183 @@ func TestDefaultVault_GetField(t *testing.T) {
 	}
 }
 
+// mockLeasedSecretEngine is a secret engine generating secrets with a lease
+type mockLeasedSecretEngine struct {
+	vault.MockSecretEngine
+}
+
+func (e *mockLeasedSecretEngine) GetLeased(path string) (map[string]interface{}, string, error) {
+	ret := e.Called(path)
+
+	data, _ := ret.Get(0).(map[string]interface{})
+
+	return data, ret.String(1), ret.Error(2)
+}
+
+func TestDefaultVault_GetLeasedData(t *testing.T) {
+	enginePath := "path"
+	secretPath := "role"
+	secretData := map[string]interface{}{
+		"username": "generated-user",
+		"password": "generated-password",
+	}
+	leaseID := "database/creds/role/lease"
+
+	tests := map[string]struct {
+		leased          bool
+		getError        error
+		expectedData    map[string]interface{}
+		expectedLeaseID string
+		expectedError   error
+	}{
+		"secret without lease": {
+			expectedData: secretData,
+		},
+		"error on reading secret without lease": {
+			getError:      assert.AnError,
+			expectedError: assert.AnError,
+		},
+		"leased secret": {
+			leased:          true,
+			expectedData:    secretData,
+			expectedLeaseID: leaseID,
+		},
+		"error on reading leased secret": {
+			leased:        true,
+			getError:      assert.AnError,
+			expectedError: assert.AnError,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			clientMock := new(vault.MockClient)
+			defer clientMock.AssertExpectations(t)
+
+			var secretEngine vault.SecretEngine
+			if tt.leased {
+				leasedEngine := new(mockLeasedSecretEngine)
+				defer leasedEngine.AssertExpectations(t)
+
+				leasedEngine.On("GetLeased", secretPath).Return(secretData, leaseID, tt.getError).Once()
+				secretEngine = leasedEngine
+			} else {
+				secretEngineMock := new(vault.MockSecretEngine)
+				defer secretEngineMock.AssertExpectations(t)
+
+				secretEngineMock.On("Get", secretPath).Return(secretData, tt.getError).Once()
+				secretEngine = secretEngineMock
+			}
+
+			require.NotPanics(t, func() {
+				secret_engines.MustRegisterFactory(t.Name(), func(_ vault.Client, _ string) vault.SecretEngine {
+					return secretEngine
+				})
+			})
+
+			engineMock := new(MockEngine)
+			defer engineMock.AssertExpectations(t)
+
+			engineMock.On("EngineName").Return(t.Name()).Once()
+			engineMock.On("EnginePath").Return(enginePath).Once()
+
+			secretMock := new(MockSecret)
+			defer secretMock.AssertExpectations(t)
+
+			secretMock.On("SecretPath").Return(secretPath).Once()
+
+			service := &defaultVault{
+				client: clientMock,
+			}
+
+			data, lease, err := service.GetLeasedData(engineMock, secretMock)
+
+			if tt.expectedError != nil {
+				assert.ErrorAs(t, err, &tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedData, data)
+			assert.Equal(t, tt.expectedLeaseID, lease)
+		})
+	}
+}
+
+func TestDefaultVault_RevokeLease(t *testing.T) {
+	leaseID := "database/creds/role/lease"
+
+	tests := map[string]struct {
+		writeError    error
+		expectedError error
+	}{
+		"lease revoked": {},
+		"error on revoking lease": {
+			writeError:    assert.AnError,
+			expectedError: assert.AnError,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			clientMock := new(vault.MockClient)
+			defer clientMock.AssertExpectations(t)
+
+			clientMock.On("Write", "sys/leases/revoke", map[string]interface{}{"lease_id": leaseID}).
+				Return(nil, tt.writeError).
+				Once()
+
+			service := &defaultVault{
+				client: clientMock,
+			}
+
+			err := service.RevokeLease(leaseID)
+			if tt.expectedError != nil {
+				assert.ErrorAs(t, err, &tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestDefaultVault_RevokeToken(t *testing.T) {
+	tests := map[string]struct {
+		writeError    error
+		expectedError error
+	}{
+		"token revoked": {},
+		"error on revoking token": {
+			writeError:    assert.AnError,
+			expectedError: assert.AnError,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			clientMock := new(vault.MockClient)
+			defer clientMock.AssertExpectations(t)
+
+			clientMock.On("Write", "auth/token/revoke-self", map[string]interface{}(nil)).
+				Return(nil, tt.writeError).
+				Once()
+
+			service := &defaultVault{
+				client: clientMock,
+			}
+
+			err := service.RevokeToken()
+			if tt.expectedError != nil {
+				assert.ErrorAs(t, err, &tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
 func TestDefaultVault_Put(t *testing.T) {
 	enginePath := "path"
 	assertEngineMock := func(engineFactoryName string, e *MockEngine) {
@@ -12,7 +12,9 @@ import (
 	"gitlab.com/gitlab-org/gitlab-runner/log"
 
 	_ "gitlab.com/gitlab-org/gitlab-runner/cache/azure"
+	_ "gitlab.com/gitlab-org/gitlab-runner/cache/filesystem"
 	_ "gitlab.com/gitlab-org/gitlab-runner/cache/gcs"
+	_ "gitlab.com/gitlab-org/gitlab-runner/cache/registry"
 	_ "gitlab.com/gitlab-org/gitlab-runner/cache/s3"
 	_ "gitlab.com/gitlab-org/gitlab-runner/commands"
 	_ "gitlab.com/gitlab-org/gitlab-runner/commands/helpers"
@@ -24,6 +26,7 @@ import (
 	_ "gitlab.com/gitlab-org/gitlab-runner/executors/shell"
 	_ "gitlab.com/gitlab-org/gitlab-runner/executors/ssh"
 	_ "gitlab.com/gitlab-org/gitlab-runner/executors/virtualbox"
+	_ "gitlab.com/gitlab-org/gitlab-runner/helpers/secrets/resolvers/plugin"
 	_ "gitlab.com/gitlab-org/gitlab-runner/helpers/secrets/resolvers/vault"
 	_ "gitlab.com/gitlab-org/gitlab-runner/shells"
 )
@@ -95,8 +95,14 @@ func (b *AbstractShell) cacheExtractor(w ShellWriter, info common.ShellScriptInf
 
 		skipRestoreCache = false
 
+		userKey, err := getCacheUserKey(info.Build, cacheOptions)
+		if err != nil {
+			w.Warningf("Skipping cache extraction, the key of %s can't be computed: %v", cacheOptions.KeyFiles, err)
+			continue
+		}
+
 		// Skip extraction if no cache is defined
-		cacheKey, cacheFile := b.cacheFile(info.Build, cacheOptions.Key)
+		cacheKey, cacheFile := b.cacheFile(info.Build, userKey)
 		if cacheKey == "" {
 			w.Noticef("Skipping cache extraction due to empty cache key")
 			continue
@@ -109,7 +115,7 @@ func (b *AbstractShell) cacheExtractor(w ShellWriter, info common.ShellScriptInf
 			continue
 		}
 
-		b.extractCacheOrFallbackCacheWrapper(w, info, cacheFile, cacheKey)
+		b.extractCacheOrFallbackCacheWrapper(w, info, cacheFile, cacheKey, cacheOptions.FallbackKeys)
 	}
 
 	if skipRestoreCache {
@@ -124,39 +130,169 @@ func (b *AbstractShell) extractCacheOrFallbackCacheWrapper(
 	info common.ShellScriptInfo,
 	cacheFile string,
 	cacheKey string,
+	fallbackKeys []string,
 ) {
-	cacheFallbackKey := info.Build.GetAllVariables().Get("CACHE_FALLBACK_KEY")
+	cacheKeys := b.cacheKeys(w, info.Build, cacheKey, fallbackKeys)
+
+	env, download := getCacheEncryptionEnv(w, info.Build)
+	if !download {
+		cacheKeys = cacheKeys[:1]
+	}
+	env = withCacheResultKey(env, info.Build)
 
 	// Execute cache-extractor command. Failure is not fatal.
 	b.guardRunnerCommand(w, info.RunnerCommand, "Extracting cache", func() {
-		b.addExtractCacheCommand(w, info, cacheFile, cacheKey, cacheFallbackKey)
+		for key, value := range env {
+			w.Variable(common.JobVariable{Key: key, Value: value})
+		}
+
+		b.addExtractCacheCommand(w, info, cacheFile, cacheKeys, 0, download)
 	})
 }
 
+// cacheKeys returns the keys tried in order when extracting the cache: the
+// cache key, the fallback keys of the job and the CACHE_FALLBACK_KEY
+// variable. Keys ending with `*` are resolved to the stored cache sharing the
+// longest prefix with the cache key.
+func (b *AbstractShell) cacheKeys(
+	w ShellWriter,
+	build *common.Build,
+	cacheKey string,
+	fallbackKeys []string,
+) []string {
+	variables := build.GetAllVariables()
+
+	var candidates []string
+	for _, key := range fallbackKeys {
+		candidates = append(candidates, variables.ExpandValue(key))
+	}
+	candidates = append(candidates, variables.Get("CACHE_FALLBACK_KEY"))
+
+	cacheKeys := []string{cacheKey}
+	seen := map[string]bool{cacheKey: true}
+
+	for _, key := range candidates {
+		if strings.HasSuffix(key, "*") {
+			key = resolveCacheKeyPrefix(w, build, cacheKey, strings.TrimSuffix(key, "*"))
+		}
+
+		if key == "" || seen[key] {
+			continue
+		}
+
+		seen[key] = true
+		cacheKeys = append(cacheKeys, key)
+	}
+
+	return cacheKeys
+}
+
+var listCacheKeys = cache.ListCacheKeys
+
+// resolveCacheKeyPrefix returns the key of the stored cache starting with
+// prefix and sharing the longest prefix with cacheKey. The most recently
+// updated cache wins ties.
+func resolveCacheKeyPrefix(w ShellWriter, build *common.Build, cacheKey string, prefix string) string {
+	keys, err := listCacheKeys(build, prefix)
+	if err != nil {
+		w.Warningf("Failed to resolve the cache key %s*: %v", prefix, err)
+		return ""
+	}
+
+	resolved := ""
+	longest := -1
+
+	for _, key := range keys {
+		if key == cacheKey {
+			continue
+		}
+
+		length := commonPrefixLength(key, cacheKey)
+		if length > longest {
+			resolved = key
+			longest = length
+		}
+	}
+
+	if resolved == "" {
+		w.Noticef("No cache found for the key %s*", prefix)
+		return ""
+	}
+
+	w.Noticef("Resolved the cache key %s* to %s", prefix, resolved)
+
+	return resolved
+}
+
+func commonPrefixLength(a string, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+
+	return n
+}
+
+// addExtractCacheCommand tries to extract the cache of cacheKeys[attempt],
+// falling back to the next keys when it fails. Without download, only the
+// local cache is extracted.
 func (b *AbstractShell) addExtractCacheCommand(
 	w ShellWriter,
 	info common.ShellScriptInfo,
 	cacheFile string,
-	cacheKey string,
-	cacheFallbackKey string,
+	cacheKeys []string,
+	attempt int,
+	download bool,
 ) {
+	cacheKey := cacheKeys[attempt]
+
 	args := []string{
 		"cache-extractor",
 		"--file", cacheFile,
 		"--timeout", strconv.Itoa(info.Build.GetCacheRequestTimeout()),
 	}
 
-	if url := cache.GetCacheDownloadURL(info.Build, cacheKey); url != nil {
-		args = append(args, "--url", url.String())
+	var env map[string]string
+	remote := false
+	if download {
+		var url *url.URL
+		if url, env = cache.GetCacheDownloadURLWithEnv(info.Build, cacheKey); url != nil {
+			args = append(args, "--url", url.String())
+			remote = true
+		}
+	}
+
+	formatArgs, formatEnv := getCacheFormatArgs(w, info.Build, cacheKey, remote, false)
+	args = append(args, formatArgs...)
+
+	var accessEnv map[string]string
+	if remote {
+		accessEnv = cache.GetCacheAccessMarkerEnv(info.Build, cacheKey)
+	}
+
+	for _, extraEnv := range []map[string]string{formatEnv, accessEnv} {
+		for key, value := range extraEnv {
+			if env == nil {
+				env = make(map[string]string)
+			}
+			env[key] = value
+		}
 	}
 
 	w.Noticef("Checking cache for %s...", cacheKey)
+	for key, value := range env {
+		w.Variable(common.JobVariable{Key: key, Value: value})
+	}
 	w.IfCmdWithOutput(info.RunnerCommand, args...)
-	w.Noticef("Successfully extracted cache")
+	if attempt == 0 {
+		w.Noticef("Successfully extracted cache")
+	} else {
+		w.Noticef("Successfully extracted cache from fallback key %s", cacheKey)
+	}
 	w.Else()
 	w.Warningf("Failed to extract cache")
-	if cacheFallbackKey != "" {
-		b.addExtractCacheCommand(w, info, cacheFile, cacheFallbackKey, "")
+	if attempt+1 < len(cacheKeys) {
+		b.addExtractCacheCommand(w, info, cacheFile, cacheKeys, attempt+1, download)
 	}
 	w.EndIf()
 }
@@ -172,6 +308,14 @@ func (b *AbstractShell) downloadArtifacts(w ShellWriter, job common.Dependency,
 		strconv.Itoa(job.ID),
 	}
 
+	if info.Build.LocalArtifactsDir != "" {
+		args = []string{
+			"artifacts-downloader",
+			"--local-path",
+			info.Build.LocalArtifactsPath(job.Name),
+		}
+	}
+
 	w.Noticef("Downloading artifacts for %s (%d)...", job.Name, job.ID)
 	w.Command(info.RunnerCommand, args...)
 }
@@ -207,10 +351,9 @@ func (b *AbstractShell) writePrepareScript(w ShellWriter, info common.ShellScrip
 }
 
 func (b *AbstractShell) writeGetSourcesScript(w ShellWriter, info common.ShellScriptInfo) error {
-
 	var projectDir = info.Build.GetAllVariables().Get("CI_PROJECT_DIR")
 	if info.Build.GitInfo.RepoURL == projectDir {
-		fmt.Println("INPLACE RUN DETECTED (in path %s)... ", projectDir)
+		w.Noticef("In-place run detected in %s, skipping fetching the sources", projectDir)
 		return nil
 	}
 
@@ -572,8 +715,14 @@ func (b *AbstractShell) archiveCache(w ShellWriter, info common.ShellScriptInfo,
 
 		skipArchiveCache = false
 
+		userKey, err := getCacheUserKey(info.Build, cacheOptions)
+		if err != nil {
+			w.Warningf("Skipping cache archiving, the key of %s can't be computed: %v", cacheOptions.KeyFiles, err)
+			continue
+		}
+
 		// Skip archiving if no cache is defined
-		cacheKey, cacheFile := b.cacheFile(info.Build, cacheOptions.Key)
+		cacheKey, cacheFile := b.cacheFile(info.Build, userKey)
 		if cacheKey == "" {
 			w.Noticef("Skipping cache archiving due to empty cache key")
 			continue
@@ -620,10 +769,30 @@ func (b *AbstractShell) addCacheUploadCommand(
 
 	args = append(args, archiverArgs...)
 
+	encryptionEnv, upload := getCacheEncryptionEnv(w, info.Build)
+
 	// Generate cache upload address
-	args = append(args, getCacheUploadURL(info.Build, cacheKey)...)
+	var multipartEnv map[string]string
+	var urlArgs []string
+	if upload {
+		multipartEnv = cache.GetCacheMultipartUploadEnv(info.Build, cacheKey)
+		urlArgs = getCacheUploadURL(info.Build, cacheKey, multipartEnv != nil)
+		args = append(args, urlArgs...)
+	}
+
+	formatArgs, formatEnv := getCacheFormatArgs(w, info.Build, cacheKey, len(urlArgs) > 0, true)
+	args = append(args, formatArgs...)
 
 	env := cache.GetCacheUploadEnv(info.Build, cacheKey)
+	for _, extraEnv := range []map[string]string{encryptionEnv, multipartEnv, formatEnv} {
+		for key, value := range extraEnv {
+			if env == nil {
+				env = make(map[string]string)
+			}
+			env[key] = value
+		}
+	}
+	env = withCacheResultKey(env, info.Build)
 
 	// Execute cache-archiver command. Failure is not fatal.
 	b.guardRunnerCommand(w, info.RunnerCommand, "Creating cache", func() {
@@ -641,12 +810,82 @@ func (b *AbstractShell) addCacheUploadCommand(
 	})
 }
 
+// getCacheEncryptionEnv returns the environment passing the keys encrypting
+// the caches to the cache helpers. When the caches are encrypted but the keys
+// can't be resolved, false is returned and only the local cache is used.
+func getCacheEncryptionEnv(w ShellWriter, build *common.Build) (map[string]string, bool) {
+	keyring, err := cache.GetCacheEncryptionKeys(build)
+	if err != nil {
+		w.Warningf("Failed to resolve the cache encryption keys, using the local cache only: %v", err)
+		return nil, false
+	}
+
+	if keyring == nil {
+		return nil, true
+	}
+
+	return map[string]string{cache.EncryptionKeysVariable: keyring.String()}, true
+}
+
+// withCacheResultKey adds the key signing the results of the cache helpers
+// to their environment, when the runner collects the results
+func withCacheResultKey(env map[string]string, build *common.Build) map[string]string {
+	key := build.CacheResultKey()
+	if key == "" {
+		return env
+	}
+
+	if env == nil {
+		env = make(map[string]string)
+	}
+	env[common.CacheResultKeyVariable] = key
+
+	return env
+}
+
+// getCacheFormatArgs selects the format of the cache. In the chunked format,
+// the chunk packs of the remote cache are passed to the cache helpers in the
+// environment, and the zip format is used when the adapter can't store them.
+func getCacheFormatArgs(
+	w ShellWriter,
+	build *common.Build,
+	cacheKey string,
+	remote bool,
+	upload bool,
+) ([]string, map[string]string) {
+	if build.Runner == nil || build.Runner.Cache == nil {
+		return nil, nil
+	}
+
+	switch build.Runner.Cache.Format {
+	case common.CacheFormatTarZstd:
+		return []string{"--format", common.CacheFormatTarZstd}, nil
+
+	case common.CacheFormatChunked:
+		args := []string{"--format", common.CacheFormatChunked}
+		if !remote {
+			return args, nil
+		}
+
+		env := cache.GetCacheChunkPacksEnv(build, cacheKey, upload)
+		if env == nil {
+			w.Warningf("The cache type can't store the chunks of the chunked cache format. Using the zip format.")
+			return nil, nil
+		}
+
+		return args, env
+	}
+
+	return nil, nil
+}
+
 // getCacheUploadURL will first try to generate the GoCloud URL if it's
-// available then fallback to a pre-signed URL.
-func getCacheUploadURL(build *common.Build, cacheKey string) []string {
+// available then fallback to a pre-signed URL. With multipart uploads, the
+// pre-signed URL is used by the cache archivers not supporting them.
+func getCacheUploadURL(build *common.Build, cacheKey string, multipart bool) []string {
 	// Prefer Go Cloud URL if supported
 	goCloudURL := cache.GetCacheGoCloudURL(build, cacheKey)
-	if goCloudURL != nil && build.IsFeatureFlagOn(featureflags.UseGoCloudWithCacheArchiver) {
+	if !multipart && goCloudURL != nil && build.IsFeatureFlagOn(featureflags.UseGoCloudWithCacheArchiver) {
 		return []string{"--gocloud-url", goCloudURL.String()}
 	}
 
@@ -677,6 +916,20 @@ func (b *AbstractShell) writeUploadArtifact(w ShellWriter, info common.ShellScri
 		strconv.Itoa(info.Build.ID),
 	}
 
+	if info.Build.LocalArtifactsDir != "" {
+		// Only the archive is passed to the dependent jobs, the reports
+		// are meaningful only to GitLab
+		if artifact.Format != common.ArtifactFormatDefault && artifact.Format != common.ArtifactFormatZip {
+			return false
+		}
+
+		args = []string{
+			"artifacts-uploader",
+			"--local-path",
+			info.Build.LocalArtifactsPath(info.Build.JobInfo.Name),
+		}
+	}
+
 	// Create list of files to archive
 	var archiverArgs []string
 	for _, path := range artifact.Paths {
@@ -724,7 +977,7 @@ func (b *AbstractShell) writeUploadArtifact(w ShellWriter, info common.ShellScri
 }
 
 func (b *AbstractShell) writeUploadArtifacts(w ShellWriter, info common.ShellScriptInfo, onSuccess bool) error {
-	if info.Build.Runner.URL == "" {
+	if info.Build.Runner.URL == "" && info.Build.LocalArtifactsDir == "" {
 		return common.ErrSkipBuildStage
 	}
 
@@ -1,14 +1,22 @@
 package shells
 
 import (
+	"crypto/sha1"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
+	_ "gitlab.com/gitlab-org/gitlab-runner/cache/filesystem"
 	_ "gitlab.com/gitlab-org/gitlab-runner/cache/test"
 	"gitlab.com/gitlab-org/gitlab-runner/common"
 	"gitlab.com/gitlab-org/gitlab-runner/helpers/tls"
@@ -284,6 +292,96 @@ func TestWriteWritingArtifactsWithExcludedPaths(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestWriteWritingArtifactsToLocalStore(t *testing.T) {
+	shell := AbstractShell{}
+
+	build := &common.Build{
+		JobResponse: common.JobResponse{
+			ID:      1001,
+			Token:   "token",
+			JobInfo: common.JobInfo{Name: "build job"},
+			Artifacts: common.Artifacts{
+				common.Artifact{
+					Paths:  []string{"out/"},
+					When:   common.ArtifactWhenOnSuccess,
+					Format: common.ArtifactFormatZip,
+				},
+				common.Artifact{
+					Paths:  []string{"junit.xml"},
+					When:   common.ArtifactWhenOnSuccess,
+					Format: common.ArtifactFormatGzip,
+					Type:   "junit",
+				},
+			},
+		},
+		Runner:            &common.RunnerConfig{},
+		LocalArtifactsDir: "/artifacts",
+	}
+
+	info := common.ShellScriptInfo{
+		RunnerCommand: "gitlab-runner-helper",
+		Build:         build,
+	}
+
+	mockWriter := new(MockShellWriter)
+	defer mockWriter.AssertExpectations(t)
+	mockWriter.On("Variable", mock.Anything)
+	mockWriter.On("Cd", mock.Anything).Once()
+	mockWriter.On("IfCmd", "gitlab-runner-helper", "--version").Once()
+	mockWriter.On("Noticef", mock.Anything).Once()
+	mockWriter.On(
+		"Command", "gitlab-runner-helper", "artifacts-uploader",
+		"--local-path", build.LocalArtifactsPath("build job"),
+		"--path", "out/",
+		"--artifact-format", "zip",
+	).Once()
+	mockWriter.On("Else").Once()
+	mockWriter.On("Warningf", mock.Anything, mock.Anything, mock.Anything).Once()
+	mockWriter.On("EndIf").Once()
+
+	err := shell.writeScript(mockWriter, common.BuildStageUploadOnSuccessArtifacts, info)
+	require.NoError(t, err)
+}
+
+func TestWriteDownloadingArtifactsFromLocalStore(t *testing.T) {
+	shell := AbstractShell{}
+
+	build := &common.Build{
+		JobResponse: common.JobResponse{
+			ID:    1001,
+			Token: "token",
+			Dependencies: common.Dependencies{
+				{ID: 1, Name: "build job", ArtifactsFile: common.DependencyArtifactsFile{Filename: "artifacts.zip"}},
+				{ID: 2, Name: "no artifacts"},
+			},
+		},
+		Runner:            &common.RunnerConfig{},
+		LocalArtifactsDir: "/artifacts",
+	}
+
+	info := common.ShellScriptInfo{
+		RunnerCommand: "gitlab-runner-helper",
+		Build:         build,
+	}
+
+	mockWriter := new(MockShellWriter)
+	defer mockWriter.AssertExpectations(t)
+	mockWriter.On("Variable", mock.Anything)
+	mockWriter.On("Cd", mock.Anything).Once()
+	mockWriter.On("IfCmd", "gitlab-runner-helper", "--version").Once()
+	mockWriter.On("Noticef", mock.Anything, mock.Anything, mock.Anything).Once()
+	mockWriter.On(
+		"Command", "gitlab-runner-helper", "artifacts-downloader",
+		"--local-path", build.LocalArtifactsPath("build job"),
+	).Once()
+	mockWriter.On("Else").Once()
+	mockWriter.On("Warningf", mock.Anything, mock.Anything, mock.Anything).Once()
+	mockWriter.On("EndIf").Once()
+
+	err := shell.writeScript(mockWriter, common.BuildStageDownloadArtifacts, info)
+	require.NoError(t, err)
+}
+
 func getJobResponseWithCachePaths() common.JobResponse {
 	return common.JobResponse{
 		ID:    1000,
@@ -824,7 +922,7 @@ func TestAbstractShell_extractCacheWithFallbackKey(t *testing.T) {
 		"--url",
 		fmt.Sprintf("test://download/project/1000/%s", testFallbackCacheKey),
 	).Once()
-	mockWriter.On("Noticef", "Successfully extracted cache").Once()
+	mockWriter.On("Noticef", "Successfully extracted cache from fallback key %s", testFallbackCacheKey).Once()
 	mockWriter.On("Else").Once()
 	mockWriter.On("Warningf", "Failed to extract cache").Once()
 	mockWriter.On("EndIf").Once()
@@ -837,6 +935,243 @@ func TestAbstractShell_extractCacheWithFallbackKey(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestAbstractShell_cacheKeys(t *testing.T) {
+	const cacheKey = "deps-main-abc"
+
+	tests := map[string]struct {
+		fallbackKeys      []string
+		variables         common.JobVariables
+		listedKeys        []string
+		listErr           error
+		setupExpectations func(*MockShellWriter)
+		expectedKeys      []string
+	}{
+		"no fallback keys": {
+			expectedKeys: []string{cacheKey},
+		},
+		"fallback keys in order": {
+			fallbackKeys: []string{"deps-$CI_COMMIT_REF_SLUG", "deps-main"},
+			variables: common.JobVariables{
+				{Key: "CI_COMMIT_REF_SLUG", Value: "feature"},
+				{Key: "CACHE_FALLBACK_KEY", Value: "deps-default"},
+			},
+			expectedKeys: []string{cacheKey, "deps-feature", "deps-main", "deps-default"},
+		},
+		"duplicated keys": {
+			fallbackKeys: []string{cacheKey, "deps-main", "deps-main"},
+			variables:    common.JobVariables{{Key: "CACHE_FALLBACK_KEY", Value: "deps-main"}},
+			expectedKeys: []string{cacheKey, "deps-main"},
+		},
+		"prefix resolved to the longest common prefix": {
+			fallbackKeys: []string{"deps-*"},
+			listedKeys:   []string{cacheKey, "deps-other", "deps-main-abd", "deps-main-abe"},
+			setupExpectations: func(w *MockShellWriter) {
+				w.On("Noticef", "Resolved the cache key %s* to %s", "deps-", "deps-main-abd").Once()
+			},
+			expectedKeys: []string{cacheKey, "deps-main-abd"},
+		},
+		"prefix without stored caches": {
+			fallbackKeys: []string{"deps-*", "deps-main"},
+			setupExpectations: func(w *MockShellWriter) {
+				w.On("Noticef", "No cache found for the key %s*", "deps-").Once()
+			},
+			expectedKeys: []string{cacheKey, "deps-main"},
+		},
+		"prefix with listing not supported": {
+			fallbackKeys: []string{"deps-*"},
+			listErr:      cache.ErrListNotSupported,
+			setupExpectations: func(w *MockShellWriter) {
+				w.On("Warningf", "Failed to resolve the cache key %s*: %v", "deps-", cache.ErrListNotSupported).Once()
+			},
+			expectedKeys: []string{cacheKey},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			oldListCacheKeys := listCacheKeys
+			listCacheKeys = func(_ *common.Build, prefix string) ([]string, error) {
+				assert.Equal(t, "deps-", prefix)
+				return tt.listedKeys, tt.listErr
+			}
+			defer func() {
+				listCacheKeys = oldListCacheKeys
+			}()
+
+			build := &common.Build{
+				JobResponse: common.JobResponse{Variables: tt.variables},
+			}
+
+			mockWriter := new(MockShellWriter)
+			defer mockWriter.AssertExpectations(t)
+			if tt.setupExpectations != nil {
+				tt.setupExpectations(mockWriter)
+			}
+
+			shell := AbstractShell{}
+			assert.Equal(t, tt.expectedKeys, shell.cacheKeys(mockWriter, build, cacheKey, tt.fallbackKeys))
+		})
+	}
+}
+
+func TestGetCacheFormatArgs(t *testing.T) {
+	filesystemCache := &common.CacheConfig{
+		Type:       "filesystem",
+		Shared:     true,
+		Format:     common.CacheFormatChunked,
+		Filesystem: &common.CacheFilesystemConfig{Path: "/cache-storage"},
+	}
+
+	tests := map[string]struct {
+		cache             *common.CacheConfig
+		remote            bool
+		upload            bool
+		expectedArgs      []string
+		expectedUploadURL string
+		expectedWarning   bool
+	}{
+		"no cache config": {},
+		"zip format": {
+			cache: &common.CacheConfig{Type: "filesystem", Format: common.CacheFormatZip},
+		},
+		"tarzstd format": {
+			cache:        &common.CacheConfig{Type: "test", Format: common.CacheFormatTarZstd},
+			expectedArgs: []string{"--format", "tarzstd"},
+		},
+		"chunked format with local cache only": {
+			cache:        &common.CacheConfig{Type: "test", Format: common.CacheFormatChunked},
+			expectedArgs: []string{"--format", "chunked"},
+		},
+		"chunked format extracted from filesystem cache": {
+			cache:        filesystemCache,
+			remote:       true,
+			expectedArgs: []string{"--format", "chunked"},
+		},
+		"chunked format archived to filesystem cache": {
+			cache:             filesystemCache,
+			remote:            true,
+			upload:            true,
+			expectedArgs:      []string{"--format", "chunked"},
+			expectedUploadURL: "file:///cache-storage/project/1000/.chunks/key.packs/1-",
+		},
+		"chunked format with cache not storing chunk packs": {
+			cache:           &common.CacheConfig{Type: "test", Format: common.CacheFormatChunked},
+			remote:          true,
+			expectedWarning: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			build := &common.Build{
+				Runner: &common.RunnerConfig{
+					RunnerSettings: common.RunnerSettings{Cache: tt.cache},
+				},
+				JobResponse: common.JobResponse{
+					ID:      1,
+					JobInfo: common.JobInfo{ProjectID: 1000},
+				},
+			}
+
+			mockWriter := new(MockShellWriter)
+			defer mockWriter.AssertExpectations(t)
+
+			if tt.expectedWarning {
+				mockWriter.On(
+					"Warningf",
+					"The cache type can't store the chunks of the chunked cache format. Using the zip format.",
+				).Once()
+			}
+
+			args, env := getCacheFormatArgs(mockWriter, build, "key", tt.remote, tt.upload)
+			assert.Equal(t, tt.expectedArgs, args)
+
+			if !tt.remote || tt.expectedWarning || tt.cache.Format != common.CacheFormatChunked {
+				assert.Nil(t, env)
+				return
+			}
+
+			var packs cache.ChunkPacks
+			require.NoError(t, json.Unmarshal([]byte(env[cache.ChunkPacksVariable]), &packs))
+			assert.Empty(t, packs.Packs)
+
+			if tt.expectedUploadURL == "" {
+				assert.Empty(t, packs.UploadURL)
+				return
+			}
+
+			assert.True(t, strings.HasPrefix(packs.UploadURL, tt.expectedUploadURL), packs.UploadURL)
+			assert.Equal(t, packs.UploadURL, tt.expectedUploadURL+strings.TrimPrefix(packs.Name, "1-"))
+		})
+	}
+}
+
+func TestGetCacheEncryptionEnv(t *testing.T) {
+	const key = "AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="
+
+	tests := map[string]struct {
+		encryption       *common.CacheEncryptionConfig
+		environment      []string
+		variables        common.JobVariables
+		expectedEnv      map[string]string
+		expectedDownload bool
+		expectedWarning  bool
+	}{
+		"encryption not configured": {
+			expectedDownload: true,
+		},
+		"key from the configuration": {
+			encryption:       &common.CacheEncryptionConfig{KeyID: "v1", Key: key},
+			expectedEnv:      map[string]string{"CACHE_ENCRYPTION_KEYS": "v1=" + key},
+			expectedDownload: true,
+		},
+		"key from a variable of the runner": {
+			encryption:       &common.CacheEncryptionConfig{KeyID: "v1", KeyVariable: "CACHE_KEY"},
+			environment:      []string{"CACHE_KEY=" + key},
+			expectedEnv:      map[string]string{"CACHE_ENCRYPTION_KEYS": "v1=" + key},
+			expectedDownload: true,
+		},
+		"key from a job variable": {
+			encryption:      &common.CacheEncryptionConfig{KeyID: "v1", KeyVariable: "CACHE_KEY"},
+			variables:       common.JobVariables{{Key: "CACHE_KEY", Value: key}},
+			expectedWarning: true,
+		},
+		"missing key": {
+			encryption:      &common.CacheEncryptionConfig{KeyID: "v1", KeyVariable: "VAULT_CACHE_KEY"},
+			expectedWarning: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			build := &common.Build{
+				Runner: &common.RunnerConfig{
+					RunnerSettings: common.RunnerSettings{
+						Environment: tt.environment,
+						Cache:       &common.CacheConfig{Type: "test", Encryption: tt.encryption},
+					},
+				},
+				JobResponse: common.JobResponse{Variables: tt.variables},
+			}
+
+			mockWriter := new(MockShellWriter)
+			defer mockWriter.AssertExpectations(t)
+
+			if tt.expectedWarning {
+				mockWriter.On(
+					"Warningf",
+					"Failed to resolve the cache encryption keys, using the local cache only: %v",
+					mock.Anything,
+				).Once()
+			}
+
+			env, download := getCacheEncryptionEnv(mockWriter, build)
+			assert.Equal(t, tt.expectedEnv, env)
+			assert.Equal(t, tt.expectedDownload, download)
+		})
+	}
+}
+
 func TestWriteUserScript(t *testing.T) {
 	tests := map[string]struct {
 		inputSteps        common.Steps
@@ -1204,3 +1539,191 @@ func TestAbstractShell_writeCleanupFileVariablesScript(t *testing.T) {
 	err := shell.writeCleanupFileVariablesScript(mockShellWriter, info)
 	assert.NoError(t, err)
 }
+
+// commitFiles commits the files to the repository, and returns the ID of
+// the commit
+func commitFiles(t *testing.T, dir string, files map[string]string) string {
+	for name, content := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+	}
+
+	for _, args := range [][]string{
+		{"add", "--all"},
+		{"-c", "user.name=Runner", "-c", "user.email=runner@example.com", "commit", "--quiet", "--message", "commit"},
+	} {
+		_, err := gitCommand(dir, args...)
+		require.NoError(t, err)
+	}
+
+	id, err := gitCommand(dir, "rev-parse", "HEAD")
+	require.NoError(t, err)
+
+	return id
+}
+
+func TestGetCacheUserKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache-key-files")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = gitCommand(dir, "init", "--quiet")
+	require.NoError(t, err)
+
+	first := commitFiles(t, dir, map[string]string{"Gemfile.lock": "gems", "yarn.lock": "packages"})
+	second := commitFiles(t, dir, map[string]string{"yarn.lock": "updated packages"})
+	// The commits after the checked out one are ignored
+	commitFiles(t, dir, map[string]string{"Gemfile.lock": "updated gems", "yarn.lock": "latest packages"})
+
+	digest := func(ids ...string) string {
+		return fmt.Sprintf("%x", sha1.Sum([]byte(strings.Join(ids, "-"))))
+	}
+
+	sortedIDs := []string{first, second}
+	sort.Strings(sortedIDs)
+
+	shallowDir, err := ioutil.TempDir("", "cache-key-files-shallow")
+	require.NoError(t, err)
+	defer os.RemoveAll(shallowDir)
+
+	_, err = gitCommand(shallowDir, "clone", "--quiet", "--depth", "1", "file://"+filepath.ToSlash(dir), ".")
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		cache       common.Cache
+		gitInfo     common.GitInfo
+		buildDir    string
+		expectedKey string
+		expectedErr error
+	}{
+		"key computed by GitLab": {
+			cache:       common.Cache{Key: "key", KeyFiles: []string{"Gemfile.lock"}},
+			expectedKey: "key",
+		},
+		"no key": {
+			expectedKey: "",
+		},
+		"one file": {
+			cache:       common.Cache{KeyFiles: []string{"Gemfile.lock"}},
+			gitInfo:     common.GitInfo{RepoURL: dir, Sha: second},
+			expectedKey: digest(first),
+		},
+		"files changed by different commits": {
+			cache:       common.Cache{KeyFiles: []string{"yarn.lock", "Gemfile.lock"}},
+			gitInfo:     common.GitInfo{RepoURL: dir, Sha: second},
+			expectedKey: digest(sortedIDs...),
+		},
+		"files changed by the same commit": {
+			cache:       common.Cache{KeyFiles: []string{"Gemfile.lock", "Gemfile.lock"}},
+			gitInfo:     common.GitInfo{RepoURL: dir, Sha: second},
+			expectedKey: digest(first),
+		},
+		"prefix": {
+			cache:       common.Cache{KeyFiles: []string{"Gemfile.lock"}, KeyPrefix: "$CI_JOB_NAME"},
+			gitInfo:     common.GitInfo{RepoURL: dir, Sha: second},
+			expectedKey: "$CI_JOB_NAME-" + digest(first),
+		},
+		"files not committed": {
+			cache:       common.Cache{KeyFiles: []string{"missing.lock"}},
+			gitInfo:     common.GitInfo{RepoURL: dir, Sha: second},
+			expectedKey: "default",
+		},
+		"prefix and files not committed": {
+			cache:       common.Cache{KeyFiles: []string{"missing.lock"}, KeyPrefix: "deps"},
+			gitInfo:     common.GitInfo{RepoURL: dir, Sha: second},
+			expectedKey: "deps-default",
+		},
+		"project directory on the runner's host": {
+			cache:       common.Cache{KeyFiles: []string{"Gemfile.lock"}},
+			gitInfo:     common.GitInfo{RepoURL: "https://gitlab.example.com/project.git", Sha: first},
+			buildDir:    dir,
+			expectedKey: digest(first),
+		},
+		"no local repository": {
+			cache:       common.Cache{KeyFiles: []string{"Gemfile.lock"}},
+			gitInfo:     common.GitInfo{RepoURL: "https://gitlab.example.com/project.git", Sha: first},
+			buildDir:    "/builds/project",
+			expectedErr: errNoCacheKeyRepository,
+		},
+		"shallow repository": {
+			cache:       common.Cache{KeyFiles: []string{"Gemfile.lock"}},
+			gitInfo:     common.GitInfo{RepoURL: shallowDir, Sha: second},
+			expectedErr: errShallowCacheKeyRepository,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			build := &common.Build{
+				BuildDir:    tt.buildDir,
+				JobResponse: common.JobResponse{GitInfo: tt.gitInfo},
+			}
+
+			key, err := getCacheUserKey(build, tt.cache)
+			assert.Equal(t, tt.expectedErr, err)
+			assert.Equal(t, tt.expectedKey, key)
+		})
+	}
+}
+
+func TestAbstractShell_cacheWithKeyFilesWithoutRepository(t *testing.T) {
+	build := &common.Build{
+		BuildDir: "/builds/project",
+		CacheDir: "/cache",
+		Runner:   &common.RunnerConfig{},
+		JobResponse: common.JobResponse{
+			GitInfo: common.GitInfo{RepoURL: "https://gitlab.example.com/project.git"},
+			Cache: common.Caches{
+				{
+					KeyFiles: []string{"Gemfile.lock"},
+					Paths:    []string{"vendor"},
+				},
+			},
+		},
+	}
+	info := common.ShellScriptInfo{
+		RunnerCommand: "runner-command",
+		Build:         build,
+	}
+
+	mockWriter := new(MockShellWriter)
+	defer mockWriter.AssertExpectations(t)
+
+	mockWriter.On(
+		"Warningf",
+		"Skipping cache extraction, the key of %s can't be computed: %v",
+		[]string{"Gemfile.lock"},
+		errNoCacheKeyRepository,
+	).Once()
+	mockWriter.On(
+		"Warningf",
+		"Skipping cache archiving, the key of %s can't be computed: %v",
+		[]string{"Gemfile.lock"},
+		errNoCacheKeyRepository,
+	).Once()
+
+	shell := AbstractShell{}
+	assert.NoError(t, shell.cacheExtractor(mockWriter, info))
+
+	skipped, err := shell.archiveCache(mockWriter, info, true)
+	assert.NoError(t, err)
+	assert.False(t, skipped)
+}
+
+func TestWriteGetSourcesScriptInPlaceRun(t *testing.T) {
+	shell := AbstractShell{}
+	build := &common.Build{
+		Runner:   &common.RunnerConfig{},
+		BuildDir: "/builds/project",
+		JobResponse: common.JobResponse{
+			GitInfo: common.GitInfo{RepoURL: "/builds/project"},
+		},
+	}
+
+	mockWriter := new(MockShellWriter)
+	defer mockWriter.AssertExpectations(t)
+
+	mockWriter.On("Noticef", "In-place run detected in %s, skipping fetching the sources", "/builds/project").Once()
+
+	err := shell.writeGetSourcesScript(mockWriter, common.ShellScriptInfo{Build: build})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,124 @@
+package shells
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+// defaultCacheKeyFilesDigest is the digest of the key of cache:key:files
+// when none of the files was committed
+const defaultCacheKeyFilesDigest = "default"
+
+var (
+	errNoCacheKeyRepository      = errors.New("no local repository to compute the key from")
+	errShallowCacheKeyRepository = errors.New(
+		"the local repository is a shallow clone, the last commits changing the files can't be found " +
+			"(use GIT_DEPTH: 0 to compute the key)",
+	)
+)
+
+func gitCommand(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, exitErr.Stderr)
+		}
+
+		return "", fmt.Errorf("git %s: %w", strings.Join(args, " "), err)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// getCacheUserKey returns the key configured for the cache, the key of
+// cache:key:files being computed when GitLab didn't compute it
+func getCacheUserKey(build *common.Build, cache common.Cache) (string, error) {
+	if cache.Key != "" || len(cache.KeyFiles) == 0 {
+		return cache.Key, nil
+	}
+
+	dir := cacheKeyRepository(build)
+	if dir == "" {
+		return "", errNoCacheKeyRepository
+	}
+
+	// the history of a shallow clone stops at its boundary commits, which
+	// would be found instead of the commits changing the files
+	shallow, err := gitCommand(dir, "rev-parse", "--is-shallow-repository")
+	if err != nil {
+		return "", err
+	}
+	if shallow == "true" {
+		return "", errShallowCacheKeyRepository
+	}
+
+	return cacheKeyFromFiles(dir, build.GitInfo.Sha, cache.KeyFiles, cache.KeyPrefix)
+}
+
+// cacheKeyRepository returns the local repository containing the checked out
+// commit: the repository of `gitlab-runner exec`, or the project directory
+// when the job runs on the runner's host
+func cacheKeyRepository(build *common.Build) string {
+	for _, dir := range []string{build.GitInfo.RepoURL, build.FullProjectDir()} {
+		if dir == "" || !filepath.IsAbs(dir) {
+			continue
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+	}
+
+	return ""
+}
+
+// cacheKeyFromFiles computes the key of cache:key:files the way GitLab does:
+// the SHA1 of the IDs of the last commits changing the files at the checked
+// out commit, prefixed with the key prefix
+func cacheKeyFromFiles(dir string, sha string, files []string, prefix string) (string, error) {
+	ids := make(map[string]bool)
+
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+
+		id, err := gitCommand(dir, "log", "-1", "--format=%H", sha, "--", file)
+		if err != nil {
+			return "", err
+		}
+
+		if id != "" {
+			ids[id] = true
+		}
+	}
+
+	digest := defaultCacheKeyFilesDigest
+	if len(ids) > 0 {
+		sorted := make([]string, 0, len(ids))
+		for id := range ids {
+			sorted = append(sorted, id)
+		}
+		sort.Strings(sorted)
+
+		digest = fmt.Sprintf("%x", sha1.Sum([]byte(strings.Join(sorted, "-"))))
+	}
+
+	if prefix == "" {
+		return digest, nil
+	}
+
+	return prefix + "-" + digest, nil
+}